@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"roi-agent-schema"
+)
+
+// captureFilter selects DNS traffic, the first packet of each connection and the TLS
+// ClientHellos: TCP SYNs, TCP segments starting with a ClientHello record, and QUIC
+// long-header packets to UDP port 443. tcpdump cannot index into TCP and UDP headers
+// behind IPv6, so for IPv6 the offsets assume no extension headers.
+const captureFilter = "port 53" +
+	" or (tcp[tcpflags] & (tcp-syn|tcp-ack) == tcp-syn)" +
+	" or (ip6 and ip6[6] == 6 and ip6[53] & 0x12 == 0x02)" +
+	" or (tcp[((tcp[12] & 0xf0) >> 2)] == 0x16 and tcp[((tcp[12] & 0xf0) >> 2) + 5] == 1)" +
+	" or (ip6 and ip6[6] == 6 and ip6[40 + ((ip6[52] & 0xf0) >> 2)] == 0x16 and ip6[40 + ((ip6[52] & 0xf0) >> 2) + 5] == 1)" +
+	" or (udp dst port 443 and udp[8] & 0x80 != 0)" +
+	" or (ip6 and ip6[6] == 17 and udp dst port 443 and ip6[48] & 0x80 != 0)"
+
+// dnsSilenceWarning is how long no DNS traffic may be seen while TLS traffic is before
+// the capture health warns that DNS is not visible
+const dnsSilenceWarning = 10 * time.Minute
+
+// tcpdumpStopTimeout is how long tcpdump gets to exit after SIGTERM
+const tcpdumpStopTimeout = 5 * time.Second
+
+// Restart backoff for tcpdump: the delay doubles per exit up to the maximum, and starts
+// over once a capture has run for captureHealthyAfter. After captureMaxFailures exits in
+// a row the capture is reported as failed, but it is still restarted at the maximum delay.
+// A clean exit after -c is a rotation, which is restarted at once and is not a failure.
+const (
+	captureRestartDelay    = time.Second
+	captureMaxRestartDelay = 5 * time.Minute
+	captureHealthyAfter    = time.Minute
+	captureMaxFailures     = 5
+)
+
+// captureStderrBytes is how much of tcpdump's stderr is kept for status
+const captureStderrBytes = 2048
+
+// captureProcess is one run of tcpdump
+type captureProcess struct {
+	cmd     *exec.Cmd
+	stderr  *tailBuffer
+	started time.Time
+	exited  chan error
+}
+
+// captureBackoff decides when tcpdump is restarted after it exits
+type captureBackoff struct {
+	packetCount int // network.tcpdump_packet_count tcpdump runs with
+	delay       time.Duration
+	failures    int
+}
+
+func newCaptureBackoff(packetCount int) *captureBackoff {
+	return &captureBackoff{packetCount: packetCount, delay: captureRestartDelay}
+}
+
+// exited records that tcpdump exited with err after running for ran. It reports whether
+// the exit is a rotation: with -c, tcpdump exits cleanly once it has captured its packets.
+// A rotation or a long run starts the backoff over.
+func (b *captureBackoff) exited(err error, ran time.Duration) (rotation bool) {
+	rotation = err == nil && b.packetCount > 0
+	if rotation || ran >= captureHealthyAfter {
+		b.delay = captureRestartDelay
+		b.failures = 0
+	}
+	return rotation
+}
+
+// next counts a failure and returns how long to wait before the next start, and
+// whether the capture has failed too often in a row to be reported as restarting
+func (b *captureBackoff) next() (delay time.Duration, failed bool) {
+	b.failures++
+	delay = b.delay
+	if b.delay *= 2; b.delay > captureMaxRestartDelay {
+		b.delay = captureMaxRestartDelay
+	}
+	return delay, b.failures >= captureMaxFailures
+}
+
+// tailBuffer keeps the last bytes written to it
+type tailBuffer struct {
+	mutex sync.Mutex
+	data  []byte
+	limit int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.data = append(b.data, p...)
+	if len(b.data) > b.limit {
+		b.data = b.data[len(b.data)-b.limit:]
+	}
+	return len(p), nil
+}
+
+// String returns the kept output, starting at a line boundary if the start was cut off
+func (b *tailBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	text := string(b.data)
+	if len(b.data) == b.limit {
+		if i := strings.IndexByte(text, '\n'); i >= 0 {
+			text = text[i+1:]
+		}
+	}
+	return strings.TrimSpace(text)
+}
+
+// startTcpdumpDNSMonitoring starts tcpdump-based DNS monitoring, with a supervisor
+// that restarts tcpdump whenever it exits until stopTcpdumpDNSMonitoring is called
+func (a *Agent) startTcpdumpDNSMonitoring() error {
+	if a.tcpdumpCancel != nil {
+		return nil // Already running
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	process, err := a.startCapture(ctx)
+	if err != nil {
+		cancel()
+		a.setCaptureState(schema.CaptureFailed, err.Error())
+		return err
+	}
+	a.tcpdumpCancel = cancel
+	a.setCaptureState(schema.CaptureRunning, "")
+
+	log.Println("Started DNS monitoring with tcpdump")
+
+	done := make(chan struct{})
+	a.tcpdumpDone = done
+	go func() {
+		defer close(done)
+		a.superviseCapture(ctx, process)
+	}()
+
+	return nil
+}
+
+// stopTcpdumpDNSMonitoring stops tcpdump monitoring and waits for it to exit
+func (a *Agent) stopTcpdumpDNSMonitoring() {
+	if a.tcpdumpCancel == nil {
+		return
+	}
+
+	a.tcpdumpCancel()
+	select {
+	case <-a.tcpdumpDone:
+	case <-time.After(2 * tcpdumpStopTimeout):
+		log.Println("Warning: tcpdump did not exit")
+	}
+	a.tcpdumpCancel = nil
+
+	log.Println("Stopped DNS monitoring")
+}
+
+// startCapture starts tcpdump and a goroutine that feeds its output to readCapture.
+// The tcpdump settings need a restart of the agent, so restarts keep the starting configuration.
+func (a *Agent) startCapture(ctx context.Context) (*captureProcess, error) {
+	command := a.startConfig.tcpdumpCommand()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+
+	// sudo passes SIGTERM on to tcpdump but cannot pass on SIGKILL, so stop it with
+	// SIGTERM and only kill it if it has not exited after a while
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = tcpdumpStopTimeout
+
+	stderr := &tailBuffer{limit: captureStderrBytes}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %v", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start tcpdump: %v", err)
+	}
+
+	process := &captureProcess{cmd: cmd, stderr: stderr, started: time.Now(), exited: make(chan error, 1)}
+	go func() {
+		if _, err := a.readCapture(stdout, nil); err != nil {
+			log.Printf("DNS monitoring capture error: %v", err)
+		}
+		// tcpdump may still be writing if the stream was unreadable
+		io.Copy(ioutil.Discard, stdout)
+		process.exited <- cmd.Wait()
+	}()
+
+	return process, nil
+}
+
+// captureStats counts what readCapture decoded
+type captureStats struct {
+	Packets   int `json:"packets"`
+	Undecoded int `json:"undecoded"`
+	Queries   int `json:"dns_queries"`
+	Responses int `json:"dns_responses"`
+	Flows     int `json:"connections"`
+	Unmatched int `json:"unmatched_connections"` // to addresses the client did not look up
+
+	TLSHellos  int `json:"tls_client_hellos"` // with a server name
+	QUICHellos int `json:"quic_client_hellos"`
+}
+
+// readCapture decodes a pcap stream, from tcpdump or a file, until the stream ends. It
+// passes every DNS message to processDNSRecord, and the other packets to processTraffic.
+// observe, if set, sees each DNS record first.
+func (a *Agent) readCapture(r io.Reader, observe func(DNSRecord)) (captureStats, error) {
+	var stats captureStats
+	reader, err := newPcapReader(r)
+	if err == io.EOF {
+		return stats, nil
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	for {
+		captured, err := reader.next()
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.Packets++
+
+		a.protect("dns-parser", func() {
+			p, err := decodePacket(reader.linkType, captured.Data)
+			if err != nil {
+				stats.Undecoded++
+				debugf("Skipping captured packet: %v", err)
+				return
+			}
+			if p.SrcPort != dnsPort && p.DstPort != dnsPort {
+				a.processTraffic(captured.Time, p, &stats)
+				return
+			}
+
+			record, err := dnsRecordFromPacket(captured.Time, p)
+			if err != nil {
+				stats.Undecoded++
+				debugf("Skipping DNS packet from %s: %v", p.Src, err)
+				return
+			}
+			if record == nil {
+				return
+			}
+
+			a.sawTraffic(schema.DomainFromDNS, captured.Time)
+			if record.Response {
+				stats.Responses++
+			} else {
+				stats.Queries++
+			}
+			if observe != nil {
+				observe(*record)
+			}
+			a.processDNSRecord(*record)
+		})
+	}
+}
+
+// superviseCapture waits for tcpdump to exit and restarts it with backoff until ctx is cancelled
+func (a *Agent) superviseCapture(ctx context.Context, process *captureProcess) {
+	backoff := newCaptureBackoff(a.startConfig.Network.TcpdumpPacketCount)
+
+	for {
+		err := <-process.exited
+		if ctx.Err() != nil {
+			return
+		}
+
+		var exit string
+		if backoff.exited(err, time.Since(process.started)) {
+			// tcpdump captured its -c packets: start the next capture right away
+			debugf("tcpdump captured %d packets, starting the next capture", backoff.packetCount)
+			started, err := a.startCapture(ctx)
+			if err == nil {
+				process = started
+				continue
+			}
+			log.Printf("Error restarting tcpdump: %v", err)
+			exit = err.Error()
+			a.captureExited(exit, "")
+		} else {
+			exit = "tcpdump exited"
+			if err != nil {
+				exit = fmt.Sprintf("tcpdump exited: %v", err)
+			}
+			stderr := process.stderr.String()
+			log.Printf("DNS monitoring stopped: %s", exit)
+			if stderr != "" {
+				log.Printf("tcpdump stderr: %s", stderr)
+			}
+			a.captureExited(exit, stderr)
+		}
+
+		// Restart, waiting longer after each failure, until tcpdump starts again
+		for process = nil; process == nil; {
+			delay, failed := backoff.next()
+			if failed {
+				a.captureWaiting(schema.CaptureFailed, fmt.Sprintf("%s (%d failures in a row)", exit, backoff.failures), delay)
+			} else {
+				a.captureWaiting(schema.CaptureRestarting, exit, delay)
+			}
+			log.Printf("Restarting tcpdump in %v", delay)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+
+			started, err := a.startCapture(ctx)
+			if err != nil {
+				log.Printf("Error restarting tcpdump: %v", err)
+				exit = err.Error()
+				a.captureExited(exit, "")
+				continue
+			}
+			process = started
+		}
+
+		a.captureMutex.Lock()
+		a.capture.Restarts++
+		a.captureMutex.Unlock()
+		a.setCaptureState(schema.CaptureRunning, "")
+		log.Println("Restarted DNS monitoring with tcpdump")
+	}
+}
+
+// setCaptureState records a new capture state
+func (a *Agent) setCaptureState(state, reason string) {
+	a.captureMutex.Lock()
+	defer a.captureMutex.Unlock()
+	if a.capture.State != state {
+		a.capture.Since = time.Now()
+	}
+	a.capture.State = state
+	a.capture.Reason = reason
+	a.capture.NextRestart = nil
+}
+
+// captureExited records how tcpdump exited
+func (a *Agent) captureExited(exit, stderr string) {
+	now := time.Now()
+	a.captureMutex.Lock()
+	defer a.captureMutex.Unlock()
+	a.capture.LastExit = exit
+	a.capture.LastExitAt = &now
+	a.capture.LastStderr = stderr
+}
+
+// captureWaiting records that tcpdump is restarted after delay
+func (a *Agent) captureWaiting(state, reason string, delay time.Duration) {
+	a.setCaptureState(state, reason)
+	next := time.Now().Add(delay)
+	a.captureMutex.Lock()
+	a.capture.NextRestart = &next
+	a.captureMutex.Unlock()
+}
+
+// captureHealth returns a copy of the capture state for status and the day file
+func (a *Agent) captureHealth() *CaptureHealth {
+	a.captureMutex.Lock()
+	defer a.captureMutex.Unlock()
+	if a.capture.State == "" {
+		return nil
+	}
+	health := a.capture
+
+	// Clients cache their lookups, so only a long silence while TLS is seen is suspicious
+	now := time.Now()
+	if health.State == schema.CaptureRunning && now.Sub(health.Since) >= dnsSilenceWarning &&
+		health.LastTLS != nil && now.Sub(*health.LastTLS) < dnsSilenceWarning &&
+		(health.LastDNS == nil || now.Sub(*health.LastDNS) >= dnsSilenceWarning) {
+		health.Warning = fmt.Sprintf("no DNS traffic for %v while TLS connections are seen: "+
+			"DNS is probably encrypted (DNS over HTTPS) or sent through a VPN, so domains come only from TLS server names", dnsSilenceWarning)
+	}
+	return &health
+}
+
+// sawTraffic records when DNS traffic (source DomainFromDNS) or a TLS server name was
+// last captured, for the warning in captureHealth
+func (a *Agent) sawTraffic(source string, at time.Time) {
+	a.captureMutex.Lock()
+	defer a.captureMutex.Unlock()
+	if source == schema.DomainFromDNS {
+		if a.capture.LastDNS == nil || at.After(*a.capture.LastDNS) {
+			a.capture.LastDNS = &at
+		}
+	} else if a.capture.LastTLS == nil || at.After(*a.capture.LastTLS) {
+		a.capture.LastTLS = &at
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCaptureBackoffRotation(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+
+	tests := []struct {
+		name        string
+		packetCount int
+		err         error
+		ran         time.Duration
+		rotation    bool
+	}{
+		{"clean exit under -c", 50, nil, time.Second, true},
+		{"clean exit under -c after a long run", 50, nil, time.Hour, true},
+		{"clean exit without -c", 0, nil, time.Second, false},
+		{"error exit under -c", 50, exitErr, time.Second, false},
+		{"error exit without -c", 0, exitErr, time.Second, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newCaptureBackoff(tt.packetCount)
+			if got := b.exited(tt.err, tt.ran); got != tt.rotation {
+				t.Errorf("exited() = %v, want %v", got, tt.rotation)
+			}
+		})
+	}
+}
+
+func TestCaptureBackoffRotationsDoNotFail(t *testing.T) {
+	b := newCaptureBackoff(50)
+	for i := 0; i < 2*captureMaxFailures; i++ {
+		if !b.exited(nil, time.Millisecond) {
+			t.Fatalf("exit %d: not a rotation", i)
+		}
+	}
+	if b.failures != 0 || b.delay != captureRestartDelay {
+		t.Errorf("after rotations failures = %d, delay = %v; want 0, %v", b.failures, b.delay, captureRestartDelay)
+	}
+}
+
+func TestCaptureBackoffQuickExits(t *testing.T) {
+	b := newCaptureBackoff(0)
+	want := captureRestartDelay
+	for i := 1; i <= captureMaxFailures+10; i++ {
+		if b.exited(errors.New("exit status 1"), time.Second) {
+			t.Fatalf("exit %d: unexpected rotation", i)
+		}
+		delay, failed := b.next()
+		if delay != want {
+			t.Errorf("exit %d: delay = %v, want %v", i, delay, want)
+		}
+		if failed != (i >= captureMaxFailures) {
+			t.Errorf("exit %d: failed = %v", i, failed)
+		}
+		if want *= 2; want > captureMaxRestartDelay {
+			want = captureMaxRestartDelay
+		}
+	}
+}
+
+func TestCaptureBackoffResetsAfterHealthyRun(t *testing.T) {
+	b := newCaptureBackoff(0)
+	for i := 0; i < captureMaxFailures; i++ {
+		b.exited(nil, time.Second)
+		b.next()
+	}
+
+	b.exited(nil, captureHealthyAfter)
+	delay, failed := b.next()
+	if delay != captureRestartDelay || failed {
+		t.Errorf("after a healthy run next() = %v, %v; want %v, false", delay, failed, captureRestartDelay)
+	}
+}
+
+func TestTcpdumpCommandPutsOptionsBeforeFilter(t *testing.T) {
+	c := &Config{}
+	c.Network.TcpdumpInterface = "any"
+	c.Network.TcpdumpPacketCount = 50
+
+	args := c.tcpdumpCommand()
+	if args[len(args)-1] != captureFilter {
+		t.Fatalf("filter is not the last argument: %q", args)
+	}
+	if !strings.Contains(strings.Join(args, " "), " -c 50 ") {
+		t.Errorf("-c 50 missing before the filter: %q", args)
+	}
+}
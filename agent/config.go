@@ -0,0 +1,605 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v3"
+	"roi-agent-data-sender/transmit"
+)
+
+// Config is the agent's effective configuration. Each value comes from, in order of
+// precedence, a command line flag, an environment variable, config.yaml or its default.
+type Config struct {
+	Monitor struct {
+		Interval          int  // seconds between samples
+		FocusPoll         int  // seconds between frontmost app checks
+		MaxGap            int  // seconds; longer gaps between samples are recorded as suspended
+		DataRetentionDays int  // day files older than this are removed, 0 keeps them
+		UseRealDataOnly   bool // the agent never generates test data
+	}
+	Network struct {
+		DNSSnooping         bool
+		MonitorPorts        []int
+		MonitorProtocols    []string
+		ActiveWindow        int // seconds a connection stays active after its last DNS query
+		TcpdumpInterface    string
+		TcpdumpPacketCount  int // 0 captures until the agent stops
+		RequiresSudo        bool
+		RealConnectionsOnly bool
+		ExcludeDomains      []string // never recorded, including their subdomains
+	}
+	Transmission struct {
+		IntervalMinutes int
+	}
+	Web struct {
+		Host        string
+		Port        int
+		AutoRefresh int
+	}
+	Security struct {
+		RequireAccessibility bool
+		RequireSudo          bool // DNS monitoring failing to start stops the agent
+		LocalOnly            bool
+	}
+	Privacy struct {
+		ExcludeApps []string // never recorded, not even in the event log
+	}
+	Debug struct {
+		EnableTestData bool
+		LogLevel       string
+	}
+
+	// Path is the config.yaml that was read, empty if none was found
+	Path string
+
+	args    []string // the command line the configuration was loaded with, used to reload it
+	origins map[string]string
+}
+
+// Log levels accepted in debug.log_level
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// configSearchPaths are tried in order when neither --config nor ROI_AGENT_CONFIG is set
+var configSearchPaths = []string{
+	"config/config.yaml",    // From project root
+	"../config/config.yaml", // From agent directory
+}
+
+// setting binds one configuration key to its field, environment variable and validation
+type setting struct {
+	key     string
+	env     string
+	value   interface{} // *int, *bool, *string, *[]int or *[]string
+	check   func() error
+	note    string
+	restart bool // a changed value only takes effect when the agent restarts
+}
+
+// defaultConfig returns the configuration used when nothing else is set
+func defaultConfig() *Config {
+	c := &Config{origins: make(map[string]string)}
+	c.Monitor.Interval = 15
+	c.Monitor.FocusPoll = 2
+	c.Monitor.MaxGap = 60
+	c.Monitor.DataRetentionDays = 30
+	c.Monitor.UseRealDataOnly = true
+	c.Network.DNSSnooping = true
+	c.Network.MonitorPorts = []int{80, 443, 8080, 3000, 5000, 8000, 9000}
+	c.Network.MonitorProtocols = []string{"HTTP", "HTTPS", "QUIC", "TCP"}
+	c.Network.ActiveWindow = 30
+	c.Network.TcpdumpInterface = "any"
+	c.Network.RequiresSudo = true
+	c.Network.RealConnectionsOnly = true
+	c.Transmission.IntervalMinutes = 10
+	c.Web.Host = "127.0.0.1"
+	c.Web.Port = 5002
+	c.Web.AutoRefresh = 30
+	c.Security.RequireAccessibility = true
+	c.Security.RequireSudo = true
+	c.Security.LocalOnly = true
+	c.Debug.LogLevel = "info"
+	return c
+}
+
+// settings lists every configuration key of c
+func (c *Config) settings() []*setting {
+	dashboard := "used by the dashboard, not the agent"
+	realData := "the agent only records real data"
+	return []*setting{
+		{key: "monitor.interval", value: &c.Monitor.Interval, check: func() error {
+			return inRange(c.Monitor.Interval, 1, 3600)
+		}},
+		{key: "monitor.focus_poll", value: &c.Monitor.FocusPoll, check: func() error {
+			if c.Monitor.FocusPoll < 1 || c.Monitor.FocusPoll > c.Monitor.Interval {
+				return fmt.Errorf("must be between 1 and monitor.interval (%d)", c.Monitor.Interval)
+			}
+			return nil
+		}},
+		{key: "monitor.max_gap", env: "ROI_AGENT_MAX_GAP_SECONDS", value: &c.Monitor.MaxGap, check: func() error {
+			if c.Monitor.MaxGap <= c.Monitor.Interval {
+				return fmt.Errorf("must be more than monitor.interval (%d)", c.Monitor.Interval)
+			}
+			return nil
+		}},
+		{key: "monitor.data_retention_days", value: &c.Monitor.DataRetentionDays, check: func() error {
+			return inRange(c.Monitor.DataRetentionDays, 0, 3650)
+		}},
+		{key: "monitor.use_real_data_only", value: &c.Monitor.UseRealDataOnly, note: realData},
+		{key: "network.dns_snooping", value: &c.Network.DNSSnooping, restart: true},
+		{key: "network.monitor_ports", value: &c.Network.MonitorPorts, check: func() error {
+			for _, port := range c.Network.MonitorPorts {
+				if port < 1 || port > 65535 {
+					return fmt.Errorf("port %d is out of range", port)
+				}
+			}
+			return nil
+		}},
+		{key: "network.monitor_protocols", value: &c.Network.MonitorProtocols, check: func() error {
+			for i, protocol := range c.Network.MonitorProtocols {
+				c.Network.MonitorProtocols[i] = strings.ToUpper(protocol)
+				switch c.Network.MonitorProtocols[i] {
+				case "HTTP", "HTTPS", "QUIC", "TCP", "UDP":
+				default:
+					return fmt.Errorf("unknown protocol %q (expected HTTP, HTTPS, QUIC, TCP or UDP)", protocol)
+				}
+			}
+			return nil
+		}},
+		{key: "network.active_window", value: &c.Network.ActiveWindow, check: func() error {
+			return inRange(c.Network.ActiveWindow, 1, 3600)
+		}},
+		{key: "network.tcpdump_interface", value: &c.Network.TcpdumpInterface, restart: true, check: func() error {
+			if c.Network.TcpdumpInterface == "" || strings.ContainsAny(c.Network.TcpdumpInterface, " \t") {
+				return fmt.Errorf("must be a single interface name")
+			}
+			return nil
+		}},
+		{key: "network.tcpdump_packet_count", value: &c.Network.TcpdumpPacketCount, restart: true, check: func() error {
+			if c.Network.TcpdumpPacketCount < 0 {
+				return fmt.Errorf("must not be negative (0 captures continuously)")
+			}
+			return nil
+		}},
+		{key: "network.requires_sudo", value: &c.Network.RequiresSudo, restart: true},
+		{key: "network.real_connections_only", value: &c.Network.RealConnectionsOnly, note: realData},
+		{key: "network.exclude_domains", value: &c.Network.ExcludeDomains, check: func() error {
+			return normalizeNames(c.Network.ExcludeDomains, func(domain string) string {
+				return strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "*"), ".")
+			})
+		}},
+		{key: "transmission.interval_minutes", env: "ROI_AGENT_INTERVAL_MINUTES", value: &c.Transmission.IntervalMinutes, check: func() error {
+			return inRange(c.Transmission.IntervalMinutes, 1, 1440)
+		}},
+		{key: "web.host", value: &c.Web.Host, note: dashboard},
+		{key: "web.port", value: &c.Web.Port, note: dashboard, check: func() error {
+			return inRange(c.Web.Port, 1, 65535)
+		}},
+		{key: "web.auto_refresh", value: &c.Web.AutoRefresh, note: dashboard, check: func() error {
+			return inRange(c.Web.AutoRefresh, 0, 3600)
+		}},
+		{key: "security.require_accessibility", value: &c.Security.RequireAccessibility, restart: true},
+		{key: "security.require_sudo", value: &c.Security.RequireSudo, restart: true},
+		{key: "security.local_only", value: &c.Security.LocalOnly, note: dashboard},
+		{key: "privacy.exclude_apps", value: &c.Privacy.ExcludeApps, check: func() error {
+			return normalizeNames(c.Privacy.ExcludeApps, strings.TrimSpace)
+		}},
+		{key: "debug.enable_test_data", value: &c.Debug.EnableTestData, note: realData},
+		{key: "debug.log_level", value: &c.Debug.LogLevel, check: func() error {
+			c.Debug.LogLevel = strings.ToLower(c.Debug.LogLevel)
+			for _, level := range logLevels {
+				if c.Debug.LogLevel == level {
+					return nil
+				}
+			}
+			return fmt.Errorf("must be one of %s", strings.Join(logLevels, ", "))
+		}},
+	}
+}
+
+// normalizeNames rewrites every name in a list with normalize and rejects empty names
+func normalizeNames(names []string, normalize func(string) string) error {
+	for i, name := range names {
+		names[i] = normalize(name)
+		if names[i] == "" {
+			return fmt.Errorf("item %d is empty", i+1)
+		}
+	}
+	return nil
+}
+
+func inRange(value, min, max int) error {
+	if value < min || value > max {
+		return fmt.Errorf("must be between %d and %d", min, max)
+	}
+	return nil
+}
+
+// envName returns the environment variable that sets s
+func (s *setting) envName() string {
+	if s.env != "" {
+		return s.env
+	}
+	return "ROI_AGENT_" + strings.ToUpper(strings.ReplaceAll(s.key, ".", "_"))
+}
+
+// set parses raw into the setting's field. Lists are comma separated.
+func (s *setting) set(raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch value := s.value.(type) {
+	case *int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		*value = n
+	case *bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		*value = b
+	case *string:
+		*value = raw
+	case *[]int:
+		return s.setList(splitList(raw))
+	case *[]string:
+		return s.setList(splitList(raw))
+	}
+	return nil
+}
+
+// setList sets a list setting from its items
+func (s *setting) setList(items []string) error {
+	switch value := s.value.(type) {
+	case *[]int:
+		list := make([]int, 0, len(items))
+		for _, item := range items {
+			n, err := strconv.Atoi(strings.TrimSpace(item))
+			if err != nil {
+				return fmt.Errorf("invalid integer %q in list", item)
+			}
+			list = append(list, n)
+		}
+		*value = list
+	case *[]string:
+		list := make([]string, 0, len(items))
+		for _, item := range items {
+			list = append(list, strings.TrimSpace(item))
+		}
+		*value = list
+	default:
+		return fmt.Errorf("expected a single value, not a list")
+	}
+	return nil
+}
+
+func splitList(raw string) []string {
+	if raw == "" {
+		return nil
+	}
+	return strings.Split(raw, ",")
+}
+
+// String formats the setting's current value
+func (s *setting) String() string {
+	switch value := s.value.(type) {
+	case *int:
+		return strconv.Itoa(*value)
+	case *bool:
+		return strconv.FormatBool(*value)
+	case *string:
+		return *value
+	case *[]int:
+		items := make([]string, len(*value))
+		for i, n := range *value {
+			items[i] = strconv.Itoa(n)
+		}
+		return strings.Join(items, ",")
+	case *[]string:
+		return strings.Join(*value, ",")
+	}
+	return ""
+}
+
+// flagValue collects a flag's raw value so flags can be applied after the file and environment
+type flagValue struct {
+	setting *setting
+	raw     *map[string]string
+}
+
+func (f flagValue) String() string { return "" }
+
+func (f flagValue) Set(raw string) error {
+	(*f.raw)[f.setting.key] = raw
+	return nil
+}
+
+func (f flagValue) IsBoolFlag() bool {
+	_, isBool := f.setting.value.(*bool)
+	return isBool
+}
+
+// LoadConfig builds the configuration from the defaults, config.yaml, the environment
+// and the flags in args, and returns the arguments left after the flags. Every key
+// can be set with --<key>=<value>, e.g. --monitor.interval=10.
+func LoadConfig(args []string) (*Config, []string, error) {
+	c := defaultConfig()
+	c.args = args
+	settings := c.settings()
+
+	flags := flag.NewFlagSet("roi-agent", flag.ContinueOnError)
+	configPath := flags.String("config", "", "path to config.yaml")
+	flagValues := make(map[string]string)
+	for _, s := range settings {
+		usage := "sets " + s.key + " (env " + s.envName() + ")"
+		flags.Var(flagValue{setting: s, raw: &flagValues}, s.key, usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	for _, s := range settings {
+		c.origins[s.key] = "default"
+	}
+
+	// config.yaml: an explicitly named file has to exist, the search paths are optional
+	path := *configPath
+	if path == "" {
+		path = os.Getenv("ROI_AGENT_CONFIG")
+	}
+	if path == "" {
+		for _, candidate := range configSearchPaths {
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+				break
+			}
+		}
+	}
+	if path != "" {
+		if err := c.loadFile(path, settings); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var problems []string
+	for _, s := range settings {
+		if raw, exists := os.LookupEnv(s.envName()); exists && raw != "" {
+			if err := s.set(raw); err != nil {
+				problems = append(problems, fmt.Sprintf("%s (%s): %v", s.key, transmit.EnvOrigin(s.envName()), err))
+				continue
+			}
+			c.origins[s.key] = transmit.EnvOrigin(s.envName())
+		}
+	}
+	for _, s := range settings {
+		if raw, exists := flagValues[s.key]; exists {
+			if err := s.set(raw); err != nil {
+				problems = append(problems, fmt.Sprintf("%s (flag --%s): %v", s.key, s.key, err))
+				continue
+			}
+			c.origins[s.key] = "flag --" + s.key
+		}
+	}
+
+	for _, s := range settings {
+		if s.check == nil {
+			continue
+		}
+		if err := s.check(); err != nil {
+			problems = append(problems, fmt.Sprintf("%s = %s (%s): %v", s.key, s.String(), c.origins[s.key], err))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, nil, fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	return c, flags.Args(), nil
+}
+
+// loadFile applies the values in a config.yaml. Unknown keys and values of the wrong
+// type are errors naming the key and line, so typos are not silently ignored.
+func (c *Config) loadFile(path string, settings []*setting) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %v", err)
+	}
+	if absPath, err := filepath.Abs(path); err == nil {
+		path = absPath
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	c.Path = path
+	if len(root.Content) == 0 {
+		return nil // empty file
+	}
+
+	byKey := make(map[string]*setting, len(settings))
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+
+	document := root.Content[0]
+	if document.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s:%d: expected sections such as monitor: and network:", path, document.Line)
+	}
+
+	var problems []string
+	for i := 0; i+1 < len(document.Content); i += 2 {
+		sectionName, section := document.Content[i], document.Content[i+1]
+		if section.Kind != yaml.MappingNode {
+			problems = append(problems, fmt.Sprintf("%s:%d: %s: expected a section of keys", path, sectionName.Line, sectionName.Value))
+			continue
+		}
+
+		for j := 0; j+1 < len(section.Content); j += 2 {
+			keyNode, valueNode := section.Content[j], section.Content[j+1]
+			key := sectionName.Value + "." + keyNode.Value
+
+			s, known := byKey[key]
+			if !known {
+				problems = append(problems, fmt.Sprintf("%s:%d: unknown key %s", path, keyNode.Line, key))
+				continue
+			}
+
+			var err error
+			switch valueNode.Kind {
+			case yaml.ScalarNode:
+				if _, isList := s.value.(*[]int); isList {
+					err = fmt.Errorf("expected a list such as [80, 443]")
+				} else if _, isList := s.value.(*[]string); isList {
+					err = fmt.Errorf("expected a list such as [\"HTTP\", \"HTTPS\"]")
+				} else {
+					err = s.set(valueNode.Value)
+				}
+			case yaml.SequenceNode:
+				items := make([]string, 0, len(valueNode.Content))
+				for _, item := range valueNode.Content {
+					items = append(items, item.Value)
+				}
+				err = s.setList(items)
+			default:
+				err = fmt.Errorf("unsupported value")
+			}
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s:%d: %s: %v", path, valueNode.Line, key, err))
+				continue
+			}
+			c.origins[key] = fmt.Sprintf("%s:%d", filepath.Base(path), valueNode.Line)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Show prints every effective value and where it came from
+func (c *Config) Show() {
+	if c.Path != "" {
+		fmt.Printf("Config file: %s\n\n", c.Path)
+	} else {
+		fmt.Printf("Config file: none found (searched %s)\n\n", strings.Join(configSearchPaths, ", "))
+	}
+
+	for _, s := range c.settings() {
+		line := fmt.Sprintf("%-32s %-28s %s", s.key, s.String(), c.origins[s.key])
+		if s.note != "" {
+			line += " (" + s.note + ")"
+		}
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+}
+
+// changedKeys lists the settings of other whose values differ from c
+func (c *Config) changedKeys(other *Config) []*setting {
+	var changed []*setting
+	otherSettings := other.settings()
+	for i, s := range c.settings() {
+		if s.String() != otherSettings[i].String() {
+			changed = append(changed, otherSettings[i])
+		}
+	}
+	return changed
+}
+
+// monitorsPort reports whether connections on port are recorded
+func (c *Config) monitorsPort(port int) bool {
+	if len(c.Network.MonitorPorts) == 0 {
+		return true
+	}
+	for _, monitored := range c.Network.MonitorPorts {
+		if port == monitored {
+			return true
+		}
+	}
+	return false
+}
+
+// monitorsProtocol reports whether connections using protocol are recorded
+func (c *Config) monitorsProtocol(protocol string) bool {
+	if len(c.Network.MonitorProtocols) == 0 {
+		return true
+	}
+	for _, monitored := range c.Network.MonitorProtocols {
+		if strings.EqualFold(protocol, monitored) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordsDomain reports whether a domain may be recorded, i.e. it is not in
+// network.exclude_domains and is not a subdomain of one
+func (c *Config) recordsDomain(domain string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for _, excluded := range c.Network.ExcludeDomains {
+		if domain == excluded || strings.HasSuffix(domain, "."+excluded) {
+			return false
+		}
+	}
+	return true
+}
+
+// recordsApp reports whether an app may be recorded, i.e. it is not in privacy.exclude_apps
+func (c *Config) recordsApp(app string) bool {
+	for _, excluded := range c.Privacy.ExcludeApps {
+		if strings.EqualFold(app, excluded) {
+			return false
+		}
+	}
+	return true
+}
+
+// tcpdumpCommand returns the command line that captures DNS traffic and connection
+// starts. tcpdump writes the raw packets in pcap format to stdout, flushing after each
+// one, and the agent decodes them.
+func (c *Config) tcpdumpCommand() []string {
+	args := []string{"tcpdump", "-i", c.Network.TcpdumpInterface, "-U", "-w", "-"}
+	if c.Network.TcpdumpPacketCount > 0 {
+		args = append(args, "-c", strconv.Itoa(c.Network.TcpdumpPacketCount))
+	}
+	// Options must come before the filter expression
+	args = append(args, captureFilter)
+	if c.Network.RequiresSudo {
+		args = append([]string{"sudo"}, args...)
+	}
+	return args
+}
+
+// logLevel is the index of debug.log_level in logLevels. It changes when the
+// configuration is reloaded, so it is accessed atomically.
+var logLevel int32 = 1
+
+// setLogLevel applies debug.log_level
+func setLogLevel(level string) {
+	for i, name := range logLevels {
+		if name == level {
+			atomic.StoreInt32(&logLevel, int32(i))
+		}
+	}
+}
+
+// debugf logs detail that is only useful when debugging, such as every DNS query
+func debugf(format string, args ...interface{}) {
+	if atomic.LoadInt32(&logLevel) <= 0 {
+		log.Printf(format, args...)
+	}
+}
+
+// infof logs routine progress such as the per-tick updates
+func infof(format string, args ...interface{}) {
+	if atomic.LoadInt32(&logLevel) <= 1 {
+		log.Printf(format, args...)
+	}
+}
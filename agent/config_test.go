@@ -0,0 +1,177 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a config.yaml into a temporary directory and returns its path
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfig(t, `monitor:
+  interval: 20
+  max_gap: 120
+network:
+  monitor_ports: [443, 8443]
+transmission:
+  interval_minutes: 5
+`)
+	t.Setenv("ROI_AGENT_MONITOR_INTERVAL", "25")
+	t.Setenv("ROI_AGENT_INTERVAL_MINUTES", "7")
+
+	config, args, err := LoadConfig([]string{"--config", path, "--monitor.interval=30", "status"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 1 || args[0] != "status" {
+		t.Errorf("remaining arguments = %v, want [status]", args)
+	}
+
+	tests := []struct {
+		key, value, origin string
+	}{
+		{"monitor.interval", "30", "flag --monitor.interval"},
+		{"transmission.interval_minutes", "7", "env ROI_AGENT_INTERVAL_MINUTES"},
+		{"monitor.max_gap", "120", "config.yaml:3"},
+		{"network.monitor_ports", "443,8443", "config.yaml:5"},
+		{"web.port", "5002", "default"},
+	}
+	values := make(map[string]string)
+	for _, s := range config.settings() {
+		values[s.key] = s.String()
+	}
+	for _, tt := range tests {
+		if values[tt.key] != tt.value || config.origins[tt.key] != tt.origin {
+			t.Errorf("%s = %s from %s, want %s from %s", tt.key, values[tt.key], config.origins[tt.key], tt.value, tt.origin)
+		}
+	}
+	if config.Path != path {
+		t.Errorf("Path = %s, want %s", config.Path, path)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		env  map[string]string
+		args []string
+		err  string
+	}{
+		{name: "unknown key", yaml: "monitor:\n  intervall: 10\n", err: "config.yaml:2: unknown key monitor.intervall"},
+		{name: "wrong type", yaml: "monitor:\n  interval: often\n", err: `monitor.interval: invalid integer "often"`},
+		{name: "scalar for a list", yaml: "network:\n  monitor_ports: 443\n", err: "network.monitor_ports: expected a list"},
+		{name: "not a section", yaml: "monitor: 15\n", err: "monitor: expected a section of keys"},
+		{name: "out of range", yaml: "web:\n  port: 70000\n", err: "web.port = 70000 (config.yaml:2): must be between 1 and 65535"},
+		{name: "depends on another key", yaml: "monitor:\n  interval: 60\n", err: "monitor.max_gap = 60 (default): must be more than monitor.interval (60)"},
+		{name: "unknown protocol", yaml: "network:\n  monitor_protocols: [HTTP, FTP]\n", err: `unknown protocol "FTP"`},
+		{name: "invalid environment value", env: map[string]string{"ROI_AGENT_MONITOR_INTERVAL": "x"},
+			err: `monitor.interval (env ROI_AGENT_MONITOR_INTERVAL): invalid integer "x"`},
+		{name: "invalid flag", args: []string{"--debug.log_level=verbose"},
+			err: "debug.log_level = verbose (flag --debug.log_level): must be one of debug, info, warn, error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			args := append([]string{"--config", writeConfig(t, tt.yaml)}, tt.args...)
+			if _, _, err := LoadConfig(args); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("LoadConfig() err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	if _, _, err := LoadConfig([]string{"--config", filepath.Join(t.TempDir(), "missing.yaml")}); err == nil {
+		t.Error("LoadConfig() accepted a --config file that does not exist")
+	}
+}
+
+func TestConfigFilters(t *testing.T) {
+	config, _, err := LoadConfig([]string{"--config", writeConfig(t, `network:
+  exclude_domains: ["*.Example.com", "tracker.net."]
+privacy:
+  exclude_apps: [" 1Password "]
+`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for domain, recorded := range map[string]bool{
+		"example.com": false, "www.example.com": false, "notexample.com": true, "Tracker.net": true, "apple.com": true,
+	} {
+		if config.recordsDomain(domain) != recorded {
+			t.Errorf("recordsDomain(%s) = %v, want %v", domain, !recorded, recorded)
+		}
+	}
+	if config.recordsApp("1password") || !config.recordsApp("Safari") {
+		t.Error("recordsApp() does not match privacy.exclude_apps case-insensitively")
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	path := writeConfig(t, "transmission:\n  interval_minutes: 10\n")
+	config, _, err := LoadConfig([]string{"--config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newTestAgent(t)
+	a.config, a.startConfig = config, config
+	a.activeDomains["ads.example.com:443"] = &NetworkConnection{Domain: "ads.example.com", Port: 443}
+	a.activeDomains["apple.com:443"] = &NetworkConnection{Domain: "apple.com", Port: 443}
+	a.configStamps = a.configFileStamps()
+
+	// Changes apply at once, except the ones that need a restart
+	if err := ioutil.WriteFile(path, []byte(`transmission:
+  interval_minutes: 5
+network:
+  exclude_domains: [example.com]
+  tcpdump_interface: en0
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !a.configFilesChanged() || a.configFilesChanged() {
+		t.Error("configFilesChanged() did not report the edited config.yaml once")
+	}
+	a.reloadConfig("test")
+	if a.currentConfig() == config || a.transmissionInterval != 5*time.Minute || a.sender == nil {
+		t.Errorf("after reloading: interval %v, sender %v", a.transmissionInterval, a.sender)
+	}
+	if _, exists := a.activeDomains["ads.example.com:443"]; exists || len(a.activeDomains) != 1 {
+		t.Errorf("connections after excluding example.com = %v", a.activeDomains)
+	}
+	status := a.configStatus()
+	if pending, _ := status["pending_restart"].([]string); len(pending) != 1 || pending[0] != "network.tcpdump_interface" {
+		t.Errorf("config status = %v, want tcpdump_interface pending a restart", status)
+	}
+
+	// An invalid file is rejected and reported, keeping the configuration in effect
+	reloaded := a.currentConfig()
+	if err := ioutil.WriteFile(path, []byte("transmission:\n  interval_minutes: 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a.reloadConfig("test")
+	status = a.configStatus()
+	if a.currentConfig() != reloaded || a.transmissionInterval != 5*time.Minute {
+		t.Error("an invalid configuration replaced the one in effect")
+	}
+	if message, _ := status["error"].(string); !strings.Contains(message, "transmission.interval_minutes") {
+		t.Errorf("config status error = %q, want the invalid key", message)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+)
+
+// Commands accepted on the control socket
+var controlCommands = []string{"status", "pause", "resume", "flush", "reload", "dump-state"}
+
+// controlTimeout bounds a control request; flush waits for the transmission to finish
+const (
+	controlTimeout      = 10 * time.Second
+	controlFlushTimeout = 3 * time.Minute
+)
+
+// controlRequest is the JSON line a client sends to the control socket
+type controlRequest struct {
+	Command string `json:"command"`
+}
+
+// controlResponse is the JSON line the agent answers with
+type controlResponse struct {
+	OK     bool        `json:"ok"`
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// controlCall is a request waiting to be handled by the main loop, which owns the agent's state
+type controlCall struct {
+	command string
+	reply   chan controlResponse
+}
+
+// controlSocketPath returns ~/.roiagent/control/agent.sock
+func controlSocketPath() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".roiagent", "control", "agent.sock")
+}
+
+// listenControl creates the control socket, readable and writable only by the user
+// running the agent. The socket is created in a directory only that user can open,
+// so nobody else can connect before its own permissions are set. A socket left
+// behind by an agent that is gone is replaced.
+func listenControl(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return nil, err
+	}
+	chownToUser(dir)
+
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another agent is already listening on %s", path)
+	}
+	os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	chownToUser(path)
+	return listener, nil
+}
+
+// serveControl accepts control connections until the listener is closed
+func (a *Agent) serveControl(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("Control socket error: %v", err)
+			}
+			return
+		}
+		go a.handleControlConn(conn)
+	}
+}
+
+// handleControlConn answers the single request sent on conn
+func (a *Agent) handleControlConn(conn net.Conn) {
+	defer a.recoverPanic("control-socket")
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlFlushTimeout))
+
+	var request controlRequest
+	if err := json.NewDecoder(conn).Decode(&request); err != nil {
+		json.NewEncoder(conn).Encode(controlResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+
+	if !isControlCommand(request.Command) {
+		json.NewEncoder(conn).Encode(controlResponse{Error: fmt.Sprintf("unknown command %q", request.Command)})
+		return
+	}
+
+	call := controlCall{command: request.Command, reply: make(chan controlResponse, 1)}
+	select {
+	case a.controlCalls <- call:
+	case <-time.After(controlTimeout):
+		json.NewEncoder(conn).Encode(controlResponse{Error: "the agent is busy, try again"})
+		return
+	}
+	json.NewEncoder(conn).Encode(<-call.reply)
+}
+
+// handleControl runs a control command on the main loop. Flush answers once its
+// transmission has finished, the other commands answer right away.
+func (a *Agent) handleControl(call controlCall) {
+	respond := func(result interface{}, err error) {
+		if err != nil {
+			call.reply <- controlResponse{Error: err.Error()}
+			return
+		}
+		call.reply <- controlResponse{OK: true, Result: result}
+	}
+	defer func() {
+		if value := recover(); value != nil {
+			a.recordCrash("control", value, debug.Stack())
+			respond(nil, fmt.Errorf("%s failed: %v", call.command, value))
+		}
+	}()
+
+	switch call.command {
+	case "status":
+		respond(a.Status(), nil)
+	case "pause":
+		respond(a.pause(), nil)
+	case "resume":
+		respond(a.resume(), nil)
+	case "reload":
+		a.configFilesChanged()
+		a.reloadConfig("control socket")
+		respond(a.configStatus(), nil)
+	case "dump-state":
+		respond(a.dumpState(), nil)
+	case "flush":
+		a.update()
+		saved := a.lastUpdate
+		started := a.transmit(func(outcome TransmitOutcome) {
+			if outcome.Err != nil {
+				respond(nil, outcome.Err)
+				return
+			}
+			respond(map[string]interface{}{
+				"saved":   saved,
+				"queued":  len(outcome.Result.Queued),
+				"sent":    outcome.Result.Flush.Sent,
+				"pending": outcome.Result.Flush.Pending,
+			}, outcome.Result.Flush.SendErr)
+		})
+		if !started {
+			respond(nil, fmt.Errorf("a data transmission is already running"))
+		}
+	}
+}
+
+// pause stops recording after saving the usage up to now. Nothing is recorded
+// until resume, and the paused time is recorded as a suspended period.
+func (a *Agent) pause() map[string]interface{} {
+	if pausedAt := a.timeline.PausedAt(); !pausedAt.IsZero() {
+		return map[string]interface{}{"paused": true, "paused_at": pausedAt}
+	}
+
+	a.update()
+	a.timeline.Pause(time.Now())
+
+	a.domainMutex.Lock()
+	a.activeDomains = make(map[string]*NetworkConnection)
+	a.pendingLookups = make(map[string]*DNSResolution)
+	a.domainMutex.Unlock()
+
+	log.Println("Recording paused")
+	return map[string]interface{}{"paused": true, "paused_at": a.timeline.PausedAt()}
+}
+
+// resume starts recording again after pause
+func (a *Agent) resume() map[string]interface{} {
+	if a.timeline.PausedAt().IsZero() {
+		return map[string]interface{}{"paused": false}
+	}
+
+	now := time.Now().Round(0)
+	a.timeline.Resume(now)
+	a.lastNetworkSample = now
+	a.update()
+	return map[string]interface{}{"paused": false}
+}
+
+// dumpState returns the agent's in-memory state for debugging. It runs on the main
+// loop; the day data is encoded here because the main loop keeps writing to it
+// while the reply is sent.
+func (a *Agent) dumpState() map[string]interface{} {
+	combinedData, err := json.Marshal(a.combinedData)
+	if err != nil {
+		combinedData, _ = json.Marshal(fmt.Sprintf("cannot encode the day data: %v", err))
+	}
+
+	a.domainMutex.RLock()
+	activeDomains := make(map[string]NetworkConnection, len(a.activeDomains))
+	for key, conn := range a.activeDomains {
+		activeDomains[key] = *conn
+	}
+	a.domainMutex.RUnlock()
+
+	config := a.currentConfig()
+	settings := make(map[string]string)
+	for _, s := range config.settings() {
+		settings[s.key] = fmt.Sprintf("%s (%s)", s.String(), config.origins[s.key])
+	}
+
+	a.transmitMutex.Lock()
+	lastTransmit := a.lastTransmit
+	a.transmitMutex.Unlock()
+	transmission := map[string]interface{}{
+		"last_triggered": a.lastTransmission,
+		"interval":       a.transmissionInterval.String(),
+	}
+	if !lastTransmit.At.IsZero() {
+		transmission["last_outcome"] = lastTransmit.At
+		if lastTransmit.Err != nil {
+			transmission["last_error"] = lastTransmit.Err.Error()
+		}
+	}
+
+	return map[string]interface{}{
+		"pid":                 os.Getpid(),
+		"combined_data":       json.RawMessage(combinedData),
+		"active_domains":      activeDomains,
+		"timeline":            a.timeline.State(),
+		"focus_carry":         durationStrings(a.focusCarry),
+		"foreground_carry":    durationStrings(a.foregroundCarry),
+		"network_carry":       durationStrings(a.networkCarry),
+		"last_network_sample": a.lastNetworkSample,
+		"last_update":         a.lastUpdate,
+		"config":              settings,
+		"transmission":        transmission,
+	}
+}
+
+// durationStrings formats the carried sub-second remainders for dump-state
+func durationStrings(carry map[string]time.Duration) map[string]string {
+	formatted := make(map[string]string, len(carry))
+	for key, d := range carry {
+		if d > 0 {
+			formatted[key] = d.String()
+		}
+	}
+	return formatted
+}
+
+// sendControl sends a command to the running agent and returns its result
+func sendControl(command string) (interface{}, error) {
+	path := controlSocketPath()
+	conn, err := net.DialTimeout("unix", path, 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("the agent is not running (no control socket at %s)", path)
+	}
+	defer conn.Close()
+
+	timeout := controlTimeout
+	if command == "flush" {
+		timeout = controlFlushTimeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if err := json.NewEncoder(conn).Encode(controlRequest{Command: command}); err != nil {
+		return nil, fmt.Errorf("error sending %s: %v", command, err)
+	}
+	var response controlResponse
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error reading the agent's response: %v", err)
+	}
+	if !response.OK {
+		return nil, errors.New(response.Error)
+	}
+	return response.Result, nil
+}
+
+// runControlCommand implements the control CLI: status, pause, resume, flush, reload and dump-state
+func runControlCommand(command string) int {
+	result, err := sendControl(command)
+	if err != nil {
+		if command == "status" {
+			// Keep the JSON shape callers such as the dashboard expect
+			data, _ := json.MarshalIndent(map[string]interface{}{"running": false, "error": err.Error()}, "", "  ")
+			fmt.Println(string(data))
+		} else {
+			fmt.Fprintf(os.Stderr, "❌ %s failed: %v\n", command, err)
+		}
+		return 1
+	}
+
+	data, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(data))
+	return 0
+}
+
+// isControlCommand reports whether command is sent to the running agent
+func isControlCommand(command string) bool {
+	for _, known := range controlCommands {
+		if command == known {
+			return true
+		}
+	}
+	return false
+}
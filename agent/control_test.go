@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"roi-agent-schema"
+)
+
+// serveTestControl serves the control socket of a test agent, running the calls the
+// way the main loop does
+func serveTestControl(t *testing.T, a *Agent) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	listener, err := listenControl(controlSocketPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.controlCalls = make(chan controlCall)
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		listener.Close()
+		close(done)
+	})
+	go a.serveControl(listener)
+	go func() {
+		for {
+			select {
+			case call := <-a.controlCalls:
+				a.handleControl(call)
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+func TestControlSocket(t *testing.T) {
+	a := newTestAgent(t)
+	a.initCombinedData("2026-10-16")
+	serveTestControl(t, a)
+
+	path := controlSocketPath()
+	if runtime.GOOS != "windows" {
+		for file, want := range map[string]os.FileMode{filepath.Dir(path): 0700, path: 0600} {
+			if info, err := os.Stat(file); err != nil || info.Mode().Perm() != want {
+				t.Errorf("%s mode = %v, want %v (%v)", file, info.Mode().Perm(), want, err)
+			}
+		}
+	}
+
+	result, err := sendControl("dump-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, _ := result.(map[string]interface{})
+	if pid, _ := state["pid"].(float64); int(pid) != os.Getpid() {
+		t.Errorf("dump-state = %v, want this process's state", result)
+	}
+
+	if _, err := sendControl("shutdown"); err == nil || !strings.Contains(err.Error(), `unknown command "shutdown"`) {
+		t.Errorf("sendControl(shutdown) err = %v, want an unknown command", err)
+	}
+
+	// A second agent cannot take over the socket while this one is listening
+	if listener, err := listenControl(path); err == nil {
+		listener.Close()
+		t.Error("listenControl() replaced the socket of a running agent")
+	}
+}
+
+func TestControlPauseResume(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("pause and resume ask System Events for the running apps")
+	}
+	a := newTestAgent(t)
+	a.initCombinedData(dateOf(time.Now()))
+	serveTestControl(t, a)
+
+	result, err := sendControl("pause")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paused, _ := result.(map[string]interface{})["paused"].(bool); !paused || a.timeline.PausedAt().IsZero() {
+		t.Errorf("pause = %v, want recording paused", result)
+	}
+
+	result, err = sendControl("resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paused, _ := result.(map[string]interface{})["paused"].(bool); paused || !a.timeline.PausedAt().IsZero() {
+		t.Errorf("resume = %v, want recording resumed", result)
+	}
+
+	// The paused time is in the event log as a suspension
+	var paused, resumed bool
+	for _, event := range readEvents(t, a.dataDir, dateOf(a.timeline.State().LastSample)) {
+		paused = paused || event.Type == EventSuspend && event.Reason == schema.SuspendPaused
+		resumed = resumed || event.Type == EventResume && event.Reason == schema.SuspendPaused
+	}
+	if !paused || !resumed {
+		t.Errorf("event log has the pause %v and the resume %v, want both", paused, resumed)
+	}
+}
+
+func TestDumpStateIsASnapshot(t *testing.T) {
+	a := newTestAgent(t)
+	a.initCombinedData("2026-10-16")
+	state := a.dumpState()
+
+	// The main loop keeps recording while the reply is being sent
+	addFocus(a, "Safari", 60)
+	data, _ := json.Marshal(state)
+	if strings.Contains(string(data), "Safari") {
+		t.Error("dump-state shares the day data that is still being recorded")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// daySpan is the part of a sampled period that falls on one local calendar day, and
+// within one transmission interval if it was split by interval
+type daySpan struct {
+	Date  string
+	Start time.Time
+	End   time.Time
+}
+
+// dateOf returns the local calendar date of t in the format used by the day files
+func dateOf(t time.Time) string {
+	return t.In(time.Local).Format("2006-01-02")
+}
+
+// nextMidnight returns the start of the local day after t. time.Date normalizes the
+// day, so days that are 23 or 25 hours long around a DST change end at the right instant.
+func nextMidnight(t time.Time) time.Time {
+	year, month, day := t.In(time.Local).Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, time.Local)
+}
+
+// splitByDay splits [start, end) at local midnight so each part can be credited
+// to the day file it belongs to
+func splitByDay(start, end time.Time) []daySpan {
+	return splitByInterval(start, end, 0)
+}
+
+// splitByInterval splits [start, end) at local midnight and at the boundaries of the
+// transmission intervals of the given length, so each part is credited to one day file
+// and its sample to the interval it was recorded in. A length of 0 splits by day only.
+func splitByInterval(start, end time.Time, length time.Duration) []daySpan {
+	var spans []daySpan
+	for start.Before(end) {
+		boundary := nextMidnight(start)
+		if length > 0 {
+			if next := intervalStart(start, length).Add(length); next.Before(boundary) {
+				boundary = next
+			}
+		}
+		if boundary.After(end) {
+			boundary = end
+		}
+		spans = append(spans, daySpan{Date: dateOf(start), Start: start, End: boundary})
+		start = boundary
+	}
+	return spans
+}
+
+// intervalStart returns the start of the transmission interval containing t. Intervals
+// start at local midnight and every length after it, matching the data-sender's alignment.
+func intervalStart(t time.Time, length time.Duration) time.Time {
+	year, month, day := t.In(time.Local).Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+	return midnight.Add(t.Sub(midnight) / length * length)
+}
+
+// usageDates returns the dates of the collected app usage and network spans in
+// chronological order
+func usageDates(usage map[string]*DayUsage, spans []daySpan) []string {
+	seen := make(map[string]bool)
+	var dates []string
+	for date := range usage {
+		seen[date] = true
+		dates = append(dates, date)
+	}
+	for _, span := range spans {
+		if !seen[span.Date] {
+			seen[span.Date] = true
+			dates = append(dates, span.Date)
+		}
+	}
+	sort.Strings(dates)
+	return dates
+}
+
+// switchDay makes date the current day, saving the current day file first.
+// Usage that crosses midnight is credited to both days this way.
+func (a *Agent) switchDay(date string) {
+	if a.combinedData.Date == date {
+		return
+	}
+
+	log.Printf("Switching day file from %s to %s", a.combinedData.Date, date)
+	a.saveCombinedData()
+	a.initCombinedData(date)
+	a.cleanupOldDayFiles()
+}
+
+// dayFilePrefixes are the per-day files in the data directory, named <prefix>YYYY-MM-DD
+var dayFilePrefixes = []string{"combined_", "events_", "samples_"}
+
+// cleanupOldDayFiles removes day files (and their corrupt backups) older than
+// monitor.data_retention_days. A retention of 0 keeps everything. A day with intervals
+// that were never transmitted is kept, since a backfill sends them from its samples.
+func (a *Agent) cleanupOldDayFiles() {
+	days := a.currentConfig().Monitor.DataRetentionDays
+	if days <= 0 {
+		return
+	}
+	a.transmitMutex.Lock()
+	sender := a.sender
+	a.transmitMutex.Unlock()
+	now := time.Now()
+	cutoff := dateOf(time.Date(now.Year(), now.Month(), now.Day()-days, 12, 0, 0, 0, time.Local))
+
+	entries, err := ioutil.ReadDir(a.dataDir)
+	if err != nil {
+		log.Printf("Error reading data directory for cleanup: %v", err)
+		return
+	}
+
+	removed := 0
+	transmitted := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		for _, prefix := range dayFilePrefixes {
+			name := entry.Name()
+			if !strings.HasPrefix(name, prefix) || len(name) < len(prefix)+10 {
+				continue
+			}
+			date := name[len(prefix) : len(prefix)+10]
+			if _, err := time.Parse("2006-01-02", date); err != nil || date >= cutoff {
+				continue
+			}
+			if _, checked := transmitted[date]; !checked {
+				transmitted[date] = sender == nil || sender.DayTransmitted(date)
+			}
+			if !transmitted[date] {
+				continue
+			}
+			if err := os.Remove(filepath.Join(a.dataDir, name)); err != nil {
+				log.Printf("Error removing old day file %s: %v", name, err)
+				continue
+			}
+			removed++
+		}
+	}
+	if removed > 0 {
+		log.Printf("Removed %d day files older than %d days", removed, days)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitByDay(t *testing.T) {
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2026, 10, day, hour, min, 0, 0, time.Local)
+	}
+	tests := []struct {
+		name       string
+		start, end time.Time
+		want       []daySpan
+	}{
+		{"same day", at(15, 10, 0), at(15, 10, 5), []daySpan{{"2026-10-15", at(15, 10, 0), at(15, 10, 5)}}},
+		{"empty", at(15, 10, 0), at(15, 10, 0), nil},
+		{"across midnight", at(15, 23, 58), at(16, 0, 3), []daySpan{
+			{"2026-10-15", at(15, 23, 58), at(16, 0, 0)},
+			{"2026-10-16", at(16, 0, 0), at(16, 0, 3)},
+		}},
+		{"ends at midnight", at(15, 23, 55), at(16, 0, 0), []daySpan{{"2026-10-15", at(15, 23, 55), at(16, 0, 0)}}},
+		{"several days", at(14, 23, 0), at(16, 1, 0), []daySpan{
+			{"2026-10-14", at(14, 23, 0), at(15, 0, 0)},
+			{"2026-10-15", at(15, 0, 0), at(16, 0, 0)},
+			{"2026-10-16", at(16, 0, 0), at(16, 1, 0)},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitByDay(tt.start, tt.end)
+			if len(got) != len(tt.want) {
+				t.Fatalf("splitByDay() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].Date != tt.want[i].Date || !got[i].Start.Equal(tt.want[i].Start) || !got[i].End.Equal(tt.want[i].End) {
+					t.Errorf("span %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSplitByInterval(t *testing.T) {
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2026, 10, day, hour, min, 0, 0, time.Local)
+	}
+	tests := []struct {
+		name       string
+		start, end time.Time
+		length     time.Duration
+		want       []daySpan
+	}{
+		{"within an interval", at(15, 10, 1), at(15, 10, 9), 10 * time.Minute, []daySpan{{"2026-10-15", at(15, 10, 1), at(15, 10, 9)}}},
+		{"across a boundary", at(15, 10, 8), at(15, 10, 13), 10 * time.Minute, []daySpan{
+			{"2026-10-15", at(15, 10, 8), at(15, 10, 10)},
+			{"2026-10-15", at(15, 10, 10), at(15, 10, 13)},
+		}},
+		// The day's last 7-minute interval starts at 23:55 and is cut short at midnight
+		{"across midnight", at(15, 23, 50), at(16, 0, 5), 7 * time.Minute, []daySpan{
+			{"2026-10-15", at(15, 23, 50), at(15, 23, 55)},
+			{"2026-10-15", at(15, 23, 55), at(16, 0, 0)},
+			{"2026-10-16", at(16, 0, 0), at(16, 0, 5)},
+		}},
+		{"by day only", at(15, 10, 8), at(15, 10, 13), 0, []daySpan{{"2026-10-15", at(15, 10, 8), at(15, 10, 13)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitByInterval(tt.start, tt.end, tt.length)
+			if len(got) != len(tt.want) {
+				t.Fatalf("splitByInterval() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].Date != tt.want[i].Date || !got[i].Start.Equal(tt.want[i].Start) || !got[i].End.Equal(tt.want[i].End) {
+					t.Errorf("span %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestIntervalStart(t *testing.T) {
+	at := func(hour, min, sec int) time.Time {
+		return time.Date(2026, 10, 16, hour, min, sec, 0, time.Local)
+	}
+	tests := []struct {
+		t      time.Time
+		length time.Duration
+		want   time.Time
+	}{
+		{at(10, 7, 30), 10 * time.Minute, at(10, 0, 0)},
+		{at(10, 10, 0), 10 * time.Minute, at(10, 10, 0)},
+		{at(0, 0, 0), 10 * time.Minute, at(0, 0, 0)},
+		{at(23, 59, 59), 10 * time.Minute, at(23, 50, 0)},
+		{at(10, 7, 30), time.Hour, at(10, 0, 0)},
+		// Intervals restart at midnight even when the length does not divide the day
+		{at(0, 5, 0), 7 * time.Minute, at(0, 0, 0)},
+	}
+
+	for _, tt := range tests {
+		if got := intervalStart(tt.t, tt.length); !got.Equal(tt.want) {
+			t.Errorf("intervalStart(%v, %v) = %v, want %v", tt.t, tt.length, got, tt.want)
+		}
+	}
+}
+
+func TestCreditUsageAcrossMidnight(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	a := newTestAgent(t)
+	a.initCombinedData("2026-10-15")
+	midnight := time.Date(2026, 10, 16, 0, 0, 0, 0, time.Local)
+	before, after := midnight.Add(-2*time.Minute), midnight.Add(3*time.Minute)
+	a.activeDomains["example.com:443"] = &NetworkConnection{
+		Domain: "example.com", Port: 443, FirstSeen: before, LastSeen: after,
+	}
+
+	appUsage := map[string]*DayUsage{
+		"2026-10-15": {Start: before, End: midnight, Periods: []*PeriodUsage{
+			{Start: before, End: midnight, Focus: map[string]time.Duration{"Safari": 2 * time.Minute}},
+		}},
+		"2026-10-16": {Start: midnight, End: after, Periods: []*PeriodUsage{
+			{Start: midnight, End: after, Focus: map[string]time.Duration{"Safari": 3 * time.Minute}},
+		}},
+	}
+	a.creditUsage(appUsage, splitByDay(before, after))
+	a.saveCombinedData()
+
+	if switches := strings.Count(logs.String(), "Switching day file"); switches != 1 {
+		t.Errorf("switched day files %d times, want once", switches)
+	}
+	for date, wantFocus := range map[string]int64{"2026-10-15": 120, "2026-10-16": 180} {
+		data, err := a.loadCombinedData(date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := midnight
+		if date == "2026-10-15" {
+			start = before
+		}
+
+		// Each day's file counts its own share, seen from that day's start
+		app := data.Apps["Safari"]
+		if app == nil || app.FocusTime != wantFocus || !app.FirstSeen.Equal(start) {
+			t.Errorf("%s Safari = %+v, want %d seconds first seen at %v", date, app, wantFocus, start)
+		}
+		conn := data.Network["example.com:443"]
+		if conn == nil || conn.Duration != wantFocus || !conn.FirstSeen.Equal(start) {
+			t.Errorf("%s example.com = %+v, want %d seconds first seen at %v", date, conn, wantFocus, start)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// dnsPort is the port DNS queries are sent to
+const dnsPort = 53
+
+// DNSType is a DNS record type, written by name in JSON
+type DNSType uint16
+
+// DNS record types the agent looks at
+const (
+	DNSTypeA     DNSType = 1
+	DNSTypeCNAME DNSType = 5
+	DNSTypeAAAA  DNSType = 28
+)
+
+var dnsTypeNames = map[DNSType]string{
+	1: "A", 2: "NS", 5: "CNAME", 6: "SOA", 12: "PTR", 15: "MX", 16: "TXT",
+	28: "AAAA", 33: "SRV", 64: "SVCB", 65: "HTTPS", 255: "ANY",
+}
+
+func (t DNSType) String() string {
+	if name, exists := dnsTypeNames[t]; exists {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", uint16(t))
+}
+
+func (t DNSType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// dnsRCodeNames are the response codes by value
+var dnsRCodeNames = []string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED"}
+
+// DNSRecord is one DNS query or response seen in the capture
+type DNSRecord struct {
+	Time      time.Time   `json:"time"`
+	ID        uint16      `json:"id"`
+	Response  bool        `json:"response"`
+	QType     DNSType     `json:"qtype"`
+	QName     string      `json:"qname"`
+	RCode     string      `json:"rcode,omitempty"` // responses only
+	Client    net.IP      `json:"client"`
+	Resolver  net.IP      `json:"resolver"`
+	Transport string      `json:"transport"`
+	Answers   []DNSAnswer `json:"answers,omitempty"` // A, AAAA and CNAME answers of a response
+}
+
+// DNSAnswer is one A, AAAA or CNAME record in the answer section of a response
+type DNSAnswer struct {
+	Name string  `json:"name"`
+	Type DNSType `json:"type"`
+	TTL  uint32  `json:"ttl"`
+	Data string  `json:"data"` // the address, or the CNAME target
+}
+
+// String formats the record as one line for replay
+func (r DNSRecord) String() string {
+	kind := "query"
+	if r.Response {
+		kind = "response " + r.RCode
+	}
+	line := fmt.Sprintf("%s %-17s id=%-5d %-5s %s client=%s resolver=%s %s",
+		r.Time.Local().Format("15:04:05.000"), kind, r.ID, r.QType, r.QName, r.Client, r.Resolver, r.Transport)
+	for _, answer := range r.Answers {
+		line += fmt.Sprintf("\n    %s %s %s ttl=%d", answer.Name, answer.Type, answer.Data, answer.TTL)
+	}
+	return line
+}
+
+// dnsMessage is the header, first question and answers of a DNS message
+type dnsMessage struct {
+	ID       uint16
+	Response bool
+	RCode    int
+	QName    string
+	QType    DNSType
+	Answers  []DNSAnswer
+}
+
+// dnsRecordFromPacket returns the DNS message carried by a packet to or from port 53,
+// or nil if the packet does not carry one
+func dnsRecordFromPacket(at time.Time, p *packet) (*DNSRecord, error) {
+	if p.SrcPort != dnsPort && p.DstPort != dnsPort {
+		return nil, nil
+	}
+
+	payload := p.Payload
+	if p.Transport == "tcp" {
+		// DNS over TCP prefixes each message with its length. Segments without data
+		// (handshakes and ACKs) and messages split across segments are skipped.
+		if len(payload) < 2 {
+			return nil, nil
+		}
+		length := int(binary.BigEndian.Uint16(payload))
+		if length > len(payload)-2 {
+			return nil, errors.New("DNS message split across TCP segments")
+		}
+		payload = payload[2 : 2+length]
+	}
+
+	message, err := parseDNSMessage(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &DNSRecord{
+		Time:      at,
+		ID:        message.ID,
+		Response:  message.Response,
+		QType:     message.QType,
+		QName:     message.QName,
+		Answers:   message.Answers,
+		Client:    p.Src,
+		Resolver:  p.Dst,
+		Transport: p.Transport,
+	}
+	if message.Response {
+		record.Client, record.Resolver = p.Dst, p.Src
+		record.RCode = fmt.Sprintf("RCODE%d", message.RCode)
+		if message.RCode < len(dnsRCodeNames) {
+			record.RCode = dnsRCodeNames[message.RCode]
+		}
+	}
+	return record, nil
+}
+
+// parseDNSMessage parses the header, the first question and the answers of a DNS message in wire format
+func parseDNSMessage(data []byte) (*dnsMessage, error) {
+	if len(data) < 12 {
+		return nil, errors.New("truncated DNS header")
+	}
+
+	flags := binary.BigEndian.Uint16(data[2:])
+	message := &dnsMessage{
+		ID:       binary.BigEndian.Uint16(data[0:]),
+		Response: flags&0x8000 != 0,
+		RCode:    int(flags & 0x000f),
+	}
+	if opcode := (flags >> 11) & 0x0f; opcode != 0 {
+		return nil, fmt.Errorf("DNS opcode %d is not a query", opcode)
+	}
+	questions := int(binary.BigEndian.Uint16(data[4:]))
+	answers := int(binary.BigEndian.Uint16(data[6:]))
+	if questions == 0 {
+		return nil, errors.New("DNS message without a question")
+	}
+
+	offset := 12
+	for i := 0; i < questions; i++ {
+		name, next, err := readDNSName(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < next+4 {
+			return nil, errors.New("truncated DNS question")
+		}
+		if i == 0 {
+			message.QName = name
+			message.QType = DNSType(binary.BigEndian.Uint16(data[next:]))
+		}
+		offset = next + 4
+	}
+
+	if !message.Response {
+		return message, nil
+	}
+	for i := 0; i < answers; i++ {
+		name, next, err := readDNSName(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < next+10 {
+			return nil, errors.New("truncated DNS answer")
+		}
+		answer := DNSAnswer{
+			Name: name,
+			Type: DNSType(binary.BigEndian.Uint16(data[next:])),
+			TTL:  binary.BigEndian.Uint32(data[next+4:]),
+		}
+		class := binary.BigEndian.Uint16(data[next+2:])
+		rdata := next + 10
+		offset = rdata + int(binary.BigEndian.Uint16(data[next+8:]))
+		if offset > len(data) {
+			return nil, errors.New("truncated DNS answer data")
+		}
+		if class != 1 {
+			continue
+		}
+
+		switch answer.Type {
+		case DNSTypeA, DNSTypeAAAA:
+			size := offset - rdata
+			if (answer.Type == DNSTypeA && size != net.IPv4len) || (answer.Type == DNSTypeAAAA && size != net.IPv6len) {
+				return nil, fmt.Errorf("invalid %s answer of %d bytes", answer.Type, size)
+			}
+			answer.Data = net.IP(data[rdata:offset]).String()
+		case DNSTypeCNAME:
+			target, _, err := readDNSName(data[:offset], rdata)
+			if err != nil {
+				return nil, err
+			}
+			answer.Data = target
+		default:
+			continue
+		}
+		message.Answers = append(message.Answers, answer)
+	}
+	return message, nil
+}
+
+// readDNSName reads a possibly compressed name at offset. It returns the name in lower
+// case without the trailing dot, and the offset just after the name.
+func readDNSName(data []byte, offset int) (string, int, error) {
+	var name strings.Builder
+	end := -1 // where the name ends in the message, before following any pointer
+	jumps := 0
+
+	for {
+		if offset >= len(data) {
+			return "", 0, errors.New("truncated DNS name")
+		}
+		length := int(data[offset])
+
+		switch length & 0xc0 {
+		case 0xc0:
+			// Compression pointer to an earlier name
+			if offset+1 >= len(data) {
+				return "", 0, errors.New("truncated DNS name pointer")
+			}
+			if jumps++; jumps > 32 {
+				return "", 0, errors.New("DNS name pointer loop")
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(data[offset:]) & 0x3fff)
+			continue
+		case 0x00:
+		default:
+			return "", 0, fmt.Errorf("unsupported DNS label type %#x", length&0xc0)
+		}
+
+		offset++
+		if length == 0 {
+			break
+		}
+		if offset+length > len(data) {
+			return "", 0, errors.New("truncated DNS label")
+		}
+		if name.Len() > 0 {
+			name.WriteByte('.')
+		}
+		// Dots and unprintable bytes inside a label are escaped as in zone files
+		for _, c := range data[offset : offset+length] {
+			if c <= ' ' || c > '~' || c == '.' || c == '\\' {
+				fmt.Fprintf(&name, "\\%03d", c)
+			} else {
+				name.WriteByte(c)
+			}
+		}
+		if name.Len() > 1024 {
+			return "", 0, errors.New("DNS name too long")
+		}
+		offset += length
+	}
+
+	if end < 0 {
+		end = offset
+	}
+	return strings.ToLower(name.String()), end, nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadDNSName(t *testing.T) {
+	// A message whose question is www.example.org at offset 12, followed by names
+	// that point into it
+	message := make([]byte, 12)
+	message = append(message, dnsNameBytes("www.example.org")...) // 12..28
+	pointerOnly := len(message)
+	message = append(message, 0xc0, 12) // -> www.example.org
+	labelThenPointer := len(message)
+	message = append(message, 3, 'c', 'd', 'n')
+	message = append(message, 0xc0, 16) // -> example.org
+	pointerChain := len(message)
+	message = append(message, 0xc0, byte(labelThenPointer)) // -> cdn.example.org
+	mixedCase := len(message)
+	message = append(message, 3, 'W', 'W', 'W', 0)
+	escaped := len(message)
+	message = append(message, 3, 'a', '.', 0x01, 0)
+
+	tests := []struct {
+		name   string
+		offset int
+		want   string
+		end    int
+	}{
+		{"uncompressed", 12, "www.example.org", 29},
+		{"pointer", pointerOnly, "www.example.org", pointerOnly + 2},
+		{"label then pointer", labelThenPointer, "cdn.example.org", labelThenPointer + 6},
+		{"pointer to a pointer", pointerChain, "cdn.example.org", pointerChain + 2},
+		{"lower case", mixedCase, "www", mixedCase + 5},
+		{"escaped bytes", escaped, `a\046\001`, escaped + 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, end, err := readDNSName(message, tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != tt.want || end != tt.end {
+				t.Errorf("readDNSName() = %q, %d; want %q, %d", name, end, tt.want, tt.end)
+			}
+		})
+	}
+}
+
+func TestReadDNSNameErrors(t *testing.T) {
+	header := make([]byte, 12)
+	withHeader := func(name ...byte) []byte {
+		return append(append([]byte(nil), header...), name...)
+	}
+	long := withHeader()
+	for i := 0; i < 20; i++ {
+		long = append(long, 63)
+		long = append(long, strings.Repeat("a", 63)...)
+	}
+	long = append(long, 0)
+
+	tests := []struct {
+		name    string
+		message []byte
+		err     string
+	}{
+		{"pointer to itself", withHeader(0xc0, 12), "pointer loop"},
+		{"pointers to each other", withHeader(0xc0, 14, 0xc0, 12), "pointer loop"},
+		{"label then loop", withHeader(1, 'a', 0xc0, 12), "pointer loop"},
+		{"truncated pointer", withHeader(0xc0), "truncated DNS name pointer"},
+		{"pointer past the end", withHeader(0xc0, 200), "truncated DNS name"},
+		{"truncated label", withHeader(5, 'a', 'b'), "truncated DNS label"},
+		{"missing terminator", withHeader(1, 'a'), "truncated DNS name"},
+		{"extended label type", withHeader(0x41, 0), "unsupported DNS label type"},
+		{"too long", long, "DNS name too long"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := readDNSName(tt.message, 12); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseDNSMessage(t *testing.T) {
+	response := dnsResponse(0x1234, "www.example.org", DNSTypeA,
+		DNSAnswer{Name: "www.example.org", Type: DNSTypeCNAME, TTL: 300, Data: "edge.cdn.example.net"},
+		DNSAnswer{Name: "edge.cdn.example.net", Type: DNSTypeA, TTL: 60, Data: "93.184.216.34"},
+		DNSAnswer{Name: "edge.cdn.example.net", Type: DNSTypeAAAA, TTL: 60, Data: "2606:2800:220:1::248"},
+	)
+
+	message, err := parseDNSMessage(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &dnsMessage{
+		ID:       0x1234,
+		Response: true,
+		QName:    "www.example.org",
+		QType:    DNSTypeA,
+		Answers: []DNSAnswer{
+			{Name: "www.example.org", Type: DNSTypeCNAME, TTL: 300, Data: "edge.cdn.example.net"},
+			{Name: "edge.cdn.example.net", Type: DNSTypeA, TTL: 60, Data: "93.184.216.34"},
+			{Name: "edge.cdn.example.net", Type: DNSTypeAAAA, TTL: 60, Data: "2606:2800:220:1::248"},
+		},
+	}
+	if !reflect.DeepEqual(message, want) {
+		t.Errorf("parseDNSMessage() = %+v, want %+v", message, want)
+	}
+}
+
+func TestParseDNSMessageCompressedAnswers(t *testing.T) {
+	// As resolvers send it: the answers name the question and the CNAME target by pointer
+	message := dnsQuery(9, "www.example.org", DNSTypeA)
+	binary.BigEndian.PutUint16(message[2:], 0x8180)
+	binary.BigEndian.PutUint16(message[6:], 2)
+	target := len(message) + 12
+	message = append(message, 0xc0, 12, 0, 5, 0, 1, 0, 0, 0, 30, 0, 6)
+	message = append(message, 3, 'c', 'd', 'n', 0xc0, 16) // cdn.example.org
+	message = append(message, 0xc0, byte(target), 0, 1, 0, 1, 0, 0, 0, 20, 0, 4, 198, 51, 100, 7)
+
+	parsed, err := parseDNSMessage(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DNSAnswer{
+		{Name: "www.example.org", Type: DNSTypeCNAME, TTL: 30, Data: "cdn.example.org"},
+		{Name: "cdn.example.org", Type: DNSTypeA, TTL: 20, Data: "198.51.100.7"},
+	}
+	if !reflect.DeepEqual(parsed.Answers, want) {
+		t.Errorf("answers = %+v, want %+v", parsed.Answers, want)
+	}
+}
+
+func TestParseDNSMessageSkipsOtherRecords(t *testing.T) {
+	response := dnsResponse(1, "example.org", DNSTypeA,
+		DNSAnswer{Name: "example.org", Type: 16, TTL: 60, Data: "txt"}, // TXT
+		DNSAnswer{Name: "example.org", Type: DNSTypeA, TTL: 60, Data: "192.0.2.1"},
+	)
+	message, err := parseDNSMessage(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(message.Answers) != 1 || message.Answers[0].Data != "192.0.2.1" {
+		t.Errorf("answers = %+v", message.Answers)
+	}
+}
+
+func TestParseDNSMessageErrors(t *testing.T) {
+	response := dnsResponse(1, "example.org", DNSTypeA, DNSAnswer{Name: "example.org", Type: DNSTypeA, TTL: 60, Data: "192.0.2.1"})
+	query := dnsQuery(1, "example.org", DNSTypeA)
+	noQuestion := append([]byte(nil), query[:12]...)
+	binary.BigEndian.PutUint16(noQuestion[4:], 0)
+	notQuery := append([]byte(nil), query...)
+	binary.BigEndian.PutUint16(notQuery[2:], 5<<11) // UPDATE
+	badA := append([]byte(nil), response...)
+	binary.BigEndian.PutUint16(badA[len(badA)-6:], 3)
+	badA = badA[:len(badA)-1]
+	loop := append([]byte(nil), response[:len(query)]...)
+	loop = append(loop, 0xc0, byte(len(query)), 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 192, 0, 2, 1)
+
+	tests := []struct {
+		name    string
+		message []byte
+		err     string
+	}{
+		{"truncated header", query[:11], "truncated DNS header"},
+		{"no question", noQuestion, "without a question"},
+		{"not a query", notQuery, "is not a query"},
+		{"truncated question", query[:len(query)-2], "truncated DNS question"},
+		{"truncated answer header", response[:len(response)-8], "truncated DNS answer"},
+		{"truncated answer data", response[:len(response)-2], "truncated DNS answer data"},
+		{"A of the wrong size", badA, "invalid A answer of 3 bytes"},
+		{"answer name loop", loop, "pointer loop"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseDNSMessage(tt.message); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDNSRecordFromPacket(t *testing.T) {
+	at := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+	client, resolver := net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1")
+	response := dnsResponse(42, "example.org", DNSTypeA, DNSAnswer{Name: "example.org", Type: DNSTypeA, TTL: 60, Data: "192.0.2.1"})
+	nxdomain := dnsResponse(43, "nope.example.org", DNSTypeA)
+	binary.BigEndian.PutUint16(nxdomain[2:], 0x8183)
+	withLength := func(message []byte) []byte {
+		return append([]byte{byte(len(message) >> 8), byte(len(message))}, message...)
+	}
+
+	tests := []struct {
+		name    string
+		p       *packet
+		want    *DNSRecord
+		wantErr string
+	}{
+		{
+			name: "UDP query",
+			p:    &packet{Src: client, Dst: resolver, Transport: "udp", SrcPort: 51000, DstPort: 53, Payload: dnsQuery(41, "example.org", DNSTypeAAAA)},
+			want: &DNSRecord{Time: at, ID: 41, QType: DNSTypeAAAA, QName: "example.org", Client: client, Resolver: resolver, Transport: "udp"},
+		},
+		{
+			name: "UDP response",
+			p:    &packet{Src: resolver, Dst: client, Transport: "udp", SrcPort: 53, DstPort: 51000, Payload: response},
+			want: &DNSRecord{Time: at, ID: 42, Response: true, QType: DNSTypeA, QName: "example.org", RCode: "NOERROR",
+				Client: client, Resolver: resolver, Transport: "udp",
+				Answers: []DNSAnswer{{Name: "example.org", Type: DNSTypeA, TTL: 60, Data: "192.0.2.1"}}},
+		},
+		{
+			name: "TCP response with a length prefix",
+			p:    &packet{Src: resolver, Dst: client, Transport: "tcp", SrcPort: 53, DstPort: 51000, Payload: withLength(response)},
+			want: &DNSRecord{Time: at, ID: 42, Response: true, QType: DNSTypeA, QName: "example.org", RCode: "NOERROR",
+				Client: client, Resolver: resolver, Transport: "tcp",
+				Answers: []DNSAnswer{{Name: "example.org", Type: DNSTypeA, TTL: 60, Data: "192.0.2.1"}}},
+		},
+		{
+			name: "TCP segment with data after the message",
+			p:    &packet{Src: client, Dst: resolver, Transport: "tcp", SrcPort: 51000, DstPort: 53, Payload: append(withLength(dnsQuery(5, "example.org", DNSTypeA)), 0, 9)},
+			want: &DNSRecord{Time: at, ID: 5, QType: DNSTypeA, QName: "example.org", Client: client, Resolver: resolver, Transport: "tcp"},
+		},
+		{
+			name: "NXDOMAIN",
+			p:    &packet{Src: resolver, Dst: client, Transport: "udp", SrcPort: 53, DstPort: 51000, Payload: nxdomain},
+			want: &DNSRecord{Time: at, ID: 43, Response: true, QType: DNSTypeA, QName: "nope.example.org", RCode: "NXDOMAIN",
+				Client: client, Resolver: resolver, Transport: "udp"},
+		},
+		{
+			name: "TCP handshake",
+			p:    &packet{Src: client, Dst: resolver, Transport: "tcp", SrcPort: 51000, DstPort: 53, TCPFlags: tcpSYN},
+		},
+		{
+			name:    "TCP message split across segments",
+			p:       &packet{Src: resolver, Dst: client, Transport: "tcp", SrcPort: 53, DstPort: 51000, Payload: withLength(response)[:20]},
+			wantErr: "split across TCP segments",
+		},
+		{
+			name: "not DNS",
+			p:    &packet{Src: client, Dst: resolver, Transport: "udp", SrcPort: 51000, DstPort: 123, Payload: []byte{1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := dnsRecordFromPacket(at, tt.p)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(record, tt.want) {
+				t.Errorf("dnsRecordFromPacket() = %+v, want %+v", record, tt.want)
+			}
+		})
+	}
+}
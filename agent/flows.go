@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"roi-agent-schema"
+)
+
+// flowWaitWindow is how long a lookup waits for the connection it was made for. A lookup
+// that no connection follows is recorded with a port guessed from the domain name.
+const flowWaitWindow = 10 * time.Second
+
+// flowStart reports whether a packet opens a connection: a TCP SYN, or a QUIC
+// long-header packet sent to UDP port 443
+func flowStart(p *packet) bool {
+	switch p.Transport {
+	case "tcp":
+		return p.TCPFlags&0x12 == 0x02 // SYN without ACK
+	case "udp":
+		return p.DstPort == 443 && len(p.Payload) > 0 && p.Payload[0]&0x80 != 0
+	}
+	return false
+}
+
+// flowProtocol names the protocol of a connection by its transport and destination port
+func flowProtocol(transport string, port int) string {
+	switch {
+	case transport == "udp" && port == 443:
+		return "QUIC"
+	case transport == "udp":
+		return "UDP"
+	case port == 443:
+		return "HTTPS"
+	case port == 80:
+		return "HTTP"
+	}
+	return "TCP"
+}
+
+// processFlow records the connection a packet opens to an address from a DNS answer,
+// with its real port and protocol. It returns false if the address was not looked up.
+func (a *Agent) processFlow(at time.Time, p *packet) bool {
+	chain, resolution := a.dnsCache.lookupAddress(p.Dst.String(), at)
+	if chain == nil {
+		return false
+	}
+	if !a.timeline.PausedAt().IsZero() {
+		return true
+	}
+
+	fqdn := chain[0]
+	port := int(p.DstPort)
+	state := "SYN_SENT"
+	if p.Transport == "udp" {
+		state = "QUIC_INITIAL"
+	}
+
+	a.domainMutex.Lock()
+	defer a.domainMutex.Unlock()
+	delete(a.pendingLookups, fqdn)
+	conn := a.recordConnection(NetworkConnection{
+		Domain:          fqdn,
+		Port:            port,
+		Protocol:        flowProtocol(p.Transport, port),
+		LastSeen:        at,
+		ConnectionState: state,
+		PortSource:      schema.PortObserved,
+		DomainSource:    schema.DomainFromDNS,
+		DNS:             resolution,
+	})
+	a.findOwner(conn, p)
+	return true
+}
+
+// processServerName records the connection a TLS ClientHello or QUIC Initial packet
+// opens, named by the server name in it, if the client's lookup of the address was not
+// seen. That is the case when DNS is encrypted or sent through a VPN.
+func (a *Agent) processServerName(at time.Time, p *packet, name, source string) {
+	if chain, _ := a.dnsCache.lookupAddress(p.Dst.String(), at); chain != nil {
+		return // named by DNS when the connection started
+	}
+	if !a.timeline.PausedAt().IsZero() {
+		return
+	}
+
+	port := int(p.DstPort)
+	state := "TLS_CLIENT_HELLO"
+	if source == schema.DomainFromQUIC {
+		state = "QUIC_INITIAL"
+	}
+
+	a.domainMutex.Lock()
+	defer a.domainMutex.Unlock()
+	conn := a.recordConnection(NetworkConnection{
+		Domain:          name,
+		Port:            port,
+		Protocol:        flowProtocol(p.Transport, port),
+		LastSeen:        at,
+		ConnectionState: state,
+		PortSource:      schema.PortObserved,
+		DomainSource:    source,
+	})
+	a.findOwner(conn, p)
+}
+
+// findOwner attributes conn, opened by packet p, to the process that opened it. PKTAP
+// captures on macOS name the process; otherwise its socket is looked up. The caller
+// holds domainMutex.
+func (a *Agent) findOwner(conn *NetworkConnection, p *packet) {
+	if conn == nil {
+		return
+	}
+	if p.Owner != nil {
+		a.attributeConnection(conn, *p.Owner)
+	} else {
+		a.queueOwnerLookup(socketKey{Transport: p.Transport, Port: p.SrcPort}, conn)
+	}
+}
+
+// resolvePendingLookups records the lookups that no connection followed within
+// flowWaitWindow. Clients look names up again while keeping their connections open,
+// so a lookup of a domain that already has connections refreshes them. Otherwise the
+// connection is recorded with a port guessed from the domain name.
+func (a *Agent) resolvePendingLookups(now time.Time) {
+	a.domainMutex.Lock()
+	defer a.domainMutex.Unlock()
+
+	for fqdn, resolution := range a.pendingLookups {
+		at := resolution.ResolvedAt
+		if now.Sub(at) < flowWaitWindow {
+			continue
+		}
+		delete(a.pendingLookups, fqdn)
+
+		refreshed := false
+		for _, conn := range a.activeDomains {
+			if conn.Domain != fqdn {
+				continue
+			}
+			if at.After(conn.LastSeen) {
+				conn.LastSeen = at
+				conn.DNS = resolution
+			}
+			conn.IsActive = true
+			refreshed = true
+		}
+		if refreshed {
+			continue
+		}
+
+		// Infer port based on common patterns
+		port := a.inferPortFromFQDN(fqdn)
+		protocol := "HTTP"
+		if port == 443 {
+			protocol = "HTTPS"
+		}
+		a.recordConnection(NetworkConnection{
+			Domain:          fqdn,
+			Port:            port,
+			Protocol:        protocol,
+			LastSeen:        at,
+			ConnectionState: "DNS_RESOLVED",
+			PortSource:      schema.PortGuessed,
+			DomainSource:    schema.DomainFromDNS,
+			DNS:             resolution,
+		})
+	}
+}
+
+// recordConnection records activity on the connection observed at observed.LastSeen,
+// and returns the connection or nil if it is not recorded. An observed port and
+// protocol replace guessed ones. The caller holds domainMutex.
+func (a *Agent) recordConnection(observed NetworkConnection) *NetworkConnection {
+	config := a.currentConfig()
+	if !a.isValidFQDN(observed.Domain) || !config.monitorsPort(observed.Port) ||
+		!config.monitorsProtocol(observed.Protocol) || !config.recordsDomain(observed.Domain) {
+		return nil
+	}
+
+	at := observed.LastSeen
+	key := fmt.Sprintf("%s:%d", observed.Domain, observed.Port)
+	if conn, exists := a.activeDomains[key]; exists {
+		// Update existing connection
+		if at.After(conn.LastSeen) {
+			conn.LastSeen = at
+			if observed.DNS != nil {
+				conn.DNS = observed.DNS
+			}
+		}
+		if observed.PortSource == schema.PortObserved {
+			conn.Protocol = observed.Protocol
+			conn.PortSource = observed.PortSource
+			conn.ConnectionState = observed.ConnectionState
+		}
+		conn.IsActive = true
+		return conn
+	}
+
+	// Add new connection
+	conn := &observed
+	conn.FirstSeen = at
+	conn.IsActive = true
+	conn.AppName = unknownApp // until the process that made it is found
+	a.activeDomains[key] = conn
+	debugf("Connection detected: %s:%d (%s, port %s, name from %s)",
+		conn.Domain, conn.Port, conn.Protocol, conn.PortSource, conn.DomainSource)
+	return conn
+}
+
+// processTraffic handles a captured packet that is not DNS: the start of a connection,
+// and the server name in a TLS ClientHello or QUIC Initial packet
+func (a *Agent) processTraffic(at time.Time, p *packet, stats *captureStats) {
+	if flowStart(p) {
+		stats.Flows++
+		if !a.processFlow(at, p) {
+			stats.Unmatched++
+		}
+	}
+
+	var name, source string
+	var err error
+	switch {
+	case p.Transport == "tcp" && len(p.Payload) > 0 && p.Payload[0] == tlsRecordHandshake:
+		source = schema.DomainFromTLS
+		name, err = tlsServerName(p.Payload)
+	case p.Transport == "udp" && p.DstPort == 443 && len(p.Payload) > 0 && p.Payload[0]&0x80 != 0:
+		source = schema.DomainFromQUIC
+		name, err = a.quicHellos.serverName(at, p)
+	default:
+		return
+	}
+	if err != nil {
+		debugf("No server name in %s packet to %s: %v", source, p.Dst, err)
+		return
+	}
+	if name == "" {
+		return
+	}
+
+	if source == schema.DomainFromTLS {
+		stats.TLSHellos++
+	} else {
+		stats.QUICHellos++
+	}
+	a.sawTraffic(source, at)
+	a.processServerName(at, p, name, source)
+}
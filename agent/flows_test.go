@@ -0,0 +1,186 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"roi-agent-schema"
+)
+
+// lookup feeds the agent a response resolving name to addresses
+func lookup(agent *Agent, at time.Time, name string, addresses ...string) {
+	record := DNSRecord{Time: at, Response: true, RCode: "NOERROR", QType: DNSTypeA, QName: name}
+	for _, ip := range addresses {
+		record.Answers = append(record.Answers, address(name, ip, 300))
+	}
+	agent.processDNSRecord(record)
+}
+
+// syn is the first packet of a TCP connection to dst
+func syn(dst string, port uint16) *packet {
+	return &packet{Src: net.ParseIP("192.168.1.20"), Dst: net.ParseIP(dst), Transport: "tcp", SrcPort: 52000, DstPort: port, TCPFlags: tcpSYN}
+}
+
+func TestFlowStart(t *testing.T) {
+	tests := []struct {
+		name string
+		p    *packet
+		want bool
+	}{
+		{"TCP SYN", &packet{Transport: "tcp", TCPFlags: tcpSYN}, true},
+		{"TCP SYN-ACK", &packet{Transport: "tcp", TCPFlags: tcpSYN | tcpACK}, false},
+		{"TCP data", &packet{Transport: "tcp", TCPFlags: tcpACK | tcpPSH}, false},
+		{"QUIC long header", &packet{Transport: "udp", DstPort: 443, Payload: []byte{0xc3}}, true},
+		{"QUIC short header", &packet{Transport: "udp", DstPort: 443, Payload: []byte{0x43}}, false},
+		{"UDP to another port", &packet{Transport: "udp", DstPort: 4433, Payload: []byte{0xc3}}, false},
+		{"empty UDP", &packet{Transport: "udp", DstPort: 443}, false},
+	}
+	for _, tt := range tests {
+		if got := flowStart(tt.p); got != tt.want {
+			t.Errorf("%s: flowStart() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFlowProtocol(t *testing.T) {
+	tests := []struct {
+		transport string
+		port      int
+		want      string
+	}{
+		{"tcp", 443, "HTTPS"},
+		{"tcp", 80, "HTTP"},
+		{"tcp", 8080, "TCP"},
+		{"udp", 443, "QUIC"},
+		{"udp", 5000, "UDP"},
+	}
+	for _, tt := range tests {
+		if got := flowProtocol(tt.transport, tt.port); got != tt.want {
+			t.Errorf("flowProtocol(%s, %d) = %s, want %s", tt.transport, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestProcessFlowObservesPort(t *testing.T) {
+	at := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+	agent := newReplayAgent(defaultConfig())
+	lookup(agent, at, "www.example.org", "192.0.2.1")
+
+	if !agent.processFlow(at.Add(flowWaitWindow/2), syn("192.0.2.1", 8080)) {
+		t.Fatal("connection to a looked up address was not matched")
+	}
+	agent.resolvePendingLookups(at.Add(2 * flowWaitWindow))
+
+	conn := agent.activeDomains["www.example.org:8080"]
+	if conn == nil {
+		t.Fatalf("connections = %v, want www.example.org:8080", agent.activeDomains)
+	}
+	if conn.PortSource != schema.PortObserved || conn.Protocol != "TCP" || conn.ConnectionState != "SYN_SENT" {
+		t.Errorf("connection = %+v, want an observed TCP port", conn)
+	}
+	if len(agent.activeDomains) != 1 || len(agent.pendingLookups) != 0 {
+		t.Errorf("connections = %v, pending = %v; want only the observed one", agent.activeDomains, agent.pendingLookups)
+	}
+}
+
+func TestProcessFlowQUIC(t *testing.T) {
+	at := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+	agent := newReplayAgent(defaultConfig())
+	lookup(agent, at, "www.example.org", "2001:db8::1")
+
+	initial := &packet{Src: net.ParseIP("fd00::20"), Dst: net.ParseIP("2001:db8::1"), Transport: "udp", SrcPort: 60000, DstPort: 443, Payload: []byte{0xc3}}
+	agent.processFlow(at.Add(time.Second), initial)
+
+	conn := agent.activeDomains["www.example.org:443"]
+	if conn == nil || conn.Protocol != "QUIC" || conn.ConnectionState != "QUIC_INITIAL" || conn.PortSource != schema.PortObserved {
+		t.Errorf("connection = %+v, want an observed QUIC connection", conn)
+	}
+}
+
+func TestProcessFlowUnknownAddress(t *testing.T) {
+	agent := newReplayAgent(defaultConfig())
+	if agent.processFlow(time.Now(), syn("203.0.113.9", 443)) {
+		t.Error("connection to an address that was not looked up was matched")
+	}
+	if len(agent.activeDomains) != 0 {
+		t.Errorf("connections = %v, want none", agent.activeDomains)
+	}
+}
+
+func TestResolvePendingLookupsGuessesPort(t *testing.T) {
+	at := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+	agent := newReplayAgent(defaultConfig())
+	lookup(agent, at, "www.example.org", "192.0.2.1")
+
+	agent.resolvePendingLookups(at.Add(flowWaitWindow - time.Millisecond))
+	if len(agent.activeDomains) != 0 {
+		t.Fatalf("lookup recorded before flowWaitWindow: %v", agent.activeDomains)
+	}
+
+	agent.resolvePendingLookups(at.Add(flowWaitWindow))
+	conn := agent.activeDomains["www.example.org:443"]
+	if conn == nil {
+		t.Fatalf("connections = %v, want www.example.org:443", agent.activeDomains)
+	}
+	if conn.PortSource != schema.PortGuessed || conn.Protocol != "HTTPS" || conn.ConnectionState != "DNS_RESOLVED" || !conn.LastSeen.Equal(at) {
+		t.Errorf("connection = %+v, want a guessed HTTPS port last seen at the lookup", conn)
+	}
+	if len(agent.pendingLookups) != 0 {
+		t.Errorf("pending = %v, want none", agent.pendingLookups)
+	}
+}
+
+func TestRecordConnectionObservedReplacesGuessed(t *testing.T) {
+	at := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+	agent := newReplayAgent(defaultConfig())
+	lookup(agent, at, "www.example.org", "192.0.2.1")
+	agent.resolvePendingLookups(at.Add(flowWaitWindow))
+
+	guessed := agent.activeDomains["www.example.org:443"]
+	if guessed == nil || guessed.PortSource != schema.PortGuessed {
+		t.Fatalf("connection = %+v, want a guessed port", guessed)
+	}
+
+	later := at.Add(time.Minute)
+	agent.processFlow(later, syn("192.0.2.1", 443))
+
+	conn := agent.activeDomains["www.example.org:443"]
+	if conn != guessed || len(agent.activeDomains) != 1 {
+		t.Fatalf("connections = %v, want the guessed connection updated", agent.activeDomains)
+	}
+	if conn.PortSource != schema.PortObserved || conn.ConnectionState != "SYN_SENT" || !conn.LastSeen.Equal(later) || !conn.FirstSeen.Equal(at) {
+		t.Errorf("connection = %+v, want observed and last seen at %v", conn, later)
+	}
+
+	// A guess never overrides an observation
+	agent.recordConnection(NetworkConnection{Domain: "www.example.org", Port: 443, Protocol: "HTTPS",
+		LastSeen: later.Add(time.Minute), ConnectionState: "DNS_RESOLVED", PortSource: schema.PortGuessed})
+	if conn.PortSource != schema.PortObserved || conn.ConnectionState != "SYN_SENT" {
+		t.Errorf("connection = %+v, want it to stay observed", conn)
+	}
+}
+
+func TestResolvePendingLookupsRefreshesConnections(t *testing.T) {
+	at := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+	agent := newReplayAgent(defaultConfig())
+	lookup(agent, at, "www.example.org", "192.0.2.1")
+	agent.processFlow(at.Add(time.Second), syn("192.0.2.1", 8080))
+	conn := agent.activeDomains["www.example.org:8080"]
+	conn.IsActive = false
+
+	// The client looks the name up again while keeping its connection open
+	again := at.Add(time.Minute)
+	lookup(agent, again, "www.example.org", "192.0.2.1")
+	agent.resolvePendingLookups(again.Add(flowWaitWindow))
+
+	if len(agent.activeDomains) != 1 {
+		t.Fatalf("connections = %v, want only www.example.org:8080", agent.activeDomains)
+	}
+	if !conn.LastSeen.Equal(again) || !conn.IsActive || conn.PortSource != schema.PortObserved {
+		t.Errorf("connection = %+v, want it refreshed at %v", conn, again)
+	}
+	if conn.DNS == nil || !conn.DNS.ResolvedAt.Equal(again) {
+		t.Errorf("resolution = %+v, want the repeat lookup", conn.DNS)
+	}
+}
@@ -1,3 +1,17 @@
 module roi-agent
 
 go 1.21
+
+require (
+	golang.org/x/sys v0.15.0
+	gopkg.in/yaml.v3 v3.0.1
+	roi-agent-data-sender v0.0.0
+	roi-agent-schema v0.0.0
+)
+
+require github.com/joho/godotenv v1.5.1 // indirect
+
+replace (
+	roi-agent-data-sender => ../data-sender
+	roi-agent-schema => ../schema
+)
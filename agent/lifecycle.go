@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Restart backoff for components that panicked: the delay doubles per crash up to the
+// maximum, and starts over once a component has run for componentHealthyAfter
+const (
+	componentRestartDelay    = time.Second
+	componentMaxRestartDelay = time.Minute
+	componentHealthyAfter    = 5 * time.Minute
+)
+
+// shutdownTransmitTimeout is how long shutdown waits for a running transmission
+const shutdownTransmitTimeout = 30 * time.Second
+
+// lockFilePath returns ~/.roiagent/agent.pid
+func lockFilePath() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".roiagent", "agent.pid")
+}
+
+// InstanceLock is the PID file that keeps a second agent from writing the same day files.
+// The lock is held on the open file, so the kernel releases it if the agent dies.
+type InstanceLock struct {
+	path string
+	file *os.File
+}
+
+// acquireInstanceLock locks the PID file and writes this process's PID into it
+func acquireInstanceLock(path string) (*InstanceLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(file); err != nil {
+		file.Close()
+		if data, readErr := ioutil.ReadFile(path); readErr == nil && len(strings.TrimSpace(string(data))) > 0 {
+			return nil, fmt.Errorf("another agent is already running (pid %s, lock file %s)", strings.TrimSpace(string(data)), path)
+		}
+		return nil, fmt.Errorf("another agent is already running (lock file %s)", path)
+	}
+
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	chownToUser(path)
+	return &InstanceLock{path: path, file: file}, nil
+}
+
+// Release removes the PID file and unlocks it
+func (l *InstanceLock) Release() {
+	os.Remove(l.path)
+	l.file.Close()
+}
+
+// chownToUser gives a file the agent created to the user who started it with sudo,
+// so the control socket and PID file stay usable without sudo
+func chownToUser(path string) {
+	uid, uidErr := strconv.Atoi(os.Getenv("SUDO_UID"))
+	gid, gidErr := strconv.Atoi(os.Getenv("SUDO_GID"))
+	if uidErr != nil || gidErr != nil || os.Geteuid() != 0 {
+		return
+	}
+	if err := os.Chown(path, uid, gid); err != nil {
+		log.Printf("Warning: Could not hand %s to the user: %v", path, err)
+	}
+}
+
+// CrashRecord counts a component's recovered panics for Status
+type CrashRecord struct {
+	Count     int       `json:"count"`
+	LastAt    time.Time `json:"last_at"`
+	LastPanic string    `json:"last_panic"`
+	Report    string    `json:"report"`
+}
+
+// crashLog collects the recovered panics of every component
+type crashLog struct {
+	mutex   sync.Mutex
+	records map[string]*CrashRecord
+}
+
+// recoverPanic turns a panic in component into a crash report. It must be deferred directly.
+func (a *Agent) recoverPanic(component string) {
+	value := recover()
+	if value == nil {
+		return
+	}
+	a.recordCrash(component, value, debug.Stack())
+}
+
+// recordCrash logs a recovered panic and writes its stack to ~/.roiagent/logs/crash_*.log
+func (a *Agent) recordCrash(component string, value interface{}, stack []byte) {
+	now := time.Now()
+	log.Printf("PANIC in %s: %v (recovered)", component, value)
+
+	homeDir, _ := os.UserHomeDir()
+	logDir := filepath.Join(homeDir, ".roiagent", "logs")
+	reportPath := filepath.Join(logDir, fmt.Sprintf("crash_%s_%s.log", now.Format("20060102-150405"), component))
+	report := fmt.Sprintf("time: %s\ncomponent: %s\npid: %d\npanic: %v\n\n%s",
+		now.Format(time.RFC3339Nano), component, os.Getpid(), value, stack)
+	os.MkdirAll(logDir, 0755)
+	if err := ioutil.WriteFile(reportPath, []byte(report), 0644); err != nil {
+		log.Printf("Error writing crash report: %v", err)
+		reportPath = ""
+	} else {
+		chownToUser(reportPath)
+		log.Printf("Crash report written to %s", reportPath)
+	}
+
+	a.crashes.mutex.Lock()
+	defer a.crashes.mutex.Unlock()
+	if a.crashes.records == nil {
+		a.crashes.records = make(map[string]*CrashRecord)
+	}
+	record, exists := a.crashes.records[component]
+	if !exists {
+		record = &CrashRecord{}
+		a.crashes.records[component] = record
+	}
+	record.Count++
+	record.LastAt = now
+	record.LastPanic = fmt.Sprint(value)
+	record.Report = reportPath
+}
+
+// crashStatus returns a copy of the crash records for Status
+func (a *Agent) crashStatus() map[string]CrashRecord {
+	a.crashes.mutex.Lock()
+	defer a.crashes.mutex.Unlock()
+	records := make(map[string]CrashRecord, len(a.crashes.records))
+	for component, record := range a.crashes.records {
+		records[component] = *record
+	}
+	return records
+}
+
+// protect runs fn, recovering from a panic in it. Handlers called for each tick, line or
+// request use it, so a panic only loses that one call.
+func (a *Agent) protect(component string, fn func()) {
+	defer a.recoverPanic(component)
+	fn()
+}
+
+// supervise runs a long-lived component on its own goroutine and restarts it with
+// backoff if it panics, until stop is closed. A component that returns normally is done.
+func (a *Agent) supervise(component string, stop <-chan struct{}, run func()) {
+	go func() {
+		delay := componentRestartDelay
+		for {
+			started := time.Now()
+			panicked := true
+			func() {
+				defer a.recoverPanic(component)
+				run()
+				panicked = false
+			}()
+			if !panicked {
+				return
+			}
+
+			if time.Since(started) > componentHealthyAfter {
+				delay = componentRestartDelay
+			}
+			log.Printf("Restarting %s in %v", component, delay)
+			select {
+			case <-stop:
+				return
+			case <-time.After(delay):
+			}
+			if delay *= 2; delay > componentMaxRestartDelay {
+				delay = componentMaxRestartDelay
+			}
+		}
+	}()
+}
+
+// shutdown saves everything recorded so far, stops capturing and waits for a running
+// transmission, so stopping the agent loses nothing
+func (a *Agent) shutdown() {
+	log.Println("Shutting down...")
+
+	// A pause ends here so the paused time is recorded
+	a.protect("shutdown", func() {
+		if !a.timeline.PausedAt().IsZero() {
+			a.resume()
+		} else {
+			a.update()
+		}
+	})
+	a.timeline.Stop(time.Now())
+	a.stopTcpdumpDNSMonitoring()
+
+	done := make(chan struct{})
+	go func() {
+		a.transmitWait.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownTransmitTimeout):
+		log.Printf("Data transmission still running after %v; it will resume from the outbox on the next start", shutdownTransmitTimeout)
+	}
+
+	log.Println("Agent stopped")
+}
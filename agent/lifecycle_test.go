@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAcquireInstanceLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roiagent", "agent.pid")
+	lock, err := acquireInstanceLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil || strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("PID file = %q, %v; want this process's PID", data, err)
+	}
+
+	// A second agent is refused and told which process holds the lock
+	if second, err := acquireInstanceLock(path); err == nil {
+		second.Release()
+		t.Fatal("acquireInstanceLock() succeeded while the lock was held")
+	} else if !strings.Contains(err.Error(), "pid "+strconv.Itoa(os.Getpid())) {
+		t.Errorf("acquireInstanceLock() err = %v, want the holder's PID", err)
+	}
+
+	// Windows does not remove a file that is still open
+	lock.Release()
+	if _, err := os.Stat(path); runtime.GOOS != "windows" && !os.IsNotExist(err) {
+		t.Error("Release() left the PID file behind")
+	}
+	again, err := acquireInstanceLock(path)
+	if err != nil {
+		t.Fatalf("acquireInstanceLock() after Release() = %v", err)
+	}
+	again.Release()
+}
+
+func TestAcquireInstanceLockStalePIDFile(t *testing.T) {
+	// A PID file left by an agent that died is not locked, so it is taken over
+	path := filepath.Join(t.TempDir(), "agent.pid")
+	if err := ioutil.WriteFile(path, []byte("999999\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := acquireInstanceLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release()
+	if data, _ := ioutil.ReadFile(path); strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("PID file = %q, want this process's PID", data)
+	}
+}
@@ -1,173 +1,291 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
-	"regexp"
-	"strconv"
+	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
-)
-
-// NetworkConnection represents a simplified network connection with FQDN
-type NetworkConnection struct {
-	Domain          string    `json:"domain"`
-	Port            int       `json:"port"`
-	Protocol        string    `json:"protocol"`
-	Duration        int64     `json:"duration"`
-	FirstSeen       time.Time `json:"first_seen"`
-	LastSeen        time.Time `json:"last_seen"`
-	IsActive        bool      `json:"is_active"`
-	AppName         string    `json:"app_name"`
-	ConnectionState string    `json:"connection_state"`
-}
-
-// AppUsage represents application usage data
-type AppUsage struct {
-	Name           string    `json:"name"`
-	ForegroundTime int64     `json:"foreground_time"`
-	BackgroundTime int64     `json:"background_time"`
-	FocusTime      int64     `json:"focus_time"`
-	LastSeen       time.Time `json:"last_seen"`
-	IsActive       bool      `json:"is_active"`
-	IsFocused      bool      `json:"is_focused"`
-}
 
-// CombinedData represents combined application and network usage data
-type CombinedData struct {
-	Date         string                        `json:"date"`
-	Apps         map[string]*AppUsage          `json:"apps"`
-	Network      map[string]*NetworkConnection `json:"network"`
-	AppTotal     struct {
-		ForegroundTime int64 `json:"foreground_time"`
-		BackgroundTime int64 `json:"background_time"`
-		FocusTime      int64 `json:"focus_time"`
-	} `json:"app_total"`
-	NetworkTotal struct {
-		TotalDuration     int64 `json:"total_duration"`
-		UniqueConnections int   `json:"unique_connections"`
-		UniqueDomains     int   `json:"unique_domains"`
-	} `json:"network_total"`
-}
-
-// loadEnvFile loads environment variables from a .env file
-func loadEnvFile(filename string) error {
-	file, err := os.Open(filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+	"roi-agent-data-sender/transmit"
+	"roi-agent-schema"
+)
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		
-		// Parse KEY=VALUE format
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) == 2 {
-			key := strings.TrimSpace(parts[0])
-			value := strings.TrimSpace(parts[1])
-			
-			// Only set if not already set (environment variables take precedence)
-			if os.Getenv(key) == "" {
-				os.Setenv(key, value)
-				log.Printf("Loaded from .env: %s=%s", key, value)
-			}
-		}
-	}
-	
-	return scanner.Err()
-}
+// The day file types are shared with the data sender and the Windows agent
+type (
+	CombinedData      = schema.DayFile
+	AppUsage          = schema.AppUsage
+	NetworkConnection = schema.NetworkConnection
+	RunningView       = schema.RunningView
+	DNSResolution     = schema.DNSResolution
+	CaptureHealth     = schema.CaptureHealth
+)
 
 // Agent represents the main monitoring agent
 type Agent struct {
+	config           *Config
+	startConfig      *Config // the configuration the agent started with
+	configMutex      sync.RWMutex
+	configStamps     map[string]string
+	configReloadedAt time.Time
+	configError      string
+	configErrorAt    time.Time
 	dataDir          string
 	combinedData     *CombinedData
+	unresumed        bool // the day file exists but could not be read yet, so it must not be overwritten
 	lastUpdate       time.Time
 	activeDomains    map[string]*NetworkConnection
 	domainMutex      sync.RWMutex
-	tcpdumpCmd       *exec.Cmd
-	tcpdumpCtx       context.Context
+	pendingLookups   map[string]*DNSResolution // answered lookups waiting for their connection
+	dnsCache         dnsCache
+	quicHellos       quicHellos
+	ownerLookups     chan ownerLookup // connections waiting for the process that made them
 	tcpdumpCancel    context.CancelFunc
+	tcpdumpDone      chan struct{}
+	capture          CaptureHealth
+	captureMutex     sync.Mutex
 	lastTransmission time.Time
 	transmissionInterval time.Duration
+	sender           *transmit.DataSender
+	transmitMutex    sync.Mutex
+	transmitting     bool
+	transmitWait     sync.WaitGroup
+	lastTransmit     TransmitOutcome
+	timeline         *Timeline
+	focusCarry       map[string]time.Duration
+	foregroundCarry  map[string]time.Duration
+	networkCarry     map[string]time.Duration
+	lastNetworkSample time.Time
+	maxGap           time.Duration
+	samples          []*UsageSample // pending until the day file is saved, one per interval
+	controlCalls     chan controlCall
+	crashes          crashLog
 }
 
 // NewAgent creates a new monitoring agent
-func NewAgent() *Agent {
+func NewAgent(config *Config) *Agent {
 	homeDir, _ := os.UserHomeDir()
 	userDataDir := filepath.Join(homeDir, ".roiagent")
 	dataDir := filepath.Join(userDataDir, "data")
 
-	// Try to load .env file first (for interval settings)
-	envPaths := []string{
-		".env",               // Current directory
-		"./data-sender/.env", // From project root
-		"../.env",            // Parent directory
-		"../data-sender/.env", // Parent/data-sender
-	}
-	
-	for _, envPath := range envPaths {
-		if _, err := os.Stat(envPath); err == nil {
-			log.Printf("Loading .env file from: %s", envPath)
-			if err := loadEnvFile(envPath); err != nil {
-				log.Printf("Warning: Failed to load .env file %s: %v", envPath, err)
-			}
-			break
-		}
-	}
-
-	// Load transmission interval from environment variable
-	intervalMinutes := 10 // default
-	if intervalStr := os.Getenv("ROI_AGENT_INTERVAL_MINUTES"); intervalStr != "" {
-		if interval, err := strconv.Atoi(intervalStr); err == nil && interval > 0 {
-			intervalMinutes = interval
-			log.Printf("Using custom transmission interval: %d minutes", interval)
-		} else {
-			log.Printf("Warning: Invalid interval value '%s', using default %d minutes", intervalStr, intervalMinutes)
-		}
-	} else {
-		log.Printf("Using default transmission interval: %d minutes", intervalMinutes)
-	}
+	log.Printf("Using transmission interval: %d minutes (%s)",
+		config.Transmission.IntervalMinutes, config.origins["transmission.interval_minutes"])
 
+	maxGap := time.Duration(config.Monitor.MaxGap) * time.Second
 	agent := &Agent{
+		config:        config,
+		startConfig:   config,
 		dataDir:       dataDir,
 		activeDomains: make(map[string]*NetworkConnection),
-		transmissionInterval: time.Duration(intervalMinutes) * time.Minute,
+		pendingLookups: make(map[string]*DNSResolution),
+		ownerLookups:   make(chan ownerLookup, ownerQueueSize),
+		transmissionInterval: time.Duration(config.Transmission.IntervalMinutes) * time.Minute,
 		lastTransmission: time.Now(),
+		sender:           transmit.NewDataSender(),
+		timeline:         NewTimeline(dataDir, maxGap, time.Duration(config.Transmission.IntervalMinutes)*time.Minute),
+		focusCarry:       make(map[string]time.Duration),
+		foregroundCarry:  make(map[string]time.Duration),
+		networkCarry:     make(map[string]time.Duration),
+		maxGap:           maxGap,
+		controlCalls:     make(chan controlCall),
 	}
 
+	// The sender aligns intervals to the same length as the agent
+	agent.sender.UseIntervalMinutes(config.Transmission.IntervalMinutes)
+
 	os.MkdirAll(agent.dataDir, 0755)
-	agent.initCombinedData()
+	agent.initCombinedData(dateOf(time.Now()))
+	agent.cleanupOldDayFiles()
+	agent.configStamps = agent.configFileStamps()
 
 	return agent
 }
 
-// initCombinedData initializes today's data (clean start but allow Web UI to read existing data)
-func (a *Agent) initCombinedData() {
-	today := time.Now().Format("2006-01-02")
+// initCombinedData initializes the data for a day, resuming from the saved day file if the agent was restarted
+func (a *Agent) initCombinedData(today string) {
+	// Usage that was never saved to the previous day file is not part of its samples either
+	a.samples = nil
 
-	// Create fresh data structure for the agent - don't load past data
-	// But allow Web UI to read the saved files
 	a.combinedData = &CombinedData{
-		Date:    today,
-		Apps:    make(map[string]*AppUsage),
-		Network: make(map[string]*NetworkConnection),
+		SchemaVersion: schema.CurrentVersion,
+		Date:          today,
+		Apps:          make(map[string]*AppUsage),
+		Network:       make(map[string]*NetworkConnection),
+	}
+
+	a.unresumed = false
+	a.resumeCombinedData()
+}
+
+// resumeCombinedData merges the saved day file into the current day's data. A corrupt
+// file is backed up and replaced; a file that cannot be read, e.g. because of its
+// permissions, is kept and read again before the next save. It reports whether the
+// current data can be saved.
+func (a *Agent) resumeCombinedData() bool {
+	date := a.combinedData.Date
+	saved, err := a.loadCombinedData(date)
+	switch {
+	case errors.Is(err, errCorruptDayFile):
+		log.Printf("Warning: Could not resume data for %s: %v", date, err)
+		a.backupCorruptDayFile(date)
+		log.Printf("Started a fresh day file for %s", date)
+	case err != nil:
+		if !a.unresumed {
+			log.Printf("Warning: Could not read the day file for %s, keeping it and retrying: %v", date, err)
+		}
+		a.unresumed = true
+		return false
+	case saved == nil:
+		log.Printf("Initialized fresh agent data for %s", date)
+	default:
+		a.reconcileSamples(saved)
+		a.mergeCombinedData(saved)
+		log.Printf("Resumed agent data for %s: %d apps, %d connections, focus %ds, network %ds",
+			date, len(a.combinedData.Apps), len(a.combinedData.Network),
+			a.combinedData.AppTotal.FocusTime, a.combinedData.NetworkTotal.TotalDuration)
+	}
+	a.unresumed = false
+	return true
+}
+
+// errCorruptDayFile is returned for a day file that exists but cannot be used: it is
+// empty, cannot be decoded or migrated, or belongs to another date
+var errCorruptDayFile = errors.New("day file is corrupt")
+
+// dayFilePath returns the path of the combined data file for the given date
+func (a *Agent) dayFilePath(date string) string {
+	return filepath.Join(a.dataDir, fmt.Sprintf("combined_%s.json", date))
+}
+
+// loadCombinedData reads a saved day file. It returns nil without error if no file exists yet.
+func (a *Agent) loadCombinedData(date string) (*CombinedData, error) {
+	data, err := ioutil.ReadFile(a.dayFilePath(date))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return nil, fmt.Errorf("%w: it is empty", errCorruptDayFile)
+	}
+
+	// Files written by older agents are upgraded to the current schema
+	saved, version, err := schema.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errCorruptDayFile, err)
+	}
+	if saved.Date != "" && saved.Date != date {
+		return nil, fmt.Errorf("%w: it is for %s, expected %s", errCorruptDayFile, saved.Date, date)
+	}
+	if version < schema.CurrentVersion {
+		log.Printf("Upgraded day file for %s from schema version %d to %d", date, version, schema.CurrentVersion)
+	}
+
+	return saved, nil
+}
+
+// backupCorruptDayFile moves an unreadable day file aside so it is kept for inspection
+// and the agent can start a new file instead of overwriting it
+func (a *Agent) backupCorruptDayFile(date string) {
+	dataFile := a.dayFilePath(date)
+	if _, err := os.Stat(dataFile); err != nil {
+		return
+	}
+
+	backupFile := fmt.Sprintf("%s.corrupt-%s", dataFile, time.Now().Format("20060102-150405"))
+	if err := os.Rename(dataFile, backupFile); err != nil {
+		log.Printf("Error backing up corrupt day file %s: %v", dataFile, err)
+		return
+	}
+	log.Printf("Backed up corrupt day file to %s", backupFile)
+
+	// The day's samples describe the counters that were lost, so set them aside too
+	sampleFile := sampleLogPath(a.dataDir, date)
+	if _, err := os.Stat(sampleFile); err == nil {
+		if err := os.Rename(sampleFile, fmt.Sprintf("%s.corrupt-%s", sampleFile, time.Now().Format("20060102-150405"))); err != nil {
+			log.Printf("Error backing up sample log %s: %v", sampleFile, err)
+		}
+	}
+}
+
+// mergeCombinedData adds saved counters into the current day's data, which already holds
+// the usage recorded since the agent started if the day file could only be read later
+func (a *Agent) mergeCombinedData(saved *CombinedData) {
+	for appName, savedApp := range saved.Apps {
+		if savedApp == nil {
+			continue
+		}
+		if savedApp.Name == "" {
+			savedApp.Name = appName
+		}
+
+		if appData, exists := a.combinedData.Apps[appName]; exists {
+			appData.ForegroundTime += savedApp.ForegroundTime
+			appData.BackgroundTime += savedApp.BackgroundTime
+			appData.FocusTime += savedApp.FocusTime
+			if !savedApp.FirstSeen.IsZero() && (appData.FirstSeen.IsZero() || savedApp.FirstSeen.Before(appData.FirstSeen)) {
+				appData.FirstSeen = savedApp.FirstSeen
+			}
+			if savedApp.LastSeen.After(appData.LastSeen) {
+				appData.LastSeen = savedApp.LastSeen
+			}
+		} else {
+			a.combinedData.Apps[appName] = savedApp
+		}
+	}
+
+	for key, savedConn := range saved.Network {
+		if savedConn == nil {
+			continue
+		}
+		savedConn.IsActive = false
+
+		if conn, exists := a.combinedData.Network[key]; exists {
+			conn.Duration += savedConn.Duration
+			if !savedConn.FirstSeen.IsZero() && (conn.FirstSeen.IsZero() || savedConn.FirstSeen.Before(conn.FirstSeen)) {
+				conn.FirstSeen = savedConn.FirstSeen
+			}
+			if savedConn.LastSeen.After(conn.LastSeen) {
+				conn.LastSeen = savedConn.LastSeen
+			}
+			for _, app := range savedConn.Apps {
+				if !containsString(conn.Apps, app) {
+					conn.Apps = append(conn.Apps, app)
+				}
+			}
+		} else {
+			a.combinedData.Network[key] = savedConn
+		}
+	}
+
+	a.combinedData.Suspended = append(saved.Suspended, a.combinedData.Suspended...)
+	if a.combinedData.Running.UpdatedAt.IsZero() {
+		a.combinedData.Running = saved.Running
 	}
-	log.Printf("Initialized fresh agent data for %s (Web UI can read saved files)", today)
+
+	a.combinedData.AppTotal.ForegroundTime += saved.AppTotal.ForegroundTime
+	a.combinedData.AppTotal.BackgroundTime += saved.AppTotal.BackgroundTime
+	a.combinedData.AppTotal.FocusTime += saved.AppTotal.FocusTime
+	a.combinedData.NetworkTotal.TotalDuration += saved.NetworkTotal.TotalDuration
+
+	domainSet := make(map[string]bool)
+	for _, conn := range a.combinedData.Network {
+		domainSet[conn.Domain] = true
+	}
+	a.combinedData.NetworkTotal.UniqueConnections = len(a.combinedData.Network)
+	a.combinedData.NetworkTotal.UniqueDomains = len(domainSet)
 }
 
 // checkAccessibilityPermissions checks if the app has accessibility permissions
@@ -243,138 +361,54 @@ func (a *Agent) getRunningApps() (map[string]bool, string, error) {
 	return apps, frontmostApp, nil
 }
 
-// startTcpdumpDNSMonitoring starts tcpdump-based DNS monitoring
-func (a *Agent) startTcpdumpDNSMonitoring() error {
-	if a.tcpdumpCmd != nil {
-		return nil // Already running
-	}
+// getFrontmostApp gets the name of the frontmost application only, which is cheap enough to poll between ticks
+func (a *Agent) getFrontmostApp() (string, error) {
+	cmd := exec.Command("osascript", "-e", `
+		tell application "System Events"
+			return name of first application process whose frontmost is true
+		end tell
+	`)
 
-	a.tcpdumpCtx, a.tcpdumpCancel = context.WithCancel(context.Background())
-	
-	// Start tcpdump to capture DNS queries
-	a.tcpdumpCmd = exec.CommandContext(a.tcpdumpCtx, "sudo", "tcpdump", "-i", "any", "port", "53", "-l", "-n", "-t")
-	
-	stdout, err := a.tcpdumpCmd.StdoutPipe()
+	output, err := cmd.Output()
 	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %v", err)
+		return "", fmt.Errorf("failed to get frontmost app: %v", err)
 	}
 
-	if err := a.tcpdumpCmd.Start(); err != nil {
-		return fmt.Errorf("failed to start tcpdump: %v", err)
-	}
-
-	log.Println("Started DNS monitoring with tcpdump")
-
-	// Start goroutine to process tcpdump output
-	go func() {
-		defer stdout.Close()
-		scanner := bufio.NewScanner(stdout)
-		
-		for scanner.Scan() {
-			line := scanner.Text()
-			a.processDNSLine(line)
-		}
-		
-		if err := scanner.Err(); err != nil {
-			log.Printf("DNS monitoring scanner error: %v", err)
-		}
-	}()
-
-	return nil
+	return strings.TrimSpace(string(output)), nil
 }
 
-// stopTcpdumpDNSMonitoring stops tcpdump monitoring
-func (a *Agent) stopTcpdumpDNSMonitoring() {
-	if a.tcpdumpCancel != nil {
-		a.tcpdumpCancel()
-		a.tcpdumpCancel = nil
+// processDNSRecord remembers the answer to an A or AAAA lookup, so that the connections
+// that follow it are attributed to the domain the client asked for
+func (a *Agent) processDNSRecord(record DNSRecord) {
+	if !record.Response || record.RCode != "NOERROR" || (record.QType != DNSTypeA && record.QType != DNSTypeAAAA) {
+		return
 	}
-	
-	if a.tcpdumpCmd != nil {
-		a.tcpdumpCmd = nil
+	resolved, addresses, ttl := resolveAnswers(record.QName, record.Answers)
+	if len(addresses) == 0 {
+		return // e.g. no AAAA records for an IPv4-only site
 	}
-	
-	log.Println("Stopped DNS monitoring")
-}
 
-// processDNSLine processes a single line from tcpdump DNS output
-func (a *Agent) processDNSLine(line string) {
-	// Parse tcpdump DNS query lines like:
-	// "14:38:50.724810 IP 192.168.0.14.49457 > cache2.itscom.jp.domain: 52389+ A? www.yahoo.co.jp. (33)"
-	// "14:38:50.723800 IP 192.168.0.14.62960 > cache2.itscom.jp.domain: 53501+ AAAA? www.yahoo.co.jp. (33)"
-	
-	fqdn, port := a.extractFQDNAndPortFromDNSQuery(line)
-	if fqdn == "" {
+	// A client that looks up a CNAME target on its own is still visiting the name it asked for first
+	chain := append(a.dnsCache.chainTo(record.QName, record.Time), resolved[1:]...)
+	resolution := &DNSResolution{Addresses: addresses, TTL: ttl, ResolvedAt: record.Time}
+	if len(chain) > 1 {
+		resolution.Chain = chain
+	}
+	a.dnsCache.add(chain, resolution)
+	if !a.timeline.PausedAt().IsZero() {
 		return
 	}
 
-	protocol := "HTTP"
-	if port == 443 {
-		protocol = "HTTPS"
+	fqdn := chain[0]
+	if !a.isValidFQDN(fqdn) || !a.currentConfig().recordsDomain(fqdn) {
+		return
 	}
 
-	key := fmt.Sprintf("%s:%d", fqdn, port)
-	currentTime := time.Now()
-
+	// The port is known once the connection is seen; until then the lookup waits
 	a.domainMutex.Lock()
 	defer a.domainMutex.Unlock()
-
-	if conn, exists := a.activeDomains[key]; exists {
-		// Update existing connection
-		conn.LastSeen = currentTime
-		conn.IsActive = true
-	} else {
-		// Add new connection
-		a.activeDomains[key] = &NetworkConnection{
-			Domain:          fqdn,
-			Port:            port,
-			Protocol:        protocol,
-			Duration:        0,
-			FirstSeen:       currentTime,
-			LastSeen:        currentTime,
-			IsActive:        true,
-			AppName:         "Unknown", // Will be determined by association with app activity
-			ConnectionState: "DNS_QUERY",
-		}
-		log.Printf("DNS Query detected: %s:%d (%s)", fqdn, port, protocol)
-	}
-}
-
-// extractFQDNAndPortFromDNSQuery extracts FQDN and inferred port from tcpdump DNS query output
-// Excludes CNAME records and only returns final A/AAAA record FQDNs
-func (a *Agent) extractFQDNAndPortFromDNSQuery(line string) (string, int) {
-	// Look for DNS query patterns
-	if !strings.Contains(line, "?") {
-		return "", 0
-	}
-	
-	// Skip CNAME queries - we only want A and AAAA records
-	if strings.Contains(line, "CNAME?") {
-		return "", 0
-	}
-	
-	// Match A or AAAA queries only (excludes CNAME)
-	patterns := []string{
-		` A\? ([a-zA-Z0-9.-]+)\.`,     // A record query
-		` AAAA\? ([a-zA-Z0-9.-]+)\.`,  // AAAA record query
-	}
-	
-	for _, pattern := range patterns {
-		re := regexp.MustCompile(pattern)
-		matches := re.FindStringSubmatch(line)
-		if len(matches) >= 2 {
-			fqdn := matches[1]
-			
-			// Validate FQDN and exclude CNAME-like patterns
-			if a.isValidFQDN(fqdn) && !a.isCNAMEPattern(fqdn) {
-				// Infer port based on common patterns
-				port := a.inferPortFromFQDN(fqdn)
-				return fqdn, port
-			}
-		}
-	}
-	
-	return "", 0
+	a.pendingLookups[fqdn] = resolution
+	debugf("DNS Query detected: %s via %s", fqdn, strings.Join(chain, " -> "))
 }
 
 // inferPortFromFQDN infers the likely port based on FQDN patterns
@@ -417,42 +451,6 @@ func (a *Agent) inferPortFromFQDN(fqdn string) int {
 	return 443
 }
 
-// isCNAMEPattern checks if a domain looks like a CNAME record
-func (a *Agent) isCNAMEPattern(domain string) bool {
-	// Common CNAME patterns to exclude
-	cnamePatterns := []string{
-		".edgekey.net",
-		".edgesuite.net",
-		".akamai.net",
-		".akamaiedge.net",
-		".cloudfront.net",
-		".elb.amazonaws.com",
-		".azureedge.net",
-		".trafficmanager.net",
-		".fastly.com",
-		".cdn",
-		"dualstack.",
-		"edge-",
-		"prod-",
-		"cache-",
-	}
-	
-	domainLower := strings.ToLower(domain)
-	for _, pattern := range cnamePatterns {
-		if strings.Contains(domainLower, pattern) {
-			return true
-		}
-	}
-	
-	// Skip domains that look like CDN or load balancer endpoints
-	if strings.Count(domain, ".") > 3 {
-		// Too many subdomains, likely a CNAME
-		return true
-	}
-	
-	return false
-}
-
 // isValidFQDN checks if a string is a valid FQDN for display (user-accessed sites only)
 func (a *Agent) isValidFQDN(domain string) bool {
 	// Basic domain validation
@@ -617,63 +615,78 @@ func (a *Agent) isValidFQDN(domain string) bool {
 	return true
 }
 
-// updateNetworkUsage updates network usage statistics
-func (a *Agent) updateNetworkUsage() {
-	interval := int64(15) // 15 seconds
-	currentTime := time.Now()
-
-	a.domainMutex.Lock()
-	defer a.domainMutex.Unlock()
-
-	// Update active connections and maintain them for longer visibility
-	activeConnections := make(map[string]*NetworkConnection)
-	domainSet := make(map[string]bool)
+// networkSpans returns the measured time since the previous network sample, split at
+// local midnight and the interval boundaries so each part is credited to the day and
+// the interval it belongs to. A gap longer than
+// the maximum is a suspension (recorded by the timeline) and credits nothing.
+func (a *Agent) networkSpans(currentTime time.Time) []daySpan {
+	spans := []daySpan{{Date: dateOf(currentTime), Start: currentTime, End: currentTime}}
+	if !a.lastNetworkSample.IsZero() && currentTime.After(a.lastNetworkSample) &&
+		currentTime.Sub(a.lastNetworkSample) <= a.maxGap {
+		spans = splitByInterval(a.lastNetworkSample, currentTime, a.transmissionInterval)
+	}
+	a.lastNetworkSample = currentTime
+	a.resolvePendingLookups(currentTime)
+	return spans
+}
 
-	// First, preserve existing connections from combined data
-	for key, conn := range a.combinedData.Network {
-		// Keep connections that were seen within the last 2 minutes
-		if currentTime.Sub(conn.LastSeen) <= 2*time.Minute {
-			activeConnections[key] = conn
-			domainSet[conn.Domain] = true
-			
-			// Mark as inactive if not seen recently
-			if currentTime.Sub(conn.LastSeen) > 30*time.Second {
-				conn.IsActive = false
-			} else {
-				conn.IsActive = true
-				conn.Duration += interval
-				a.combinedData.NetworkTotal.TotalDuration += interval
+// creditNetworkUsage merges connections detected up to at into the current day and
+// credits elapsed to the connections active at that time
+func (a *Agent) creditNetworkUsage(elapsed time.Duration, at time.Time) {
+	// Merge newly detected connections from DNS monitoring into the day's connections
+	for key, conn := range a.activeDomains {
+		// Skip connections not seen yet at this point, or last seen on an earlier day
+		if conn.FirstSeen.After(at) || dateOf(conn.LastSeen) < a.combinedData.Date {
+			continue
+		}
+		if existing, exists := a.combinedData.Network[key]; exists {
+			if conn.LastSeen.After(existing.LastSeen) {
+				existing.LastSeen = conn.LastSeen
+				existing.DNS = conn.DNS
+			}
+			if conn.PortSource == schema.PortObserved {
+				existing.Protocol = conn.Protocol
+				existing.PortSource = conn.PortSource
+				existing.ConnectionState = conn.ConnectionState
+			}
+			a.mergeConnectionApps(existing, conn)
+		} else {
+			newConn := *conn
+			newConn.Duration = 0
+			newConn.Apps = nil
+			if dateOf(newConn.FirstSeen) != a.combinedData.Date {
+				// Seen before midnight: in this day's file it starts with the day
+				newConn.FirstSeen = at.Add(-elapsed)
 			}
+			a.mergeConnectionApps(&newConn, conn)
+			a.combinedData.Network[key] = &newConn
 		}
 	}
+	a.updateAppDomains()
 
-	// Add newly detected connections from DNS monitoring
-	for key, conn := range a.activeDomains {
-		if existing, exists := activeConnections[key]; exists {
-			// Update existing connection
-			existing.LastSeen = conn.LastSeen
-			existing.IsActive = true
-			existing.Duration += interval
-			a.combinedData.NetworkTotal.TotalDuration += interval
+	sample := a.recordSample(at.Add(-elapsed), at)
+
+	// Keep every connection seen today, but only credit the ones seen within the active window
+	domainSet := make(map[string]bool)
+	for key, conn := range a.combinedData.Network {
+		domainSet[conn.Domain] = true
+
+		if at.Sub(conn.LastSeen) > time.Duration(a.currentConfig().Network.ActiveWindow)*time.Second {
+			conn.IsActive = false
 		} else {
-			// Add new connection
-			conn.Duration = interval
-			activeConnections[key] = conn
-			a.combinedData.NetworkTotal.TotalDuration += interval
-			a.combinedData.NetworkTotal.UniqueConnections++
+			conn.IsActive = true
+			seconds := creditSeconds(a.networkCarry, key, elapsed)
+			conn.Duration += seconds
+			a.combinedData.NetworkTotal.TotalDuration += seconds
+			if seconds > 0 {
+				sample.Network[key] = &SampleConn{Duration: seconds}
+			}
 		}
-		domainSet[conn.Domain] = true
 	}
 
-	// Update combined data with persistent connections
-	a.combinedData.Network = activeConnections
-	
 	// Update totals
-	a.combinedData.NetworkTotal.UniqueConnections = len(activeConnections)
+	a.combinedData.NetworkTotal.UniqueConnections = len(a.combinedData.Network)
 	a.combinedData.NetworkTotal.UniqueDomains = len(domainSet)
-
-	log.Printf("Network update: %d total connections (%d active), %d unique domains", 
-		len(activeConnections), a.countActiveConnections(activeConnections), len(domainSet))
 }
 
 // countActiveConnections counts currently active connections
@@ -687,161 +700,118 @@ func (a *Agent) countActiveConnections(connections map[string]*NetworkConnection
 	return count
 }
 
-// updateAppUsage updates application usage data
-func (a *Agent) updateAppUsage() {
-	currentTime := time.Now()
-	interval := int64(15)
-
+// collectAppUsage returns the app usage since the previous update by date, derived from
+// the focus and launch/quit event timeline, and records the running and frontmost apps
+// observed now as new events
+func (a *Agent) collectAppUsage(currentTime time.Time) (map[string]*DayUsage, map[string]bool, string, error) {
 	runningApps, frontmostApp, err := a.getRunningApps()
 	if err != nil {
-		log.Printf("Error getting running apps: %v", err)
-		return
-	}
-
-	// Reset all apps to inactive first
-	for _, appData := range a.combinedData.Apps {
-		appData.IsActive = false
-		appData.IsFocused = false
+		return nil, nil, "", err
 	}
+	runningApps, frontmostApp = a.recordedApps(runningApps, frontmostApp)
+
+	// Durations since the last update are derived from the event log timestamps,
+	// then the running set and focus observed now are recorded as new events
+	usage := a.timeline.Collect(currentTime)
+	a.timeline.SetRunning(runningApps, currentTime)
+	a.timeline.SetFocus(frontmostApp, currentTime)
+	return usage, runningApps, frontmostApp, nil
+}
 
-	// Update existing apps and add new ones
+// updateRunningApps records the apps running and focused now in the current day
+func (a *Agent) updateRunningApps(runningApps map[string]bool, frontmostApp string, currentTime time.Time) {
+	running := make([]string, 0, len(runningApps))
 	for appName := range runningApps {
-		isFocused := (appName == frontmostApp)
-		
-		if appData, exists := a.combinedData.Apps[appName]; exists {
-			// Update existing app
-			appData.IsActive = true
-			appData.IsFocused = isFocused
-			appData.LastSeen = currentTime
-			appData.ForegroundTime += interval
-			a.combinedData.AppTotal.ForegroundTime += interval
-			
-			if isFocused {
-				appData.FocusTime += interval
-				a.combinedData.AppTotal.FocusTime += interval
-			}
-		} else {
-			// Add new app
-			focusTime := int64(0)
-			if isFocused {
-				focusTime = interval
-				a.combinedData.AppTotal.FocusTime += interval
-			}
+		a.ensureApp(appName, currentTime).LastSeen = currentTime
+		running = append(running, appName)
+	}
+	sort.Strings(running)
 
-			a.combinedData.Apps[appName] = &AppUsage{
-				Name:           appName,
-				ForegroundTime: interval,
-				FocusTime:      focusTime,
-				LastSeen:       currentTime,
-				IsActive:       true,
-				IsFocused:      isFocused,
-			}
-			a.combinedData.AppTotal.ForegroundTime += interval
-		}
+	focusedApp := ""
+	if runningApps[frontmostApp] {
+		focusedApp = frontmostApp
 	}
 
-	// Remove apps that haven't been active for more than 2 minutes
-	for appName, appData := range a.combinedData.Apps {
-		if !appData.IsActive && currentTime.Sub(appData.LastSeen) > 2*time.Minute {
-			delete(a.combinedData.Apps, appName)
-		}
+	a.combinedData.Running = RunningView{
+		Apps:       running,
+		FocusedApp: focusedApp,
+		UpdatedAt:  currentTime,
 	}
 
-	activeApps := 0
-	for _, appData := range a.combinedData.Apps {
-		if appData.IsActive {
-			activeApps++
+	infof("App update: %d running apps (seen today: %d), frontmost: %s",
+		len(running), len(a.combinedData.Apps), frontmostApp)
+}
+
+// recordedApps removes the apps in privacy.exclude_apps. An excluded frontmost app is
+// recorded as no app having focus.
+func (a *Agent) recordedApps(runningApps map[string]bool, frontmostApp string) (map[string]bool, string) {
+	config := a.currentConfig()
+	for appName := range runningApps {
+		if !config.recordsApp(appName) {
+			delete(runningApps, appName)
 		}
 	}
-
-	log.Printf("App update: %d active apps (total: %d), frontmost: %s", 
-		activeApps, len(a.combinedData.Apps), frontmostApp)
+	if !config.recordsApp(frontmostApp) {
+		frontmostApp = ""
+	}
+	return runningApps, frontmostApp
 }
 
-// triggerDataTransmission triggers data transmission if interval has passed
-func (a *Agent) triggerDataTransmission() {
-	if time.Since(a.lastTransmission) >= a.transmissionInterval {
-		log.Println("Triggering data transmission...")
-		
-		// Save current data before transmission
-		a.saveCombinedData()
-		
-		// Execute data sender using built binary
-		go func() {
-			// Get the current working directory and find project root
-			wd, err := os.Getwd()
-			if err != nil {
-				log.Printf("Error getting working directory: %v", err)
-				return
-			}
-			
-			// Look for project root (directory containing data-sender folder)
-			projectRoot := wd
-			for {
-				dataSenderDir := filepath.Join(projectRoot, "data-sender")
-				if _, err := os.Stat(dataSenderDir); err == nil {
-					break // Found project root
-				}
-				parent := filepath.Dir(projectRoot)
-				if parent == projectRoot {
-					// Reached filesystem root without finding data-sender
-					log.Printf("Error: Could not find project root with data-sender directory")
-					return
-				}
-				projectRoot = parent
-			}
-			
-			dataSenderDir := filepath.Join(projectRoot, "data-sender")
-			dataSenderBinary := filepath.Join(dataSenderDir, "data-sender")
-			
-			// Build data-sender if binary doesn't exist or source is newer
-			needsBuild := true
-			if info, err := os.Stat(dataSenderBinary); err == nil {
-				// Check if any .go file is newer than binary
-				binaryTime := info.ModTime()
-				needsBuild = false
-				
-				files, _ := filepath.Glob(filepath.Join(dataSenderDir, "*.go"))
-				for _, file := range files {
-					if fileInfo, err := os.Stat(file); err == nil {
-						if fileInfo.ModTime().After(binaryTime) {
-							needsBuild = true
-							break
-						}
-					}
-				}
-			}
-			
-			if needsBuild {
-				log.Printf("Building data-sender binary...")
-				buildCmd := exec.Command("go", "build", "-o", "data-sender", ".")
-				buildCmd.Dir = dataSenderDir
-				if output, err := buildCmd.CombinedOutput(); err != nil {
-					log.Printf("Data-sender build error: %v, output: %s", err, string(output))
-					return
-				}
-				log.Printf("Data-sender built successfully")
+// creditAppUsage adds one day's share of the collected usage to the current day's data,
+// recording each interval's share in that interval's sample. Apps that are no longer
+// running stay in the day's roster with their counters.
+func (a *Agent) creditAppUsage(usage *DayUsage) {
+	for _, period := range usage.Periods {
+		sample := a.recordSample(period.Start, period.End)
+
+		for appName, d := range period.Foreground {
+			appData := a.ensureApp(appName, period.Start)
+			seconds := creditSeconds(a.foregroundCarry, appName, d)
+			appData.ForegroundTime += seconds
+			a.combinedData.AppTotal.ForegroundTime += seconds
+			if seconds > 0 {
+				sample.sampleApp(appName).ForegroundTime += seconds
 			}
-			
-			log.Printf("Executing data transmission: %s process", dataSenderBinary)
-			
-			cmd := exec.Command(dataSenderBinary, "process")
-			cmd.Dir = dataSenderDir
-			
-			if output, err := cmd.CombinedOutput(); err != nil {
-				log.Printf("Data transmission error: %v, output: %s", err, string(output))
-			} else {
-				log.Printf("Data transmission completed: %s", string(output))
+		}
+		for appName, d := range period.Focus {
+			appData := a.ensureApp(appName, period.Start)
+			seconds := creditSeconds(a.focusCarry, appName, d)
+			appData.FocusTime += seconds
+			a.combinedData.AppTotal.FocusTime += seconds
+			if seconds > 0 {
+				sample.sampleApp(appName).FocusTime += seconds
 			}
-		}()
-		
-		a.lastTransmission = time.Now()
+		}
 	}
+	a.combinedData.Suspended = append(a.combinedData.Suspended, usage.Suspended...)
+}
+
+// ensureApp returns the day's usage entry for an app, adding it the first time it is seen today
+func (a *Agent) ensureApp(appName string, seenAt time.Time) *AppUsage {
+	appData, exists := a.combinedData.Apps[appName]
+	if !exists {
+		appData = &AppUsage{
+			Name:      appName,
+			FirstSeen: seenAt,
+			LastSeen:  seenAt,
+		}
+		a.combinedData.Apps[appName] = appData
+	}
+	if appData.FirstSeen.IsZero() {
+		appData.FirstSeen = seenAt
+	}
+	return appData
 }
 
 // saveCombinedData saves the current combined data to file
 func (a *Agent) saveCombinedData() {
-	dataFile := filepath.Join(a.dataDir, fmt.Sprintf("combined_%s.json", a.combinedData.Date))
+	// Saving now would overwrite the usage in a day file that could not be read
+	if a.unresumed && !a.resumeCombinedData() {
+		return
+	}
+
+	dataFile := a.dayFilePath(a.combinedData.Date)
+	a.combinedData.Capture = a.captureHealth()
 
 	data, err := json.MarshalIndent(a.combinedData, "", "  ")
 	if err != nil {
@@ -849,11 +819,20 @@ func (a *Agent) saveCombinedData() {
 		return
 	}
 
-	if err := ioutil.WriteFile(dataFile, data, 0644); err != nil {
+	// Write to a temporary file and rename it so a crash never leaves a half-written day file
+	tmpFile := dataFile + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, data, 0644); err != nil {
+		log.Printf("Error saving combined data: %v", err)
+		return
+	}
+	if err := os.Rename(tmpFile, dataFile); err != nil {
 		log.Printf("Error saving combined data: %v", err)
+		os.Remove(tmpFile)
 		return
 	}
 
+	// Only saved usage is written to the sample log, so the samples always sum to the day file
+	a.flushSamples()
 	a.lastUpdate = time.Now()
 }
 
@@ -861,73 +840,173 @@ func (a *Agent) saveCombinedData() {
 func (a *Agent) Start() {
 	log.Println("Starting ROI Agent with tcpdump-based DNS Monitoring")
 
+	config := a.currentConfig()
 	if !a.checkAccessibilityPermissions() {
-		fmt.Println("=== macOS Accessibility Permissions Required ===")
-		fmt.Println("ROI Agent needs accessibility permissions to monitor app usage.")
-		fmt.Println("Please grant permissions and restart the application.")
-		fmt.Println("================================================")
-		return
+		if config.Security.RequireAccessibility {
+			fmt.Println("=== macOS Accessibility Permissions Required ===")
+			fmt.Println("ROI Agent needs accessibility permissions to monitor app usage.")
+			fmt.Println("Please grant permissions and restart the application.")
+			fmt.Println("================================================")
+			return
+		}
+		log.Println("Warning: Accessibility permissions missing, app usage may not be recorded")
 	}
 
 	// Start DNS monitoring
-	if err := a.startTcpdumpDNSMonitoring(); err != nil {
+	if !config.Network.DNSSnooping {
+		log.Println("DNS monitoring is disabled (network.dns_snooping)")
+		a.setCaptureState(schema.CaptureDisabled, "network.dns_snooping is off")
+	} else if err := a.startTcpdumpDNSMonitoring(); err != nil {
 		log.Printf("Failed to start DNS monitoring: %v", err)
-		fmt.Println("=== sudo Permissions Required ===")
-		fmt.Println("DNS monitoring requires sudo permissions for tcpdump.")
-		fmt.Println("Please run with sudo or use the start script.")
-		fmt.Println("==================================")
-		return
+		if config.Security.RequireSudo {
+			fmt.Println("=== sudo Permissions Required ===")
+			fmt.Println("DNS monitoring requires sudo permissions for tcpdump.")
+			fmt.Println("Please run with sudo or use the start script.")
+			fmt.Println("==================================")
+			return
+		}
+		log.Println("Continuing without DNS monitoring")
 	}
 
 	defer a.stopTcpdumpDNSMonitoring()
 
 	log.Println("Starting comprehensive monitoring...")
 
-	ticker := time.NewTicker(15 * time.Second)
+	interval := config.Monitor.Interval
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
 	defer ticker.Stop()
 
+	// Config changes are picked up by polling the files, or at once on SIGHUP
+	configWatch := time.NewTicker(configWatchInterval)
+	defer configWatch.Stop()
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	// SIGINT and SIGTERM save the day file and stop capturing before exiting
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, syscall.SIGINT, syscall.SIGTERM)
+	stopping := make(chan struct{})
+
+	// Record focus changes between ticks with their real timestamps
+	a.timeline.Start(time.Now())
+	a.supervise("focus-watch", stopping, func() { a.watchFocus(stopping) })
+
+	// Attribute connections to the apps that made them
+	if resolver := newOwnerResolver(); resolver != nil {
+		a.supervise("socket-owners", stopping, func() { a.attributeOwners(resolver, stopping) })
+	}
+
+	// Commands from "roi-agent status", "pause" etc. are handled on this loop
+	if listener, err := listenControl(controlSocketPath()); err != nil {
+		log.Printf("Control socket unavailable: %v", err)
+	} else {
+		defer os.Remove(controlSocketPath())
+		defer listener.Close()
+		a.supervise("control-socket", stopping, func() { a.serveControl(listener) })
+	}
+
 	// Initial updates
-	a.updateAppUsage()
-	a.updateNetworkUsage()
-	a.saveCombinedData()
+	a.protect("tick", a.update)
 
 	for {
 		select {
 		case <-ticker.C:
-			// Check if it's a new day
-			today := time.Now().Format("2006-01-02")
-			if a.combinedData.Date != today {
-				a.saveCombinedData()
-				a.initCombinedData()
-			}
+			a.protect("tick", func() {
+				a.update()
+
+				// Check for data transmission
+				a.triggerDataTransmission()
+			})
+		case call := <-a.controlCalls:
+			a.handleControl(call)
+		case <-hangup:
+			a.protect("reload", func() {
+				a.configFilesChanged()
+				a.reloadConfig("SIGHUP")
+			})
+		case <-configWatch.C:
+			a.protect("reload", func() {
+				if a.configFilesChanged() {
+					a.reloadConfig("config file changed")
+				}
+			})
+		case sig := <-terminate:
+			// A second signal stops the agent at once
+			signal.Stop(terminate)
+			log.Printf("Received %v", sig)
+			close(stopping)
+			a.shutdown()
+			return
+		}
 
-			a.updateAppUsage()
-			a.updateNetworkUsage()
-			a.saveCombinedData()
-			
-			// Check for data transmission
-			a.triggerDataTransmission()
+		if next := a.currentConfig().Monitor.Interval; next != interval {
+			interval = next
+			ticker.Reset(time.Duration(interval) * time.Second)
 		}
 	}
 }
 
-// Status returns current agent status
-func (a *Agent) Status() map[string]interface{} {
-	activeApps := 0
-	focusedApp := ""
-	for _, appData := range a.combinedData.Apps {
-		if appData.IsActive {
-			activeApps++
+// creditUsage credits the collected app usage and network spans to their day files.
+// Usage that crosses midnight is credited to each day in order, switching to each day
+// once. The caller holds domainMutex.
+func (a *Agent) creditUsage(appUsage map[string]*DayUsage, networkSpans []daySpan) {
+	for _, date := range usageDates(appUsage, networkSpans) {
+		a.switchDay(date)
+		if usage := appUsage[date]; usage != nil {
+			a.creditAppUsage(usage)
 		}
-		if appData.IsFocused {
-			focusedApp = appData.Name
+		for _, span := range networkSpans {
+			if span.Date == date {
+				a.creditNetworkUsage(span.End.Sub(span.Start), span.End)
+			}
 		}
 	}
+}
+
+// update records the usage since the previous update and saves the day file.
+// Nothing is recorded while recording is paused.
+func (a *Agent) update() {
+	if !a.timeline.PausedAt().IsZero() {
+		return
+	}
+
+	// Wall clock time, since the monotonic clock stops while the machine sleeps
+	currentTime := time.Now().Round(0)
+	appUsage, runningApps, frontmostApp, appsErr := a.collectAppUsage(currentTime)
+	if appsErr != nil {
+		log.Printf("Error getting running apps: %v", appsErr)
+	}
+	networkSpans := a.networkSpans(currentTime)
+
+	a.domainMutex.Lock()
+	defer a.domainMutex.Unlock()
+
+	a.creditUsage(appUsage, networkSpans)
+	a.switchDay(dateOf(currentTime))
+
+	if appsErr == nil {
+		a.updateRunningApps(runningApps, frontmostApp, currentTime)
+	}
+	activeConnections := a.combinedData.Network
+	infof("Network update: %d total connections (%d active), %d unique domains",
+		len(activeConnections), a.countActiveConnections(activeConnections), a.combinedData.NetworkTotal.UniqueDomains)
+	a.saveCombinedData()
+}
+
+// Status returns current agent status
+func (a *Agent) Status() map[string]interface{} {
+	activeApps := len(a.combinedData.Running.Apps)
+	focusedApp := a.combinedData.Running.FocusedApp
+	capture := a.captureHealth()
 
 	return map[string]interface{}{
 		"running":              true,
+		"pid":                  os.Getpid(),
+		"paused":               !a.timeline.PausedAt().IsZero(),
 		"accessibility_ok":     a.checkAccessibilityPermissions(),
-		"dns_monitoring":       a.tcpdumpCmd != nil,
+		"dns_monitoring":       capture != nil && capture.State == schema.CaptureRunning,
+		"capture":              capture,
 		"current_date":         a.combinedData.Date,
 		"active_apps":          activeApps,
 		"total_apps":           len(a.combinedData.Apps),
@@ -937,31 +1016,76 @@ func (a *Agent) Status() map[string]interface{} {
 		"app_foreground_time":  a.combinedData.AppTotal.ForegroundTime,
 		"app_focus_time":       a.combinedData.AppTotal.FocusTime,
 		"network_duration":     a.combinedData.NetworkTotal.TotalDuration,
+		"suspended_periods":    len(a.combinedData.Suspended),
+		"max_gap_seconds":      int64(a.maxGap / time.Second),
+		"config":               a.configStatus(),
 		"last_update":          a.lastUpdate,
+		"transmission":         a.transmissionStatus(),
+		"crashes":              a.crashStatus(),
 	}
 }
 
 func main() {
-	agent := NewAgent()
-
-	if len(os.Args) > 1 {
-		switch os.Args[1] {
-		case "status":
-			status := agent.Status()
-			data, _ := json.MarshalIndent(status, "", "  ")
-			fmt.Println(string(data))
-			return
-		case "check-permissions":
-			if agent.checkAccessibilityPermissions() {
-				fmt.Println("Accessibility permissions: OK")
-			} else {
-				fmt.Println("Accessibility permissions: REQUIRED")
-			}
+	// .env values are part of the environment, which takes precedence over config.yaml.
+	// The data sender reads the same files.
+	transmit.LoadEnvFiles()
+
+	config, args, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+	setLogLevel(config.Debug.LogLevel)
+
+	if len(args) > 0 && args[0] == "config" {
+		if len(args) < 2 || args[1] != "show" {
+			fmt.Println("Usage: roi-agent [flags] config show")
 			return
+		}
+		config.Show()
+		return
+	}
+
+	// These talk to the agent that is already running instead of starting one
+	if len(args) > 0 && isControlCommand(args[0]) {
+		if len(args) > 1 {
+			fmt.Printf("Usage: roi-agent %s\n", args[0])
+			os.Exit(2)
+		}
+		os.Exit(runControlCommand(args[0]))
+	}
+
+	if len(args) > 0 && args[0] == "replay" {
+		os.Exit(runReplay(config, args[1:]))
+	}
+
+	if len(args) > 0 && args[0] == "check-permissions" {
+		// Only asks macOS, so it runs alongside an agent without touching the day files
+		if (&Agent{config: config}).checkAccessibilityPermissions() {
+			fmt.Println("Accessibility permissions: OK")
+		} else {
+			fmt.Println("Accessibility permissions: REQUIRED")
+		}
+		return
+	}
+
+	// Only one agent may record into the day files at a time. NewAgent already loads,
+	// migrates and cleans them up, so the lock comes first.
+	lock, err := acquireInstanceLock(lockFilePath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	defer lock.Release()
+
+	agent := NewAgent(config)
+
+	if len(args) > 0 {
+		switch args[0] {
 		case "test-dns":
 			// Test DNS monitoring for 30 seconds
 			fmt.Println("Testing DNS monitoring for 30 seconds...")
-			testAgent := NewAgent()
+			testAgent := NewAgent(config)
 			if err := testAgent.startTcpdumpDNSMonitoring(); err != nil {
 				fmt.Printf("Error starting DNS monitoring: %v\n", err)
 				return
@@ -969,6 +1093,7 @@ func main() {
 			
 			time.Sleep(30 * time.Second)
 			testAgent.stopTcpdumpDNSMonitoring()
+			testAgent.resolvePendingLookups(time.Now().Add(flowWaitWindow))
 			
 			fmt.Printf("Detected %d connections:\n", len(testAgent.activeDomains))
 			for key, conn := range testAgent.activeDomains {
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestAgent returns an agent whose day files live in a temporary directory, without
+// starting monitoring, capture or the data sender
+func newTestAgent(t *testing.T) *Agent {
+	t.Helper()
+	config := defaultConfig()
+	dataDir := t.TempDir()
+	maxGap := time.Duration(config.Monitor.MaxGap) * time.Second
+	interval := time.Duration(config.Transmission.IntervalMinutes) * time.Minute
+	return &Agent{
+		config:               config,
+		startConfig:          config,
+		dataDir:              dataDir,
+		activeDomains:        make(map[string]*NetworkConnection),
+		pendingLookups:       make(map[string]*DNSResolution),
+		transmissionInterval: interval,
+		timeline:             NewTimeline(dataDir, maxGap, interval),
+		focusCarry:           make(map[string]time.Duration),
+		foregroundCarry:      make(map[string]time.Duration),
+		networkCarry:         make(map[string]time.Duration),
+		maxGap:               maxGap,
+	}
+}
+
+// addFocus credits seconds of focus to app in the agent's current day
+func addFocus(a *Agent, app string, seconds int64) {
+	usage, exists := a.combinedData.Apps[app]
+	if !exists {
+		usage = &AppUsage{Name: app}
+		a.combinedData.Apps[app] = usage
+	}
+	usage.FocusTime += seconds
+	a.combinedData.AppTotal.FocusTime += seconds
+}
+
+func TestInitCombinedDataResumes(t *testing.T) {
+	a := newTestAgent(t)
+	a.initCombinedData("2026-10-16")
+	addFocus(a, "Safari", 60)
+	a.saveCombinedData()
+
+	restarted := newTestAgent(t)
+	restarted.dataDir = a.dataDir
+	restarted.initCombinedData("2026-10-16")
+	if app := restarted.combinedData.Apps["Safari"]; app == nil || app.FocusTime != 60 ||
+		restarted.combinedData.AppTotal.FocusTime != 60 {
+		t.Errorf("resumed data = %+v, want Safari's 60 seconds", restarted.combinedData.Apps)
+	}
+}
+
+func TestInitCombinedDataCorrupt(t *testing.T) {
+	tests := map[string]string{
+		"empty":      "  \n",
+		"not JSON":   `{"date": "2026-10-16", "apps": `,
+		"other date": `{"schema_version": 2, "date": "2026-10-15"}`,
+	}
+
+	for name, contents := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := newTestAgent(t)
+			if err := ioutil.WriteFile(a.dayFilePath("2026-10-16"), []byte(contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			a.initCombinedData("2026-10-16")
+			if a.unresumed || len(a.combinedData.Apps) != 0 {
+				t.Errorf("after a corrupt day file: unresumed %v, apps %v", a.unresumed, a.combinedData.Apps)
+			}
+			backups, _ := filepath.Glob(a.dayFilePath("2026-10-16") + ".corrupt-*")
+			if len(backups) != 1 {
+				t.Fatalf("backups = %v, want one", backups)
+			}
+			if data, _ := ioutil.ReadFile(backups[0]); string(data) != contents {
+				t.Errorf("backup = %q, want the corrupt file", data)
+			}
+		})
+	}
+}
+
+func TestInitCombinedDataUnreadable(t *testing.T) {
+	a := newTestAgent(t)
+	path := a.dayFilePath("2026-10-16")
+
+	// A day file that cannot be read, here because it is a directory, is not corrupt
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	a.initCombinedData("2026-10-16")
+	if !a.unresumed {
+		t.Fatal("initCombinedData() did not notice the unreadable day file")
+	}
+	if backups, _ := filepath.Glob(path + ".corrupt-*"); len(backups) != 0 {
+		t.Errorf("an unreadable day file was backed up as corrupt: %v", backups)
+	}
+
+	// Usage recorded meanwhile is kept in memory, and saving leaves the file alone
+	addFocus(a, "Safari", 10)
+	addFocus(a, "Mail", 30)
+	a.saveCombinedData()
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		t.Fatalf("saveCombinedData() replaced the unreadable day file: %v", err)
+	}
+
+	// Once the file can be read its counters are added to the ones recorded since
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	saved := newTestAgent(t)
+	saved.dataDir = a.dataDir
+	saved.initCombinedData("2026-10-16")
+	addFocus(saved, "Safari", 60)
+	saved.saveCombinedData()
+
+	a.saveCombinedData()
+	if a.unresumed {
+		t.Fatal("the day file was not resumed once readable")
+	}
+	resumed, err := a.loadCombinedData("2026-10-16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Apps["Safari"].FocusTime != 70 || resumed.Apps["Mail"].FocusTime != 30 || resumed.AppTotal.FocusTime != 100 {
+		t.Errorf("saved day file: Safari %d, Mail %d, total %d; want 70, 30 and 100",
+			resumed.Apps["Safari"].FocusTime, resumed.Apps["Mail"].FocusTime, resumed.AppTotal.FocusTime)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// unknownApp is the app name of a connection whose process was not found
+const unknownApp = "Unknown"
+
+// ownerBatchDelay is how long connections are collected before the sockets are listed
+// once for all of them, since listing them is slow
+const ownerBatchDelay = 200 * time.Millisecond
+
+// ownerQueueSize bounds the connections waiting for their process. Connections beyond
+// it are left to the app that made them before, or "Unknown".
+const ownerQueueSize = 1024
+
+// socketKey identifies the local end of a connection
+type socketKey struct {
+	Transport string // "tcp" or "udp"
+	Port      uint16
+}
+
+// socketOwner is the process that owns a socket
+type socketOwner struct {
+	PID     int
+	Command string // the process name
+	Path    string // the executable, if known
+}
+
+// ownerResolver lists the processes that own the local TCP and UDP sockets. Each
+// platform provides one from newOwnerResolver, which returns nil if it has none.
+type ownerResolver interface {
+	sockets() (map[socketKey]socketOwner, error)
+}
+
+// ownerLookup is a connection waiting for the process that opened it
+type ownerLookup struct {
+	socket socketKey
+	conn   *NetworkConnection
+}
+
+// appName returns the app a process belongs to. Helpers run from inside their app's
+// bundle, so the outermost .app bundle of the executable names the app.
+func (o socketOwner) appName() string {
+	if o.Path == "" {
+		return o.Command
+	}
+	for _, part := range strings.Split(o.Path, "/") {
+		if strings.HasSuffix(part, ".app") {
+			return strings.TrimSuffix(part, ".app")
+		}
+	}
+	return filepath.Base(o.Path)
+}
+
+// queueOwnerLookup asks attributeOwners for the process that opened conn from socket
+func (a *Agent) queueOwnerLookup(socket socketKey, conn *NetworkConnection) {
+	if a.ownerLookups == nil {
+		return // replay: the sockets are long gone
+	}
+	select {
+	case a.ownerLookups <- ownerLookup{socket: socket, conn: conn}:
+	default:
+		debugf("Too many connections waiting for their process, leaving %s:%d unattributed", conn.Domain, conn.Port)
+	}
+}
+
+// attributeOwners attributes the queued connections to the apps that opened them until
+// stop is closed. Connections that closed before the sockets were listed stay unattributed.
+func (a *Agent) attributeOwners(resolver ownerResolver, stop <-chan struct{}) {
+	lastError := ""
+	for {
+		var batch []ownerLookup
+		select {
+		case <-stop:
+			return
+		case lookup := <-a.ownerLookups:
+			batch = append(batch, lookup)
+		}
+
+		// Collect the connections opened together, e.g. by one page load
+		timer := time.NewTimer(ownerBatchDelay)
+	collect:
+		for {
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case lookup := <-a.ownerLookups:
+				batch = append(batch, lookup)
+			case <-timer.C:
+				break collect
+			}
+		}
+
+		sockets, err := resolver.sockets()
+		if err != nil {
+			if err.Error() != lastError {
+				log.Printf("Warning: cannot list socket owners, connections are not attributed to apps: %v", err)
+				lastError = err.Error()
+			}
+			continue
+		}
+		lastError = ""
+
+		a.domainMutex.Lock()
+		for _, lookup := range batch {
+			if owner, exists := sockets[lookup.socket]; exists {
+				a.attributeConnection(lookup.conn, owner)
+			}
+		}
+		a.domainMutex.Unlock()
+	}
+}
+
+// attributeConnection records that the process owner made conn. Apps excluded from
+// recording are not named. The caller holds domainMutex.
+func (a *Agent) attributeConnection(conn *NetworkConnection, owner socketOwner) {
+	app := owner.appName()
+	if app == "" || !a.currentConfig().recordsApp(app) {
+		return
+	}
+	conn.AppName = app
+	if !containsString(conn.Apps, app) {
+		conn.Apps = append(conn.Apps, app)
+	}
+	debugf("Connection %s:%d made by %s (pid %d)", conn.Domain, conn.Port, app, owner.PID)
+}
+
+// mergeConnectionApps adds the apps that made conn to the day's connection into, by
+// the names the day's apps use
+func (a *Agent) mergeConnectionApps(into, conn *NetworkConnection) {
+	if conn.AppName != unknownApp {
+		into.AppName = a.dayAppName(conn.AppName)
+	}
+	for _, app := range conn.Apps {
+		if name := a.dayAppName(app); !containsString(into.Apps, name) {
+			into.Apps = append(into.Apps, name)
+		}
+	}
+}
+
+// dayAppName returns the name the day's apps use for app, whose bundle name can differ
+// from its process name in case
+func (a *Agent) dayAppName(app string) string {
+	if _, exists := a.combinedData.Apps[app]; exists {
+		return app
+	}
+	for name := range a.combinedData.Apps {
+		if strings.EqualFold(name, app) {
+			return name
+		}
+	}
+	return app
+}
+
+// updateAppDomains lists on each of the day's apps the domains of the connections it made
+func (a *Agent) updateAppDomains() {
+	for _, app := range a.combinedData.Apps {
+		app.Domains = nil
+	}
+	for _, conn := range a.combinedData.Network {
+		for _, name := range conn.Apps {
+			if app, exists := a.combinedData.Apps[name]; exists && !containsString(app.Domains, conn.Domain) {
+				app.Domains = append(app.Domains, conn.Domain)
+			}
+		}
+	}
+	for _, app := range a.combinedData.Apps {
+		sort.Strings(app.Domains)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// lsofResolver finds socket owners by listing the open sockets with lsof. Only the
+// processes of the agent's user are listed.
+type lsofResolver struct{}
+
+func newOwnerResolver() ownerResolver {
+	return lsofResolver{}
+}
+
+func (lsofResolver) sockets() (map[socketKey]socketOwner, error) {
+	// lsof exits with 1 when it finds nothing, or cannot read some of the files
+	output, err := exec.Command("lsof", "-nP", "-iTCP", "-iUDP", "-FpcPn").Output()
+	if err != nil && len(output) == 0 {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return map[socketKey]socketOwner{}, nil
+		}
+		return nil, fmt.Errorf("lsof: %v", err)
+	}
+	owners := parseLsof(string(output))
+
+	// The executables tell which app a helper process belongs to
+	pids := make(map[int]string)
+	for _, owner := range owners {
+		pids[owner.PID] = ""
+	}
+	if len(pids) == 0 {
+		return owners, nil
+	}
+	list := make([]string, 0, len(pids))
+	for pid := range pids {
+		list = append(list, strconv.Itoa(pid))
+	}
+	output, err = exec.Command("ps", "-o", "pid=,comm=", "-p", strings.Join(list, ",")).Output()
+	if err != nil && len(output) == 0 {
+		return owners, nil // the process names from lsof are still useful
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if len(fields) < 2 {
+			continue
+		}
+		if pid, err := strconv.Atoi(fields[0]); err == nil {
+			pids[pid] = strings.TrimSpace(fields[1])
+		}
+	}
+	for socket, owner := range owners {
+		owner.Path = pids[owner.PID]
+		owners[socket] = owner
+	}
+	return owners, nil
+}
+
+// parseLsof reads the sockets from lsof -F output: a p (pid) and c (command) line per
+// process, then f (descriptor), P (protocol) and n (addresses) lines per socket
+func parseLsof(output string) map[socketKey]socketOwner {
+	owners := make(map[socketKey]socketOwner)
+	var owner socketOwner
+	transport := ""
+
+	for _, line := range strings.Split(output, "\n") {
+		if line == "" {
+			continue
+		}
+		value := line[1:]
+		switch line[0] {
+		case 'p':
+			pid, _ := strconv.Atoi(value)
+			owner = socketOwner{PID: pid}
+		case 'c':
+			owner.Command = value
+		case 'f':
+			transport = ""
+		case 'P':
+			transport = strings.ToLower(value)
+		case 'n':
+			// e.g. 192.168.0.14:51000->183.79.250.124:443, [::1]:5000 or *:5353
+			local := value
+			if arrow := strings.Index(local, "->"); arrow >= 0 {
+				local = local[:arrow]
+			}
+			colon := strings.LastIndexByte(local, ':')
+			if colon < 0 || transport == "" {
+				continue
+			}
+			port, err := strconv.ParseUint(local[colon+1:], 10, 16)
+			if err != nil {
+				continue
+			}
+			owners[socketKey{Transport: transport, Port: uint16(port)}] = owner
+		}
+	}
+	return owners
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLsof(t *testing.T) {
+	output := "p412\ncGoogle Chrome He\n" +
+		"f23\nPTCP\nn192.168.0.14:51000->183.79.250.124:443\n" +
+		"f24\nPUDP\nn*:5353\n" +
+		"f25\nPTCP\nn[::1]:5000\n" +
+		"p88\ncsecurityd\n" +
+		"f7\nPTCP\nn[fe80::1%lo0]:51001->[fe80::2%lo0]:443\n" +
+		"f8\nn10.0.0.2:51002\n" + // no protocol line
+		"f9\nPTCP\nnnot-an-address\n"
+
+	want := map[socketKey]socketOwner{
+		{Transport: "tcp", Port: 51000}: {PID: 412, Command: "Google Chrome He"},
+		{Transport: "udp", Port: 5353}:  {PID: 412, Command: "Google Chrome He"},
+		{Transport: "tcp", Port: 5000}:  {PID: 412, Command: "Google Chrome He"},
+		{Transport: "tcp", Port: 51001}: {PID: 88, Command: "securityd"},
+	}
+	if owners := parseLsof(output); !reflect.DeepEqual(owners, want) {
+		t.Errorf("parseLsof() = %v, want %v", owners, want)
+	}
+
+	if owners := parseLsof(""); len(owners) != 0 {
+		t.Errorf("parseLsof(\"\") = %v, want none", owners)
+	}
+}
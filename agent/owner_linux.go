@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// procResolver finds socket owners in /proc: the sockets' inodes by local port in
+// /proc/net, and the processes holding them in their file descriptors. Only the
+// processes of the agent's user are visible.
+type procResolver struct {
+	root string
+}
+
+func newOwnerResolver() ownerResolver {
+	return procResolver{root: "/proc"}
+}
+
+func (r procResolver) sockets() (map[socketKey]socketOwner, error) {
+	inodes := make(map[string]socketKey)
+	tables := []struct{ file, transport string }{
+		{"net/tcp", "tcp"}, {"net/tcp6", "tcp"}, {"net/udp", "udp"}, {"net/udp6", "udp"},
+	}
+	for _, table := range tables {
+		data, err := ioutil.ReadFile(filepath.Join(r.root, table.file))
+		if os.IsNotExist(err) {
+			continue // IPv6 is disabled
+		}
+		if err != nil {
+			return nil, err
+		}
+		parseProcNet(data, table.transport, inodes)
+	}
+
+	processes, err := ioutil.ReadDir(r.root)
+	if err != nil {
+		return nil, err
+	}
+	owners := make(map[socketKey]socketOwner)
+	for _, process := range processes {
+		pid, err := strconv.Atoi(process.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join(r.root, process.Name(), "fd")
+		dir, err := os.Open(fdDir)
+		if err != nil {
+			continue // another user's process, or one that has exited
+		}
+		fds, _ := dir.Readdirnames(-1)
+		dir.Close()
+
+		var owner *socketOwner
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			socket, exists := inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")]
+			if !exists {
+				continue
+			}
+			if owner == nil {
+				owner = r.process(pid)
+			}
+			owners[socket] = *owner
+		}
+	}
+	return owners, nil
+}
+
+// process returns the name and executable of a process
+func (r procResolver) process(pid int) *socketOwner {
+	dir := filepath.Join(r.root, strconv.Itoa(pid))
+	comm, _ := ioutil.ReadFile(filepath.Join(dir, "comm"))
+	path, _ := os.Readlink(filepath.Join(dir, "exe"))
+	return &socketOwner{PID: pid, Command: strings.TrimSpace(string(comm)), Path: path}
+}
+
+// parseProcNet adds the sockets of a /proc/net/tcp or udp table to inodes, keyed by inode
+func parseProcNet(data []byte, transport string, inodes map[string]socketKey) {
+	lines := strings.Split(string(data), "\n")
+	for _, line := range lines[1:] {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		fields := strings.Fields(line)
+		if len(fields) < 10 || fields[9] == "0" {
+			continue // sockets in TIME_WAIT have no inode
+		}
+		colon := strings.LastIndexByte(fields[1], ':')
+		if colon < 0 {
+			continue
+		}
+		port, err := strconv.ParseUint(fields[1][colon+1:], 16, 16)
+		if err != nil {
+			continue
+		}
+		inodes[fields[9]] = socketKey{Transport: transport, Port: uint16(port)}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const procNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 31201 1 0000000000000000 100 0 0 10 0
+   1: 1401A8C0:CB20 22D8B85D:01BB 01 00000000:00000000 02:000A7B5E 00000000  1000        0 31877 2 0000000000000000 20 4 30 10 -1
+   2: 1401A8C0:CB21 22D8B85D:01BB 06 00000000:00000000 03:00001000 00000000     0        0 0 3 0000000000000000
+`
+
+const procNetTCP6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0000000000000000FFFF00001401A8C0:D431 0000000000000000FFFF000022D8B85D:01BB 01 00000000:00000000 00:00000000 00000000  1000        0 40022 1 0000000000000000 20 4 0 10 -1
+`
+
+const procNetUDP = `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  512: 1401A8C0:EA60 22D8B85D:01BB 01 00000000:00000000 00:00000000 00000000  1000        0 40100 2 0000000000000000 0
+  700: 00000000:0044 00000000:0000 07
+  701: 00000000 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 40101 2 0000000000000000 0
+`
+
+func TestParseProcNet(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		transport string
+		want      map[string]socketKey
+	}{
+		{"tcp", procNetTCP, "tcp", map[string]socketKey{
+			"31201": {Transport: "tcp", Port: 3306},
+			"31877": {Transport: "tcp", Port: 52000},
+			// TIME_WAIT, inode 0, is skipped
+		}},
+		{"tcp6", procNetTCP6, "tcp", map[string]socketKey{
+			"40022": {Transport: "tcp", Port: 54321},
+		}},
+		{"udp", procNetUDP, "udp", map[string]socketKey{
+			"40100": {Transport: "udp", Port: 60000},
+			// The truncated line and the line without a port are skipped
+		}},
+		{"header only", "  sl  local_address rem_address   st\n", "tcp", map[string]socketKey{}},
+		{"empty", "", "tcp", map[string]socketKey{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inodes := make(map[string]socketKey)
+			parseProcNet([]byte(tt.data), tt.transport, inodes)
+			if !reflect.DeepEqual(inodes, tt.want) {
+				t.Errorf("parseProcNet() = %v, want %v", inodes, tt.want)
+			}
+		})
+	}
+}
+
+// writeProcFixture writes a /proc tree with the socket tables and processes whose
+// file descriptors link to sockets
+func writeProcFixture(t *testing.T, processes map[string]map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	write := func(path, data string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(target, path string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(filepath.Join(root, "net", "tcp"), procNetTCP)
+	write(filepath.Join(root, "net", "tcp6"), procNetTCP6)
+	write(filepath.Join(root, "net", "udp"), procNetUDP)
+	// net/udp6 is missing, as when IPv6 is disabled
+	write(filepath.Join(root, "self", "comm"), "self\n") // not a pid, so skipped
+
+	for pid, fds := range processes {
+		for fd, target := range fds {
+			switch fd {
+			case "comm":
+				write(filepath.Join(root, pid, "comm"), target+"\n")
+			case "exe":
+				link(target, filepath.Join(root, pid, "exe"))
+			default:
+				link(target, filepath.Join(root, pid, "fd", fd))
+			}
+		}
+	}
+	return root
+}
+
+func TestProcResolverSockets(t *testing.T) {
+	root := writeProcFixture(t, map[string]map[string]string{
+		"1200": {
+			"comm": "firefox",
+			"exe":  "/usr/lib/firefox/firefox",
+			"0":    "/dev/null",
+			"40":   "socket:[31877]",
+			"41":   "socket:[40100]",
+			"42":   "pipe:[555]",
+		},
+		"1300": {
+			"comm": "mysqld",
+			"3":    "socket:[31201]",
+			"4":    "socket:[99999]", // a socket that is not in the tables
+		},
+		"1400": {
+			"comm": "curl",
+			"5":    "socket:[40022]",
+		},
+		"1500": {"comm": "sleep"}, // no fd directory, like another user's process
+	})
+
+	owners, err := procResolver{root: root}.sockets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[socketKey]socketOwner{
+		{Transport: "tcp", Port: 52000}: {PID: 1200, Command: "firefox", Path: "/usr/lib/firefox/firefox"},
+		{Transport: "udp", Port: 60000}: {PID: 1200, Command: "firefox", Path: "/usr/lib/firefox/firefox"},
+		{Transport: "tcp", Port: 3306}:  {PID: 1300, Command: "mysqld"},
+		{Transport: "tcp", Port: 54321}: {PID: 1400, Command: "curl"},
+	}
+	if !reflect.DeepEqual(owners, want) {
+		t.Errorf("sockets() = %v, want %v", owners, want)
+	}
+}
+
+func TestProcResolverMissingProc(t *testing.T) {
+	if _, err := (procResolver{root: filepath.Join(t.TempDir(), "missing")}).sockets(); err == nil {
+		t.Error("sockets() of a missing /proc returned no error")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package main
+
+// newOwnerResolver returns nil: connections are not attributed to apps on this platform
+func newOwnerResolver() ownerResolver {
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeOwners is an ownerResolver with a fixed socket table. It signals listed each
+// time the sockets are listed.
+type fakeOwners struct {
+	owners map[socketKey]socketOwner
+	err    error
+	listed chan struct{}
+}
+
+func (f fakeOwners) sockets() (map[socketKey]socketOwner, error) {
+	defer func() { f.listed <- struct{}{} }()
+	return f.owners, f.err
+}
+
+// attributeBatch runs attributeOwners over the connections opened by packets until the
+// resolver has listed the sockets once
+func attributeBatch(t *testing.T, agent *Agent, resolver fakeOwners, packets ...*packet) []*NetworkConnection {
+	t.Helper()
+	agent.ownerLookups = make(chan ownerLookup, ownerQueueSize)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		agent.attributeOwners(resolver, stop)
+	}()
+
+	at := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+	var conns []*NetworkConnection
+	for i, p := range packets {
+		lookup(agent, at, "www.example.net", p.Dst.String())
+		agent.processFlow(at.Add(time.Duration(i)*time.Millisecond), p)
+		conns = append(conns, agent.activeDomains[fmt.Sprintf("www.example.net:%d", p.DstPort)])
+	}
+
+	select {
+	case <-resolver.listed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the sockets were never listed")
+	}
+	close(stop)
+	<-done
+	return conns
+}
+
+func TestAttributeOwners(t *testing.T) {
+	resolver := fakeOwners{
+		owners: map[socketKey]socketOwner{
+			{Transport: "tcp", Port: 52000}: {PID: 412, Command: "Google Chrome He", Path: "/Applications/Google Chrome.app/Contents/Frameworks/Google Chrome Framework.framework/Helpers/Google Chrome Helper.app/Contents/MacOS/Google Chrome Helper"},
+		},
+		listed: make(chan struct{}, 1),
+	}
+	p := syn("192.0.2.1", 443)
+	p.SrcPort = 52000
+
+	conns := attributeBatch(t, newReplayAgent(defaultConfig()), resolver, p)
+	if conns[0] == nil {
+		t.Fatal("connection was not recorded")
+	}
+	if conns[0].AppName != "Google Chrome" || !reflect.DeepEqual(conns[0].Apps, []string{"Google Chrome"}) {
+		t.Errorf("connection app = %q %v, want Google Chrome", conns[0].AppName, conns[0].Apps)
+	}
+}
+
+func TestAttributeOwnersLeavesUnknownOwner(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolver fakeOwners
+	}{
+		{"socket already closed", fakeOwners{owners: map[socketKey]socketOwner{
+			{Transport: "udp", Port: 52000}: {PID: 7, Command: "dnsmasq"}, // same port, other transport
+			{Transport: "tcp", Port: 52001}: {PID: 8, Command: "curl"},
+		}}},
+		{"sockets cannot be listed", fakeOwners{err: errors.New("permission denied")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.resolver.listed = make(chan struct{}, 1)
+			p := syn("192.0.2.1", 443)
+			p.SrcPort = 52000
+
+			conns := attributeBatch(t, newReplayAgent(defaultConfig()), tt.resolver, p)
+			if conns[0] == nil {
+				t.Fatal("connection was not recorded")
+			}
+			if conns[0].AppName != unknownApp || len(conns[0].Apps) != 0 {
+				t.Errorf("connection app = %q %v, want %q", conns[0].AppName, conns[0].Apps, unknownApp)
+			}
+		})
+	}
+}
+
+func TestQueueOwnerLookupInReplay(t *testing.T) {
+	// Replay has no sockets to look up, so connections stay unattributed
+	agent := newReplayAgent(defaultConfig())
+	at := time.Now()
+	lookup(agent, at, "www.example.net", "192.0.2.1")
+	agent.processFlow(at, syn("192.0.2.1", 443))
+
+	if conn := agent.activeDomains["www.example.net:443"]; conn == nil || conn.AppName != unknownApp {
+		t.Errorf("connection = %+v, want app %q", conn, unknownApp)
+	}
+}
+
+func TestAttributeConnection(t *testing.T) {
+	config := defaultConfig()
+	config.Privacy.ExcludeApps = []string{"Secret App"}
+	agent := newReplayAgent(config)
+	conn := &NetworkConnection{Domain: "www.example.net", Port: 443, AppName: unknownApp}
+
+	agent.attributeConnection(conn, socketOwner{PID: 1, Command: "secret", Path: "/Applications/Secret App.app/Contents/MacOS/secret"})
+	if conn.AppName != unknownApp || len(conn.Apps) != 0 {
+		t.Errorf("excluded app recorded: %q %v", conn.AppName, conn.Apps)
+	}
+
+	agent.attributeConnection(conn, socketOwner{PID: 2, Command: "firefox", Path: "/usr/lib/firefox/firefox"})
+	agent.attributeConnection(conn, socketOwner{PID: 3, Command: "curl"})
+	agent.attributeConnection(conn, socketOwner{PID: 2, Command: "firefox", Path: "/usr/lib/firefox/firefox"})
+	if conn.AppName != "firefox" || !reflect.DeepEqual(conn.Apps, []string{"firefox", "curl"}) {
+		t.Errorf("connection app = %q %v, want firefox last and both listed once", conn.AppName, conn.Apps)
+	}
+}
+
+func TestSocketOwnerAppName(t *testing.T) {
+	tests := []struct {
+		owner socketOwner
+		want  string
+	}{
+		{socketOwner{Command: "curl"}, "curl"},
+		{socketOwner{Command: "firefox-bin", Path: "/usr/lib/firefox/firefox-bin"}, "firefox-bin"},
+		{socketOwner{Command: "Slack Helper", Path: "/Applications/Slack.app/Contents/Frameworks/Slack Helper.app/Contents/MacOS/Slack Helper"}, "Slack"},
+		{socketOwner{Command: "Safari", Path: "/Applications/Safari.app/Contents/MacOS/Safari"}, "Safari"},
+	}
+	for _, tt := range tests {
+		if got := tt.owner.appName(); got != tt.want {
+			t.Errorf("appName(%+v) = %q, want %q", tt.owner, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Link-layer header types of pcap files (https://www.tcpdump.org/linktypes.html)
+const (
+	linkTypeNull        = 0   // BSD loopback, address family in host byte order
+	linkTypeEthernet    = 1   // EN10MB
+	linkTypeRawBSD      = 12  // DLT_RAW as written by some BSDs
+	linkTypeRawOpenBSD  = 14  // DLT_RAW on OpenBSD
+	linkTypeRaw         = 101 // raw IPv4 or IPv6
+	linkTypeLoop        = 108 // OpenBSD loopback, address family in network byte order
+	linkTypeLinuxSLL    = 113 // Linux "any" device
+	linkTypePKTAPApple  = 149 // DLT_PKTAP as written by macOS
+	linkTypePKTAP       = 258 // macOS "any" device, with process metadata
+	linkTypeLinuxSLL2   = 276 // Linux "any" device, newer tcpdump
+	pcapMaxSnapLen      = 262144
+	pcapRecordHeaderLen = 16
+)
+
+// pcapReader reads the packets of a classic libpcap stream, as written by "tcpdump -w -"
+type pcapReader struct {
+	reader   *bufio.Reader
+	order    binary.ByteOrder
+	nanos    bool
+	linkType uint32
+}
+
+// capturedPacket is one record of a pcap stream
+type capturedPacket struct {
+	Time time.Time
+	Data []byte
+}
+
+// newPcapReader reads the stream's global header
+func newPcapReader(r io.Reader) (*pcapReader, error) {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("error reading pcap header: %v", err)
+	}
+
+	p := &pcapReader{reader: reader}
+	switch magic := binary.LittleEndian.Uint32(header); magic {
+	case 0xa1b2c3d4:
+		p.order = binary.LittleEndian
+	case 0xd4c3b2a1:
+		p.order = binary.BigEndian
+	case 0xa1b23c4d:
+		p.order, p.nanos = binary.LittleEndian, true
+	case 0x4d3cb2a1:
+		p.order, p.nanos = binary.BigEndian, true
+	case 0x0a0d0d0a:
+		return nil, errors.New("pcapng files are not supported, write the capture in pcap format (tcpdump -w without -P)")
+	default:
+		return nil, fmt.Errorf("not a pcap stream (magic %#08x)", magic)
+	}
+	p.linkType = p.order.Uint32(header[20:]) & 0x0fffffff
+	return p, nil
+}
+
+// next returns the next packet, or io.EOF at the end of the stream
+func (p *pcapReader) next() (capturedPacket, error) {
+	header := make([]byte, pcapRecordHeaderLen)
+	if _, err := io.ReadFull(p.reader, header); err != nil {
+		if err == io.EOF {
+			return capturedPacket{}, io.EOF
+		}
+		return capturedPacket{}, fmt.Errorf("error reading pcap record: %v", err)
+	}
+
+	seconds := int64(p.order.Uint32(header[0:]))
+	fraction := int64(p.order.Uint32(header[4:]))
+	length := p.order.Uint32(header[8:])
+	if length > pcapMaxSnapLen {
+		return capturedPacket{}, fmt.Errorf("pcap record of %d bytes is too long", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(p.reader, data); err != nil {
+		return capturedPacket{}, fmt.Errorf("error reading pcap record: %v", err)
+	}
+
+	if !p.nanos {
+		fraction *= int64(time.Microsecond)
+	}
+	return capturedPacket{Time: time.Unix(seconds, fraction), Data: data}, nil
+}
+
+// packet is the IP and transport layer of a captured packet
+type packet struct {
+	Src       net.IP
+	Dst       net.IP
+	Transport string // "udp" or "tcp"
+	SrcPort   uint16
+	DstPort   uint16
+	TCPFlags  uint8
+	Payload   []byte
+	Owner     *socketOwner // the process that sent or received the packet, from a PKTAP header
+}
+
+// errNotIP is returned for frames that do not carry IPv4 or IPv6, such as ARP
+var errNotIP = errors.New("not an IP packet")
+
+// decodePacket decodes a frame of the given link type down to its UDP or TCP payload
+func decodePacket(linkType uint32, data []byte) (*packet, error) {
+	switch linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return nil, errors.New("truncated Ethernet header")
+		}
+		etherType := binary.BigEndian.Uint16(data[12:])
+		data = data[14:]
+		// 802.1Q and 802.1ad VLAN tags
+		for etherType == 0x8100 || etherType == 0x88a8 {
+			if len(data) < 4 {
+				return nil, errors.New("truncated VLAN tag")
+			}
+			etherType = binary.BigEndian.Uint16(data[2:])
+			data = data[4:]
+		}
+		return decodeEtherType(etherType, data)
+	case linkTypeNull, linkTypeLoop:
+		// The address family's value differs between systems and byte orders,
+		// so the IP version is taken from the packet itself
+		if len(data) < 4 {
+			return nil, errors.New("truncated loopback header")
+		}
+		return decodeIP(data[4:])
+	case linkTypeRaw, linkTypeRawBSD, linkTypeRawOpenBSD:
+		return decodeIP(data)
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return nil, errors.New("truncated SLL header")
+		}
+		return decodeEtherType(binary.BigEndian.Uint16(data[14:]), data[16:])
+	case linkTypeLinuxSLL2:
+		if len(data) < 20 {
+			return nil, errors.New("truncated SLL2 header")
+		}
+		return decodeEtherType(binary.BigEndian.Uint16(data[0:]), data[20:])
+	case linkTypePKTAP, linkTypePKTAPApple:
+		// The header is in the byte order of the Mac that wrote it, which is little-endian
+		if len(data) < 12 {
+			return nil, errors.New("truncated PKTAP header")
+		}
+		headerLen := binary.LittleEndian.Uint32(data[0:])
+		innerType := binary.LittleEndian.Uint32(data[8:])
+		if headerLen < 12 || int(headerLen) > len(data) {
+			return nil, fmt.Errorf("invalid PKTAP header length %d", headerLen)
+		}
+		if innerType == linkTypePKTAP || innerType == linkTypePKTAPApple {
+			return nil, errors.New("nested PKTAP header")
+		}
+		p, err := decodePacket(innerType, data[headerLen:])
+		if err == nil {
+			p.Owner = pktapOwner(data[:headerLen])
+		}
+		return p, err
+	}
+	return nil, fmt.Errorf("unsupported link type %d", linkType)
+}
+
+// pktapOwner returns the process recorded in a PKTAP header, or nil if there is none.
+// The effective process, which a system service made the connection for, is preferred.
+func pktapOwner(header []byte) *socketOwner {
+	process := func(pidOffset, commOffset int) *socketOwner {
+		if len(header) < commOffset+17 {
+			return nil
+		}
+		pid := int32(binary.LittleEndian.Uint32(header[pidOffset:]))
+		comm := header[commOffset : commOffset+17]
+		if i := bytes.IndexByte(comm, 0); i >= 0 {
+			comm = comm[:i]
+		}
+		if pid <= 0 || len(comm) == 0 {
+			return nil
+		}
+		return &socketOwner{PID: int(pid), Command: string(comm)}
+	}
+
+	if owner := process(84, 88); owner != nil {
+		return owner
+	}
+	return process(52, 56)
+}
+
+// decodeEtherType decodes the payload of an Ethernet-style frame
+func decodeEtherType(etherType uint16, data []byte) (*packet, error) {
+	switch etherType {
+	case 0x0800, 0x86dd:
+		return decodeIP(data)
+	}
+	return nil, errNotIP
+}
+
+// decodeIP decodes an IPv4 or IPv6 packet
+func decodeIP(data []byte) (*packet, error) {
+	if len(data) < 1 {
+		return nil, errors.New("empty IP packet")
+	}
+
+	switch data[0] >> 4 {
+	case 4:
+		if len(data) < 20 {
+			return nil, errors.New("truncated IPv4 header")
+		}
+		headerLen := int(data[0]&0x0f) * 4
+		totalLen := int(binary.BigEndian.Uint16(data[2:]))
+		if headerLen < 20 || totalLen < headerLen || len(data) < headerLen {
+			return nil, errors.New("invalid IPv4 header")
+		}
+		// Ethernet pads short frames, a snap length can cut long ones
+		if totalLen < len(data) {
+			data = data[:totalLen]
+		}
+		// Only the first fragment has the transport header
+		if binary.BigEndian.Uint16(data[6:])&0x1fff != 0 {
+			return nil, errors.New("IPv4 fragment")
+		}
+		return decodeTransport(data[9], net.IP(data[12:16]), net.IP(data[16:20]), data[headerLen:])
+	case 6:
+		if len(data) < 40 {
+			return nil, errors.New("truncated IPv6 header")
+		}
+		payloadLen := int(binary.BigEndian.Uint16(data[4:]))
+		src, dst := net.IP(data[8:24]), net.IP(data[24:40])
+		nextHeader := data[6]
+		data = data[40:]
+		if payloadLen < len(data) {
+			data = data[:payloadLen]
+		}
+
+		// Skip extension headers
+	headers:
+		for i := 0; i < 8; i++ {
+			switch nextHeader {
+			case 0, 43, 60: // hop-by-hop, routing, destination options
+				if len(data) < 8 || len(data) < (int(data[1])+1)*8 {
+					return nil, errors.New("truncated IPv6 extension header")
+				}
+				nextHeader, data = data[0], data[(int(data[1])+1)*8:]
+				continue
+			case 44: // fragment
+				if len(data) < 8 {
+					return nil, errors.New("truncated IPv6 fragment header")
+				}
+				if binary.BigEndian.Uint16(data[2:])&0xfff8 != 0 {
+					return nil, errors.New("IPv6 fragment")
+				}
+				nextHeader, data = data[0], data[8:]
+				continue
+			case 51: // authentication header
+				if len(data) < 8 || len(data) < (int(data[1])+2)*4 {
+					return nil, errors.New("truncated IPv6 authentication header")
+				}
+				nextHeader, data = data[0], data[(int(data[1])+2)*4:]
+				continue
+			}
+			break headers
+		}
+		return decodeTransport(nextHeader, src, dst, data)
+	}
+	return nil, errNotIP
+}
+
+// decodeTransport decodes a UDP datagram or TCP segment
+func decodeTransport(protocol uint8, src, dst net.IP, data []byte) (*packet, error) {
+	p := &packet{Src: src, Dst: dst}
+	switch protocol {
+	case 17:
+		if len(data) < 8 {
+			return nil, errors.New("truncated UDP header")
+		}
+		p.Transport = "udp"
+		p.SrcPort = binary.BigEndian.Uint16(data[0:])
+		p.DstPort = binary.BigEndian.Uint16(data[2:])
+		p.Payload = data[8:]
+	case 6:
+		if len(data) < 20 {
+			return nil, errors.New("truncated TCP header")
+		}
+		headerLen := int(data[12]>>4) * 4
+		if headerLen < 20 || len(data) < headerLen {
+			return nil, errors.New("invalid TCP header")
+		}
+		p.Transport = "tcp"
+		p.SrcPort = binary.BigEndian.Uint16(data[0:])
+		p.DstPort = binary.BigEndian.Uint16(data[2:])
+		p.TCPFlags = data[13]
+		p.Payload = data[headerLen:]
+	default:
+		return nil, fmt.Errorf("unsupported IP protocol %d", protocol)
+	}
+	return p, nil
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Packet builders for fixtures. Checksums are left zero: the agent never checks them.
+
+func ipv4Packet(protocol uint8, src, dst string, transport []byte) []byte {
+	header := make([]byte, 20)
+	header[0] = 0x45
+	binary.BigEndian.PutUint16(header[2:], uint16(20+len(transport)))
+	header[8] = 64
+	header[9] = protocol
+	copy(header[12:], net.ParseIP(src).To4())
+	copy(header[16:], net.ParseIP(dst).To4())
+	return append(header, transport...)
+}
+
+func ipv6Packet(nextHeader uint8, src, dst string, payload []byte) []byte {
+	header := make([]byte, 40)
+	header[0] = 0x60
+	binary.BigEndian.PutUint16(header[4:], uint16(len(payload)))
+	header[6] = nextHeader
+	header[7] = 64
+	copy(header[8:], net.ParseIP(src).To16())
+	copy(header[24:], net.ParseIP(dst).To16())
+	return append(header, payload...)
+}
+
+func udpSegment(srcPort, dstPort uint16, payload []byte) []byte {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint16(header[0:], srcPort)
+	binary.BigEndian.PutUint16(header[2:], dstPort)
+	binary.BigEndian.PutUint16(header[4:], uint16(8+len(payload)))
+	return append(header, payload...)
+}
+
+func tcpSegment(srcPort, dstPort uint16, flags uint8, payload []byte) []byte {
+	header := make([]byte, 20)
+	binary.BigEndian.PutUint16(header[0:], srcPort)
+	binary.BigEndian.PutUint16(header[2:], dstPort)
+	header[12] = 5 << 4
+	header[13] = flags
+	return append(header, payload...)
+}
+
+const (
+	tcpSYN = 0x02
+	tcpACK = 0x10
+	tcpPSH = 0x08
+)
+
+func ethernetFrame(etherType uint16, payload []byte) []byte {
+	header := make([]byte, 14)
+	copy(header[0:], []byte{0x02, 0, 0, 0, 0, 1})
+	copy(header[6:], []byte{0x02, 0, 0, 0, 0, 2})
+	binary.BigEndian.PutUint16(header[12:], etherType)
+	return append(header, payload...)
+}
+
+func sllFrame(etherType uint16, payload []byte) []byte {
+	header := make([]byte, 16)
+	binary.BigEndian.PutUint16(header[0:], 4) // sent by us
+	binary.BigEndian.PutUint16(header[2:], 1)
+	binary.BigEndian.PutUint16(header[4:], 6)
+	binary.BigEndian.PutUint16(header[14:], etherType)
+	return append(header, payload...)
+}
+
+func sll2Frame(etherType uint16, payload []byte) []byte {
+	header := make([]byte, 20)
+	binary.BigEndian.PutUint16(header[0:], etherType)
+	binary.BigEndian.PutUint32(header[4:], 2) // interface index
+	binary.BigEndian.PutUint16(header[8:], 1)
+	header[10] = 4
+	header[11] = 6
+	return append(header, payload...)
+}
+
+// nullFrame is a BSD loopback frame, whose address family is in host byte order
+func nullFrame(family uint32, order binary.ByteOrder, payload []byte) []byte {
+	header := make([]byte, 4)
+	order.PutUint32(header, family)
+	return append(header, payload...)
+}
+
+// pktapFrame wraps an inner frame in a macOS PKTAP header naming the process, and the
+// effective process if epid is not zero
+func pktapFrame(innerType uint32, pid int32, comm string, epid int32, ecomm string, inner []byte) []byte {
+	header := make([]byte, 156)
+	binary.LittleEndian.PutUint32(header[0:], uint32(len(header)))
+	binary.LittleEndian.PutUint32(header[4:], 1)
+	binary.LittleEndian.PutUint32(header[8:], innerType)
+	copy(header[12:], "en0")
+	binary.LittleEndian.PutUint32(header[52:], uint32(pid))
+	copy(header[56:], comm)
+	binary.LittleEndian.PutUint32(header[84:], uint32(epid))
+	copy(header[88:], ecomm)
+	return append(header, inner...)
+}
+
+// dnsNameBytes encodes name in wire format without compression
+func dnsNameBytes(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// dnsQuery builds a query for qname
+func dnsQuery(id uint16, qname string, qtype DNSType) []byte {
+	message := make([]byte, 12)
+	binary.BigEndian.PutUint16(message[0:], id)
+	binary.BigEndian.PutUint16(message[2:], 0x0100) // recursion desired
+	binary.BigEndian.PutUint16(message[4:], 1)
+	message = append(message, dnsNameBytes(qname)...)
+	return append(message, byte(qtype>>8), byte(qtype), 0, 1)
+}
+
+// dnsResponse builds a NOERROR response to a query for qname with the given answers
+func dnsResponse(id uint16, qname string, qtype DNSType, answers ...DNSAnswer) []byte {
+	message := dnsQuery(id, qname, qtype)
+	binary.BigEndian.PutUint16(message[2:], 0x8180)
+	binary.BigEndian.PutUint16(message[6:], uint16(len(answers)))
+	for _, answer := range answers {
+		var rdata []byte
+		switch answer.Type {
+		case DNSTypeA:
+			rdata = net.ParseIP(answer.Data).To4()
+		case DNSTypeAAAA:
+			rdata = net.ParseIP(answer.Data).To16()
+		default:
+			rdata = dnsNameBytes(answer.Data)
+		}
+		record := make([]byte, 10)
+		binary.BigEndian.PutUint16(record[0:], uint16(answer.Type))
+		binary.BigEndian.PutUint16(record[2:], 1)
+		binary.BigEndian.PutUint32(record[4:], answer.TTL)
+		binary.BigEndian.PutUint16(record[8:], uint16(len(rdata)))
+		message = append(message, dnsNameBytes(answer.Name)...)
+		message = append(message, record...)
+		message = append(message, rdata...)
+	}
+	return message
+}
+
+// pcapRecord is a packet of a pcap fixture
+type pcapRecord struct {
+	at   time.Time
+	data []byte
+}
+
+// pcapFile builds a little-endian, microsecond pcap stream
+func pcapFile(linkType uint32, records ...pcapRecord) []byte {
+	var b bytes.Buffer
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], pcapMaxSnapLen)
+	binary.LittleEndian.PutUint32(header[20:], linkType)
+	b.Write(header)
+	for _, record := range records {
+		recordHeader := make([]byte, pcapRecordHeaderLen)
+		binary.LittleEndian.PutUint32(recordHeader[0:], uint32(record.at.Unix()))
+		binary.LittleEndian.PutUint32(recordHeader[4:], uint32(record.at.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(recordHeader[8:], uint32(len(record.data)))
+		binary.LittleEndian.PutUint32(recordHeader[12:], uint32(len(record.data)))
+		b.Write(recordHeader)
+		b.Write(record.data)
+	}
+	return b.Bytes()
+}
+
+func TestPcapReader(t *testing.T) {
+	at := time.Date(2026, 10, 16, 9, 30, 0, 123456000, time.UTC)
+	frame := ethernetFrame(0x0800, ipv4Packet(17, "10.0.0.2", "10.0.0.1", udpSegment(50000, 53, dnsQuery(1, "example.org", DNSTypeA))))
+	stream := pcapFile(linkTypeEthernet, pcapRecord{at, frame}, pcapRecord{at.Add(time.Second), frame})
+
+	reader, err := newPcapReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader.linkType != linkTypeEthernet {
+		t.Errorf("linkType = %d, want %d", reader.linkType, linkTypeEthernet)
+	}
+	for i := 0; i < 2; i++ {
+		captured, err := reader.next()
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		if want := at.Add(time.Duration(i) * time.Second); !captured.Time.Equal(want) {
+			t.Errorf("packet %d: time = %v, want %v", i, captured.Time, want)
+		}
+		if !bytes.Equal(captured.Data, frame) {
+			t.Errorf("packet %d: data differs", i)
+		}
+	}
+	if _, err := reader.next(); err != io.EOF {
+		t.Errorf("after the last packet err = %v, want io.EOF", err)
+	}
+}
+
+func TestPcapReaderByteOrders(t *testing.T) {
+	tests := []struct {
+		name  string
+		magic []byte
+		order binary.ByteOrder
+		nanos bool
+	}{
+		{"little-endian micro", []byte{0xd4, 0xc3, 0xb2, 0xa1}, binary.LittleEndian, false},
+		{"big-endian micro", []byte{0xa1, 0xb2, 0xc3, 0xd4}, binary.BigEndian, false},
+		{"little-endian nano", []byte{0x4d, 0x3c, 0xb2, 0xa1}, binary.LittleEndian, true},
+		{"big-endian nano", []byte{0xa1, 0xb2, 0x3c, 0x4d}, binary.BigEndian, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := make([]byte, 24)
+			copy(header, tt.magic)
+			tt.order.PutUint32(header[20:], linkTypeRaw)
+			record := make([]byte, pcapRecordHeaderLen)
+			tt.order.PutUint32(record[0:], 1000)
+			tt.order.PutUint32(record[4:], 500)
+			tt.order.PutUint32(record[8:], 1)
+			tt.order.PutUint32(record[12:], 1)
+			stream := append(append(header, record...), 0x45)
+
+			reader, err := newPcapReader(bytes.NewReader(stream))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reader.linkType != linkTypeRaw || reader.nanos != tt.nanos {
+				t.Errorf("linkType = %d, nanos = %v", reader.linkType, reader.nanos)
+			}
+			captured, err := reader.next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			fraction := 500 * time.Microsecond
+			if tt.nanos {
+				fraction = 500 * time.Nanosecond
+			}
+			if want := time.Unix(1000, int64(fraction)); !captured.Time.Equal(want) {
+				t.Errorf("time = %v, want %v", captured.Time, want)
+			}
+		})
+	}
+}
+
+func TestPcapReaderErrors(t *testing.T) {
+	valid := pcapFile(linkTypeRaw, pcapRecord{time.Unix(1, 0), ipv4Packet(17, "10.0.0.2", "10.0.0.1", udpSegment(1, 53, nil))})
+	pcapng := make([]byte, 24)
+	copy(pcapng, []byte{0x0a, 0x0d, 0x0d, 0x0a})
+	tooLong := append([]byte(nil), valid[:24]...)
+	record := make([]byte, pcapRecordHeaderLen)
+	binary.LittleEndian.PutUint32(record[8:], pcapMaxSnapLen+1)
+	tooLong = append(tooLong, record...)
+
+	tests := []struct {
+		name      string
+		stream    []byte
+		headerErr string
+		nextErr   string
+	}{
+		{"pcapng", pcapng, "pcapng", ""},
+		{"not pcap", []byte(strings.Repeat("x", 24)), "not a pcap stream", ""},
+		{"truncated global header", valid[:10], "error reading pcap header", ""},
+		{"truncated record header", valid[:24+8], "", "error reading pcap record"},
+		{"truncated record data", valid[:len(valid)-1], "", "error reading pcap record"},
+		{"record too long", tooLong, "", "too long"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := newPcapReader(bytes.NewReader(tt.stream))
+			if tt.headerErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.headerErr) {
+					t.Fatalf("newPcapReader() err = %v, want %q", err, tt.headerErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := reader.next(); err == nil || !strings.Contains(err.Error(), tt.nextErr) {
+				t.Errorf("next() err = %v, want %q", err, tt.nextErr)
+			}
+		})
+	}
+
+	if _, err := newPcapReader(bytes.NewReader(nil)); err != io.EOF {
+		t.Errorf("empty stream err = %v, want io.EOF", err)
+	}
+}
+
+func TestDecodePacketLinkTypes(t *testing.T) {
+	query := dnsQuery(7, "example.org", DNSTypeA)
+	v4 := ipv4Packet(17, "192.168.1.20", "192.168.1.1", udpSegment(51000, 53, query))
+	v6 := ipv6Packet(17, "fd00::20", "fd00::1", udpSegment(51000, 53, query))
+	vlan := append([]byte{0x00, 0x64, 0x08, 0x00}, v4...)
+
+	tests := []struct {
+		name     string
+		linkType uint32
+		frame    []byte
+		src, dst string
+	}{
+		{"Ethernet IPv4", linkTypeEthernet, ethernetFrame(0x0800, v4), "192.168.1.20", "192.168.1.1"},
+		{"Ethernet IPv6", linkTypeEthernet, ethernetFrame(0x86dd, v6), "fd00::20", "fd00::1"},
+		{"Ethernet 802.1Q", linkTypeEthernet, ethernetFrame(0x8100, vlan), "192.168.1.20", "192.168.1.1"},
+		{"Ethernet padded", linkTypeEthernet, append(ethernetFrame(0x0800, v4), 0, 0, 0, 0), "192.168.1.20", "192.168.1.1"},
+		{"SLL", linkTypeLinuxSLL, sllFrame(0x0800, v4), "192.168.1.20", "192.168.1.1"},
+		{"SLL IPv6", linkTypeLinuxSLL, sllFrame(0x86dd, v6), "fd00::20", "fd00::1"},
+		{"SLL2", linkTypeLinuxSLL2, sll2Frame(0x0800, v4), "192.168.1.20", "192.168.1.1"},
+		{"SLL2 IPv6", linkTypeLinuxSLL2, sll2Frame(0x86dd, v6), "fd00::20", "fd00::1"},
+		{"NULL little-endian", linkTypeNull, nullFrame(2, binary.LittleEndian, v4), "192.168.1.20", "192.168.1.1"},
+		{"NULL big-endian IPv6", linkTypeNull, nullFrame(30, binary.BigEndian, v6), "fd00::20", "fd00::1"},
+		{"OpenBSD loopback", linkTypeLoop, nullFrame(2, binary.BigEndian, v4), "192.168.1.20", "192.168.1.1"},
+		{"raw", linkTypeRaw, v4, "192.168.1.20", "192.168.1.1"},
+		{"PKTAP Ethernet", linkTypePKTAP, pktapFrame(linkTypeEthernet, 0, "", 0, "", ethernetFrame(0x0800, v4)), "192.168.1.20", "192.168.1.1"},
+		{"PKTAP Apple", linkTypePKTAPApple, pktapFrame(linkTypeNull, 0, "", 0, "", nullFrame(2, binary.LittleEndian, v4)), "192.168.1.20", "192.168.1.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := decodePacket(tt.linkType, tt.frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Src.String() != tt.src || p.Dst.String() != tt.dst {
+				t.Errorf("addresses = %s -> %s, want %s -> %s", p.Src, p.Dst, tt.src, tt.dst)
+			}
+			if p.Transport != "udp" || p.SrcPort != 51000 || p.DstPort != 53 {
+				t.Errorf("transport = %s %d -> %d", p.Transport, p.SrcPort, p.DstPort)
+			}
+			if !bytes.Equal(p.Payload, query) {
+				t.Errorf("payload = %x, want %x", p.Payload, query)
+			}
+		})
+	}
+}
+
+func TestDecodePacketTCP(t *testing.T) {
+	frame := ethernetFrame(0x0800, ipv4Packet(6, "10.0.0.2", "93.184.216.34", tcpSegment(52000, 443, tcpSYN, nil)))
+	p, err := decodePacket(linkTypeEthernet, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Transport != "tcp" || p.DstPort != 443 || p.TCPFlags != tcpSYN || !flowStart(p) {
+		t.Errorf("decoded %+v", p)
+	}
+}
+
+func TestDecodePacketPKTAPOwner(t *testing.T) {
+	inner := ethernetFrame(0x0800, ipv4Packet(6, "10.0.0.2", "93.184.216.34", tcpSegment(52000, 443, tcpSYN, nil)))
+
+	tests := []struct {
+		name    string
+		frame   []byte
+		pid     int
+		command string
+	}{
+		{"process", pktapFrame(linkTypeEthernet, 412, "firefox", 0, "", inner), 412, "firefox"},
+		{"effective process", pktapFrame(linkTypeEthernet, 98, "nsurlsessiond", 412, "Slack", inner), 412, "Slack"},
+		{"no process", pktapFrame(linkTypeEthernet, 0, "", 0, "", inner), 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := decodePacket(linkTypePKTAP, tt.frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.pid == 0 {
+				if p.Owner != nil {
+					t.Errorf("owner = %+v, want none", p.Owner)
+				}
+				return
+			}
+			if p.Owner == nil || p.Owner.PID != tt.pid || p.Owner.Command != tt.command {
+				t.Errorf("owner = %+v, want pid %d %s", p.Owner, tt.pid, tt.command)
+			}
+		})
+	}
+}
+
+func TestDecodePacketErrors(t *testing.T) {
+	v4 := ipv4Packet(17, "10.0.0.2", "10.0.0.1", udpSegment(1, 53, nil))
+	fragment := append([]byte(nil), v4...)
+	binary.BigEndian.PutUint16(fragment[6:], 10)
+	nestedPKTAP := pktapFrame(linkTypePKTAP, 0, "", 0, "", v4)
+	badPKTAP := pktapFrame(linkTypeRaw, 0, "", 0, "", v4)
+	binary.LittleEndian.PutUint32(badPKTAP[0:], uint32(len(badPKTAP)+1))
+
+	tests := []struct {
+		name     string
+		linkType uint32
+		frame    []byte
+		err      string
+	}{
+		{"short Ethernet", linkTypeEthernet, make([]byte, 10), "truncated Ethernet header"},
+		{"truncated VLAN tag", linkTypeEthernet, ethernetFrame(0x8100, []byte{0}), "truncated VLAN tag"},
+		{"ARP", linkTypeEthernet, ethernetFrame(0x0806, make([]byte, 28)), "not an IP packet"},
+		{"short SLL", linkTypeLinuxSLL, make([]byte, 15), "truncated SLL header"},
+		{"short SLL2", linkTypeLinuxSLL2, make([]byte, 19), "truncated SLL2 header"},
+		{"short loopback", linkTypeNull, []byte{2, 0}, "truncated loopback header"},
+		{"short PKTAP", linkTypePKTAP, make([]byte, 8), "truncated PKTAP header"},
+		{"PKTAP length past the frame", linkTypePKTAP, badPKTAP, "invalid PKTAP header length"},
+		{"nested PKTAP", linkTypePKTAP, nestedPKTAP, "nested PKTAP header"},
+		{"unknown link type", 9999, v4, "unsupported link type"},
+		{"empty IP", linkTypeRaw, nil, "empty IP packet"},
+		{"truncated IPv4", linkTypeRaw, v4[:19], "truncated IPv4 header"},
+		{"IPv4 fragment", linkTypeRaw, fragment, "IPv4 fragment"},
+		{"truncated IPv6", linkTypeRaw, ipv6Packet(17, "::1", "::1", nil)[:39], "truncated IPv6 header"},
+		{"truncated UDP", linkTypeRaw, ipv4Packet(17, "10.0.0.2", "10.0.0.1", []byte{0, 1, 0}), "truncated UDP header"},
+		{"truncated TCP", linkTypeRaw, ipv4Packet(6, "10.0.0.2", "10.0.0.1", make([]byte, 10)), "truncated TCP header"},
+		{"ICMP", linkTypeRaw, ipv4Packet(1, "10.0.0.2", "10.0.0.1", make([]byte, 8)), "unsupported IP protocol 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodePacket(tt.linkType, tt.frame); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDecodeIPv6ExtensionHeaders(t *testing.T) {
+	udp := udpSegment(51000, 53, dnsQuery(1, "example.org", DNSTypeA))
+	hopByHop := append([]byte{17, 0, 0, 0, 0, 0, 0, 0}, udp...)
+	fragment := append([]byte{17, 0, 0, 0, 0, 0, 0, 1}, udp...)
+	later := append([]byte{17, 0, 0, 8, 0, 0, 0, 1}, udp...)
+
+	tests := []struct {
+		name    string
+		next    uint8
+		payload []byte
+		err     string
+	}{
+		{"hop-by-hop", 0, hopByHop, ""},
+		{"first fragment", 44, fragment, ""},
+		{"later fragment", 44, later, "IPv6 fragment"},
+		{"truncated extension", 0, []byte{17, 4, 0}, "truncated IPv6 extension header"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := decodeIP(ipv6Packet(tt.next, "fd00::20", "fd00::1", tt.payload))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Transport != "udp" || p.DstPort != 53 {
+				t.Errorf("decoded %+v", p)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// QUIC versions whose Initial packets can be decrypted (RFC 9001 and RFC 9369). Initial
+// packets are encrypted with keys derived from the destination connection ID and a
+// published salt, so anyone who sees them can read the ClientHello inside.
+var quicVersions = map[uint32]quicVersion{
+	0x00000001: {
+		initialType: 0,
+		salt:        mustDecodeHex("38762cf7f55934b34d179ae6a4c80cadccbb7f0a"),
+		keyLabel:    "quic key", ivLabel: "quic iv", hpLabel: "quic hp",
+	},
+	0x6b3343cf: {
+		initialType: 1,
+		salt:        mustDecodeHex("0dede3def700a6db819381be6e269dcbf9bd2ed9"),
+		keyLabel:    "quicv2 key", ivLabel: "quicv2 iv", hpLabel: "quicv2 hp",
+	},
+}
+
+// quicVersion is how one QUIC version protects its Initial packets
+type quicVersion struct {
+	initialType                byte // the long header packet type of Initial packets
+	salt                       []byte
+	keyLabel, ivLabel, hpLabel string
+}
+
+// quicHelloTimeout is how long the parts of a ClientHello split across Initial packets
+// are kept waiting for the rest
+const quicHelloTimeout = 10 * time.Second
+
+// maxQUICHellos bounds the ClientHellos being reassembled at once
+const maxQUICHellos = 256
+
+// maxQUICHelloSize bounds the size of a reassembled ClientHello
+const maxQUICHelloSize = 64 * 1024
+
+// quicHellos reassembles the ClientHellos carried in the CRYPTO frames of client
+// Initial packets. Browsers split large ClientHellos across packets and frames, in any
+// order, so each connection's frames are kept until the server name can be read.
+type quicHellos struct {
+	mutex   sync.Mutex
+	pending map[string]*quicHello
+}
+
+// quicHello is the ClientHello data of one connection received so far, by offset
+type quicHello struct {
+	frames  map[uint64][]byte
+	started time.Time
+}
+
+// serverName decrypts a client Initial packet and returns the server name of the
+// ClientHello it completes, or "" while parts of the ClientHello are missing
+func (q *quicHellos) serverName(at time.Time, p *packet) (string, error) {
+	dcid, frames, err := decryptQUICInitial(p.Payload)
+	if err != nil || len(frames) == 0 {
+		return "", err
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.pending == nil {
+		q.pending = make(map[string]*quicHello)
+	}
+	for key, hello := range q.pending {
+		if at.Sub(hello.started) > quicHelloTimeout || at.Before(hello.started) {
+			delete(q.pending, key)
+		}
+	}
+
+	key := p.Src.String() + " " + hex.EncodeToString(dcid)
+	hello, exists := q.pending[key]
+	if !exists {
+		if len(q.pending) >= maxQUICHellos {
+			return "", errors.New("too many QUIC ClientHellos being reassembled")
+		}
+		hello = &quicHello{frames: make(map[uint64][]byte), started: at}
+		q.pending[key] = hello
+	}
+	for offset, data := range frames {
+		hello.frames[offset] = data
+	}
+
+	name, err := clientHelloServerName(hello.assemble())
+	if err == errTruncatedHello {
+		return "", nil
+	}
+	delete(q.pending, key)
+	return name, err
+}
+
+// assemble returns the ClientHello data contiguous from its start
+func (h *quicHello) assemble() []byte {
+	offsets := make([]uint64, 0, len(h.frames))
+	for offset := range h.frames {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	var data []byte
+	for _, offset := range offsets {
+		if offset > uint64(len(data)) || len(data) >= maxQUICHelloSize {
+			break
+		}
+		frame := h.frames[offset]
+		if end := offset + uint64(len(frame)); end > uint64(len(data)) {
+			data = append(data, frame[uint64(len(data))-offset:]...)
+		}
+	}
+	return data
+}
+
+// decryptQUICInitial removes the protection of a client Initial packet, the first in a
+// UDP datagram. It returns the packet's destination connection ID and the data of its
+// CRYPTO frames by offset.
+func decryptQUICInitial(datagram []byte) ([]byte, map[uint64][]byte, error) {
+	if len(datagram) < 7 || datagram[0]&0xc0 != 0xc0 {
+		return nil, nil, errors.New("not a QUIC long header packet")
+	}
+	version, supported := quicVersions[binary.BigEndian.Uint32(datagram[1:])]
+	if !supported {
+		return nil, nil, fmt.Errorf("unsupported QUIC version %#x", binary.BigEndian.Uint32(datagram[1:]))
+	}
+	if (datagram[0]>>4)&0x03 != version.initialType {
+		return nil, nil, nil // Handshake and 0-RTT packets carry no ClientHello
+	}
+
+	// Destination and source connection IDs, token, then the length of the rest
+	offset := 5
+	var dcid []byte
+	for i := 0; i < 2; i++ {
+		if len(datagram) < offset+1 {
+			return nil, nil, errors.New("truncated QUIC header")
+		}
+		size := int(datagram[offset])
+		if size > 20 || len(datagram) < offset+1+size {
+			return nil, nil, errors.New("invalid QUIC connection ID")
+		}
+		if i == 0 {
+			dcid = datagram[offset+1 : offset+1+size]
+		}
+		offset += 1 + size
+	}
+	tokenLength, n := readQUICVarint(datagram[offset:])
+	if n == 0 || uint64(len(datagram)-offset-n) < tokenLength {
+		return nil, nil, errors.New("truncated QUIC token")
+	}
+	offset += n + int(tokenLength)
+	length, n := readQUICVarint(datagram[offset:])
+	if n == 0 || uint64(len(datagram)-offset-n) < length {
+		return nil, nil, errors.New("truncated QUIC packet")
+	}
+	pnOffset := offset + n
+	packetEnd := pnOffset + int(length)
+	if packetEnd < pnOffset+4+16 {
+		return nil, nil, errors.New("QUIC packet too short to sample")
+	}
+
+	key, iv, hp := quicClientInitialKeys(version, dcid)
+	block, err := aes.NewCipher(hp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Remove the header protection to learn the packet number
+	header := append([]byte(nil), datagram[:pnOffset+4]...)
+	mask := make([]byte, aes.BlockSize)
+	block.Encrypt(mask, datagram[pnOffset+4:pnOffset+4+16])
+	header[0] ^= mask[0] & 0x0f
+	pnLength := int(header[0]&0x03) + 1
+	header = header[:pnOffset+pnLength]
+	var packetNumber uint64
+	for i := 0; i < pnLength; i++ {
+		header[pnOffset+i] ^= mask[1+i]
+		packetNumber = packetNumber<<8 | uint64(header[pnOffset+i])
+	}
+
+	block, err = aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := append([]byte(nil), iv...)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(packetNumber >> (8 * i))
+	}
+	plaintext, err := aead.Open(nil, nonce, datagram[pnOffset+pnLength:packetEnd], header)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot decrypt QUIC Initial: %v", err)
+	}
+
+	frames, err := quicCryptoFrames(plaintext)
+	return dcid, frames, err
+}
+
+// quicCryptoFrames returns the data of the CRYPTO frames in an Initial packet's payload by offset
+func quicCryptoFrames(payload []byte) (map[uint64][]byte, error) {
+	frames := make(map[uint64][]byte)
+	for len(payload) > 0 {
+		frameType := payload[0]
+		payload = payload[1:]
+		switch frameType {
+		case 0x00, 0x01: // PADDING, PING
+		case 0x02, 0x03: // ACK, with ECN counts for 0x03
+			fields := 4
+			values := make([]uint64, 0, fields)
+			for i := 0; i < fields; i++ {
+				value, n := readQUICVarint(payload)
+				if n == 0 {
+					return nil, errors.New("truncated QUIC ACK frame")
+				}
+				values = append(values, value)
+				payload = payload[n:]
+			}
+			skip := 2 * int(values[2]) // gap and length of each further range
+			if frameType == 0x03 {
+				skip += 3
+			}
+			for i := 0; i < skip; i++ {
+				_, n := readQUICVarint(payload)
+				if n == 0 {
+					return nil, errors.New("truncated QUIC ACK frame")
+				}
+				payload = payload[n:]
+			}
+		case 0x06: // CRYPTO
+			offset, n := readQUICVarint(payload)
+			if n == 0 {
+				return nil, errors.New("truncated QUIC CRYPTO frame")
+			}
+			payload = payload[n:]
+			size, n := readQUICVarint(payload)
+			if n == 0 || uint64(len(payload)-n) < size {
+				return nil, errors.New("truncated QUIC CRYPTO frame")
+			}
+			frames[offset] = payload[n : n+int(size)]
+			payload = payload[n+int(size):]
+		case 0x1c: // CONNECTION_CLOSE
+			return frames, nil
+		default:
+			return nil, fmt.Errorf("unexpected QUIC frame type %#x in an Initial packet", frameType)
+		}
+	}
+	return frames, nil
+}
+
+// readQUICVarint reads a variable-length integer. It returns its size in bytes, or 0
+// if data is too short.
+func readQUICVarint(data []byte) (uint64, int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	size := 1 << (data[0] >> 6)
+	if len(data) < size {
+		return 0, 0
+	}
+	value := uint64(data[0] & 0x3f)
+	for _, b := range data[1:size] {
+		value = value<<8 | uint64(b)
+	}
+	return value, size
+}
+
+// quicClientInitialKeys derives the key, IV and header protection key of the client's
+// Initial packets from the destination connection ID the client chose
+func quicClientInitialKeys(version quicVersion, dcid []byte) ([]byte, []byte, []byte) {
+	initialSecret := hkdfExtract(version.salt, dcid)
+	clientSecret := hkdfExpandLabel(initialSecret, "client in", sha256.Size)
+	return hkdfExpandLabel(clientSecret, version.keyLabel, 16),
+		hkdfExpandLabel(clientSecret, version.ivLabel, 12),
+		hkdfExpandLabel(clientSecret, version.hpLabel, 16)
+}
+
+// hkdfExtract is HKDF-Extract with SHA-256 (RFC 5869)
+func hkdfExtract(salt, secret []byte) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(secret)
+	return mac.Sum(nil)
+}
+
+// hkdfExpandLabel is TLS 1.3's HKDF-Expand-Label with SHA-256 and an empty context (RFC 8446)
+func hkdfExpandLabel(secret []byte, label string, length int) []byte {
+	label = "tls13 " + label
+	info := []byte{byte(length >> 8), byte(length), byte(len(label))}
+	info = append(info, label...)
+	info = append(info, 0)
+
+	var out, block []byte
+	for counter := byte(1); len(out) < length; counter++ {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(block)
+		mac.Write(info)
+		mac.Write([]byte{counter})
+		block = mac.Sum(nil)
+		out = append(out, block...)
+	}
+	return out[:length]
+}
+
+// mustDecodeHex decodes a hex constant
+func mustDecodeHex(s string) []byte {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
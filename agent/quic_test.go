@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc9001Hello is the CRYPTO frame of the client Initial in RFC 9001 Appendix A.2,
+// carrying a ClientHello for example.com
+const rfc9001Hello = "060040f1010000ed0303ebf8fa56f12939b9584a3896472ec40bb863cfd3e868" +
+	"04fe3a47f06a2b69484c00000413011302010000c000000010000e00000b6578" +
+	"616d706c652e636f6dff01000100000a00080006001d00170018001000070005" +
+	"04616c706e000500050100000000003300260024001d00209370b2c9caa47fba" +
+	"baf4559fedba753de171fa71f50f1ce15d43e994ec74d748002b000302030400" +
+	"0d0010000e0403050306030203080408050806002d00020101001c0002400100" +
+	"3900320408ffffffffffffffff05048000ffff07048000ffff08011001048000" +
+	"75300901100f088394c8f03e51570806048000ffff"
+
+// protectQUICInitial builds a client Initial packet with a 4-byte packet number, as a
+// client does: AEAD encryption followed by header protection (RFC 9001 section 5)
+func protectQUICInitial(versionNumber uint32, key, iv, hp, dcid []byte, packetNumber uint32, payload []byte) []byte {
+	version := quicVersions[versionNumber]
+	header := []byte{0xc0 | version.initialType<<4 | 0x03}
+	header = binary.BigEndian.AppendUint32(header, versionNumber)
+	header = append(header, byte(len(dcid)))
+	header = append(header, dcid...)
+	header = append(header, 0, 0) // no source connection ID, no token
+	length := 4 + len(payload) + 16
+	header = append(header, 0x40|byte(length>>8), byte(length))
+	pnOffset := len(header)
+	header = binary.BigEndian.AppendUint32(header, packetNumber)
+
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	nonce := append([]byte(nil), iv...)
+	for i := 0; i < 4; i++ {
+		nonce[len(nonce)-1-i] ^= byte(packetNumber >> (8 * i))
+	}
+	packet := aead.Seal(append([]byte(nil), header...), nonce, payload, header)
+
+	block, _ = aes.NewCipher(hp)
+	mask := make([]byte, aes.BlockSize)
+	block.Encrypt(mask, packet[pnOffset+4:pnOffset+4+16])
+	packet[0] ^= mask[0] & 0x0f
+	for i := 0; i < 4; i++ {
+		packet[pnOffset+i] ^= mask[1+i]
+	}
+	return packet
+}
+
+// quicInitial builds a client Initial with keys derived from dcid, padded like a browser's
+func quicInitial(versionNumber uint32, dcid []byte, packetNumber uint32, frames ...[]byte) []byte {
+	key, iv, hp := quicClientInitialKeys(quicVersions[versionNumber], dcid)
+	payload := bytes.Join(frames, nil)
+	if len(payload) < 1162 {
+		payload = append(payload, make([]byte, 1162-len(payload))...)
+	}
+	return protectQUICInitial(versionNumber, key, iv, hp, dcid, packetNumber, payload)
+}
+
+// cryptoFrame encodes a CRYPTO frame at offset
+func cryptoFrame(offset int, data []byte) []byte {
+	frame := []byte{0x06, 0x80 | byte(offset>>24), byte(offset >> 16), byte(offset >> 8), byte(offset)}
+	frame = append(frame, 0x40|byte(len(data)>>8), byte(len(data)))
+	return append(frame, data...)
+}
+
+func TestQUICClientInitialKeys(t *testing.T) {
+	dcid := mustDecodeHex("8394c8f03e515708")
+	tests := []struct {
+		name        string
+		version     uint32
+		key, iv, hp string
+	}{
+		// RFC 9001 Appendix A.1
+		{"v1", 0x00000001, "1f369613dd76d5467730efcbe3b1a22d", "fa044b2f42a3fd3b46fb255c", "9f50449e04a0e810283a1e9933adedd2"},
+		// RFC 9369 Appendix A.1
+		{"v2", 0x6b3343cf, "8b1a0bc121284290a29e0971b5cd045d", "91f73e2351d8fa91660e909f", "45b95e15235d6f45a6b19cbcb0294ba9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, iv, hp := quicClientInitialKeys(quicVersions[tt.version], dcid)
+			if hex.EncodeToString(key) != tt.key || hex.EncodeToString(iv) != tt.iv || hex.EncodeToString(hp) != tt.hp {
+				t.Errorf("keys = %x %x %x, want %s %s %s", key, iv, hp, tt.key, tt.iv, tt.hp)
+			}
+		})
+	}
+
+	// RFC 9001 Appendix A.1
+	initial := hkdfExtract(quicVersions[1].salt, dcid)
+	if want := "7db5df06e7a69e432496adedb00851923595221596ae2ae9fb8115c1e9ed0a44"; hex.EncodeToString(initial) != want {
+		t.Errorf("initial_secret = %x, want %s", initial, want)
+	}
+	client := hkdfExpandLabel(initial, "client in", 32)
+	if want := "c00cf151ca5be075ed0ebfb5c80323c42d6b7db67881289af4008f1f6c357aea"; hex.EncodeToString(client) != want {
+		t.Errorf("client_initial_secret = %x, want %s", client, want)
+	}
+}
+
+func TestDecryptQUICInitialRFC9001(t *testing.T) {
+	// RFC 9001 Appendix A.2: the client Initial, built from the keys of A.1 and the
+	// unprotected payload, must match the published protected packet
+	dcid := mustDecodeHex("8394c8f03e515708")
+	payload := make([]byte, 1162)
+	copy(payload, mustDecodeHex(rfc9001Hello))
+	datagram := protectQUICInitial(0x00000001,
+		mustDecodeHex("1f369613dd76d5467730efcbe3b1a22d"),
+		mustDecodeHex("fa044b2f42a3fd3b46fb255c"),
+		mustDecodeHex("9f50449e04a0e810283a1e9933adedd2"),
+		dcid, 2, payload)
+
+	if len(datagram) != 1200 {
+		t.Fatalf("packet of %d bytes, want 1200", len(datagram))
+	}
+	if want := "c000000001088394c8f03e5157080000449e7b9aec34"; hex.EncodeToString(datagram[:22]) != want {
+		t.Errorf("protected header = %x, want %s", datagram[:22], want)
+	}
+	if want := "d1b1c98dd7689fb8ec11d242b123dc9b"; hex.EncodeToString(datagram[22:38]) != want {
+		t.Errorf("sample = %x, want %s", datagram[22:38], want)
+	}
+	if want := "e221af44860018ab0856972e194cd934"; hex.EncodeToString(datagram[1184:]) != want {
+		t.Errorf("tag = %x, want %s", datagram[1184:], want)
+	}
+
+	gotDCID, frames, err := decryptQUICInitial(datagram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(gotDCID, dcid) || len(frames) != 1 || len(frames[0]) != 0xf1 {
+		t.Fatalf("decryptQUICInitial() = %x, %d frames", gotDCID, len(frames))
+	}
+
+	var hellos quicHellos
+	p := &packet{Src: net.ParseIP("192.168.1.20"), Dst: net.ParseIP("93.184.216.34"), Transport: "udp", SrcPort: 60000, DstPort: 443, Payload: datagram}
+	name, err := hellos.serverName(time.Now(), p)
+	if err != nil || name != "example.com" {
+		t.Errorf("serverName() = %q, %v; want example.com", name, err)
+	}
+}
+
+func TestQUICHelloSplitAcrossFrames(t *testing.T) {
+	dcid := mustDecodeHex("0011223344556677")
+	hello := clientHello(tlsExtension(21, make([]byte, 1500)), sniExtension("www.example.org"))
+	first, second, third := hello[:700], hello[700:1200], hello[1200:]
+	src := net.ParseIP("192.168.1.20")
+	at := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+
+	for _, version := range []uint32{0x00000001, 0x6b3343cf} {
+		// Chrome sends the frames out of order, in two packets
+		packets := [][]byte{
+			quicInitial(version, dcid, 0, cryptoFrame(1200, third), cryptoFrame(0, first)),
+			quicInitial(version, dcid, 1, []byte{0x01}, cryptoFrame(700, second)),
+		}
+
+		var hellos quicHellos
+		for i, datagram := range packets {
+			p := &packet{Src: src, Transport: "udp", SrcPort: 60000, DstPort: 443, Payload: datagram}
+			name, err := hellos.serverName(at.Add(time.Duration(i)*time.Millisecond), p)
+			if err != nil {
+				t.Fatalf("version %#x packet %d: %v", version, i, err)
+			}
+			want := ""
+			if i == len(packets)-1 {
+				want = "www.example.org"
+			}
+			if name != want {
+				t.Errorf("version %#x packet %d: serverName() = %q, want %q", version, i, name, want)
+			}
+		}
+		if len(hellos.pending) != 0 {
+			t.Errorf("version %#x: %d ClientHellos still pending", version, len(hellos.pending))
+		}
+	}
+}
+
+func TestQUICHelloReassemblyExpires(t *testing.T) {
+	dcid := mustDecodeHex("0011223344556677")
+	hello := clientHello(tlsExtension(21, make([]byte, 1500)), sniExtension("www.example.org"))
+	src := net.ParseIP("192.168.1.20")
+	at := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+
+	var hellos quicHellos
+	first := &packet{Src: src, Payload: quicInitial(1, dcid, 0, cryptoFrame(0, hello[:1000]))}
+	second := &packet{Src: src, Payload: quicInitial(1, dcid, 1, cryptoFrame(1000, hello[1000:]))}
+	hellos.serverName(at, first)
+	name, err := hellos.serverName(at.Add(quicHelloTimeout+time.Second), second)
+	if err != nil || name != "" {
+		t.Errorf("serverName() after the timeout = %q, %v; want nothing", name, err)
+	}
+}
+
+func TestDecryptQUICInitialMalformed(t *testing.T) {
+	dcid := mustDecodeHex("0011223344556677")
+	valid := quicInitial(1, dcid, 0, cryptoFrame(0, clientHello(sniExtension("www.example.org"))))
+	with := func(change func([]byte) []byte) []byte {
+		return change(append([]byte(nil), valid...))
+	}
+
+	tests := []struct {
+		name     string
+		datagram []byte
+		err      string
+	}{
+		{"short header", []byte{0x40, 1, 2, 3}, "not a QUIC long header packet"},
+		{"unknown version", with(func(b []byte) []byte { b[4] = 9; return b }), "unsupported QUIC version"},
+		{"connection ID too long", with(func(b []byte) []byte { b[5] = 21; return b }), "invalid QUIC connection ID"},
+		{"truncated connection ID", valid[:10], "invalid QUIC connection ID"},
+		{"truncated before the token", valid[:14], "truncated QUIC"},
+		{"truncated packet", valid[:600], "truncated QUIC packet"},
+		{"length too short to sample", with(func(b []byte) []byte { b[16], b[17] = 0x40, 10; return b }), "too short to sample"},
+		{"corrupt ciphertext", with(func(b []byte) []byte { b[100] ^= 1; return b }), "cannot decrypt QUIC Initial"},
+		{"different connection ID", with(func(b []byte) []byte { b[6] ^= 1; return b }), "cannot decrypt QUIC Initial"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decryptQUICInitial(tt.datagram); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	// Handshake packets carry no ClientHello
+	handshake := with(func(b []byte) []byte { b[0] = 0xe0 | b[0]&0x0f; return b })
+	if _, frames, err := decryptQUICInitial(handshake); err != nil || frames != nil {
+		t.Errorf("Handshake packet: frames = %v, err = %v; want neither", frames, err)
+	}
+}
+
+func TestQUICServerNameMalformedHello(t *testing.T) {
+	dcid := mustDecodeHex("0011223344556677")
+	src := net.ParseIP("192.168.1.20")
+	serverHello := clientHello(sniExtension("www.example.org"))
+	serverHello[0] = 2
+
+	tests := []struct {
+		name   string
+		frames [][]byte
+		err    string
+	}{
+		{"not a ClientHello", [][]byte{cryptoFrame(0, serverHello)}, "is not a ClientHello"},
+		{"invalid server name", [][]byte{cryptoFrame(0, clientHello(sniExtension("a\x00b")))}, "invalid server name"},
+		{"unexpected frame", [][]byte{{0x08, 0x00}}, "unexpected QUIC frame type"},
+		{"truncated CRYPTO frame", [][]byte{{0x06, 0x00, 0x44, 0xff}}, "truncated QUIC CRYPTO frame"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hellos quicHellos
+			p := &packet{Src: src, Payload: quicInitial(1, dcid, 0, tt.frames...)}
+			if _, err := hellos.serverName(time.Now(), p); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	// A truncated ClientHello waits for the rest instead of failing
+	var hellos quicHellos
+	hello := clientHello(sniExtension("www.example.org"))
+	p := &packet{Src: src, Payload: quicInitial(1, dcid, 0, cryptoFrame(0, hello[:len(hello)-5]))}
+	if name, err := hellos.serverName(time.Now(), p); err != nil || name != "" {
+		t.Errorf("truncated ClientHello: serverName() = %q, %v; want to wait", name, err)
+	}
+}
+
+func TestQUICCryptoFrames(t *testing.T) {
+	payload := []byte{0x01, 0x00, 0x00}                                 // PING, PADDING
+	payload = append(payload, 0x02, 0x05, 0x00, 0x01, 0x00, 0x01, 0x00) // ACK with one further range
+	payload = append(payload, 0x03, 0x05, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03)
+	payload = append(payload, cryptoFrame(10, []byte("world"))...)
+	payload = append(payload, cryptoFrame(0, []byte("hello"))...)
+
+	frames, err := quicCryptoFrames(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 || string(frames[0]) != "hello" || string(frames[10]) != "world" {
+		t.Errorf("quicCryptoFrames() = %q", frames)
+	}
+}
+
+func TestReadQUICVarint(t *testing.T) {
+	// RFC 9000 Appendix A.1
+	tests := []struct {
+		data  string
+		value uint64
+		size  int
+	}{
+		{"c2197c5eff14e88c", 151288809941952652, 8},
+		{"9d7f3e7d", 494878333, 4},
+		{"7bbd", 15293, 2},
+		{"25", 37, 1},
+		{"4025", 37, 2},
+		{"9d7f3e", 0, 0},
+		{"", 0, 0},
+	}
+	for _, tt := range tests {
+		value, size := readQUICVarint(mustDecodeHex(tt.data))
+		if value != tt.value || size != tt.size {
+			t.Errorf("readQUICVarint(%s) = %d, %d; want %d, %d", tt.data, value, size, tt.value, tt.size)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"roi-agent-data-sender/transmit"
+)
+
+// configWatchInterval is how often the config files are checked for changes
+const configWatchInterval = 5 * time.Second
+
+// currentConfig returns the configuration in effect. A reload replaces the whole
+// Config, so the returned value can be read without holding the lock.
+func (a *Agent) currentConfig() *Config {
+	a.configMutex.RLock()
+	defer a.configMutex.RUnlock()
+	return a.config
+}
+
+// watchedConfigFiles returns config.yaml and every .env file, including ones that do not exist yet
+func (a *Agent) watchedConfigFiles() []string {
+	files := transmit.EnvFiles()
+	if path := a.currentConfig().Path; path != "" {
+		files = append(files, path)
+	}
+	if path := os.Getenv("ROI_AGENT_CONFIG"); path != "" {
+		files = append(files, path)
+	}
+	return append(files, configSearchPaths...)
+}
+
+// configFileStamps returns the modification time and size of each watched file
+func (a *Agent) configFileStamps() map[string]string {
+	stamps := make(map[string]string)
+	for _, path := range a.watchedConfigFiles() {
+		if info, err := os.Stat(path); err == nil {
+			stamps[path] = fmt.Sprintf("%d:%d", info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return stamps
+}
+
+// configFilesChanged reports whether a watched file was created, changed or removed since the last check
+func (a *Agent) configFilesChanged() bool {
+	stamps := a.configFileStamps()
+	changed := len(stamps) != len(a.configStamps)
+	for path, stamp := range stamps {
+		if a.configStamps[path] != stamp {
+			changed = true
+		}
+	}
+	a.configStamps = stamps
+	return changed
+}
+
+// reloadConfig loads the configuration again with the original command line and applies
+// it. An invalid configuration is rejected: the agent keeps running with the old one and
+// reports the error in status until a valid configuration is loaded.
+func (a *Agent) reloadConfig(reason string) {
+	old := a.currentConfig()
+
+	transmit.ReloadEnvFiles()
+	config, _, err := LoadConfig(old.args)
+	if err != nil {
+		log.Printf("Configuration reload (%s) rejected, keeping the current configuration: %v", reason, err)
+		a.configMutex.Lock()
+		a.configError = err.Error()
+		a.configErrorAt = time.Now()
+		a.configMutex.Unlock()
+		return
+	}
+
+	var applied, pending []string
+	for _, s := range old.changedKeys(config) {
+		if s.restart {
+			pending = append(pending, s.key)
+			log.Printf("Config changed: %s = %s (%s), takes effect when the agent restarts", s.key, s.String(), config.origins[s.key])
+		} else {
+			applied = append(applied, s.key)
+			log.Printf("Config changed: %s = %s (%s)", s.key, s.String(), config.origins[s.key])
+		}
+	}
+
+	// The sender reads its own settings from the .env files, so it is replaced too.
+	// A transmission still running keeps the sender it started with.
+	sender := transmit.NewDataSender()
+	sender.UseIntervalMinutes(config.Transmission.IntervalMinutes)
+	a.transmitMutex.Lock()
+	a.sender = sender
+	a.transmitMutex.Unlock()
+
+	a.transmissionInterval = time.Duration(config.Transmission.IntervalMinutes) * time.Minute
+	a.maxGap = time.Duration(config.Monitor.MaxGap) * time.Second
+	a.timeline.SetMaxGap(a.maxGap)
+	a.timeline.SetInterval(a.transmissionInterval)
+	setLogLevel(config.Debug.LogLevel)
+
+	a.configMutex.Lock()
+	a.config = config
+	a.configReloadedAt = time.Now()
+	a.configError = ""
+	a.configErrorAt = time.Time{}
+	a.configMutex.Unlock()
+
+	a.dropExcludedDomains()
+	log.Printf("Configuration reloaded (%s): %d changes applied, %d need a restart", reason, len(applied), len(pending))
+}
+
+// dropExcludedDomains forgets detected connections to domains that are now excluded,
+// so they are not merged into the day file
+func (a *Agent) dropExcludedDomains() {
+	config := a.currentConfig()
+
+	a.domainMutex.Lock()
+	defer a.domainMutex.Unlock()
+	for key, conn := range a.activeDomains {
+		if !config.recordsDomain(conn.Domain) {
+			delete(a.activeDomains, key)
+		}
+	}
+	for fqdn := range a.pendingLookups {
+		if !config.recordsDomain(fqdn) {
+			delete(a.pendingLookups, fqdn)
+		}
+	}
+}
+
+// configStatus reports the reload state for Status
+func (a *Agent) configStatus() map[string]interface{} {
+	a.configMutex.RLock()
+	defer a.configMutex.RUnlock()
+
+	report := map[string]interface{}{
+		"file": a.config.Path,
+	}
+	if !a.configReloadedAt.IsZero() {
+		report["reloaded_at"] = a.configReloadedAt
+	}
+	if a.configError != "" {
+		report["error"] = a.configError
+		report["error_at"] = a.configErrorAt
+	}
+
+	// Settings changed since the agent started that it cannot apply while running
+	var pending []string
+	for _, s := range a.startConfig.changedKeys(a.config) {
+		if s.restart {
+			pending = append(pending, s.key)
+		}
+	}
+	if len(pending) > 0 {
+		report["pending_restart"] = pending
+	}
+	return report
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// newReplayAgent returns an agent that only decodes and records network activity in
+// memory. It does not read or write the day files.
+func newReplayAgent(config *Config) *Agent {
+	return &Agent{
+		config:         config,
+		startConfig:    config,
+		activeDomains:  make(map[string]*NetworkConnection),
+		pendingLookups: make(map[string]*DNSResolution),
+		timeline: NewTimeline("", time.Duration(config.Monitor.MaxGap)*time.Second,
+			time.Duration(config.Transmission.IntervalMinutes)*time.Minute),
+	}
+}
+
+// runReplay implements "roi-agent replay --pcap file.pcap": it feeds a saved capture
+// through the same decoding and recording as live capture, so it needs no root
+func runReplay(config *Config, args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	pcapPath := flags.String("pcap", "", "capture file in pcap format, e.g. from tcpdump -w file.pcap port 53 or tcp")
+	asJSON := flags.Bool("json", false, "print each DNS record as a JSON line")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *pcapPath == "" || flags.NArg() > 0 {
+		fmt.Println("Usage: roi-agent [flags] replay --pcap file.pcap [--json]")
+		return 2
+	}
+
+	file, err := os.Open(*pcapPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	defer file.Close()
+
+	agent := newReplayAgent(config)
+	encoder := json.NewEncoder(os.Stdout)
+	stats, err := agent.readCapture(file, func(record DNSRecord) {
+		if *asJSON {
+			encoder.Encode(record)
+		} else {
+			fmt.Println(record)
+		}
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error reading %s: %v\n", *pcapPath, err)
+		return 1
+	}
+	if *asJSON {
+		return 0
+	}
+	// The capture has ended, so no more connections follow the remaining lookups
+	agent.resolvePendingLookups(time.Now().Add(flowWaitWindow))
+
+	fmt.Printf("\nRead %d packets (%d not decoded): %d DNS queries, %d responses, %d connections (%d to addresses not looked up), "+
+		"%d TLS and %d QUIC ClientHellos with a server name\n",
+		stats.Packets, stats.Undecoded, stats.Queries, stats.Responses, stats.Flows, stats.Unmatched, stats.TLSHellos, stats.QUICHellos)
+
+	keys := make([]string, 0, len(agent.activeDomains))
+	for key := range agent.activeDomains {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Printf("Detected %d connections:\n", len(keys))
+	for _, key := range keys {
+		conn := agent.activeDomains[key]
+		fmt.Printf("%s: %s (%s, port %s, name from %s) by %s\n",
+			key, conn.Domain, conn.Protocol, conn.PortSource, conn.DomainSource, conn.AppName)
+		if conn.DNS != nil {
+			if len(conn.DNS.Chain) > 0 {
+				fmt.Printf("    chain: %s\n", strings.Join(conn.DNS.Chain, " -> "))
+			}
+			fmt.Printf("    addresses: %s (ttl %ds)\n", strings.Join(conn.DNS.Addresses, ", "), conn.DNS.TTL)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"roi-agent-schema"
+)
+
+// replayFixture is a capture of a browser looking up two names and connecting to one
+func replayFixture(start time.Time) []byte {
+	client, resolver := "192.168.1.20", "192.168.1.1"
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	frame := func(ip []byte) []byte { return ethernetFrame(0x0800, ip) }
+
+	return pcapFile(linkTypeEthernet,
+		pcapRecord{at(0), frame(ipv4Packet(17, client, resolver, udpSegment(51000, 53, dnsQuery(1, "www.example.org", DNSTypeA))))},
+		pcapRecord{at(20), frame(ipv4Packet(17, resolver, client, udpSegment(53, 51000, dnsResponse(1, "www.example.org", DNSTypeA,
+			DNSAnswer{Name: "www.example.org", Type: DNSTypeCNAME, TTL: 300, Data: "edge.example.net"},
+			DNSAnswer{Name: "edge.example.net", Type: DNSTypeA, TTL: 60, Data: "93.184.216.34"},
+		))))},
+		pcapRecord{at(25), frame(ipv4Packet(6, client, "93.184.216.34", tcpSegment(52000, 443, tcpSYN, nil)))},
+		// A lookup that no connection follows
+		pcapRecord{at(100), frame(ipv4Packet(17, client, resolver, udpSegment(51001, 53, dnsQuery(2, "news.example.org", DNSTypeA))))},
+		pcapRecord{at(120), frame(ipv4Packet(17, resolver, client, udpSegment(53, 51001, dnsResponse(2, "news.example.org", DNSTypeA,
+			DNSAnswer{Name: "news.example.org", Type: DNSTypeA, TTL: 60, Data: "198.51.100.7"},
+		))))},
+		// A connection to an address that was not looked up
+		pcapRecord{at(200), frame(ipv4Packet(6, client, "203.0.113.9", tcpSegment(52001, 443, tcpSYN, nil)))},
+		// ARP is counted but not decoded
+		pcapRecord{at(300), ethernetFrame(0x0806, make([]byte, 28))},
+	)
+}
+
+func TestReadCaptureReplay(t *testing.T) {
+	start := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+	agent := newReplayAgent(defaultConfig())
+
+	var records []DNSRecord
+	stats, err := agent.readCapture(bytes.NewReader(replayFixture(start)), func(record DNSRecord) {
+		records = append(records, record)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := captureStats{Packets: 7, Undecoded: 1, Queries: 2, Responses: 2, Flows: 2, Unmatched: 1}
+	if stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+	if len(records) != 4 || records[1].QName != "www.example.org" || len(records[1].Answers) != 2 {
+		t.Errorf("records = %+v", records)
+	}
+
+	agent.resolvePendingLookups(start.Add(flowWaitWindow + time.Second))
+
+	observed := agent.activeDomains["www.example.org:443"]
+	if observed == nil {
+		t.Fatalf("no connection to www.example.org:443 in %v", agent.activeDomains)
+	}
+	if observed.PortSource != schema.PortObserved || observed.Protocol != "HTTPS" || observed.AppName != unknownApp {
+		t.Errorf("www.example.org:443 = %+v", observed)
+	}
+	if observed.DNS == nil || len(observed.DNS.Chain) != 2 || observed.DNS.Chain[1] != "edge.example.net" {
+		t.Errorf("www.example.org:443 resolution = %+v", observed.DNS)
+	}
+
+	guessed := agent.activeDomains["news.example.org:443"]
+	if guessed == nil || guessed.PortSource != schema.PortGuessed {
+		t.Errorf("news.example.org:443 = %+v, want a guessed port", guessed)
+	}
+	if len(agent.activeDomains) != 2 {
+		t.Errorf("recorded %d connections, want 2", len(agent.activeDomains))
+	}
+}
+
+func TestRunReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	if err := ioutil.WriteFile(path, replayFixture(time.Now()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The summary goes to stdout and errors to stderr
+	stdout, stderr := os.Stdout, os.Stderr
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer null.Close()
+	os.Stdout, os.Stderr = null, null
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+
+	if code := runReplay(defaultConfig(), []string{"--pcap", path}); code != 0 {
+		t.Errorf("replay exited with %d", code)
+	}
+	if code := runReplay(defaultConfig(), []string{"--pcap", path, "--json"}); code != 0 {
+		t.Errorf("replay --json exited with %d", code)
+	}
+	if code := runReplay(defaultConfig(), nil); code != 2 {
+		t.Errorf("replay without --pcap exited with %d, want 2", code)
+	}
+	if code := runReplay(defaultConfig(), []string{"--pcap", filepath.Join(t.TempDir(), "missing.pcap")}); code != 1 {
+		t.Errorf("replay of a missing file exited with %d, want 1", code)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// maxCNAMEChain bounds how many CNAME records are followed from one name
+const maxCNAMEChain = 16
+
+// dnsCachePruneInterval is how often expired entries are forgotten
+const dnsCachePruneInterval = time.Minute
+
+// maxDNSTTL caps how long an answer is remembered
+const maxDNSTTL = 24 * time.Hour
+
+// dnsCacheMaxEntries bounds the aliases and the addresses remembered. When either is
+// full, the entries closest to expiring are forgotten first.
+const dnsCacheMaxEntries = 10000
+
+// dnsAddressGrace is how long after its TTL an address is still attributed to its name.
+// Clients keep using an address for connections opened shortly after it expired.
+const dnsAddressGrace = 5 * time.Minute
+
+// dnsCache remembers the answers to the client's lookups until their TTL expires. The
+// CNAME targets of a name let a later lookup of a target be attributed to the name
+// asked for first, and the addresses let connections be attributed to the name.
+type dnsCache struct {
+	mutex     sync.Mutex
+	aliases   map[string]dnsAlias
+	addresses map[string]dnsAddress
+	pruned    time.Time
+}
+
+// dnsAlias is the chain from the name the client asked for to a CNAME target
+type dnsAlias struct {
+	chain   []string
+	expires time.Time
+}
+
+// dnsAddress is the most recent answer an address was returned in
+type dnsAddress struct {
+	chain      []string
+	resolution *DNSResolution
+	expires    time.Time
+}
+
+// chainTo returns the chain of names ending with name: from the name the client asked
+// for through its CNAME targets, or just name if it is not a known alias
+func (d *dnsCache) chainTo(name string, at time.Time) []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if alias, exists := d.aliases[name]; exists && at.Before(alias.expires) {
+		return alias.chain
+	}
+	return []string{name}
+}
+
+// add remembers every name in chain after the first as an alias of the first, and the
+// resolved addresses as addresses of the first, for the resolution's TTL
+func (d *dnsCache) add(chain []string, resolution *DNSResolution) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.aliases == nil {
+		d.aliases = make(map[string]dnsAlias)
+		d.addresses = make(map[string]dnsAddress)
+	}
+
+	// Expiry is judged by the records' capture times, which replay takes from old files
+	at := resolution.ResolvedAt
+	if at.Sub(d.pruned) > dnsCachePruneInterval || at.Before(d.pruned) {
+		for name, alias := range d.aliases {
+			if !at.Before(alias.expires) {
+				delete(d.aliases, name)
+			}
+		}
+		for address, entry := range d.addresses {
+			if !at.Before(entry.expires) {
+				delete(d.addresses, address)
+			}
+		}
+		d.pruned = at
+	}
+
+	ttl := time.Duration(resolution.TTL) * time.Second
+	if ttl > maxDNSTTL {
+		ttl = maxDNSTTL
+	}
+	for i := 1; i < len(chain); i++ {
+		d.aliases[chain[i]] = dnsAlias{chain: chain[: i+1 : i+1], expires: at.Add(ttl)}
+	}
+	for _, address := range resolution.Addresses {
+		d.addresses[address] = dnsAddress{chain: chain, resolution: resolution, expires: at.Add(ttl + dnsAddressGrace)}
+	}
+
+	for len(d.aliases) > dnsCacheMaxEntries {
+		oldest := ""
+		for name, alias := range d.aliases {
+			if oldest == "" || alias.expires.Before(d.aliases[oldest].expires) {
+				oldest = name
+			}
+		}
+		delete(d.aliases, oldest)
+	}
+	for len(d.addresses) > dnsCacheMaxEntries {
+		oldest := ""
+		for address, entry := range d.addresses {
+			if oldest == "" || entry.expires.Before(d.addresses[oldest].expires) {
+				oldest = address
+			}
+		}
+		delete(d.addresses, oldest)
+	}
+}
+
+// lookupAddress returns the chain and resolution of the latest answer that returned
+// address, or nil if no unexpired answer did. An address shared by several names, as
+// on CDNs, is attributed to the name looked up last.
+func (d *dnsCache) lookupAddress(address string, at time.Time) ([]string, *DNSResolution) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if entry, exists := d.addresses[address]; exists && at.Before(entry.expires) {
+		return entry.chain, entry.resolution
+	}
+	return nil, nil
+}
+
+// resolveAnswers follows the CNAME records from qname through the answers. It returns
+// the names along the way starting with qname, the addresses of the last name and the
+// shortest TTL of the records used.
+func resolveAnswers(qname string, answers []DNSAnswer) ([]string, []string, uint32) {
+	chain := []string{qname}
+	ttl := uint32(math.MaxUint32)
+	useTTL := func(recordTTL uint32) {
+		if recordTTL < ttl {
+			ttl = recordTTL
+		}
+	}
+
+	name := qname
+	for len(chain) <= maxCNAMEChain {
+		next := ""
+		for _, answer := range answers {
+			if answer.Type == DNSTypeCNAME && answer.Name == name {
+				next = answer.Data
+				useTTL(answer.TTL)
+				break
+			}
+		}
+		if next == "" || containsString(chain, next) {
+			break
+		}
+		chain = append(chain, next)
+		name = next
+	}
+
+	var addresses []string
+	for _, answer := range answers {
+		if (answer.Type == DNSTypeA || answer.Type == DNSTypeAAAA) && answer.Name == name {
+			addresses = append(addresses, answer.Data)
+			useTTL(answer.TTL)
+		}
+	}
+	if len(addresses) == 0 {
+		return chain, nil, 0
+	}
+	return chain, addresses, ttl
+}
+
+// containsString reports whether list contains value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
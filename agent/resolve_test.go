@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func cname(name, target string, ttl uint32) DNSAnswer {
+	return DNSAnswer{Name: name, Type: DNSTypeCNAME, TTL: ttl, Data: target}
+}
+
+func address(name, ip string, ttl uint32) DNSAnswer {
+	answerType := DNSTypeA
+	if net.ParseIP(ip).To4() == nil {
+		answerType = DNSTypeAAAA
+	}
+	return DNSAnswer{Name: name, Type: answerType, TTL: ttl, Data: ip}
+}
+
+func TestResolveAnswers(t *testing.T) {
+	long := []DNSAnswer{}
+	for i := 0; i < maxCNAMEChain+5; i++ {
+		long = append(long, cname(fmt.Sprintf("n%d.example.org", i), fmt.Sprintf("n%d.example.org", i+1), 60))
+	}
+
+	tests := []struct {
+		name      string
+		qname     string
+		answers   []DNSAnswer
+		chain     []string
+		addresses []string
+		ttl       uint32
+	}{
+		{
+			name:      "address",
+			qname:     "example.org",
+			answers:   []DNSAnswer{address("example.org", "192.0.2.1", 300), address("example.org", "192.0.2.2", 120)},
+			chain:     []string{"example.org"},
+			addresses: []string{"192.0.2.1", "192.0.2.2"},
+			ttl:       120,
+		},
+		{
+			name:  "multi-hop chain",
+			qname: "www.example.org",
+			answers: []DNSAnswer{
+				address("edge.cdn.example.net", "2001:db8::1", 600),
+				cname("www.example.org", "www.example.org.cdn.example.com", 3600),
+				cname("www.example.org.cdn.example.com", "edge.cdn.example.net", 30),
+			},
+			chain:     []string{"www.example.org", "www.example.org.cdn.example.com", "edge.cdn.example.net"},
+			addresses: []string{"2001:db8::1"},
+			ttl:       30,
+		},
+		{
+			name:  "addresses of other names are ignored",
+			qname: "www.example.org",
+			answers: []DNSAnswer{
+				cname("www.example.org", "edge.example.net", 300),
+				address("www.example.org", "192.0.2.9", 300),
+				address("edge.example.net", "192.0.2.1", 300),
+			},
+			chain:     []string{"www.example.org", "edge.example.net"},
+			addresses: []string{"192.0.2.1"},
+			ttl:       300,
+		},
+		{
+			name:    "loop",
+			qname:   "a.example.org",
+			answers: []DNSAnswer{cname("a.example.org", "b.example.org", 60), cname("b.example.org", "a.example.org", 60)},
+			chain:   []string{"a.example.org", "b.example.org"},
+		},
+		{
+			name:    "loop with an address",
+			qname:   "a.example.org",
+			answers: []DNSAnswer{cname("a.example.org", "a.example.org", 60), address("a.example.org", "192.0.2.1", 60)},
+			chain:   []string{"a.example.org"},
+			// The address belongs to the name the loop returns to
+			addresses: []string{"192.0.2.1"},
+			ttl:       60,
+		},
+		{
+			name:    "chain without an address",
+			qname:   "a.example.org",
+			answers: []DNSAnswer{cname("a.example.org", "b.example.org", 60), cname("b.example.org", "c.example.org", 60)},
+			chain:   []string{"a.example.org", "b.example.org", "c.example.org"},
+		},
+		{
+			name:    "chain longer than the limit",
+			qname:   "n0.example.org",
+			answers: append(long, address(fmt.Sprintf("n%d.example.org", len(long)), "192.0.2.1", 60)),
+			chain: func() []string {
+				var chain []string
+				for i := 0; i <= maxCNAMEChain; i++ {
+					chain = append(chain, fmt.Sprintf("n%d.example.org", i))
+				}
+				return chain
+			}(),
+		},
+		{
+			name:  "no answers",
+			qname: "example.org",
+			chain: []string{"example.org"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, addresses, ttl := resolveAnswers(tt.qname, tt.answers)
+			if !reflect.DeepEqual(chain, tt.chain) {
+				t.Errorf("chain = %v, want %v", chain, tt.chain)
+			}
+			if !reflect.DeepEqual(addresses, tt.addresses) || ttl != tt.ttl {
+				t.Errorf("addresses = %v ttl %d, want %v ttl %d", addresses, ttl, tt.addresses, tt.ttl)
+			}
+		})
+	}
+}
+
+func TestProcessDNSRecordAttributesAliasToAskedName(t *testing.T) {
+	at := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+	agent := newReplayAgent(defaultConfig())
+	response := func(at time.Time, qname string, answers ...DNSAnswer) DNSRecord {
+		return DNSRecord{Time: at, Response: true, RCode: "NOERROR", QType: DNSTypeA, QName: qname, Answers: answers}
+	}
+
+	agent.processDNSRecord(response(at, "www.example.org",
+		cname("www.example.org", "mail.example.net", 300),
+		cname("mail.example.net", "edge.example.com", 300),
+		address("edge.example.com", "192.0.2.1", 60)))
+
+	// The client later looks up the middle of the chain on its own
+	agent.processDNSRecord(response(at.Add(time.Second), "mail.example.net",
+		cname("mail.example.net", "edge.example.com", 300),
+		address("edge.example.com", "192.0.2.2", 60)))
+
+	if _, exists := agent.pendingLookups["mail.example.net"]; exists {
+		t.Error("the alias was recorded as a domain of its own")
+	}
+	resolution := agent.pendingLookups["www.example.org"]
+	if resolution == nil {
+		t.Fatalf("pending lookups = %v, want www.example.org", agent.pendingLookups)
+	}
+	wantChain := []string{"www.example.org", "mail.example.net", "edge.example.com"}
+	if !reflect.DeepEqual(resolution.Chain, wantChain) || !reflect.DeepEqual(resolution.Addresses, []string{"192.0.2.2"}) {
+		t.Errorf("resolution = %+v, want chain %v and the second address", resolution, wantChain)
+	}
+
+	chain, _ := agent.dnsCache.lookupAddress("192.0.2.2", at.Add(2*time.Second))
+	if !reflect.DeepEqual(chain, wantChain) {
+		t.Errorf("lookupAddress() chain = %v, want %v", chain, wantChain)
+	}
+
+	// Once the alias expires, a lookup of it stands on its own
+	later := at.Add(301 * time.Second)
+	agent.processDNSRecord(response(later, "mail.example.net",
+		cname("mail.example.net", "edge.example.com", 300),
+		address("edge.example.com", "192.0.2.3", 60)))
+	if _, exists := agent.pendingLookups["mail.example.net"]; !exists {
+		t.Error("lookup of an expired alias was not recorded under its own name")
+	}
+}
+
+func TestProcessDNSRecordSkipsChainsWithoutAddresses(t *testing.T) {
+	agent := newReplayAgent(defaultConfig())
+	agent.processDNSRecord(DNSRecord{Time: time.Now(), Response: true, RCode: "NOERROR", QType: DNSTypeA, QName: "a.example.org",
+		Answers: []DNSAnswer{cname("a.example.org", "b.example.org", 60), cname("b.example.org", "a.example.org", 60)}})
+
+	if len(agent.pendingLookups) != 0 {
+		t.Errorf("pending lookups = %v, want none", agent.pendingLookups)
+	}
+	if chain := agent.dnsCache.chainTo("b.example.org", time.Now()); len(chain) != 1 {
+		t.Errorf("chainTo() = %v, want the name alone", chain)
+	}
+}
+
+func TestDNSCacheLookupAddressExpiry(t *testing.T) {
+	at := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+	var cache dnsCache
+	cache.add([]string{"www.example.org", "edge.example.net"},
+		&DNSResolution{Addresses: []string{"192.0.2.1"}, TTL: 60, ResolvedAt: at})
+
+	expires := at.Add(60*time.Second + dnsAddressGrace)
+	tests := []struct {
+		name  string
+		at    time.Time
+		found bool
+	}{
+		{"at resolution", at, true},
+		{"after the TTL, within the grace", at.Add(61 * time.Second), true},
+		{"just before expiry", expires.Add(-time.Nanosecond), true},
+		{"at expiry", expires, false},
+		{"after expiry", expires.Add(time.Hour), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, resolution := cache.lookupAddress("192.0.2.1", tt.at)
+			if found := chain != nil && resolution != nil; found != tt.found {
+				t.Errorf("lookupAddress() found = %v, want %v", found, tt.found)
+			}
+		})
+	}
+
+	if chain, _ := cache.lookupAddress("192.0.2.99", at); chain != nil {
+		t.Errorf("unknown address found: %v", chain)
+	}
+	if chain := cache.chainTo("edge.example.net", at.Add(60*time.Second)); len(chain) != 1 {
+		t.Errorf("alias outlived its TTL: %v", chain)
+	}
+}
+
+func TestDNSCacheCapsTTL(t *testing.T) {
+	at := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+	var cache dnsCache
+	cache.add([]string{"example.org"}, &DNSResolution{Addresses: []string{"192.0.2.1"}, TTL: 7 * 24 * 3600, ResolvedAt: at})
+
+	if chain, _ := cache.lookupAddress("192.0.2.1", at.Add(maxDNSTTL+dnsAddressGrace)); chain != nil {
+		t.Error("address remembered past maxDNSTTL")
+	}
+}
+
+func TestDNSCacheSizeBound(t *testing.T) {
+	at := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+	var cache dnsCache
+
+	// Every name is added at the same time, so entries added with a shorter TTL expire first
+	total := dnsCacheMaxEntries + 10
+	for i := 0; i < total; i++ {
+		name := fmt.Sprintf("host%d.example.org", i)
+		ttl := uint32(3600 + i)
+		if i < 10 {
+			ttl = uint32(60 + i)
+		}
+		cache.add([]string{name, "alias-" + name},
+			&DNSResolution{Addresses: []string{fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)}, TTL: ttl, ResolvedAt: at})
+	}
+
+	if len(cache.addresses) != dnsCacheMaxEntries || len(cache.aliases) != dnsCacheMaxEntries {
+		t.Fatalf("cache holds %d addresses and %d aliases, want %d", len(cache.addresses), len(cache.aliases), dnsCacheMaxEntries)
+	}
+	for i := 0; i < 10; i++ {
+		if chain, _ := cache.lookupAddress(fmt.Sprintf("10.0.0.%d", i), at); chain != nil {
+			t.Errorf("short-lived address %d was kept", i)
+		}
+	}
+	last := total - 1
+	if chain, _ := cache.lookupAddress(fmt.Sprintf("10.%d.%d.%d", last>>16&0xff, last>>8&0xff, last&0xff), at); chain == nil {
+		t.Error("latest address was evicted")
+	}
+}
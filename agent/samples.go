@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// UsageSample is the usage one tick credited to a day file within one transmission
+// interval. The sender sums the samples whose End falls within an interval, so the
+// deltas of consecutive intervals add up exactly to the counters in combined_YYYY-MM-DD.json.
+type UsageSample struct {
+	Start   time.Time              `json:"start"`
+	End     time.Time              `json:"end"`
+	Apps    map[string]*SampleApp  `json:"apps,omitempty"`
+	Network map[string]*SampleConn `json:"network,omitempty"`
+}
+
+// SampleApp is the whole seconds credited to one app by a sample
+type SampleApp struct {
+	FocusTime      int64 `json:"focus_time,omitempty"`
+	ForegroundTime int64 `json:"foreground_time,omitempty"`
+}
+
+// SampleConn is the whole seconds credited to one connection by a sample
+type SampleConn struct {
+	Duration int64 `json:"duration"`
+}
+
+// sampleLogPath returns the sample log file for the given date
+func sampleLogPath(dataDir, date string) string {
+	return filepath.Join(dataDir, fmt.Sprintf("samples_%s.jsonl", date))
+}
+
+// sampleInterval returns the start of the transmission interval the sender credits a
+// sample ending at end to: the interval (start, start+length] that contains end
+func sampleInterval(end time.Time, length time.Duration) time.Time {
+	return intervalStart(end.Add(-time.Nanosecond), length)
+}
+
+// recordSample extends the current day's pending sample for the interval the span
+// [start, end) belongs to. The span must not cross an interval boundary.
+func (a *Agent) recordSample(start, end time.Time) *UsageSample {
+	interval := sampleInterval(end, a.transmissionInterval)
+	for _, sample := range a.samples {
+		if sampleInterval(sample.End, a.transmissionInterval).Equal(interval) {
+			if start.Before(sample.Start) {
+				sample.Start = start
+			}
+			if end.After(sample.End) {
+				sample.End = end
+			}
+			return sample
+		}
+	}
+
+	sample := &UsageSample{
+		Start:   start,
+		End:     end,
+		Apps:    make(map[string]*SampleApp),
+		Network: make(map[string]*SampleConn),
+	}
+	a.samples = append(a.samples, sample)
+	return sample
+}
+
+// sampleApp returns the pending sample entry for an app
+func (s *UsageSample) sampleApp(appName string) *SampleApp {
+	app, exists := s.Apps[appName]
+	if !exists {
+		app = &SampleApp{}
+		s.Apps[appName] = app
+	}
+	return app
+}
+
+// flushSamples appends the pending samples to the current day's sample log. It is called
+// right after the day file is saved so both files describe the same credited time.
+func (a *Agent) flushSamples() {
+	samples := a.samples
+	a.samples = nil
+	sort.Slice(samples, func(i, j int) bool { return samples[i].End.Before(samples[j].End) })
+	a.appendSamples(a.combinedData.Date, samples)
+}
+
+// appendSamples appends the samples that credit any time to a day's sample log
+func (a *Agent) appendSamples(date string, samples []*UsageSample) {
+	var lines []byte
+	for _, sample := range samples {
+		if len(sample.Apps) == 0 && len(sample.Network) == 0 {
+			continue
+		}
+		line, err := json.Marshal(sample)
+		if err != nil {
+			log.Printf("Error marshaling usage sample: %v", err)
+			continue
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	path := sampleLogPath(a.dataDir, date)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("Error opening sample log %s: %v", path, err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(lines); err != nil {
+		log.Printf("Error writing sample log %s: %v", path, err)
+	}
+}
+
+// loadSampleTotals sums the counters credited by a day's sample log, skipping a
+// half-written last line
+func (a *Agent) loadSampleTotals(date string) (*UsageSample, error) {
+	totals := &UsageSample{Apps: make(map[string]*SampleApp), Network: make(map[string]*SampleConn)}
+	file, err := os.Open(sampleLogPath(a.dataDir, date))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return totals, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var sample UsageSample
+		if json.Unmarshal(scanner.Bytes(), &sample) != nil {
+			continue
+		}
+		if sample.End.After(totals.End) {
+			totals.End = sample.End
+		}
+		for appName, delta := range sample.Apps {
+			app := totals.sampleApp(appName)
+			app.FocusTime += delta.FocusTime
+			app.ForegroundTime += delta.ForegroundTime
+		}
+		for key, delta := range sample.Network {
+			conn, exists := totals.Network[key]
+			if !exists {
+				conn = &SampleConn{}
+				totals.Network[key] = conn
+			}
+			conn.Duration += delta.Duration
+		}
+	}
+	return totals, scanner.Err()
+}
+
+// reconcileSamples appends a sample with the counters of a saved day file that its
+// sample log is missing, e.g. because the agent stopped between saving the day file
+// and appending the samples. The sample is credited to the current interval, or to the
+// day's last one for an earlier day, so the deltas sent still sum to the day file.
+func (a *Agent) reconcileSamples(saved *CombinedData) {
+	totals, err := a.loadSampleTotals(saved.Date)
+	if err != nil {
+		log.Printf("Error reading sample log for %s: %v", saved.Date, err)
+		return
+	}
+
+	missing := &UsageSample{Apps: make(map[string]*SampleApp), Network: make(map[string]*SampleConn)}
+	for appName, app := range saved.Apps {
+		if app == nil {
+			continue
+		}
+		credited := totals.Apps[appName]
+		if credited == nil {
+			credited = &SampleApp{}
+		}
+		if app.FocusTime > credited.FocusTime {
+			missing.sampleApp(appName).FocusTime = app.FocusTime - credited.FocusTime
+		}
+		if app.ForegroundTime > credited.ForegroundTime {
+			missing.sampleApp(appName).ForegroundTime = app.ForegroundTime - credited.ForegroundTime
+		}
+	}
+	for key, conn := range saved.Network {
+		if conn == nil {
+			continue
+		}
+		var credited int64
+		if totals.Network[key] != nil {
+			credited = totals.Network[key].Duration
+		}
+		if conn.Duration > credited {
+			missing.Network[key] = &SampleConn{Duration: conn.Duration - credited}
+		}
+	}
+	if len(missing.Apps) == 0 && len(missing.Network) == 0 {
+		return
+	}
+
+	day, _ := time.ParseInLocation("2006-01-02", saved.Date, time.Local)
+	missing.End = time.Now().Round(0)
+	if dateOf(missing.End) != saved.Date {
+		missing.End = nextMidnight(day)
+	}
+	missing.Start = sampleInterval(missing.End, a.transmissionInterval)
+	if totals.End.After(missing.Start) && totals.End.Before(missing.End) {
+		missing.Start = totals.End
+	}
+	a.appendSamples(saved.Date, []*UsageSample{missing})
+	log.Printf("Added the usage missing from the sample log for %s: %d apps, %d connections",
+		saved.Date, len(missing.Apps), len(missing.Network))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+// loadSamples reads a day's sample log
+func loadSamples(t *testing.T, a *Agent, date string) []UsageSample {
+	t.Helper()
+	file, err := os.Open(sampleLogPath(a.dataDir, date))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var samples []UsageSample
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var sample UsageSample
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+func TestSamplesSumToDayFile(t *testing.T) {
+	a := newTestAgent(t)
+	start := time.Date(2026, 10, 15, 23, 41, 30, 0, time.Local)
+	a.initCombinedData(dateOf(start))
+	a.timeline.Start(start)
+	a.timeline.SetRunning(map[string]bool{"Safari": true, "Mail": true}, start)
+	a.timeline.SetFocus("Safari", start)
+	a.lastNetworkSample = start
+	conn := &NetworkConnection{Domain: "example.com", Port: 443, FirstSeen: start, LastSeen: start}
+	a.activeDomains["example.com:443"] = conn
+
+	// Ticks 50 seconds apart cross interval boundaries and midnight between ticks
+	for now := start.Add(50 * time.Second); now.Before(start.Add(40 * time.Minute)); now = now.Add(50 * time.Second) {
+		if now.Minute()%7 == 0 {
+			a.timeline.SetFocus("Mail", now.Add(-20*time.Second))
+		}
+		conn.LastSeen = now
+		appUsage := a.timeline.Collect(now)
+		a.creditUsage(appUsage, a.networkSpans(now))
+		a.switchDay(dateOf(now))
+		a.saveCombinedData()
+	}
+
+	for _, date := range []string{"2026-10-15", "2026-10-16"} {
+		data, err := a.loadCombinedData(date)
+		if err != nil || data == nil {
+			t.Fatalf("day file for %s: %v", date, err)
+		}
+
+		focus := make(map[string]int64)
+		foreground := make(map[string]int64)
+		var network int64
+		for _, sample := range loadSamples(t, a, date) {
+			// A sample is sent with the interval containing its end, so it must start there too
+			if sample.Start.Before(sampleInterval(sample.End, a.transmissionInterval)) {
+				t.Errorf("sample %v - %v crosses an interval boundary", sample.Start, sample.End)
+			}
+			if dateOf(sample.Start) != date {
+				t.Errorf("sample %v - %v is in the sample log for %s", sample.Start, sample.End, date)
+			}
+			for appName, delta := range sample.Apps {
+				focus[appName] += delta.FocusTime
+				foreground[appName] += delta.ForegroundTime
+			}
+			for _, delta := range sample.Network {
+				network += delta.Duration
+			}
+		}
+
+		for appName, app := range data.Apps {
+			if focus[appName] != app.FocusTime || foreground[appName] != app.ForegroundTime {
+				t.Errorf("%s %s: samples credit focus %d, foreground %d; day file has %d and %d",
+					date, appName, focus[appName], foreground[appName], app.FocusTime, app.ForegroundTime)
+			}
+		}
+		if network != data.NetworkTotal.TotalDuration || network == 0 {
+			t.Errorf("%s: samples credit %d network seconds, day file has %d", date, network, data.NetworkTotal.TotalDuration)
+		}
+	}
+}
+
+func TestReconcileSamples(t *testing.T) {
+	a := newTestAgent(t)
+	a.initCombinedData("2026-10-15")
+	addFocus(a, "Safari", 60)
+	addFocus(a, "Mail", 20)
+	a.saveCombinedData()
+
+	// The agent stopped after saving the day file but before appending the last sample
+	a.appendSamples("2026-10-15", []*UsageSample{{
+		Start: time.Date(2026, 10, 15, 10, 0, 0, 0, time.Local),
+		End:   time.Date(2026, 10, 15, 10, 1, 0, 0, time.Local),
+		Apps:  map[string]*SampleApp{"Safari": {FocusTime: 40}},
+	}})
+
+	// Only the first restart has anything to add
+	for i := 0; i < 2; i++ {
+		restarted := newTestAgent(t)
+		restarted.dataDir = a.dataDir
+		restarted.initCombinedData("2026-10-15")
+	}
+
+	samples := loadSamples(t, a, "2026-10-15")
+	if len(samples) != 2 {
+		t.Fatalf("sample log = %+v, want the saved sample and one with the missing usage", samples)
+	}
+	missing := samples[1]
+	if missing.Apps["Safari"] == nil || missing.Apps["Safari"].FocusTime != 20 ||
+		missing.Apps["Mail"] == nil || missing.Apps["Mail"].FocusTime != 20 {
+		t.Errorf("reconciling sample = %+v, want Safari 20 and Mail 20", missing.Apps)
+	}
+
+	// For an earlier day the missing usage goes into the day's last interval
+	midnight := time.Date(2026, 10, 16, 0, 0, 0, 0, time.Local)
+	if !missing.End.Equal(midnight) || missing.Start.Before(sampleInterval(midnight, a.transmissionInterval)) {
+		t.Errorf("reconciling sample %v - %v, want it within the day's last interval", missing.Start, missing.End)
+	}
+}
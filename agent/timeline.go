@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"roi-agent-schema"
+)
+
+// Event types recorded in the day's event log
+const (
+	EventFocusChange = "focus_change"
+	EventAppLaunch   = "app_launch"
+	EventAppQuit     = "app_quit"
+	EventAgentStart  = "agent_start" // closes any spans left open by a previous run
+	EventAgentStop   = "agent_stop"  // the agent shut down cleanly
+	EventSuspend     = "suspend"     // last sample before a gap longer than the maximum gap
+	EventResume      = "resume"      // first sample after that gap
+)
+
+// TimelineEvent is a single entry of the append-only event log
+type TimelineEvent struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	App  string    `json:"app,omitempty"`
+
+	// Carried marks the focus_change written at midnight to open a new day's log
+	// with the app that was already focused; it continues the previous session
+	Carried bool `json:"carried,omitempty"`
+
+	// Reason is schema.SuspendPaused on the suspend event written when recording is paused
+	Reason string `json:"reason,omitempty"`
+}
+
+// SuspendedPeriod is a gap between samples that was too long to be usage
+type SuspendedPeriod = schema.SuspendedPeriod
+
+// Timeline records focus and app lifecycle events to events_YYYY-MM-DD.jsonl
+// and derives focus and foreground durations from the measured time between samples
+type Timeline struct {
+	dataDir  string
+	maxGap   time.Duration
+	interval time.Duration // usage is split at the transmission interval boundaries
+	mutex    sync.Mutex
+
+	focusedApp string
+	running    map[string]bool
+	lastSample time.Time
+	pausedAt   time.Time // zero unless recording is paused
+
+	pending map[string]*DayUsage
+}
+
+// DayUsage is the usage accumulated for one local calendar day between Start and End
+type DayUsage struct {
+	Start     time.Time
+	End       time.Time
+	Periods   []*PeriodUsage // in chronological order
+	Suspended []SuspendedPeriod
+}
+
+// PeriodUsage is the focus and foreground time of a day within one transmission interval,
+// so the sample it is recorded in is sent with that interval
+type PeriodUsage struct {
+	Start      time.Time
+	End        time.Time
+	Focus      map[string]time.Duration
+	Foreground map[string]time.Duration
+}
+
+// NewTimeline creates a timeline that writes its event log into dataDir
+func NewTimeline(dataDir string, maxGap, interval time.Duration) *Timeline {
+	return &Timeline{
+		dataDir:  dataDir,
+		maxGap:   maxGap,
+		interval: interval,
+		running:  make(map[string]bool),
+		pending:  make(map[string]*DayUsage),
+	}
+}
+
+// SetMaxGap changes the longest gap between samples that is still counted as usage
+func (t *Timeline) SetMaxGap(maxGap time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.maxGap = maxGap
+}
+
+// SetInterval changes the transmission interval length the usage is split by
+func (t *Timeline) SetInterval(interval time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.interval = interval
+}
+
+// eventLogPath returns the event log file for the given date
+func eventLogPath(dataDir, date string) string {
+	return filepath.Join(dataDir, fmt.Sprintf("events_%s.jsonl", date))
+}
+
+// appendEvent appends one event to the log of the day the event happened on
+func (t *Timeline) appendEvent(event TimelineEvent) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error marshaling timeline event: %v", err)
+		return
+	}
+
+	path := eventLogPath(t.dataDir, dateOf(event.Time))
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("Error opening event log %s: %v", path, err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Printf("Error writing event log %s: %v", path, err)
+	}
+}
+
+// Start records that the agent (re)started, so readers close spans left open by a previous run
+func (t *Timeline) Start(at time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.focusedApp = ""
+	t.running = make(map[string]bool)
+	t.lastSample = at.Round(0)
+	t.appendEvent(TimelineEvent{Time: at, Type: EventAgentStart})
+}
+
+// Stop records that the agent shut down, which closes the open spans at the real stop time
+func (t *Timeline) Stop(at time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.appendEvent(TimelineEvent{Time: at, Type: EventAgentStop})
+}
+
+// SetFocus records a focus change if app differs from the currently focused app
+func (t *Timeline) SetFocus(app string, at time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.pausedAt.IsZero() {
+		return
+	}
+	t.advance(at)
+
+	app = strings.TrimSpace(app)
+	if app == t.focusedApp {
+		return
+	}
+
+	t.focusedApp = app
+	t.appendEvent(TimelineEvent{Time: at, Type: EventFocusChange, App: app})
+}
+
+// SetRunning records launch and quit events by comparing apps with the previous running set
+func (t *Timeline) SetRunning(apps map[string]bool, at time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.pausedAt.IsZero() {
+		return
+	}
+	t.advance(at)
+
+	for app := range apps {
+		if !t.running[app] {
+			t.appendEvent(TimelineEvent{Time: at, Type: EventAppLaunch, App: app})
+		}
+	}
+	for app := range t.running {
+		if !apps[app] {
+			t.appendEvent(TimelineEvent{Time: at, Type: EventAppQuit, App: app})
+		}
+	}
+
+	t.running = make(map[string]bool, len(apps))
+	for app := range apps {
+		t.running[app] = true
+	}
+}
+
+// Collect returns the focus and foreground time and the suspended periods accumulated
+// since the previous call, keyed by the local date they happened on and split into the
+// transmission intervals within each day. Focus time comes from the focus change
+// timestamps, foreground time from the running set between launch and quit events.
+func (t *Timeline) Collect(at time.Time) map[string]*DayUsage {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.advance(at)
+
+	usage := t.pending
+	t.pending = make(map[string]*DayUsage)
+	return usage
+}
+
+// day returns the pending usage for the date of span, extended to cover it
+func (t *Timeline) day(span daySpan) *DayUsage {
+	usage, exists := t.pending[span.Date]
+	if !exists {
+		usage = &DayUsage{
+			Start: span.Start,
+			End:   span.End,
+		}
+		t.pending[span.Date] = usage
+	}
+	if span.End.After(usage.End) {
+		usage.End = span.End
+	}
+	return usage
+}
+
+// period returns the day's usage within the interval of span, extended to cover it.
+// Spans are added in order, so only the last period can share the interval.
+func (u *DayUsage) period(span daySpan, length time.Duration) *PeriodUsage {
+	if n := len(u.Periods); n > 0 {
+		last := u.Periods[n-1]
+		if length <= 0 || intervalStart(last.Start, length).Equal(intervalStart(span.Start, length)) {
+			if span.End.After(last.End) {
+				last.End = span.End
+			}
+			return last
+		}
+	}
+	period := &PeriodUsage{
+		Start:      span.Start,
+		End:        span.End,
+		Focus:      make(map[string]time.Duration),
+		Foreground: make(map[string]time.Duration),
+	}
+	u.Periods = append(u.Periods, period)
+	return period
+}
+
+// Pause stops recording at, after crediting the time up to it. Focus and running app
+// changes are ignored until Resume, and the paused time is recorded as suspended.
+func (t *Timeline) Pause(at time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.pausedAt.IsZero() {
+		return
+	}
+	t.advance(at)
+	t.pausedAt = t.lastSample
+	t.appendEvent(TimelineEvent{Time: t.pausedAt, Type: EventSuspend, Reason: schema.SuspendPaused})
+}
+
+// Resume starts recording again after Pause. Focus has to be observed again.
+func (t *Timeline) Resume(at time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.pausedAt.IsZero() {
+		return
+	}
+	at = at.Round(0)
+	for _, span := range splitByDay(t.pausedAt, at) {
+		usage := t.day(span)
+		usage.Suspended = append(usage.Suspended, SuspendedPeriod{
+			Start:   span.Start,
+			End:     span.End,
+			Seconds: int64(span.End.Sub(span.Start) / time.Second),
+			Reason:  schema.SuspendPaused,
+		})
+	}
+	t.appendEvent(TimelineEvent{Time: at, Type: EventResume, Reason: schema.SuspendPaused})
+	log.Printf("Recording resumed after a pause of %v", at.Sub(t.pausedAt).Round(time.Second))
+
+	t.pausedAt = time.Time{}
+	t.lastSample = at
+	t.focusedApp = ""
+}
+
+// PausedAt returns when recording was paused, or the zero time if it is not paused
+func (t *Timeline) PausedAt() time.Time {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.pausedAt
+}
+
+// TimelineState is the timeline's in-memory state, for dump-state
+type TimelineState struct {
+	FocusedApp string    `json:"focused_app"`
+	Running    []string  `json:"running"`
+	LastSample time.Time `json:"last_sample"`
+	PausedAt   time.Time `json:"paused_at,omitempty"`
+	Pending    []string  `json:"pending_days"`
+}
+
+// State returns a copy of the timeline's state
+func (t *Timeline) State() TimelineState {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	state := TimelineState{
+		FocusedApp: t.focusedApp,
+		Running:    make([]string, 0, len(t.running)),
+		LastSample: t.lastSample,
+		PausedAt:   t.pausedAt,
+		Pending:    make([]string, 0, len(t.pending)),
+	}
+	for app := range t.running {
+		state.Running = append(state.Running, app)
+	}
+	for date := range t.pending {
+		state.Pending = append(state.Pending, date)
+	}
+	sort.Strings(state.Running)
+	sort.Strings(state.Pending)
+	return state
+}
+
+// FocusedApp returns the app that currently has focus
+func (t *Timeline) FocusedApp() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.focusedApp
+}
+
+// advance credits the measured time since the previous sample to the focused and
+// running apps. A gap longer than maxGap is recorded as a suspended period instead.
+func (t *Timeline) advance(at time.Time) {
+	// Compare wall clock readings: the monotonic clock stops while the machine sleeps
+	at = at.Round(0)
+
+	if t.lastSample.IsZero() {
+		t.lastSample = at
+		return
+	}
+	if !at.After(t.lastSample) {
+		return
+	}
+	if !t.pausedAt.IsZero() {
+		return // Resume records the paused time
+	}
+
+	gap := at.Sub(t.lastSample)
+	if gap > t.maxGap {
+		for _, span := range splitByDay(t.lastSample, at) {
+			usage := t.day(span)
+			usage.Suspended = append(usage.Suspended, SuspendedPeriod{
+				Start:   span.Start,
+				End:     span.End,
+				Seconds: int64(span.End.Sub(span.Start) / time.Second),
+			})
+		}
+		t.appendEvent(TimelineEvent{Time: t.lastSample, Type: EventSuspend})
+		t.appendEvent(TimelineEvent{Time: at, Type: EventResume})
+		log.Printf("Detected suspended period of %v (%s - %s)", gap.Round(time.Second),
+			t.lastSample.Format("15:04:05"), at.Format("15:04:05"))
+
+		// Focus has to be observed again after waking up
+		t.focusedApp = ""
+	} else {
+		date := dateOf(t.lastSample)
+		for _, span := range splitByInterval(t.lastSample, at, t.interval) {
+			// Open the new day's event log with the app that is still focused
+			if span.Date != date && t.focusedApp != "" {
+				t.appendEvent(TimelineEvent{Time: span.Start, Type: EventFocusChange, App: t.focusedApp, Carried: true})
+			}
+			date = span.Date
+
+			period := t.day(span).period(span, t.interval)
+			if t.focusedApp != "" {
+				period.Focus[t.focusedApp] += span.End.Sub(span.Start)
+			}
+			for app := range t.running {
+				period.Foreground[app] += span.End.Sub(span.Start)
+			}
+		}
+	}
+
+	t.lastSample = at
+}
+
+// creditSeconds converts a duration into whole seconds for an app, carrying the
+// remainder over to the next call so rounding never drifts from the event log
+func creditSeconds(carry map[string]time.Duration, app string, d time.Duration) int64 {
+	total := carry[app] + d
+	seconds := int64(total / time.Second)
+	carry[app] = total - time.Duration(seconds)*time.Second
+	return seconds
+}
+
+// watchFocus samples the frontmost app between ticks so focus changes get real timestamps
+func (a *Agent) watchFocus(stop <-chan struct{}) {
+	poll := a.currentConfig().Monitor.FocusPoll
+	ticker := time.NewTicker(time.Duration(poll) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			config := a.currentConfig()
+			if config.Monitor.FocusPoll != poll {
+				poll = config.Monitor.FocusPoll
+				ticker.Reset(time.Duration(poll) * time.Second)
+			}
+
+			frontmostApp, err := a.getFrontmostApp()
+			if err != nil {
+				continue
+			}
+			if !config.recordsApp(frontmostApp) {
+				frontmostApp = ""
+			}
+			a.timeline.SetFocus(frontmostApp, time.Now())
+		}
+	}
+}
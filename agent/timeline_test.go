@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+// readEvents reads a day's event log
+func readEvents(t *testing.T, dataDir, date string) []TimelineEvent {
+	t.Helper()
+	file, err := os.Open(eventLogPath(dataDir, date))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var events []TimelineEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event TimelineEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// collectTotals sums the focus and foreground time collected for each app
+func collectTotals(usage map[string]*DayUsage) (focus, foreground map[string]time.Duration) {
+	focus = make(map[string]time.Duration)
+	foreground = make(map[string]time.Duration)
+	for _, day := range usage {
+		for _, period := range day.Periods {
+			for app, d := range period.Focus {
+				focus[app] += d
+			}
+			for app, d := range period.Foreground {
+				foreground[app] += d
+			}
+		}
+	}
+	return focus, foreground
+}
+
+func TestTimelineFocus(t *testing.T) {
+	dataDir := t.TempDir()
+	timeline := NewTimeline(dataDir, time.Minute, 10*time.Minute)
+	start := time.Date(2026, 10, 16, 10, 0, 0, 0, time.Local)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	timeline.Start(start)
+	timeline.SetRunning(map[string]bool{"Safari": true}, start)
+	timeline.SetFocus("Safari", start)
+	timeline.SetRunning(map[string]bool{"Safari": true, "Mail": true}, at(5))
+	timeline.SetFocus("Mail", at(12))
+	timeline.SetFocus("Mail", at(15)) // unchanged, so not an event
+	timeline.SetRunning(map[string]bool{"Mail": true}, at(20))
+
+	focus, foreground := collectTotals(timeline.Collect(at(30)))
+	want := map[string]time.Duration{"Safari": 12 * time.Second, "Mail": 18 * time.Second}
+	for app, d := range want {
+		if focus[app] != d {
+			t.Errorf("focus of %s = %v, want %v", app, focus[app], d)
+		}
+	}
+	if foreground["Safari"] != 20*time.Second || foreground["Mail"] != 25*time.Second {
+		t.Errorf("foreground = %v, want Safari 20s and Mail 25s", foreground)
+	}
+	if timeline.FocusedApp() != "Mail" {
+		t.Errorf("FocusedApp() = %q, want Mail", timeline.FocusedApp())
+	}
+
+	// The next collection starts where this one ended
+	focus, _ = collectTotals(timeline.Collect(at(40)))
+	if len(focus) != 1 || focus["Mail"] != 10*time.Second {
+		t.Errorf("second Collect() focus = %v, want Mail 10s", focus)
+	}
+
+	wantEvents := []TimelineEvent{
+		{Time: start, Type: EventAgentStart},
+		{Time: start, Type: EventAppLaunch, App: "Safari"},
+		{Time: start, Type: EventFocusChange, App: "Safari"},
+		{Time: at(5), Type: EventAppLaunch, App: "Mail"},
+		{Time: at(12), Type: EventFocusChange, App: "Mail"},
+		{Time: at(20), Type: EventAppQuit, App: "Safari"},
+	}
+	events := readEvents(t, dataDir, "2026-10-16")
+	if len(events) != len(wantEvents) {
+		t.Fatalf("events = %+v, want %+v", events, wantEvents)
+	}
+	for i, event := range events {
+		if !event.Time.Equal(wantEvents[i].Time) || event.Type != wantEvents[i].Type || event.App != wantEvents[i].App {
+			t.Errorf("event %d = %+v, want %+v", i, event, wantEvents[i])
+		}
+	}
+}
+
+func TestCreditSeconds(t *testing.T) {
+	carry := make(map[string]time.Duration)
+	var total int64
+	for i := 0; i < 4; i++ {
+		total += creditSeconds(carry, "Safari", 1500*time.Millisecond)
+	}
+	if total != 6 || carry["Safari"] != 0 {
+		t.Errorf("credited %d seconds with %v carried, want 6 and nothing", total, carry["Safari"])
+	}
+	if seconds := creditSeconds(carry, "Safari", 700*time.Millisecond); seconds != 0 || carry["Safari"] != 700*time.Millisecond {
+		t.Errorf("creditSeconds(700ms) = %d with %v carried", seconds, carry["Safari"])
+	}
+}
+
+func TestTimelineSuspended(t *testing.T) {
+	dataDir := t.TempDir()
+	timeline := NewTimeline(dataDir, time.Minute, 10*time.Minute)
+	start := time.Date(2026, 10, 16, 10, 0, 0, 0, time.Local)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	timeline.Start(start)
+	timeline.SetRunning(map[string]bool{"Safari": true}, start)
+	timeline.SetFocus("Safari", start)
+
+	// A gap of exactly the maximum is still usage
+	focus, _ := collectTotals(timeline.Collect(at(60)))
+	if focus["Safari"] != time.Minute {
+		t.Errorf("focus after a gap of max_gap = %v, want 1m0s", focus["Safari"])
+	}
+
+	// A longer one, e.g. while the machine sleeps, is suspended and credits nothing
+	usage := timeline.Collect(at(60 + 3600))
+	focus, foreground := collectTotals(usage)
+	if len(focus) != 0 || len(foreground) != 0 {
+		t.Errorf("a suspended hour credited focus %v, foreground %v", focus, foreground)
+	}
+	day := usage["2026-10-16"]
+	if day == nil || len(day.Suspended) != 1 {
+		t.Fatalf("collected usage = %+v, want one suspended period", day)
+	}
+	if period := day.Suspended[0]; !period.Start.Equal(at(60)) || !period.End.Equal(at(3660)) || period.Seconds != 3600 {
+		t.Errorf("suspended period = %+v, want the hour after the last sample", period)
+	}
+
+	// Focus has to be observed again after waking up
+	if timeline.FocusedApp() != "" {
+		t.Errorf("FocusedApp() after a suspension = %q, want none", timeline.FocusedApp())
+	}
+	var suspend, resume bool
+	for _, event := range readEvents(t, dataDir, "2026-10-16") {
+		suspend = suspend || event.Type == EventSuspend && event.Time.Equal(at(60))
+		resume = resume || event.Type == EventResume && event.Time.Equal(at(3660))
+	}
+	if !suspend || !resume {
+		t.Errorf("event log has suspend %v and resume %v, want both", suspend, resume)
+	}
+
+	// Time after the resume is usage again for the apps still running
+	_, foreground = collectTotals(timeline.Collect(at(3670)))
+	if foreground["Safari"] != 10*time.Second {
+		t.Errorf("foreground after resuming = %v, want 10s", foreground["Safari"])
+	}
+}
+
+func TestTimelineSuspendedAcrossMidnight(t *testing.T) {
+	timeline := NewTimeline(t.TempDir(), time.Minute, 10*time.Minute)
+	start := time.Date(2026, 10, 15, 23, 0, 0, 0, time.Local)
+	timeline.Start(start)
+
+	usage := timeline.Collect(start.Add(2 * time.Hour))
+	for date, seconds := range map[string]int64{"2026-10-15": 3600, "2026-10-16": 3600} {
+		if day := usage[date]; day == nil || len(day.Suspended) != 1 || day.Suspended[0].Seconds != seconds {
+			t.Errorf("suspended on %s = %+v, want %d seconds", date, day, seconds)
+		}
+	}
+}
+
+func TestTimelineFocusAcrossMidnight(t *testing.T) {
+	dataDir := t.TempDir()
+	timeline := NewTimeline(dataDir, time.Minute, 10*time.Minute)
+	start := time.Date(2026, 10, 15, 23, 59, 40, 0, time.Local)
+	timeline.Start(start)
+	timeline.SetFocus("Safari", start)
+
+	usage := timeline.Collect(start.Add(50 * time.Second))
+	for date, want := range map[string]time.Duration{"2026-10-15": 20 * time.Second, "2026-10-16": 30 * time.Second} {
+		focus, _ := collectTotals(map[string]*DayUsage{date: usage[date]})
+		if focus["Safari"] != want {
+			t.Errorf("focus on %s = %v, want %v", date, focus["Safari"], want)
+		}
+	}
+
+	// The new day's event log starts with the app that is still focused
+	events := readEvents(t, dataDir, "2026-10-16")
+	if len(events) != 1 || events[0].Type != EventFocusChange || events[0].App != "Safari" || !events[0].Carried ||
+		!events[0].Time.Equal(time.Date(2026, 10, 16, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("events on the new day = %+v, want Safari's focus carried over at midnight", events)
+	}
+}
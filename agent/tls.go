@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TLS constants the ClientHello parser looks at
+const (
+	tlsRecordHandshake      = 0x16
+	tlsHandshakeClientHello = 1
+	tlsExtensionServerName  = 0
+	tlsServerNameHost       = 0
+)
+
+// errTruncatedHello is returned when the data ends before the server name. A ClientHello
+// larger than one TCP segment continues in segments the capture does not select.
+var errTruncatedHello = errors.New("ClientHello continues beyond the captured data")
+
+// tlsServerName returns the server name of the TLS ClientHello at the start of a TCP
+// segment's payload, or "" if the ClientHello names no server
+func tlsServerName(payload []byte) (string, error) {
+	if len(payload) < 5 || payload[0] != tlsRecordHandshake {
+		return "", errors.New("not a TLS handshake record")
+	}
+	if payload[1] != 3 {
+		return "", fmt.Errorf("unknown TLS record version %d.%d", payload[1], payload[2])
+	}
+	length := int(binary.BigEndian.Uint16(payload[3:]))
+	hello := payload[5:]
+	if len(hello) > length {
+		hello = hello[:length]
+	}
+	return clientHelloServerName(hello)
+}
+
+// clientHelloServerName returns the host name in the Server Name Indication extension
+// of a ClientHello handshake message, or "" if it has none. The message may be cut
+// short, as long as the extension is complete.
+func clientHelloServerName(hello []byte) (string, error) {
+	if len(hello) < 4 {
+		return "", errTruncatedHello
+	}
+	if hello[0] != tlsHandshakeClientHello {
+		return "", fmt.Errorf("TLS handshake message type %d is not a ClientHello", hello[0])
+	}
+	length := int(hello[1])<<16 | int(hello[2])<<8 | int(hello[3])
+	body := hello[4:]
+	if len(body) > length {
+		body = body[:length]
+	}
+
+	// version, random, then the session ID, cipher suites and compression methods
+	offset := 2 + 32
+	for _, lengthSize := range []int{1, 2, 1} {
+		if len(body) < offset+lengthSize {
+			return "", errTruncatedHello
+		}
+		size := int(body[offset])
+		if lengthSize == 2 {
+			size = int(binary.BigEndian.Uint16(body[offset:]))
+		}
+		offset += lengthSize + size
+	}
+	if offset == len(body) && len(body) == length {
+		return "", nil // no extensions
+	}
+	if len(body) < offset+2 {
+		return "", errTruncatedHello
+	}
+	end := offset + 2 + int(binary.BigEndian.Uint16(body[offset:]))
+	offset += 2
+
+	for offset < end {
+		if len(body) < offset+4 {
+			return "", errTruncatedHello
+		}
+		extension := binary.BigEndian.Uint16(body[offset:])
+		size := int(binary.BigEndian.Uint16(body[offset+2:]))
+		offset += 4
+		if extension != tlsExtensionServerName {
+			offset += size
+			continue
+		}
+		if len(body) < offset+size {
+			return "", errTruncatedHello
+		}
+		return parseServerNameExtension(body[offset : offset+size])
+	}
+	if offset > len(body) {
+		return "", errTruncatedHello
+	}
+	return "", nil
+}
+
+// parseServerNameExtension returns the host name in a server_name extension
+func parseServerNameExtension(data []byte) (string, error) {
+	if len(data) < 2 {
+		return "", errors.New("truncated server_name extension")
+	}
+	list := data[2:]
+	for len(list) >= 3 {
+		nameType := list[0]
+		size := int(binary.BigEndian.Uint16(list[1:]))
+		if len(list) < 3+size {
+			break
+		}
+		if nameType == tlsServerNameHost {
+			name := strings.TrimSuffix(strings.ToLower(string(list[3:3+size])), ".")
+			for _, c := range name {
+				if c <= ' ' || c > '~' {
+					return "", fmt.Errorf("invalid server name %q", name)
+				}
+			}
+			return name, nil
+		}
+		list = list[3+size:]
+	}
+	return "", errors.New("server_name extension without a host name")
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// tlsExtension encodes one ClientHello extension
+func tlsExtension(extensionType uint16, data []byte) []byte {
+	b := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint16(b[0:], extensionType)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(data)))
+	return append(b, data...)
+}
+
+// sniExtension encodes a server_name extension naming host
+func sniExtension(host string) []byte {
+	entry := append([]byte{tlsServerNameHost, byte(len(host) >> 8), byte(len(host))}, host...)
+	list := append([]byte{byte(len(entry) >> 8), byte(len(entry))}, entry...)
+	return tlsExtension(tlsExtensionServerName, list)
+}
+
+// clientHello encodes a ClientHello handshake message with the given extensions, or
+// none at all if extensions is nil
+func clientHello(extensions ...[]byte) []byte {
+	body := []byte{3, 3}
+	body = append(body, make([]byte, 32)...)          // random
+	body = append(body, 32)                           // session ID length
+	body = append(body, make([]byte, 32)...)          // session ID
+	body = append(body, 0, 4, 0x13, 0x01, 0x13, 0x02) // cipher suites
+	body = append(body, 1, 0)                         // compression methods
+	if extensions != nil {
+		var all []byte
+		for _, extension := range extensions {
+			all = append(all, extension...)
+		}
+		body = append(body, byte(len(all)>>8), byte(len(all)))
+		body = append(body, all...)
+	}
+	header := []byte{tlsHandshakeClientHello, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+	return append(header, body...)
+}
+
+// tlsRecord wraps a handshake message in a TLS record
+func tlsRecord(message []byte) []byte {
+	return append([]byte{tlsRecordHandshake, 3, 1, byte(len(message) >> 8), byte(len(message))}, message...)
+}
+
+func TestTLSServerName(t *testing.T) {
+	supportedVersions := tlsExtension(43, []byte{2, 3, 4})
+	padding := tlsExtension(21, make([]byte, 300))
+
+	tests := []struct {
+		name   string
+		record []byte
+		want   string
+	}{
+		{"server name", tlsRecord(clientHello(sniExtension("www.example.org"))), "www.example.org"},
+		{"after other extensions", tlsRecord(clientHello(supportedVersions, padding, sniExtension("www.example.org"))), "www.example.org"},
+		{"lower case without the trailing dot", tlsRecord(clientHello(sniExtension("WWW.Example.ORG."))), "www.example.org"},
+		{"no server name extension", tlsRecord(clientHello(supportedVersions)), ""},
+		{"no extensions", tlsRecord(clientHello()), ""},
+		{"data after the record", append(tlsRecord(clientHello(sniExtension("www.example.org"))), 0x17, 3, 3), "www.example.org"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, err := tlsServerName(tt.record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != tt.want {
+				t.Errorf("tlsServerName() = %q, want %q", name, tt.want)
+			}
+		})
+	}
+}
+
+func TestTLSServerNameSplitAcrossRecords(t *testing.T) {
+	// A ClientHello larger than a record continues in the next record. The first
+	// record is enough if it holds the server name.
+	early := clientHello(sniExtension("www.example.org"), tlsExtension(21, make([]byte, 600)))
+	late := clientHello(tlsExtension(21, make([]byte, 600)), sniExtension("www.example.org"))
+	firstRecord := func(message []byte) []byte {
+		return tlsRecord(message[:200])
+	}
+
+	name, err := tlsServerName(firstRecord(early))
+	if err != nil || name != "www.example.org" {
+		t.Errorf("server name in the first record: tlsServerName() = %q, %v", name, err)
+	}
+	if _, err := tlsServerName(firstRecord(late)); err != errTruncatedHello {
+		t.Errorf("server name in the second record: err = %v, want errTruncatedHello", err)
+	}
+}
+
+func TestTLSServerNameTruncated(t *testing.T) {
+	record := tlsRecord(clientHello(tlsExtension(43, []byte{2, 3, 4}), sniExtension("www.example.org"), tlsExtension(21, make([]byte, 40))))
+	sniEnd := len(record) - 44
+
+	// Every prefix either fails or, once the server name is complete, names it
+	for n := 0; n < len(record); n++ {
+		name, err := tlsServerName(record[:n])
+		if n >= sniEnd {
+			if err != nil || name != "www.example.org" {
+				t.Errorf("prefix of %d bytes: tlsServerName() = %q, %v; want the name", n, name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("prefix of %d bytes: tlsServerName() = %q without an error", n, name)
+		}
+	}
+}
+
+func TestTLSServerNameMalformed(t *testing.T) {
+	valid := tlsRecord(clientHello(sniExtension("www.example.org")))
+	with := func(offset int, value byte) []byte {
+		record := append([]byte(nil), valid...)
+		record[offset] = value
+		return record
+	}
+	serverHello := append([]byte(nil), valid...)
+	serverHello[5] = 2
+	emptyList := tlsRecord(clientHello(tlsExtension(tlsExtensionServerName, []byte{0, 0})))
+	shortExtension := tlsRecord(clientHello(tlsExtension(tlsExtensionServerName, []byte{0})))
+	otherNameType := tlsRecord(clientHello(tlsExtension(tlsExtensionServerName, []byte{0, 4, 1, 0, 1, 'x'})))
+	overlongEntry := tlsRecord(clientHello(tlsExtension(tlsExtensionServerName, []byte{0, 10, 0, 0, 200, 'x'})))
+	sessionIDOffset := 5 + 4 + 2 + 32
+
+	tests := []struct {
+		name   string
+		record []byte
+		err    string
+	}{
+		{"application data", with(0, 0x17), "not a TLS handshake record"},
+		{"SSL 2 record", with(1, 2), "unknown TLS record version"},
+		{"not a ClientHello", serverHello, "is not a ClientHello"},
+		{"control character in the name", tlsRecord(clientHello(sniExtension("www.exa\nmple.org"))), "invalid server name"},
+		{"non-ASCII name", tlsRecord(clientHello(sniExtension("www.exämple.org"))), "invalid server name"},
+		{"empty server name list", emptyList, "without a host name"},
+		{"server_name extension too short", shortExtension, "truncated server_name extension"},
+		{"only other name types", otherNameType, "without a host name"},
+		{"entry longer than the list", overlongEntry, "without a host name"},
+		{"session ID past the end", with(sessionIDOffset, 0xff), errTruncatedHello.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tlsServerName(tt.record); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestTLSServerNameCorruptBytes(t *testing.T) {
+	// Corrupting any byte must not make the parser panic or read out of bounds
+	valid := tlsRecord(clientHello(tlsExtension(43, []byte{2, 3, 4}), sniExtension("www.example.org")))
+	for i := range valid {
+		for _, value := range []byte{0x00, 0x7f, 0xff} {
+			record := append([]byte(nil), valid...)
+			record[i] = value
+			tlsServerName(record)
+		}
+	}
+}
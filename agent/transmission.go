@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"roi-agent-data-sender/transmit"
+)
+
+// TransmitOutcome is the result of the most recent transmission run
+type TransmitOutcome struct {
+	At     time.Time
+	Result *transmit.RunResult
+	Err    error
+}
+
+// triggerDataTransmission runs the data sender on its own goroutine once an aligned interval has closed
+func (a *Agent) triggerDataTransmission() {
+	if !intervalStart(time.Now(), a.transmissionInterval).After(a.lastTransmission) {
+		return
+	}
+	a.transmit(nil)
+}
+
+// transmit starts a transmission run on its own goroutine and calls done, if given,
+// with its outcome. It returns false if the previous run is still in progress.
+func (a *Agent) transmit(done func(TransmitOutcome)) bool {
+	a.transmitMutex.Lock()
+	if a.transmitting {
+		a.transmitMutex.Unlock()
+		log.Println("Previous data transmission still running, skipping")
+		return false
+	}
+	a.transmitting = true
+	a.transmitWait.Add(1)
+	sender := a.sender
+	a.transmitMutex.Unlock()
+
+	log.Println("Triggering data transmission...")
+	a.lastTransmission = time.Now()
+
+	go func() {
+		defer a.transmitWait.Done()
+
+		var result *transmit.RunResult
+		var err error
+		func() {
+			defer func() {
+				if value := recover(); value != nil {
+					a.recordCrash("transmission", value, debug.Stack())
+					err = fmt.Errorf("data transmission panicked: %v", value)
+				}
+			}()
+			result, err = sender.ProcessCurrentInterval()
+		}()
+
+		switch {
+		case errors.Is(err, transmit.ErrDisabled):
+			log.Println("Data transmission is disabled")
+		case err != nil:
+			log.Printf("Data transmission error: %v", err)
+		default:
+			log.Printf("Data transmission completed: %d intervals queued, %d payloads sent, %d still queued",
+				len(result.Queued), result.Flush.Sent, result.Flush.Pending)
+			if errors.Is(result.Flush.SendErr, transmit.ErrRevoked) {
+				log.Println("The server revoked this device's credential; data transmission is disabled until it is enrolled again")
+			} else if result.Flush.SendErr != nil {
+				log.Printf("Data transmission will be retried: %v", result.Flush.SendErr)
+			}
+		}
+
+		outcome := TransmitOutcome{At: time.Now(), Result: result, Err: err}
+		a.transmitMutex.Lock()
+		a.transmitting = false
+		a.lastTransmit = outcome
+		a.transmitMutex.Unlock()
+
+		if done != nil {
+			done(outcome)
+		}
+	}()
+	return true
+}
+
+// transmissionStatus reports the sender's progress and outbox for Status
+func (a *Agent) transmissionStatus() map[string]interface{} {
+	a.transmitMutex.Lock()
+	sender := a.sender
+	outcome := a.lastTransmit
+	running := a.transmitting
+	a.transmitMutex.Unlock()
+
+	status := sender.Status()
+
+	report := map[string]interface{}{
+		"enabled":           status.Enabled,
+		"interval_minutes":  status.IntervalMinutes,
+		"last_interval_end": status.LastIntervalEnd,
+		"outbox_depth":      status.Outbox.Depth,
+		"enrolled":          !status.EnrolledAt.IsZero(),
+		"in_progress":       running,
+	}
+	if status.OutboxErr != nil {
+		report["outbox_error"] = status.OutboxErr.Error()
+	}
+	if status.Outbox.LastError != "" {
+		report["last_send_error"] = status.Outbox.LastError
+	}
+	if !outcome.At.IsZero() {
+		report["last_run"] = outcome.At
+		if outcome.Err != nil && !errors.Is(outcome.Err, transmit.ErrDisabled) {
+			report["last_run_error"] = outcome.Err.Error()
+		}
+	}
+	return report
+}
@@ -2,4 +2,10 @@ module roi-agent-data-sender
 
 go 1.21
 
-require github.com/joho/godotenv v1.5.1
+require (
+	github.com/joho/godotenv v1.5.1
+	golang.org/x/sys v0.15.0
+	roi-agent-schema v0.0.0
+)
+
+replace roi-agent-schema => ../schema
@@ -1,54 +1,93 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"time"
+
+	"roi-agent-data-sender/transmit"
 )
 
 func main() {
-	if len(os.Args) < 2 {
+	args, err := parseSettingFlags(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+
+	if len(args) < 1 {
 		fmt.Println("ROI Agent Data Sender - Enhanced with 10-minute intervals")
 		fmt.Println("")
 		fmt.Println("Usage:")
-		fmt.Println("  data-sender process                 # Process and send current 10-minute interval")
+		fmt.Println("  data-sender process                 # Queue closed intervals and send the outbox")
 		fmt.Println("  data-sender test                    # Test configuration and connection")
 		fmt.Println("  data-sender status                  # Show current status and configuration")
 		fmt.Println("  data-sender logs [limit]            # Show recent transmission logs (default: 10)")
 		fmt.Println("  data-sender cleanup                 # Cleanup old files (data, transmission, logs)")
 		fmt.Println("  data-sender set-interval <minutes>  # Set transmission interval (1-1440 minutes)")
+		fmt.Println("  data-sender config show [--origin]  # Show every setting, and where it came from")
+		fmt.Println("  data-sender config get <name>       # Show one setting")
+		fmt.Println("  data-sender config set <name> <value> # Save a setting in ~/.roiagent/data-sender/.env")
+		fmt.Println("  data-sender config unset <name>     # Remove a saved setting")
+		fmt.Println("  data-sender gaps [--from T] [--to T] # List intervals with usage that were never sent")
+		fmt.Println("  data-sender backfill --from T --to T [--rate N]  # Send those intervals, N per minute (default: 30)")
+		fmt.Println("  data-sender enroll [--insecure] <server> <code>  # Enroll this device with a one-time code from the admin")
+		fmt.Println("  data-sender device [rotate]         # Show this device's ID, or replace it with a new one")
+		fmt.Println("  data-sender validate [files...]     # Check day files against the data schema (default: all)")
 		fmt.Println("  data-sender env-example             # Create .env.example file")
 		fmt.Println("")
 		fmt.Println("Examples:")
-		fmt.Println("  data-sender process                 # Send data for the current interval")
+		fmt.Println("  data-sender process                 # Send data for the intervals closed since the last run")
 		fmt.Println("  data-sender test                    # Test if data transmission works")
 		fmt.Println("  data-sender logs 20                 # Show last 20 transmission attempts")
 		fmt.Println("  data-sender set-interval 5          # Set interval to 5 minutes")
+		fmt.Println("  data-sender config set base_url https://api.example.com/v1/roi-agent")
+		fmt.Println("  data-sender --enabled=false process # Flags override every other source for one run")
+		fmt.Println("  data-sender backfill --from 2025-07-18 --to \"2025-07-19 09:00\"")
 		fmt.Println("")
-		fmt.Println("Environment Variables (.env file):")
-		fmt.Println("  ROI_AGENT_BASE_URL         # Server base URL")
-		fmt.Println("  ROI_AGENT_API_KEY          # API authentication key")
-		fmt.Println("  ROI_AGENT_INTERVAL_MINUTES # Transmission interval in minutes (default: 10)")
+		fmt.Println("Settings (flag --<name>, environment variable, .env file, then default):")
+		for _, setting := range transmit.Settings {
+			fmt.Printf("  %-22s %-32s # %s", setting.Name, setting.Env, setting.Usage)
+			if setting.Default != "" && !setting.Secret {
+				fmt.Printf(" (default: %s)", setting.Default)
+			}
+			fmt.Println()
+		}
 		return
 	}
 
-	sender := NewDataSender()
-	command := os.Args[1]
+	sender := transmit.NewDataSender()
+	command := args[0]
 
 	switch command {
 	case "process":
-		if err := sender.processCurrentInterval(); err != nil {
+		result, err := sender.ProcessCurrentInterval()
+		if errors.Is(err, transmit.ErrDisabled) {
+			log.Println("Data transmission is disabled")
+			return
+		}
+		if err != nil {
 			log.Fatalf("Error processing current interval: %v", err)
 		}
+		log.Printf("Queued %d intervals, sent %d payloads, %d still queued",
+			len(result.Queued), result.Flush.Sent, result.Flush.Pending)
+		if errors.Is(result.Flush.SendErr, transmit.ErrRevoked) {
+			log.Printf("The server revoked this device's credential; run data-sender enroll to enroll it again")
+		} else if result.Flush.SendErr != nil {
+			log.Printf("Last send failed and will be retried: %v", result.Flush.SendErr)
+		}
 	case "test":
 		sender.TestConnection()
 	case "status":
 		sender.ShowStatus()
 	case "logs":
 		limit := 10
-		if len(os.Args) >= 3 {
-			if l, err := strconv.Atoi(os.Args[2]); err == nil {
+		if len(args) >= 2 {
+			if l, err := strconv.Atoi(args[1]); err == nil {
 				limit = l
 			}
 		}
@@ -66,18 +105,194 @@ func main() {
 			fmt.Println(".env.example file created")
 		}
 	case "set-interval":
-		if len(os.Args) < 3 {
+		if len(args) < 2 {
 			fmt.Println("Usage: data-sender set-interval <minutes>")
 			fmt.Println("Valid range: 1-1440 minutes (1 minute to 24 hours)")
 			return
 		}
-		interval, err := strconv.Atoi(os.Args[2])
+		interval, err := strconv.Atoi(args[1])
 		if err != nil || interval < 1 || interval > 1440 {
 			fmt.Println("Error: Invalid interval. Must be between 1 and 1440 minutes.")
 			return
 		}
-		sender.SetTransmissionInterval(interval)
+		if err := sender.SetTransmissionInterval(interval); err != nil {
+			log.Fatalf("Error saving interval: %v", err)
+		}
+		fmt.Printf("Transmission interval set to %d minutes in %s\n", interval, transmit.UserEnvPath())
+		fmt.Println("A running agent picks up the new interval within a few seconds.")
+	case "config":
+		if err := runConfig(sender, args[1:]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "gaps":
+		from, to, _, err := parseRangeArgs("gaps", args[1:], false)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if err := sender.ShowGaps(from, to); err != nil {
+			log.Fatalf("Error finding gaps: %v", err)
+		}
+	case "backfill":
+		from, to, rate, err := parseRangeArgs("backfill", args[1:], true)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			fmt.Println("Usage: data-sender backfill --from <date/time> --to <date/time> [--rate <intervals per minute>]")
+			return
+		}
+		result, err := sender.Backfill(from, to, rate)
+		if err != nil {
+			log.Fatalf("Error during backfill: %v", err)
+		}
+		if result.Queued == 0 {
+			fmt.Println("Nothing to backfill")
+		} else {
+			fmt.Printf("Backfill queued %d intervals\n", result.Queued)
+		}
+		if result.Flush.Pending > 0 {
+			fmt.Printf("%d payloads are still in the outbox and will be retried\n", result.Flush.Pending)
+		}
+	case "enroll":
+		flags := flag.NewFlagSet("enroll", flag.ContinueOnError)
+		insecure := flags.Bool("insecure", false, "allow a plain http:// server")
+		if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 2 {
+			fmt.Println("Usage: data-sender enroll [--insecure] <server> <code>")
+			return
+		}
+		credential, err := sender.Enroll(flags.Arg(0), flags.Arg(1), *insecure)
+		if err != nil {
+			log.Fatalf("Error enrolling device: %v", err)
+		}
+		fmt.Printf("Enrolled device %s with %s\n", credential.DeviceID, credential.Server)
+		fmt.Printf("Payloads will be sent to %s\n", credential.IngestURL)
+	case "device":
+		if len(args) >= 2 && args[1] == "rotate" {
+			identity, err := sender.RotateDeviceID()
+			if err != nil {
+				log.Fatalf("Error rotating device ID: %v", err)
+			}
+			fmt.Printf("New device ID: %s (%s)\n", identity.ID, identity.Source)
+			fmt.Println("The server will see this machine as a new device from the next transmission")
+			return
+		}
+		if len(args) >= 2 {
+			fmt.Println("Usage: data-sender device [rotate]")
+			return
+		}
+		identity, err := sender.DeviceIdentity()
+		if err != nil {
+			log.Fatalf("Error reading device identity: %v", err)
+		}
+		fmt.Printf("Device ID: %s\n", sender.Status().DeviceID)
+		if identity != nil {
+			fmt.Printf("Source: %s\n", identity.Source)
+			fmt.Printf("Created: %s\n", identity.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+			if identity.ID != sender.Status().DeviceID {
+				fmt.Println("(overridden by ROI_AGENT_DEVICE_ID)")
+			}
+		}
+	case "validate":
+		invalid, err := sender.ValidateDataFiles(args[1:])
+		if err != nil {
+			log.Fatalf("Error validating day files: %v", err)
+		}
+		if invalid > 0 {
+			os.Exit(1)
+		}
 	default:
 		fmt.Printf("Unknown command: %s\n", command)
 	}
 }
+
+// parseSettingFlags applies --<setting> flags given before the command and returns the rest
+func parseSettingFlags(args []string) ([]string, error) {
+	flags := flag.NewFlagSet("data-sender", flag.ContinueOnError)
+	for _, setting := range transmit.Settings {
+		name := setting.Name
+		flags.Func(name, setting.Usage+" (env "+setting.Env+")", func(value string) error {
+			return transmit.SetFlag(name, value)
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	return flags.Args(), nil
+}
+
+// runConfig implements config show, get, set and unset
+func runConfig(sender *transmit.DataSender, args []string) error {
+	if len(args) == 0 {
+		args = []string{"show"}
+	}
+
+	switch {
+	case args[0] == "show" && len(args) <= 2:
+		withOrigin := len(args) == 2 && args[1] == "--origin"
+		if len(args) == 2 && !withOrigin {
+			return fmt.Errorf("usage: data-sender config show [--origin]")
+		}
+		sender.ShowSettings(withOrigin)
+	case args[0] == "get" && len(args) == 2:
+		value, err := sender.Setting(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Println(value.Display())
+	case args[0] == "set" && len(args) == 3:
+		setting, err := sender.SetSetting(args[1], args[2])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Saved %s in %s\n", setting.Name, transmit.UserEnvPath())
+		if source := setting.OverriddenBy(); source != "" {
+			fmt.Printf("⚠️  %s takes precedence over the saved value\n", source)
+		}
+	case args[0] == "unset" && len(args) == 2:
+		setting, err := sender.UnsetSetting(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Removed %s from %s\n", setting.Name, transmit.UserEnvPath())
+	default:
+		return fmt.Errorf("usage: data-sender config show [--origin] | get <name> | set <name> <value> | unset <name>")
+	}
+	return nil
+}
+
+// parseRangeArgs parses --from, --to and --rate. Without a bound the range is open on that side.
+func parseRangeArgs(command string, args []string, requireBounds bool) (time.Time, time.Time, int, error) {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	fromArg := flags.String("from", "", "start date/time (local)")
+	toArg := flags.String("to", "", "end date/time (local)")
+	rate := flags.Int("rate", transmit.DefaultBackfillRate, "intervals per minute")
+	if err := flags.Parse(args); err != nil {
+		return time.Time{}, time.Time{}, 0, err
+	}
+
+	if requireBounds && (*fromArg == "" || *toArg == "") {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("--from and --to are required")
+	}
+
+	from := time.Time{}
+	to := time.Now()
+	var err error
+	if *fromArg != "" {
+		if from, err = transmit.ParseTimeArg(*fromArg); err != nil {
+			return time.Time{}, time.Time{}, 0, err
+		}
+	}
+	if *toArg != "" {
+		if to, err = transmit.ParseTimeArg(*toArg); err != nil {
+			return time.Time{}, time.Time{}, 0, err
+		}
+	}
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("--to must be after --from")
+	}
+	if *rate <= 0 {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("--rate must be positive")
+	}
+
+	return from, to, *rate, nil
+}
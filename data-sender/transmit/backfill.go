@@ -0,0 +1,225 @@
+package transmit
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultBackfillRate is how many intervals per minute a backfill sends unless overridden
+const DefaultBackfillRate = 30
+
+// timeArgLayouts are the accepted formats of --from and --to, in local time
+var timeArgLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseTimeArg parses a command line date/time in local time, or RFC 3339 with an offset
+func ParseTimeArg(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range timeArgLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date/time %q (use YYYY-MM-DD, \"YYYY-MM-DD HH:MM\" or RFC 3339)", value)
+}
+
+// Gap is an aligned interval with recorded usage that was never sent successfully
+type Gap struct {
+	Interval
+	Queued bool
+}
+
+// FindGaps compares the day files with the transmission log and returns the aligned
+// intervals within [from, to) that have usage samples but no successful transmission.
+// Intervals that have not closed yet are left out.
+func (ds *DataSender) FindGaps(from, to time.Time) ([]Gap, error) {
+	if now := time.Now(); to.After(now) {
+		to = now
+	}
+	length := ds.intervalLength()
+
+	sent := make(map[Interval]bool)
+	logs, err := ds.loadTransmissionLogs()
+	if err != nil {
+		return nil, fmt.Errorf("error reading transmission logs: %v", err)
+	}
+	for _, logEntry := range logs {
+		if logEntry.Success {
+			sent[Interval{Start: logEntry.StartTime.Round(0).UTC(), End: logEntry.EndTime.Round(0).UTC()}] = true
+		}
+	}
+
+	dayFiles, err := filepath.Glob(filepath.Join(ds.dataDir, "combined_*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var gaps []Gap
+	for _, dayFile := range dayFiles {
+		date := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(dayFile), "combined_"), ".json")
+		dayStart, err := time.ParseInLocation("2006-01-02", date, time.Local)
+		if err != nil || !dayStart.Before(to) || !dayStart.AddDate(0, 0, 1).After(from) {
+			continue
+		}
+
+		samples, err := ds.loadSamples(date)
+		if err != nil {
+			log.Printf("Error reading sample log for %s: %v", date, err)
+			continue
+		}
+
+		// Each sample belongs to the interval containing its end
+		active := make(map[Interval]bool)
+		for _, sample := range samples {
+			if len(sample.Apps) == 0 && len(sample.Network) == 0 {
+				continue
+			}
+			interval := alignedInterval(sample.End.Add(-time.Nanosecond), length)
+			if interval.Start.Before(from) || interval.End.After(to) {
+				continue
+			}
+			active[interval] = true
+		}
+
+		for interval := range active {
+			key := Interval{Start: interval.Start.UTC(), End: interval.End.UTC()}
+			if sent[key] {
+				continue
+			}
+			gaps = append(gaps, Gap{Interval: interval, Queued: ds.isQueued(interval.Start, interval.End)})
+		}
+	}
+
+	sort.Slice(gaps, func(i, j int) bool {
+		return gaps[i].Start.Before(gaps[j].Start)
+	})
+	return gaps, nil
+}
+
+// DayTransmitted reports whether the day files of date are no longer needed for sending:
+// the day has been processed and every interval of it with usage was transmitted or is
+// queued in the outbox, which holds its own copy of the payload. While transmission is
+// disabled nothing is waiting to be sent.
+func (ds *DataSender) DayTransmitted(date string) bool {
+	if !ds.config.Enabled {
+		return true
+	}
+	dayStart, err := time.ParseInLocation("2006-01-02", date, time.Local)
+	if err != nil {
+		return false
+	}
+	dayEnd := dayStart.AddDate(0, 0, 1)
+	if ds.loadTransmissionState().LastIntervalEnd.Before(dayEnd) {
+		return false
+	}
+
+	gaps, err := ds.FindGaps(dayStart, dayEnd)
+	if err != nil {
+		log.Printf("Error finding untransmitted intervals for %s: %v", date, err)
+		return false
+	}
+	for _, gap := range gaps {
+		if !gap.Queued {
+			return false
+		}
+	}
+	return true
+}
+
+// ShowGaps lists the intervals with usage that were never sent successfully
+func (ds *DataSender) ShowGaps(from, to time.Time) error {
+	gaps, err := ds.FindGaps(from, to)
+	if err != nil {
+		return err
+	}
+
+	if len(gaps) == 0 {
+		fmt.Println("No gaps: every interval with recorded usage was transmitted")
+		return nil
+	}
+
+	fmt.Printf("Untransmitted intervals (%d, %d-minute alignment):\n", len(gaps), ds.intervalMinutes)
+	fmt.Println("=======================================================")
+	for _, gap := range gaps {
+		status := ""
+		if gap.Queued {
+			status = " (queued)"
+		}
+		fmt.Printf("  %s - %s%s\n", gap.Start.Format("2006-01-02 15:04"), gap.End.Format("15:04"), status)
+	}
+	fmt.Println("\nRun 'data-sender backfill --from <date/time> --to <date/time>' to send them")
+	return nil
+}
+
+// BackfillResult is the outcome of a backfill
+type BackfillResult struct {
+	Queued int         // intervals generated and queued
+	Flush  FlushResult // outbox state after the last send
+}
+
+// Backfill generates and sends the untransmitted intervals within [from, to) through
+// ProcessDataInterval, at most perMinute intervals per minute. The transmission lock is
+// taken for each interval rather than the whole backfill, so the agent keeps sending.
+func (ds *DataSender) Backfill(from, to time.Time, perMinute int) (*BackfillResult, error) {
+	if !ds.config.Enabled {
+		return nil, ErrDisabled
+	}
+	if perMinute <= 0 {
+		perMinute = DefaultBackfillRate
+	}
+
+	gaps, err := ds.FindGaps(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Gap
+	for _, gap := range gaps {
+		if !gap.Queued {
+			pending = append(pending, gap)
+		}
+	}
+
+	result := &BackfillResult{}
+	if len(pending) > 0 {
+		log.Printf("Backfilling %d intervals at up to %d per minute", len(pending), perMinute)
+	}
+	limiter := time.NewTicker(time.Minute / time.Duration(perMinute))
+	defer limiter.Stop()
+
+	for i, gap := range pending {
+		if i > 0 {
+			<-limiter.C
+		}
+
+		log.Printf("Backfilling interval %s - %s (%d/%d)",
+			gap.Start.Format("2006-01-02 15:04"), gap.End.Format("15:04"), i+1, len(pending))
+		err := ds.ProcessDataInterval(gap.Start, gap.End)
+		if err == ErrNoIntervalData {
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("backfill stopped at %s: %w", gap.Start.Format("2006-01-02 15:04"), err)
+		}
+		result.Queued++
+	}
+
+	unlock, err := ds.lockTransmission()
+	if err != nil {
+		return result, err
+	}
+	defer unlock()
+	result.Flush, err = ds.flushOutbox()
+	return result, err
+}
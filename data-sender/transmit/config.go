@@ -0,0 +1,96 @@
+package transmit
+
+import (
+	"log"
+	"strconv"
+	"time"
+)
+
+// Configuration for data transmission, resolved from Settings by loadConfig
+type Config struct {
+	BaseURL  string
+	APIKey   string
+	DeviceID string
+	Enabled  bool
+
+	// PayloadFormat selects the payload layout: PayloadFormatV2 adds every app's usage
+	// in app_usage, PayloadFormatLegacy sends only the single-entry apps array
+	PayloadFormat string
+}
+
+// Payload formats accepted in ROI_AGENT_PAYLOAD_FORMAT
+const (
+	PayloadFormatV2     = "v2"
+	PayloadFormatLegacy = "legacy"
+)
+
+// loadConfig resolves every setting, in order of precedence, from flags, the
+// environment and .env files, the legacy transmission_config.json and the defaults
+func (ds *DataSender) loadConfig() {
+	LoadEnvFiles()
+
+	legacy := ds.loadLegacyConfig()
+	ds.values = make(map[string]string, len(Settings))
+	ds.origins = make(map[string]string, len(Settings))
+	for i := range Settings {
+		s := &Settings[i]
+		value, origin, err := ds.resolve(s, legacy)
+		if err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		ds.values[s.Env], ds.origins[s.Env] = value, origin
+	}
+
+	ds.config = Config{
+		BaseURL:       ds.values["ROI_AGENT_BASE_URL"],
+		APIKey:        ds.values["ROI_AGENT_API_KEY"],
+		DeviceID:      ds.deviceID(),
+		PayloadFormat: ds.values["ROI_AGENT_PAYLOAD_FORMAT"],
+	}
+	if deviceID := ds.values["ROI_AGENT_DEVICE_ID"]; deviceID != "" && deviceID != "auto-generated" {
+		ds.config.DeviceID = deviceID
+	}
+
+	// For Mac App, enable by default if the server is configured rather than left at the samples
+	if ds.origins["ROI_AGENT_BASE_URL"] != "default" && ds.origins["ROI_AGENT_API_KEY"] != "default" {
+		ds.config.Enabled = true
+	}
+
+	// An enrolled device sends with its own credential instead of the shared API key
+	credential, err := ds.loadCredential()
+	if err != nil {
+		log.Printf("Warning: Could not read device credential: %v", err)
+	}
+	if credential != nil {
+		if credential.DeviceID != ds.config.DeviceID {
+			log.Printf("Warning: Device was enrolled as %s but its ID is now %s; enroll again", credential.DeviceID, ds.config.DeviceID)
+		} else {
+			ds.credential = credential
+			ds.config.Enabled = true
+			log.Printf("Using device credential enrolled with %s", credential.Server)
+		}
+	}
+
+	if enabled := ds.values["ROI_AGENT_ENABLED"]; enabled != "" {
+		ds.config.Enabled, _ = strconv.ParseBool(enabled)
+	}
+
+	ds.intervalMinutes, _ = strconv.Atoi(ds.values["ROI_AGENT_INTERVAL_MINUTES"])
+	hours, _ := strconv.Atoi(ds.values["ROI_AGENT_OUTBOX_MAX_AGE_HOURS"])
+	ds.outboxMaxAge = time.Duration(hours) * time.Hour
+	mb, _ := strconv.Atoi(ds.values["ROI_AGENT_OUTBOX_MAX_MB"])
+	ds.outboxMaxBytes = int64(mb) * 1024 * 1024
+	ds.dataRetentionDays, _ = strconv.Atoi(ds.values["ROI_AGENT_MONITOR_DATA_RETENTION_DAYS"])
+
+	log.Printf("Data transmission enabled: %v", ds.config.Enabled)
+	log.Printf("Base URL: %s", ds.endpoint())
+}
+
+// endpoint returns the URL payloads are sent to: the ingest URL of an enrolled device,
+// otherwise the configured base URL
+func (ds *DataSender) endpoint() string {
+	if ds.credential != nil {
+		return ds.credential.IngestURL
+	}
+	return ds.config.BaseURL
+}
@@ -0,0 +1,212 @@
+package transmit
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Sources a device ID can be generated from, selected with ROI_AGENT_DEVICE_ID_SOURCE
+const (
+	DeviceSourceRandom  = "random"  // random UUID, the default
+	DeviceSourceMachine = "machine" // hash of the OS machine identifier, falls back to random
+)
+
+// DeviceIdentity is the device ID persisted in ~/.roiagent/device.json. It is generated
+// once and does not depend on the hostname, so renaming a machine keeps its identity.
+type DeviceIdentity struct {
+	ID        string    `json:"device_id"`
+	Source    string    `json:"source"` // random, machine-id or platform-uuid
+	CreatedAt time.Time `json:"created_at"`
+
+	// Generation is bumped by each rotation so a machine-derived ID changes too
+	Generation int      `json:"generation"`
+	Previous   []string `json:"previous_ids,omitempty"`
+}
+
+// platformUUIDPattern extracts IOPlatformUUID from ioreg output on macOS
+var platformUUIDPattern = regexp.MustCompile(`"IOPlatformUUID"\s*=\s*"([^"]+)"`)
+
+// deviceIDSource returns the configured ID source
+func (ds *DataSender) deviceIDSource() string {
+	if source := ds.values["ROI_AGENT_DEVICE_ID_SOURCE"]; source != "" {
+		return source
+	}
+	return DeviceSourceRandom
+}
+
+// loadDeviceIdentity reads the persisted identity. It returns nil without error if none exists yet.
+func (ds *DataSender) loadDeviceIdentity() (*DeviceIdentity, error) {
+	data, err := ioutil.ReadFile(ds.devicePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var identity DeviceIdentity
+	if err := json.Unmarshal(data, &identity); err != nil {
+		return nil, fmt.Errorf("device file is corrupt: %v", err)
+	}
+	if identity.ID == "" {
+		return nil, fmt.Errorf("device file has no device_id")
+	}
+	return &identity, nil
+}
+
+// saveDeviceIdentity saves the identity atomically
+func (ds *DataSender) saveDeviceIdentity(identity *DeviceIdentity) error {
+	data, err := json.MarshalIndent(identity, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := ds.devicePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, ds.devicePath)
+}
+
+// deviceID returns the persisted device ID, generating and saving it on first use.
+// An unreadable device file is kept aside rather than silently replaced.
+func (ds *DataSender) deviceID() string {
+	identity, err := ds.loadDeviceIdentity()
+	if err != nil {
+		log.Printf("Warning: Could not read device identity: %v", err)
+		backupPath := fmt.Sprintf("%s.corrupt-%s", ds.devicePath, time.Now().Format("20060102-150405"))
+		if err := os.Rename(ds.devicePath, backupPath); err == nil {
+			log.Printf("Backed up device file to %s", backupPath)
+		}
+	}
+	if identity != nil {
+		return identity.ID
+	}
+
+	identity = newDeviceIdentity(ds.deviceIDSource(), 0)
+	if err := ds.saveDeviceIdentity(identity); err != nil {
+		log.Printf("Warning: Could not save device identity, the ID will change on the next run: %v", err)
+	} else {
+		log.Printf("Generated device ID %s (%s)", identity.ID, identity.Source)
+	}
+	return identity.ID
+}
+
+// RotateDeviceID replaces the persisted device ID with a new one, so the server sees
+// this machine as a new device from now on. It returns the new identity.
+func (ds *DataSender) RotateDeviceID() (*DeviceIdentity, error) {
+	current, err := ds.loadDeviceIdentity()
+	if err != nil {
+		log.Printf("Warning: Replacing unreadable device identity: %v", err)
+	}
+
+	generation := 0
+	var previous []string
+	if current != nil {
+		generation = current.Generation
+		previous = append(current.Previous, current.ID)
+	}
+
+	identity := newDeviceIdentity(ds.deviceIDSource(), generation+1)
+	identity.Previous = previous
+	if err := ds.saveDeviceIdentity(identity); err != nil {
+		return nil, fmt.Errorf("error saving device identity: %v", err)
+	}
+
+	// The server issued the credential for the old ID
+	if credential, _ := ds.loadCredential(); credential != nil {
+		log.Printf("Removed the device credential enrolled for %s; enroll the new device ID again", credential.DeviceID)
+		ds.dropCredential()
+	}
+
+	if ds.values["ROI_AGENT_DEVICE_ID"] != "" {
+		log.Printf("Warning: ROI_AGENT_DEVICE_ID is set and overrides the rotated device ID")
+	} else {
+		ds.config.DeviceID = identity.ID
+	}
+	return identity, nil
+}
+
+// DeviceIdentity returns the persisted identity, or nil if none has been generated
+func (ds *DataSender) DeviceIdentity() (*DeviceIdentity, error) {
+	return ds.loadDeviceIdentity()
+}
+
+// newDeviceIdentity generates an identity from source. A machine identifier is never
+// sent as is: it is hashed with the generation, so rotating yields an unrelated ID.
+func newDeviceIdentity(source string, generation int) *DeviceIdentity {
+	identity := &DeviceIdentity{
+		Source:     DeviceSourceRandom,
+		CreatedAt:  time.Now().UTC(),
+		Generation: generation,
+	}
+
+	if source == DeviceSourceMachine {
+		machineID, machineSource, err := readMachineID()
+		if err == nil {
+			sum := sha256.Sum256([]byte(fmt.Sprintf("roi-agent:%s:%d", machineID, generation)))
+			identity.ID = formatUUID(sum[:16])
+			identity.Source = machineSource
+			return identity
+		}
+		log.Printf("Warning: Could not read machine identifier, using a random device ID: %v", err)
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		// crypto/rand does not fail on supported platforms; keep a unique ID regardless
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%d", time.Now().UnixNano(), os.Getpid())))
+		copy(random, sum[:])
+	}
+	identity.ID = formatUUID(random)
+	return identity
+}
+
+// readMachineID returns the OS machine identifier and which one it is
+func readMachineID() (string, string, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		output, err := exec.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+		if err != nil {
+			return "", "", err
+		}
+		if match := platformUUIDPattern.FindSubmatch(output); match != nil {
+			return string(match[1]), "platform-uuid", nil
+		}
+		return "", "", fmt.Errorf("IOPlatformUUID not found in ioreg output")
+	case "linux":
+		for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			if machineID := strings.TrimSpace(string(data)); machineID != "" {
+				return machineID, "machine-id", nil
+			}
+		}
+		return "", "", fmt.Errorf("no machine-id file found")
+	}
+	return "", "", fmt.Errorf("no machine identifier on %s", runtime.GOOS)
+}
+
+// formatUUID formats 16 bytes as a version 4 style UUID
+func formatUUID(b []byte) string {
+	u := make([]byte, 16)
+	copy(u, b)
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+
+	s := hex.EncodeToString(u)
+	return fmt.Sprintf("%s-%s-%s-%s-%s", s[0:8], s[8:12], s[12:16], s[16:20], s[20:32])
+}
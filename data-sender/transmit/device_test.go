@@ -0,0 +1,107 @@
+package transmit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestDeviceIDPersisted(t *testing.T) {
+	ds := newTestSender(t)
+	id := ds.deviceID()
+	if !uuidPattern.MatchString(id) {
+		t.Errorf("deviceID() = %q, want a UUID", id)
+	}
+	if again := ds.deviceID(); again != id {
+		t.Errorf("deviceID() = %q on the second call, want %q", again, id)
+	}
+}
+
+func TestDeviceIDCorruptFile(t *testing.T) {
+	ds := newTestSender(t)
+	if err := ioutil.WriteFile(ds.devicePath, []byte(`{"device_id": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if id := ds.deviceID(); !uuidPattern.MatchString(id) {
+		t.Errorf("deviceID() = %q, want a new UUID", id)
+	}
+	if backups, _ := filepath.Glob(ds.devicePath + ".corrupt-*"); len(backups) != 1 {
+		t.Errorf("corrupt device file backups = %v, want one", backups)
+	}
+	identity, err := ds.loadDeviceIdentity()
+	if err != nil || identity == nil {
+		t.Errorf("loadDeviceIdentity() after replacing a corrupt file = %v, %v", identity, err)
+	}
+}
+
+func TestRotateDeviceID(t *testing.T) {
+	ds := newTestSender(t)
+	oldID := ds.deviceID()
+	ds.config.DeviceID = oldID
+	credential := &Credential{Server: "https://roi.example.com", IngestURL: "https://roi.example.com/ingest", DeviceID: oldID, Token: "token-1", EnrolledAt: time.Now()}
+	if err := ds.saveCredential(credential); err != nil {
+		t.Fatal(err)
+	}
+	ds.credential = credential
+
+	identity, err := ds.RotateDeviceID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.ID == oldID || !uuidPattern.MatchString(identity.ID) || identity.Generation != 1 ||
+		len(identity.Previous) != 1 || identity.Previous[0] != oldID {
+		t.Errorf("RotateDeviceID() = %+v", identity)
+	}
+	if ds.config.DeviceID != identity.ID || ds.deviceID() != identity.ID {
+		t.Errorf("device ID after rotation = %s, persisted %s, want %s", ds.config.DeviceID, ds.deviceID(), identity.ID)
+	}
+
+	// The credential was issued for the old ID, so it is dropped
+	if ds.credential != nil {
+		t.Error("the credential for the old device ID is still in use")
+	}
+	if _, err := os.Stat(ds.credentialPath); !os.IsNotExist(err) {
+		t.Error("the credential for the old device ID is still stored")
+	}
+
+	again, err := ds.RotateDeviceID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Generation != 2 || len(again.Previous) != 2 || again.Previous[1] != identity.ID {
+		t.Errorf("second RotateDeviceID() = %+v", again)
+	}
+}
+
+func TestRotateDeviceIDOverridden(t *testing.T) {
+	ds := newTestSender(t)
+	ds.values = map[string]string{"ROI_AGENT_DEVICE_ID": "fixed-id"}
+	ds.config.DeviceID = "fixed-id"
+
+	identity, err := ds.RotateDeviceID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds.config.DeviceID != "fixed-id" {
+		t.Errorf("device ID = %s, want the ROI_AGENT_DEVICE_ID override", ds.config.DeviceID)
+	}
+	if persisted, _ := ds.loadDeviceIdentity(); persisted == nil || persisted.ID != identity.ID {
+		t.Errorf("persisted identity = %+v, want the rotated one", persisted)
+	}
+}
+
+func TestFormatUUID(t *testing.T) {
+	b := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	if got, want := formatUUID(b), "ffffffff-ffff-4fff-bfff-ffffffffffff"; got != want {
+		t.Errorf("formatUUID() = %s, want %s", got, want)
+	}
+	if b[6] != 0xff {
+		t.Error("formatUUID() modified its argument")
+	}
+}
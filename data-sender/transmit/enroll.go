@@ -0,0 +1,201 @@
+package transmit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// ErrRevoked is returned when the server rejects the device credential. The credential
+// is removed and the device has to be enrolled again.
+var ErrRevoked = errors.New("device credential was revoked by the server")
+
+// Credential is the per-device credential received on enrollment, stored in
+// ~/.roiagent/credentials.json readable only by the user
+type Credential struct {
+	Server     string    `json:"server"`
+	IngestURL  string    `json:"ingest_url"`
+	DeviceID   string    `json:"device_id"`
+	Token      string    `json:"token"`
+	EnrolledAt time.Time `json:"enrolled_at"`
+}
+
+// enrollRequest is posted to <server>/enroll with the one-time code
+type enrollRequest struct {
+	Code         string `json:"code"`
+	DeviceID     string `json:"device_id"`
+	Hostname     string `json:"hostname"`
+	OS           string `json:"os"`
+	AgentVersion string `json:"agent_version"`
+}
+
+// enrollResponse is the server's answer to a valid code. IngestURL defaults to
+// <server>/ingest when the server does not name one.
+type enrollResponse struct {
+	Token     string `json:"device_token"`
+	IngestURL string `json:"ingest_url"`
+}
+
+// loadCredential reads the stored credential. It returns nil without error if the device is not enrolled.
+func (ds *DataSender) loadCredential() (*Credential, error) {
+	data, err := ioutil.ReadFile(ds.credentialPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var credential Credential
+	if err := json.Unmarshal(data, &credential); err != nil {
+		return nil, fmt.Errorf("credential file is corrupt: %v", err)
+	}
+	if credential.Token == "" || credential.IngestURL == "" {
+		return nil, fmt.Errorf("credential file is incomplete")
+	}
+	return &credential, nil
+}
+
+// saveCredential writes the credential atomically with 0600 permissions
+func (ds *DataSender) saveCredential(credential *Credential) error {
+	data, err := json.MarshalIndent(credential, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := ds.credentialPath + ".tmp"
+	os.Remove(tmpPath)
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, ds.credentialPath)
+}
+
+// Enroll exchanges a one-time enrollment code for a per-device credential and stores it.
+// From then on payloads are sent to the ingest URL with the device credential instead
+// of ROI_AGENT_BASE_URL and ROI_AGENT_API_KEY. A plain http:// server is refused unless
+// insecure is set, since the code and the credential would travel in cleartext.
+func (ds *DataSender) Enroll(server, code string, insecure bool) (*Credential, error) {
+	server = strings.TrimRight(strings.TrimSpace(server), "/")
+	if !strings.HasPrefix(server, "https://") && !strings.HasPrefix(server, "http://") {
+		server = "https://" + server
+	}
+	if strings.HasPrefix(server, "http://") && !insecure {
+		return nil, fmt.Errorf("refusing to enroll over plain http, which sends the code and credential in cleartext; use https:// or --insecure")
+	}
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, fmt.Errorf("enrollment code is empty")
+	}
+
+	hostname, _ := os.Hostname()
+	body, err := json.Marshal(enrollRequest{
+		Code:         code,
+		DeviceID:     ds.config.DeviceID,
+		Hostname:     hostname,
+		OS:           runtime.GOOS,
+		AgentVersion: "1.0.0",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling enrollment request: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", server+"/enroll", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ROI-Agent/1.0.0")
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending enrollment request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading enrollment response: %v", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden ||
+		resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil, fmt.Errorf("enrollment code was rejected (status %d): %s", resp.StatusCode, string(responseBody))
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return nil, fmt.Errorf("server returned status %d: %s", resp.StatusCode, string(responseBody))
+	}
+
+	var enrolled enrollResponse
+	if err := json.Unmarshal(responseBody, &enrolled); err != nil {
+		return nil, fmt.Errorf("invalid enrollment response: %v", err)
+	}
+	if enrolled.Token == "" {
+		return nil, fmt.Errorf("enrollment response has no device_token")
+	}
+	if enrolled.IngestURL == "" {
+		enrolled.IngestURL = server + "/ingest"
+	}
+
+	credential := &Credential{
+		Server:     server,
+		IngestURL:  enrolled.IngestURL,
+		DeviceID:   ds.config.DeviceID,
+		Token:      enrolled.Token,
+		EnrolledAt: time.Now().UTC(),
+	}
+	if err := ds.saveCredential(credential); err != nil {
+		return nil, fmt.Errorf("error saving credential: %v", err)
+	}
+
+	ds.credential = credential
+	if ds.values["ROI_AGENT_ENABLED"] == "" {
+		ds.config.Enabled = true
+	}
+	return credential, nil
+}
+
+// revokeCredential removes the credential the server no longer accepts. The file is
+// renamed rather than deleted so an admin can see when the device was offboarded.
+func (ds *DataSender) revokeCredential() {
+	if ds.credential == nil {
+		return
+	}
+	ds.credential = nil
+	ds.config.Enabled = false
+
+	revokedPath := fmt.Sprintf("%s.revoked-%s", ds.credentialPath, time.Now().Format("20060102-150405"))
+	if err := os.Rename(ds.credentialPath, revokedPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing revoked credential: %v", err)
+		os.Remove(ds.credentialPath)
+	}
+	log.Printf("Device credential was revoked by the server; transmission is disabled until the device is enrolled again")
+}
+
+// dropCredential removes the credential because it no longer matches the device ID
+func (ds *DataSender) dropCredential() {
+	if err := os.Remove(ds.credentialPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing credential: %v", err)
+	}
+	ds.credential = nil
+}
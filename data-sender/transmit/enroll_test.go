@@ -0,0 +1,246 @@
+package transmit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// enrollServer serves /enroll, accepting only code and answering with response
+func enrollServer(t *testing.T, code string, response string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request enrollRequest
+		if r.Method != "POST" || r.URL.Path != "/enroll" || json.NewDecoder(r.Body).Decode(&request) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if request.Code != code || request.DeviceID != "device-1" || request.OS != runtime.GOOS {
+			http.Error(w, "unknown code", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestEnroll(t *testing.T) {
+	server := enrollServer(t, "ABCD-1234", `{"device_token": "token-1"}`)
+	ds := newTestSender(t)
+	ds.config.Enabled = false
+
+	credential, err := ds.Enroll("  "+server.URL+"/ ", " ABCD-1234\n", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential.Token != "token-1" || credential.Server != server.URL || credential.IngestURL != server.URL+"/ingest" ||
+		credential.DeviceID != "device-1" || credential.EnrolledAt.IsZero() {
+		t.Errorf("Enroll() = %+v", credential)
+	}
+	if ds.credential != credential || !ds.config.Enabled || ds.endpoint() != server.URL+"/ingest" {
+		t.Errorf("after Enroll() credential %v, enabled %v, endpoint %s", ds.credential, ds.config.Enabled, ds.endpoint())
+	}
+
+	info, err := os.Stat(ds.credentialPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("credential file mode = %v, want 0600", info.Mode().Perm())
+	}
+	saved, err := ds.loadCredential()
+	if err != nil || saved == nil || saved.Token != "token-1" || saved.IngestURL != credential.IngestURL {
+		t.Errorf("loadCredential() = %+v, %v", saved, err)
+	}
+}
+
+func TestEnrollIngestURL(t *testing.T) {
+	server := enrollServer(t, "ABCD-1234", `{"device_token": "token-1", "ingest_url": "https://ingest.example.com/v1"}`)
+	ds := newTestSender(t)
+	ds.values = map[string]string{"ROI_AGENT_ENABLED": "false"}
+	ds.config.Enabled = false
+
+	credential, err := ds.Enroll(server.URL, "ABCD-1234", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential.IngestURL != "https://ingest.example.com/v1" {
+		t.Errorf("ingest URL = %s", credential.IngestURL)
+	}
+	if ds.config.Enabled {
+		t.Error("Enroll() enabled transmission that ROI_AGENT_ENABLED disables")
+	}
+}
+
+func TestEnrollFails(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		response string
+		status   int
+		err      string
+	}{
+		{"wrong code", "WRONG", "", 0, "enrollment code was rejected (status 401)"},
+		{"empty code", " ", "", 0, "enrollment code is empty"},
+		{"code already used", "ABCD-1234", "", http.StatusGone, "enrollment code was rejected (status 410)"},
+		{"forbidden", "ABCD-1234", "", http.StatusForbidden, "enrollment code was rejected (status 403)"},
+		{"server error", "ABCD-1234", "", http.StatusInternalServerError, "server returned status 500"},
+		{"no token", "ABCD-1234", `{"ingest_url": "https://ingest.example.com"}`, 0, "has no device_token"},
+		{"invalid response", "ABCD-1234", `<html>`, 0, "invalid enrollment response"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := enrollServer(t, "ABCD-1234", tt.response)
+			if tt.status != 0 {
+				server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					http.Error(w, "no", tt.status)
+				}))
+				defer server.Close()
+			}
+			ds := newTestSender(t)
+
+			if _, err := ds.Enroll(server.URL, tt.code, true); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Enroll() err = %v, want %q", err, tt.err)
+			}
+			if _, err := os.Stat(ds.credentialPath); !os.IsNotExist(err) || ds.credential != nil {
+				t.Error("a credential was stored for a failed enrollment")
+			}
+		})
+	}
+}
+
+func TestEnrollRefusesPlainHTTP(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"device_token": "token-1"}`))
+	}))
+	defer server.Close()
+	ds := newTestSender(t)
+
+	if _, err := ds.Enroll(server.URL, "ABCD-1234", false); err == nil || !strings.Contains(err.Error(), "--insecure") {
+		t.Errorf("Enroll() over http err = %v, want a refusal", err)
+	}
+	if requests != 0 {
+		t.Error("the enrollment code was sent over plain http")
+	}
+
+	// A server without a scheme is contacted over https
+	if _, err := ds.Enroll(strings.TrimPrefix(server.URL, "http://"), "ABCD-1234", false); err == nil || requests != 0 {
+		t.Errorf("Enroll() without a scheme err = %v after %d plain http requests", err, requests)
+	}
+}
+
+func TestLoadCredentialInvalid(t *testing.T) {
+	tests := map[string]string{
+		"corrupt":    `{"token": `,
+		"incomplete": `{"token": "token-1"}`,
+	}
+	for name, data := range tests {
+		ds := newTestSender(t)
+		if err := ioutil.WriteFile(ds.credentialPath, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if credential, err := ds.loadCredential(); err == nil || credential != nil {
+			t.Errorf("%s credential: loadCredential() = %v, %v; want an error", name, credential, err)
+		}
+	}
+}
+
+func TestSendDataRevoked(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		http.Error(w, "device offboarded", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	ds := newTestSender(t)
+	credential := &Credential{Server: server.URL, IngestURL: server.URL + "/ingest", DeviceID: "device-1", Token: "token-1", EnrolledAt: time.Now()}
+	if err := ds.saveCredential(credential); err != nil {
+		t.Fatal(err)
+	}
+	ds.credential = credential
+
+	if err := ds.sendData(TransmissionPayload{DeviceID: "device-1"}); err != ErrRevoked {
+		t.Errorf("sendData() err = %v, want ErrRevoked", err)
+	}
+	if authorization != "Bearer token-1" {
+		t.Errorf("Authorization = %q", authorization)
+	}
+
+	// Transmission stops and the credential is kept aside for the admin
+	if ds.credential != nil || ds.config.Enabled {
+		t.Errorf("credential %v, enabled %v after revocation", ds.credential, ds.config.Enabled)
+	}
+	if _, err := os.Stat(ds.credentialPath); !os.IsNotExist(err) {
+		t.Error("the revoked credential is still in use")
+	}
+	revoked, _ := filepath.Glob(ds.credentialPath + ".revoked-*")
+	if len(revoked) != 1 {
+		t.Errorf("revoked credential files = %v, want one", revoked)
+	}
+	if err := ds.sendData(TransmissionPayload{}); err == nil || !strings.Contains(err.Error(), "not configured") {
+		t.Errorf("sendData() after revocation err = %v", err)
+	}
+}
+
+func TestSendDataForbiddenIsRetried(t *testing.T) {
+	// A 403, e.g. from a proxy, is an ordinary failure: the payload stays queued
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "blocked by policy", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	ds := newTestSender(t)
+	credential := &Credential{Server: server.URL, IngestURL: server.URL + "/ingest", DeviceID: "device-1", Token: "token-1", EnrolledAt: time.Now()}
+	if err := ds.saveCredential(credential); err != nil {
+		t.Fatal(err)
+	}
+	ds.credential = credential
+	start := time.Now().Add(-time.Hour).Truncate(10 * time.Minute)
+	queueEntries(t, ds, start)
+
+	result, err := ds.flushOutbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SendErr == nil || result.SendErr == ErrRevoked || result.Pending != 1 {
+		t.Errorf("flushOutbox() = %+v, want a failed send left in the outbox", result)
+	}
+	if ds.credential == nil || !ds.config.Enabled || !ds.isQueued(start, start.Add(10*time.Minute)) {
+		t.Error("a 403 revoked the credential or dropped the payload")
+	}
+	if _, err := os.Stat(ds.credentialPath); err != nil {
+		t.Errorf("credential file after a 403: %v", err)
+	}
+}
+
+func TestSendDataAPIKeyUnauthorized(t *testing.T) {
+	// Without a device credential a 401 is an ordinary failure, retried later
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "key" {
+			t.Errorf("X-API-Key = %q", r.Header.Get("X-API-Key"))
+		}
+		http.Error(w, "wrong key", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	ds := newTestSender(t)
+	ds.config.BaseURL, ds.config.APIKey = server.URL, "key"
+	if err := ds.sendData(TransmissionPayload{}); err == nil || err == ErrRevoked {
+		t.Errorf("sendData() err = %v, want a failed send", err)
+	}
+	if !ds.config.Enabled {
+		t.Error("a rejected API key disabled transmission")
+	}
+}
@@ -0,0 +1,42 @@
+package transmit
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrDisabled is returned when data transmission is disabled in the configuration
+var ErrDisabled = errors.New("data transmission is disabled")
+
+// ErrNoIntervalData is returned for an interval without any day file, e.g. while the agent was not running
+var ErrNoIntervalData = errors.New("no data for interval")
+
+// IntervalError reports an interval whose payload could not be built or queued
+type IntervalError struct {
+	Interval Interval
+	Err      error
+}
+
+func (e *IntervalError) Error() string {
+	return fmt.Sprintf("interval %s-%s: %v",
+		e.Interval.Start.Format("2006-01-02 15:04"), e.Interval.End.Format("15:04"), e.Err)
+}
+
+func (e *IntervalError) Unwrap() error {
+	return e.Err
+}
+
+// RunResult is the outcome of one ProcessCurrentInterval run
+type RunResult struct {
+	Queued []Interval  // intervals whose payloads this run queued
+	Empty  []Interval  // closed intervals without any data to send
+	Flush  FlushResult // what sending the outbox achieved afterwards
+}
+
+// FlushResult is the outcome of sending the outbox
+type FlushResult struct {
+	Sent    int   // payloads the server accepted
+	Dropped int   // payloads dropped over the maximum age or disk quota
+	Pending int   // payloads still queued afterwards
+	SendErr error // the failed send that stopped the flush, retried later with backoff
+}
@@ -0,0 +1,136 @@
+package transmit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Event types written by the agent's event log (matching agent/timeline.go)
+const (
+	EventFocusChange = "focus_change"
+	EventAppLaunch   = "app_launch"
+	EventAppQuit     = "app_quit"
+	EventAgentStart  = "agent_start"
+	EventAgentStop   = "agent_stop"
+	EventSuspend     = "suspend"
+	EventResume      = "resume"
+)
+
+// TimelineEvent is a single entry of the agent's events_YYYY-MM-DD.jsonl log
+type TimelineEvent struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	App  string    `json:"app,omitempty"`
+
+	// Carried marks the focus_change that opens a new day's log with the app
+	// that was already focused before midnight
+	Carried bool `json:"carried,omitempty"`
+}
+
+// FocusSpan is a continuous period during which one app had focus
+type FocusSpan struct {
+	App   string
+	Start time.Time
+	End   time.Time
+}
+
+// loadEvents loads the event log for a date, skipping a half-written last line
+func (ds *DataSender) loadEvents(date string) ([]TimelineEvent, error) {
+	path := filepath.Join(ds.dataDir, fmt.Sprintf("events_%s.jsonl", date))
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []TimelineEvent{}, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []TimelineEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event TimelineEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			log.Printf("Skipping malformed event in %s: %v", path, err)
+			continue
+		}
+		events = append(events, event)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events, scanner.Err()
+}
+
+// focusSpans turns focus events into spans. A span ends at the next focus change,
+// when its app quits, when the agent stops or restarts or the device suspends, or at until for
+// the span still open.
+func focusSpans(events []TimelineEvent, until time.Time) []FocusSpan {
+	var spans []FocusSpan
+	var current *FocusSpan
+
+	closeCurrent := func(at time.Time) {
+		if current != nil && at.After(current.Start) {
+			current.End = at
+			spans = append(spans, *current)
+		}
+		current = nil
+	}
+
+	for _, event := range events {
+		if event.Time.After(until) {
+			break
+		}
+		switch event.Type {
+		case EventFocusChange:
+			// A focus change carried over midnight continues the same session
+			if event.Carried && current != nil && current.App == event.App {
+				continue
+			}
+			closeCurrent(event.Time)
+			if event.App != "" {
+				current = &FocusSpan{App: event.App, Start: event.Time}
+			}
+		case EventAppQuit:
+			if current != nil && current.App == event.App {
+				closeCurrent(event.Time)
+			}
+		case EventAgentStart, EventAgentStop, EventSuspend:
+			closeCurrent(event.Time)
+		}
+	}
+	closeCurrent(until)
+
+	return spans
+}
+
+// focusInInterval clips focus spans to [startTime, endTime) and returns the focus time
+// and number of focus sessions per app
+func focusInInterval(spans []FocusSpan, startTime, endTime time.Time) (map[string]time.Duration, map[string]int) {
+	focus := make(map[string]time.Duration)
+	sessions := make(map[string]int)
+
+	for _, span := range spans {
+		start, end := span.Start, span.End
+		if start.Before(startTime) {
+			start = startTime
+		}
+		if end.After(endTime) {
+			end = endTime
+		}
+		if !end.After(start) {
+			continue
+		}
+		focus[span.App] += end.Sub(start)
+		sessions[span.App]++
+	}
+
+	return focus, sessions
+}
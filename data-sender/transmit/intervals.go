@@ -0,0 +1,103 @@
+package transmit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// maxCatchUp is how far back the sender catches up on intervals it missed; older
+// intervals are left to an explicit backfill
+const maxCatchUp = 7 * 24 * time.Hour
+
+// Interval is a transmission window [Start, End)
+type Interval struct {
+	Start time.Time
+	End   time.Time
+}
+
+// TransmissionState records how far the sender has processed intervals
+type TransmissionState struct {
+	LastIntervalEnd time.Time `json:"last_interval_end"`
+}
+
+// intervalLength returns the configured interval as a duration
+func (ds *DataSender) intervalLength() time.Duration {
+	return time.Duration(ds.intervalMinutes) * time.Minute
+}
+
+// UseIntervalMinutes sets the interval length for this process, e.g. from the agent's
+// configuration, without writing it to the .env file like SetTransmissionInterval
+func (ds *DataSender) UseIntervalMinutes(minutes int) {
+	if minutes > 0 {
+		ds.intervalMinutes = minutes
+	}
+}
+
+// alignedInterval returns the interval containing t. Intervals start at local midnight
+// and every interval length after it, so the same length always gives the same
+// boundaries (:00, :10, :20, ...). The last interval of a day ends at the next midnight,
+// which also keeps 23 and 25 hour days around DST changes aligned.
+func alignedInterval(t time.Time, length time.Duration) Interval {
+	year, month, day := t.In(time.Local).Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+	nextMidnight := time.Date(year, month, day+1, 0, 0, 0, 0, time.Local)
+
+	start := midnight.Add(t.Sub(midnight) / length * length)
+	end := start.Add(length)
+	if end.After(nextMidnight) {
+		end = nextMidnight
+	}
+	return Interval{Start: start, End: end}
+}
+
+// closedIntervals returns the intervals after since that have ended by now. The first
+// interval starts exactly at since, so consecutive runs never overlap or leave a gap
+// even when the interval length was changed in between.
+func closedIntervals(since, now time.Time, length time.Duration) []Interval {
+	var intervals []Interval
+	for start := since; ; {
+		interval := alignedInterval(start, length)
+		interval.Start = start
+		if interval.End.After(now) {
+			break
+		}
+		intervals = append(intervals, interval)
+		start = interval.End
+	}
+	return intervals
+}
+
+// loadTransmissionState loads the processing state, starting fresh if it is missing or unreadable
+func (ds *DataSender) loadTransmissionState() TransmissionState {
+	var state TransmissionState
+
+	data, err := ioutil.ReadFile(ds.statePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading transmission state: %v", err)
+		}
+		return state
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Printf("Error parsing transmission state: %v", err)
+		return TransmissionState{}
+	}
+	return state
+}
+
+// saveTransmissionState saves the processing state atomically
+func (ds *DataSender) saveTransmissionState(state TransmissionState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := ds.statePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, ds.statePath)
+}
@@ -0,0 +1,135 @@
+package transmit
+
+import (
+	"testing"
+	"time"
+)
+
+// useLocalTime makes name the local time zone for the rest of the test
+func useLocalTime(t *testing.T, name string) *time.Location {
+	t.Helper()
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s is not available: %v", name, err)
+	}
+	local := time.Local
+	time.Local = location
+	t.Cleanup(func() { time.Local = local })
+	return location
+}
+
+func TestAlignedInterval(t *testing.T) {
+	newYork := useLocalTime(t, "America/New_York")
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, newYork)
+	}
+	edt, est := time.FixedZone("EDT", -4*3600), time.FixedZone("EST", -5*3600)
+
+	tests := []struct {
+		name       string
+		t          time.Time
+		length     time.Duration
+		start, end time.Time
+	}{
+		{"within an interval", at(2026, 10, 16, 9, 17), 10 * time.Minute, at(2026, 10, 16, 9, 10), at(2026, 10, 16, 9, 20)},
+		{"exactly at a boundary", at(2026, 10, 16, 9, 20), 10 * time.Minute, at(2026, 10, 16, 9, 20), at(2026, 10, 16, 9, 30)},
+		{"just before a boundary", at(2026, 10, 16, 9, 20).Add(-time.Nanosecond), 10 * time.Minute, at(2026, 10, 16, 9, 10), at(2026, 10, 16, 9, 20)},
+		{"at midnight", at(2026, 10, 16, 0, 0), 10 * time.Minute, at(2026, 10, 16, 0, 0), at(2026, 10, 16, 0, 10)},
+		{"last interval cut at midnight", at(2026, 10, 16, 23, 58), 7 * time.Minute, at(2026, 10, 16, 23, 55), at(2026, 10, 17, 0, 0)},
+		{"in another time zone", time.Date(2026, 10, 16, 13, 17, 0, 0, time.UTC), 10 * time.Minute, at(2026, 10, 16, 9, 10), at(2026, 10, 16, 9, 20)},
+
+		// 8 March 2026 has 23 hours: 02:00 EST is 03:00 EDT
+		{"before the spring gap", at(2026, 3, 8, 1, 30), time.Hour, at(2026, 3, 8, 1, 0), time.Date(2026, 3, 8, 3, 0, 0, 0, edt)},
+		{"after the spring gap", time.Date(2026, 3, 8, 3, 30, 0, 0, edt), time.Hour, time.Date(2026, 3, 8, 3, 0, 0, 0, edt), time.Date(2026, 3, 8, 4, 0, 0, 0, edt)},
+		{"last interval of a 23 hour day", at(2026, 3, 8, 23, 30), 7 * time.Hour, at(2026, 3, 8, 22, 0), at(2026, 3, 9, 0, 0)},
+
+		// 1 November 2026 has 25 hours: 01:00 to 02:00 comes twice
+		{"first 01:30", time.Date(2026, 11, 1, 1, 30, 0, 0, edt), time.Hour, time.Date(2026, 11, 1, 1, 0, 0, 0, edt), time.Date(2026, 11, 1, 1, 0, 0, 0, est)},
+		{"second 01:30", time.Date(2026, 11, 1, 1, 30, 0, 0, est), time.Hour, time.Date(2026, 11, 1, 1, 0, 0, 0, est), time.Date(2026, 11, 1, 2, 0, 0, 0, est)},
+		{"last interval of a 25 hour day", at(2026, 11, 1, 23, 30), time.Hour, at(2026, 11, 1, 23, 0), at(2026, 11, 2, 0, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := alignedInterval(tt.t, tt.length)
+			if !got.Start.Equal(tt.start) || !got.End.Equal(tt.end) {
+				t.Errorf("alignedInterval(%v, %v) = [%v, %v), want [%v, %v)", tt.t, tt.length, got.Start, got.End, tt.start, tt.end)
+			}
+		})
+	}
+}
+
+func TestClosedIntervals(t *testing.T) {
+	newYork := useLocalTime(t, "America/New_York")
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, newYork)
+	}
+	est := time.FixedZone("EST", -5*3600)
+
+	tests := []struct {
+		name      string
+		since     time.Time
+		now       time.Time
+		length    time.Duration
+		intervals []Interval
+	}{
+		{"open interval left out", at(10, 16, 9, 0), at(10, 16, 9, 25), 10 * time.Minute, []Interval{
+			{at(10, 16, 9, 0), at(10, 16, 9, 10)},
+			{at(10, 16, 9, 10), at(10, 16, 9, 20)},
+		}},
+		{"interval ending exactly now", at(10, 16, 9, 0), at(10, 16, 9, 20), 10 * time.Minute, []Interval{
+			{at(10, 16, 9, 0), at(10, 16, 9, 10)},
+			{at(10, 16, 9, 10), at(10, 16, 9, 20)},
+		}},
+		{"nothing closed yet", at(10, 16, 9, 0), at(10, 16, 9, 10).Add(-time.Nanosecond), 10 * time.Minute, nil},
+		{"first interval starts at since", at(10, 16, 9, 5), at(10, 16, 9, 20), 10 * time.Minute, []Interval{
+			{at(10, 16, 9, 5), at(10, 16, 9, 10)},
+			{at(10, 16, 9, 10), at(10, 16, 9, 20)},
+		}},
+		{"across midnight", at(10, 16, 23, 40), at(10, 17, 0, 30), 25 * time.Minute, []Interval{
+			{at(10, 16, 23, 40), at(10, 16, 23, 45)},
+			{at(10, 16, 23, 45), at(10, 17, 0, 0)},
+			{at(10, 17, 0, 0), at(10, 17, 0, 25)},
+		}},
+		{"across the spring gap", at(3, 8, 0, 0), at(3, 8, 4, 0), time.Hour, []Interval{
+			{at(3, 8, 0, 0), at(3, 8, 1, 0)},
+			{at(3, 8, 1, 0), at(3, 8, 3, 0)},
+			{at(3, 8, 3, 0), at(3, 8, 4, 0)},
+		}},
+		{"across the repeated hour", at(11, 1, 0, 0), time.Date(2026, 11, 1, 3, 0, 0, 0, est), time.Hour, []Interval{
+			{at(11, 1, 0, 0), at(11, 1, 1, 0)},
+			{at(11, 1, 1, 0), time.Date(2026, 11, 1, 1, 0, 0, 0, est)},
+			{time.Date(2026, 11, 1, 1, 0, 0, 0, est), time.Date(2026, 11, 1, 2, 0, 0, 0, est)},
+			{time.Date(2026, 11, 1, 2, 0, 0, 0, est), time.Date(2026, 11, 1, 3, 0, 0, 0, est)},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := closedIntervals(tt.since, tt.now, tt.length)
+			if len(got) != len(tt.intervals) {
+				t.Fatalf("closedIntervals() = %d intervals %v, want %d", len(got), got, len(tt.intervals))
+			}
+			for i, interval := range got {
+				if !interval.Start.Equal(tt.intervals[i].Start) || !interval.End.Equal(tt.intervals[i].End) {
+					t.Errorf("interval %d = [%v, %v), want [%v, %v)", i, interval.Start, interval.End, tt.intervals[i].Start, tt.intervals[i].End)
+				}
+			}
+		})
+	}
+}
+
+func TestTransmissionState(t *testing.T) {
+	ds := newTestSender(t)
+	if state := ds.loadTransmissionState(); !state.LastIntervalEnd.IsZero() {
+		t.Errorf("missing state = %+v, want zero", state)
+	}
+
+	end := time.Date(2026, 10, 16, 9, 20, 0, 0, time.UTC)
+	if err := ds.saveTransmissionState(TransmissionState{LastIntervalEnd: end}); err != nil {
+		t.Fatal(err)
+	}
+	if state := ds.loadTransmissionState(); !state.LastIntervalEnd.Equal(end) {
+		t.Errorf("loaded state = %+v, want the interval ending %v", state, end)
+	}
+}
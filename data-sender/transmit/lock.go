@@ -0,0 +1,47 @@
+package transmit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrBusy is returned when another process kept the transmission lock for longer than
+// transmissionLockWait, e.g. a long backfill while the agent is sending
+var ErrBusy = errors.New("another process is transmitting; try again later")
+
+// transmissionLockWait is how long a run waits for another process to finish transmitting
+const transmissionLockWait = time.Minute
+
+// transmissionLockPoll is how often a waiting run tries the lock again
+const transmissionLockPoll = 100 * time.Millisecond
+
+// lockPath returns ~/.roiagent/transmission/lock
+func (ds *DataSender) lockPath() string {
+	return filepath.Join(ds.transmissionDir, "lock")
+}
+
+// lockTransmission takes the lock that serializes the agent and the data-sender command
+// on the outbox, transmission_logs.json and transmission_state.json, which they all
+// read, modify and write back. The lock is held on the open file, so the kernel releases
+// it if the process dies. The returned function releases it.
+func (ds *DataSender) lockTransmission() (func(), error) {
+	if err := os.MkdirAll(ds.transmissionDir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(ds.lockPath(), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(transmissionLockWait)
+	for lockFile(file) != nil {
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, ErrBusy
+		}
+		time.Sleep(transmissionLockPoll)
+	}
+	return func() { file.Close() }, nil
+}
@@ -0,0 +1,53 @@
+package transmit
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLockTransmission(t *testing.T) {
+	ds := newTestSender(t)
+	unlock, err := ds.lockTransmission()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another process, or another DataSender, opens the file separately
+	file, err := os.Open(ds.lockPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if lockFile(file) == nil {
+		t.Fatal("the transmission lock was taken twice")
+	}
+
+	unlock()
+	if err := lockFile(file); err != nil {
+		t.Errorf("lockFile() after unlock = %v", err)
+	}
+}
+
+func TestProcessWaitsForTransmissionLock(t *testing.T) {
+	ds := newTestSender(t)
+	ds.config.BaseURL, ds.config.APIKey = "http://127.0.0.1:1", "key"
+	unlock, err := ds.lockTransmission()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const held = 300 * time.Millisecond
+	go func() {
+		time.Sleep(held)
+		unlock()
+	}()
+
+	start := time.Now()
+	if _, err := ds.ProcessCurrentInterval(); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < held {
+		t.Errorf("ProcessCurrentInterval() ran after %v while another run held the lock", waited)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package transmit
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive flock on file, failing if another process holds it
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
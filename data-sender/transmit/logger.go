@@ -1,13 +1,19 @@
-package main
+package transmit
 
 import (
 	"encoding/json"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"time"
 )
 
+// transmissionLogMaxAge is how long transmission log entries are kept. Entries for a day
+// whose day file is still kept stay longer, so gap detection and cleanup can always tell
+// which of its intervals were sent.
+const transmissionLogMaxAge = 14 * 24 * time.Hour
+
 // wasIntervalTransmitted checks if an interval was already successfully transmitted
 func (ds *DataSender) wasIntervalTransmitted(startTime, endTime time.Time) bool {
 	logs, err := ds.loadTransmissionLogs()
@@ -63,10 +69,21 @@ func (ds *DataSender) logTransmissionResult(startTime, endTime time.Time, succes
 
 	logs = append(logs, logEntry)
 
-	// Keep only last 100 log entries
-	if len(logs) > 100 {
-		logs = logs[len(logs)-100:]
+	// Keep only the entries within the retention period or with a day file
+	cutoff := time.Now().Add(-transmissionLogMaxAge)
+	dayFiles := make(map[string]bool)
+	kept := logs[:0]
+	for _, entry := range logs {
+		date := entry.StartTime.In(time.Local).Format("2006-01-02")
+		if _, checked := dayFiles[date]; !checked {
+			_, statErr := os.Stat(filepath.Join(ds.dataDir, "combined_"+date+".json"))
+			dayFiles[date] = statErr == nil
+		}
+		if entry.Timestamp.After(cutoff) || dayFiles[date] {
+			kept = append(kept, entry)
+		}
 	}
+	logs = kept
 
 	data, jsonErr := json.MarshalIndent(logs, "", "  ")
 	if jsonErr != nil {
@@ -0,0 +1,259 @@
+package transmit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Retry backoff for queued payloads: the delay doubles per failed attempt up to the
+// maximum, and the actual wait is randomized between half and all of it so devices
+// that went offline together don't retry in lockstep
+const (
+	outboxBaseBackoff = 30 * time.Second
+	outboxMaxBackoff  = time.Hour
+)
+
+// OutboxEntry is a payload waiting in the outbox until the server acknowledges it
+type OutboxEntry struct {
+	StartTime   time.Time           `json:"start_time"`
+	EndTime     time.Time           `json:"end_time"`
+	CreatedAt   time.Time           `json:"created_at"`
+	Attempts    int                 `json:"attempts"`
+	NextAttempt time.Time           `json:"next_attempt"`
+	LastError   string              `json:"last_error,omitempty"`
+	Payload     TransmissionPayload `json:"payload"`
+
+	path string
+	size int64
+}
+
+// OutboxStatus summarizes the outbox for status output
+type OutboxStatus struct {
+	Depth       int
+	Bytes       int64
+	Oldest      time.Time
+	NextAttempt time.Time
+	LastError   string
+}
+
+// outboxEntryPath returns the file that holds the payload of an interval
+func (ds *DataSender) outboxEntryPath(startTime, endTime time.Time) string {
+	return filepath.Join(ds.outboxDir, fmt.Sprintf("interval_%s_%s.json",
+		startTime.UTC().Format("20060102T150405Z"), endTime.UTC().Format("20060102T150405Z")))
+}
+
+// isQueued reports whether the payload of an interval is waiting in the outbox
+func (ds *DataSender) isQueued(startTime, endTime time.Time) bool {
+	_, err := os.Stat(ds.outboxEntryPath(startTime, endTime))
+	return err == nil
+}
+
+// enqueue stores a payload in the outbox; it is removed only once the server accepted it
+func (ds *DataSender) enqueue(payload TransmissionPayload, startTime, endTime time.Time) error {
+	if err := os.MkdirAll(ds.outboxDir, 0755); err != nil {
+		return fmt.Errorf("error creating outbox: %v", err)
+	}
+
+	now := time.Now()
+	entry := &OutboxEntry{
+		StartTime:   startTime,
+		EndTime:     endTime,
+		CreatedAt:   now,
+		NextAttempt: now,
+		Payload:     payload,
+		path:        ds.outboxEntryPath(startTime, endTime),
+	}
+	return ds.saveOutboxEntry(entry)
+}
+
+// saveOutboxEntry writes an entry atomically so a crash never leaves a half-written payload
+func (ds *DataSender) saveOutboxEntry(entry *OutboxEntry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling outbox entry: %v", err)
+	}
+
+	tmpPath := entry.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("error writing outbox entry: %v", err)
+	}
+	if err := os.Rename(tmpPath, entry.path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error writing outbox entry: %v", err)
+	}
+	entry.size = int64(len(data))
+	return nil
+}
+
+// loadOutbox loads the queued entries, oldest interval first
+func (ds *DataSender) loadOutbox() ([]*OutboxEntry, error) {
+	files, err := ioutil.ReadDir(ds.outboxDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []*OutboxEntry
+	for _, file := range files {
+		if file.IsDir() || !strings.HasPrefix(file.Name(), "interval_") || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		path := filepath.Join(ds.outboxDir, file.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Printf("Error reading outbox entry %s: %v", path, err)
+			continue
+		}
+
+		entry := &OutboxEntry{}
+		if err := json.Unmarshal(data, entry); err != nil {
+			log.Printf("Removing unreadable outbox entry %s: %v", path, err)
+			os.Remove(path)
+			continue
+		}
+		entry.path = path
+		entry.size = file.Size()
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].StartTime.Before(entries[j].StartTime)
+	})
+	return entries, nil
+}
+
+// pruneOutbox drops entries queued for longer than the maximum age and, while the outbox is over its
+// disk quota, the oldest remaining ones. Dropped intervals are logged as failed.
+func (ds *DataSender) pruneOutbox(entries []*OutboxEntry, now time.Time) ([]*OutboxEntry, int) {
+	dropped := 0
+	var kept []*OutboxEntry
+	var totalBytes int64
+	for _, entry := range entries {
+		if now.Sub(entry.CreatedAt) > ds.outboxMaxAge {
+			ds.dropOutboxEntry(entry, fmt.Errorf("dropped from outbox after %v", ds.outboxMaxAge))
+			dropped++
+			continue
+		}
+		kept = append(kept, entry)
+		totalBytes += entry.size
+	}
+
+	for len(kept) > 0 && totalBytes > ds.outboxMaxBytes {
+		entry := kept[0]
+		kept = kept[1:]
+		totalBytes -= entry.size
+		ds.dropOutboxEntry(entry, fmt.Errorf("dropped from outbox over the %d byte quota", ds.outboxMaxBytes))
+		dropped++
+	}
+
+	return kept, dropped
+}
+
+// dropOutboxEntry removes an entry that will never be sent and records why
+func (ds *DataSender) dropOutboxEntry(entry *OutboxEntry, reason error) {
+	log.Printf("Dropping queued interval %s-%s: %v",
+		entry.StartTime.Format("2006-01-02 15:04"), entry.EndTime.Format("15:04"), reason)
+	if err := os.Remove(entry.path); err != nil {
+		log.Printf("Error removing outbox entry %s: %v", entry.path, err)
+	}
+	ds.logTransmissionResult(entry.StartTime, entry.EndTime, false, reason, entry.Attempts, 0)
+}
+
+// flushOutbox sends every entry that is due, oldest first. It stops at the first failed
+// send, since the server is most likely unreachable for the rest as well; that entry is
+// rescheduled with backoff and reported in the result, so only errors reading the
+// outbox are returned.
+func (ds *DataSender) flushOutbox() (FlushResult, error) {
+	var result FlushResult
+
+	entries, err := ds.loadOutbox()
+	if err != nil {
+		return result, fmt.Errorf("error reading outbox: %v", err)
+	}
+
+	now := time.Now()
+	entries, result.Dropped = ds.pruneOutbox(entries, now)
+	result.Pending = len(entries)
+	for _, entry := range entries {
+		if entry.NextAttempt.After(now) || result.SendErr != nil {
+			continue
+		}
+
+		err := ds.sendData(entry.Payload)
+		entry.Attempts++
+		payloadSize := len(entry.Payload.Apps) + len(entry.Payload.AppUsage) + len(entry.Payload.Networks)
+
+		if err == nil {
+			log.Printf("Successfully transmitted interval %s-%s (attempt %d)",
+				entry.StartTime.Format("15:04"), entry.EndTime.Format("15:04"), entry.Attempts)
+			if err := os.Remove(entry.path); err != nil {
+				log.Printf("Error removing outbox entry %s: %v", entry.path, err)
+			}
+			ds.logTransmissionResult(entry.StartTime, entry.EndTime, true, nil, entry.Attempts-1, payloadSize)
+			result.Sent++
+			result.Pending--
+			continue
+		}
+
+		entry.LastError = err.Error()
+		entry.NextAttempt = now.Add(outboxBackoff(entry.Attempts))
+		log.Printf("Transmission of interval %s-%s failed (attempt %d), next attempt at %s: %v",
+			entry.StartTime.Format("15:04"), entry.EndTime.Format("15:04"), entry.Attempts,
+			entry.NextAttempt.Format("15:04:05"), err)
+		if saveErr := ds.saveOutboxEntry(entry); saveErr != nil {
+			log.Printf("Error updating outbox entry: %v", saveErr)
+		}
+		ds.logTransmissionResult(entry.StartTime, entry.EndTime, false, err, entry.Attempts-1, payloadSize)
+		result.SendErr = err
+	}
+
+	return result, nil
+}
+
+// outboxBackoff returns the delay before the next attempt after the given number of
+// failed attempts: exponential up to outboxMaxBackoff, with up to half of it as jitter
+func outboxBackoff(attempts int) time.Duration {
+	delay := outboxMaxBackoff
+	if attempts < 20 {
+		if d := outboxBaseBackoff << uint(attempts-1); d < outboxMaxBackoff {
+			delay = d
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// outboxStatus returns the queue depth and the state of the oldest and next entries
+func (ds *DataSender) outboxStatus() (OutboxStatus, error) {
+	var status OutboxStatus
+
+	entries, err := ds.loadOutbox()
+	if err != nil {
+		return status, err
+	}
+
+	for _, entry := range entries {
+		status.Depth++
+		status.Bytes += entry.size
+		if status.Oldest.IsZero() || entry.StartTime.Before(status.Oldest) {
+			status.Oldest = entry.StartTime
+		}
+		if status.NextAttempt.IsZero() || entry.NextAttempt.Before(status.NextAttempt) {
+			status.NextAttempt = entry.NextAttempt
+		}
+		if entry.LastError != "" {
+			status.LastError = entry.LastError
+		}
+	}
+	return status, nil
+}
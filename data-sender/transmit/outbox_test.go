@@ -0,0 +1,276 @@
+package transmit
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestSender returns a DataSender whose files all live in a temporary directory,
+// without reading the user's settings
+func newTestSender(t *testing.T) *DataSender {
+	t.Helper()
+	dir := t.TempDir()
+	return &DataSender{
+		dataDir:           filepath.Join(dir, "data"),
+		transmissionDir:   filepath.Join(dir, "transmission"),
+		configPath:        filepath.Join(dir, "transmission_config.json"),
+		logPath:           filepath.Join(dir, "transmission_logs.json"),
+		statePath:         filepath.Join(dir, "transmission_state.json"),
+		devicePath:        filepath.Join(dir, "device.json"),
+		credentialPath:    filepath.Join(dir, "credentials.json"),
+		outboxDir:         filepath.Join(dir, "transmission", "outbox"),
+		outboxMaxAge:      72 * time.Hour,
+		outboxMaxBytes:    50 * 1024 * 1024,
+		intervalMinutes:   10,
+		defaultInterval:   10,
+		dataRetentionDays: 30,
+		config:            Config{DeviceID: "device-1", Enabled: true},
+	}
+}
+
+// queueEntries enqueues one payload per interval start and returns them as loaded
+func queueEntries(t *testing.T, ds *DataSender, starts ...time.Time) []*OutboxEntry {
+	t.Helper()
+	for _, start := range starts {
+		payload := TransmissionPayload{DeviceID: ds.config.DeviceID, StartTime: start.Format(time.RFC3339)}
+		if err := ds.enqueue(payload, start, start.Add(10*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := ds.loadOutbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		delay    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{4, 4 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour}, // 64 minutes, capped
+		{19, time.Hour},
+		{20, time.Hour},
+		{1000, time.Hour},
+	}
+
+	for _, tt := range tests {
+		// The jitter keeps every wait between half and all of the delay
+		for i := 0; i < 200; i++ {
+			if got := outboxBackoff(tt.attempts); got < tt.delay/2 || got > tt.delay {
+				t.Fatalf("outboxBackoff(%d) = %v, want between %v and %v", tt.attempts, got, tt.delay/2, tt.delay)
+			}
+		}
+	}
+
+	// Devices that failed together do not all retry at the same moment
+	waits := make(map[time.Duration]bool)
+	for i := 0; i < 20; i++ {
+		waits[outboxBackoff(5)] = true
+	}
+	if len(waits) < 2 {
+		t.Errorf("outboxBackoff(5) gave the same wait 20 times")
+	}
+}
+
+func TestLoadOutboxOrder(t *testing.T) {
+	ds := newTestSender(t)
+	base := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	entries := queueEntries(t, ds, base.Add(20*time.Minute), base, base.Add(10*time.Minute))
+
+	if len(entries) != 3 {
+		t.Fatalf("loadOutbox() = %d entries, want 3", len(entries))
+	}
+	for i, entry := range entries {
+		if want := base.Add(time.Duration(i) * 10 * time.Minute); !entry.StartTime.Equal(want) {
+			t.Errorf("entry %d starts at %v, want %v", i, entry.StartTime, want)
+		}
+		if entry.size == 0 || entry.path != ds.outboxEntryPath(entry.StartTime, entry.EndTime) {
+			t.Errorf("entry %d: size %d, path %s", i, entry.size, entry.path)
+		}
+	}
+	if !ds.isQueued(base, base.Add(10*time.Minute)) || ds.isQueued(base, base.Add(5*time.Minute)) {
+		t.Error("isQueued() does not match the queued intervals")
+	}
+}
+
+func TestLoadOutboxPartialEntries(t *testing.T) {
+	ds := newTestSender(t)
+	start := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	queueEntries(t, ds, start)
+
+	// A crash can leave a temporary file behind, or a truncated entry written by an
+	// older version that wrote in place
+	tmpPath := ds.outboxEntryPath(start.Add(10*time.Minute), start.Add(20*time.Minute)) + ".tmp"
+	truncatedPath := ds.outboxEntryPath(start.Add(20*time.Minute), start.Add(30*time.Minute))
+	otherPath := filepath.Join(ds.outboxDir, "notes.txt")
+	for _, path := range []string{tmpPath, truncatedPath, otherPath} {
+		if err := ioutil.WriteFile(path, []byte(`{"start_time": "2026-10-16T09:`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := ds.loadOutbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !entries[0].StartTime.Equal(start) {
+		t.Fatalf("loadOutbox() = %d entries, want only the complete one", len(entries))
+	}
+	if _, err := os.Stat(truncatedPath); !os.IsNotExist(err) {
+		t.Error("the truncated entry was not removed")
+	}
+	if _, err := os.Stat(otherPath); err != nil {
+		t.Error("a file that is not an entry was removed")
+	}
+}
+
+func TestLoadOutboxMissing(t *testing.T) {
+	entries, err := newTestSender(t).loadOutbox()
+	if err != nil || len(entries) != 0 {
+		t.Errorf("loadOutbox() of a missing outbox = %v, %v; want nothing", entries, err)
+	}
+}
+
+func TestPruneOutboxMaxAge(t *testing.T) {
+	ds := newTestSender(t)
+	now := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	entries := queueEntries(t, ds, now.Add(-80*time.Hour), now.Add(-10*time.Hour), now.Add(-time.Hour))
+	entries[0].CreatedAt = now.Add(-ds.outboxMaxAge - time.Minute)
+	entries[1].CreatedAt = now.Add(-ds.outboxMaxAge) // exactly at the limit, kept
+	entries[2].CreatedAt = now.Add(-time.Hour)
+
+	kept, dropped := ds.pruneOutbox(entries, now)
+	if dropped != 1 || len(kept) != 2 || kept[0] != entries[1] || kept[1] != entries[2] {
+		t.Fatalf("pruneOutbox() kept %d and dropped %d, want the two within the maximum age", len(kept), dropped)
+	}
+	if _, err := os.Stat(entries[0].path); !os.IsNotExist(err) {
+		t.Error("the expired entry is still in the outbox")
+	}
+
+	logs, err := ds.loadTransmissionLogs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].Success || !logs[0].StartTime.Equal(entries[0].StartTime) {
+		t.Errorf("transmission logs = %+v, want the expired interval as failed", logs)
+	}
+}
+
+func TestPruneOutboxQuota(t *testing.T) {
+	ds := newTestSender(t)
+	now := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	entries := queueEntries(t, ds, now.Add(-40*time.Minute), now.Add(-30*time.Minute), now.Add(-20*time.Minute), now.Add(-10*time.Minute))
+	for _, entry := range entries {
+		entry.CreatedAt = now
+		entry.size = 100
+	}
+
+	// Over the quota, the oldest intervals go first until the rest fits
+	ds.outboxMaxBytes = 250
+	kept, dropped := ds.pruneOutbox(entries, now)
+	if dropped != 2 || len(kept) != 2 || kept[0] != entries[2] || kept[1] != entries[3] {
+		t.Fatalf("pruneOutbox() kept %d and dropped %d, want the two newest", len(kept), dropped)
+	}
+	for i, entry := range entries {
+		_, err := os.Stat(entry.path)
+		if queued := err == nil; queued != (i >= 2) {
+			t.Errorf("entry %d queued = %v", i, queued)
+		}
+	}
+
+	// Exactly at the quota nothing is dropped
+	ds.outboxMaxBytes = 200
+	if kept, dropped := ds.pruneOutbox(kept, now); dropped != 0 || len(kept) != 2 {
+		t.Errorf("pruneOutbox() at the quota dropped %d", dropped)
+	}
+}
+
+func TestFlushOutbox(t *testing.T) {
+	var requests int
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if failing {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	ds := newTestSender(t)
+	ds.config.BaseURL, ds.config.APIKey = server.URL, "key"
+	base := time.Now().Add(-time.Hour).Truncate(10 * time.Minute)
+	queueEntries(t, ds, base, base.Add(10*time.Minute))
+
+	// The first failure stops the flush and reschedules that entry with backoff
+	before := time.Now()
+	result, err := ds.flushOutbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 || result.Sent != 0 || result.Pending != 2 || result.SendErr == nil {
+		t.Fatalf("flushOutbox() = %+v after %d requests, want one failed send", result, requests)
+	}
+	entries, _ := ds.loadOutbox()
+	first := entries[0]
+	if first.Attempts != 1 || first.LastError == "" {
+		t.Errorf("failed entry: attempts %d, error %q", first.Attempts, first.LastError)
+	}
+	if wait := first.NextAttempt.Sub(before); wait < outboxBaseBackoff/2 || wait > outboxBaseBackoff+time.Minute {
+		t.Errorf("failed entry retried after %v, want about %v", wait, outboxBaseBackoff)
+	}
+	if entries[1].Attempts != 0 {
+		t.Error("the flush went on after a failed send")
+	}
+
+	// The rescheduled entry waits; the other one is due and is sent
+	failing = false
+	result, err = ds.flushOutbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 || result.Sent != 1 || result.Pending != 1 || result.SendErr != nil {
+		t.Fatalf("flushOutbox() = %+v after %d requests, want only the due entry sent", result, requests)
+	}
+	if ds.isQueued(entries[1].StartTime, entries[1].EndTime) || !ds.isQueued(first.StartTime, first.EndTime) {
+		t.Error("the sent entry is still queued, or the waiting one is gone")
+	}
+	if !ds.wasIntervalTransmitted(entries[1].StartTime, entries[1].EndTime) {
+		t.Error("the sent interval was not logged as transmitted")
+	}
+}
+
+func TestOutboxStatus(t *testing.T) {
+	ds := newTestSender(t)
+	base := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	entries := queueEntries(t, ds, base.Add(10*time.Minute), base)
+	entries[0].NextAttempt = base.Add(time.Hour)
+	entries[0].LastError = "server returned status 503"
+	if err := ds.saveOutboxEntry(entries[0]); err != nil {
+		t.Fatal(err)
+	}
+	entries[1].NextAttempt = base.Add(30 * time.Minute)
+	if err := ds.saveOutboxEntry(entries[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := ds.outboxStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Depth != 2 || status.Bytes == 0 || !status.Oldest.Equal(base) ||
+		!status.NextAttempt.Equal(base.Add(30*time.Minute)) || status.LastError != "server returned status 503" {
+		t.Errorf("outboxStatus() = %+v", status)
+	}
+}
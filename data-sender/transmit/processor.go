@@ -0,0 +1,547 @@
+// Package transmit turns the agent's day files into per-interval payloads, queues them
+// in a durable outbox and sends them to the server. The agent calls it in-process and
+// the data-sender command is a thin wrapper around it.
+package transmit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"roi-agent-schema"
+)
+
+// DataSender handles the transmission of monitoring data
+type DataSender struct {
+	config            Config
+	dataDir           string
+	transmissionDir   string
+	configPath        string
+	logPath           string
+	statePath         string
+	devicePath        string
+	credentialPath    string
+	credential        *Credential
+	values            map[string]string // effective setting values by environment variable
+	origins           map[string]string // where each value came from
+	outboxDir         string
+	outboxMaxAge      time.Duration
+	outboxMaxBytes    int64
+	intervalMinutes   int
+	dataRetentionDays int
+	defaultInterval   int
+}
+
+// NewDataSender creates a new data sender instance
+func NewDataSender() *DataSender {
+	homeDir, _ := os.UserHomeDir()
+	userDataDir := filepath.Join(homeDir, ".roiagent")
+	dataDir := filepath.Join(userDataDir, "data")
+	transmissionDir := filepath.Join(userDataDir, "transmission")
+	configPath := filepath.Join(userDataDir, "transmission_config.json")
+	logPath := filepath.Join(userDataDir, "transmission_logs.json")
+	statePath := filepath.Join(userDataDir, "transmission_state.json")
+
+	// Create directories
+	os.MkdirAll(transmissionDir, 0755)
+
+	sender := &DataSender{
+		dataDir:         dataDir,
+		transmissionDir: transmissionDir,
+		configPath:      configPath,
+		logPath:         logPath,
+		statePath:       statePath,
+		devicePath:      filepath.Join(userDataDir, "device.json"),
+		credentialPath:  filepath.Join(userDataDir, "credentials.json"),
+		outboxDir:       filepath.Join(transmissionDir, "outbox"),
+		defaultInterval: 10,
+	}
+
+	sender.loadConfig()
+	return sender
+}
+
+// ProcessCurrentInterval queues every aligned interval that has closed since the last one
+// processed and sends the outbox, stopping at the first interval that cannot be built
+func (ds *DataSender) ProcessCurrentInterval() (*RunResult, error) {
+	if !ds.config.Enabled {
+		return nil, ErrDisabled
+	}
+
+	unlock, err := ds.lockTransmission()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	now := time.Now()
+	length := ds.intervalLength()
+	state := ds.loadTransmissionState()
+	result := &RunResult{}
+
+	// Without state (or after a long absence) start with the interval that just closed
+	since := state.LastIntervalEnd
+	if since.IsZero() || now.Sub(since) > maxCatchUp {
+		current := alignedInterval(now, length)
+		since = alignedInterval(current.Start.Add(-time.Nanosecond), length).Start
+		if !state.LastIntervalEnd.IsZero() {
+			log.Printf("Last processed interval ended %s, skipping to %s",
+				state.LastIntervalEnd.Format("2006-01-02 15:04"), since.Format("2006-01-02 15:04"))
+		}
+	}
+
+	intervals := closedIntervals(since, now, length)
+	if len(intervals) == 0 {
+		log.Printf("No closed interval since %s", since.Format("2006-01-02 15:04"))
+	}
+
+	for _, interval := range intervals {
+		log.Printf("Processing interval: %s to %s",
+			interval.Start.Format("2006-01-02 15:04:05"), interval.End.Format("15:04:05"))
+
+		queued, err := ds.queueInterval(interval.Start, interval.End)
+		switch {
+		case err == ErrNoIntervalData:
+			log.Printf("No data for interval %s-%s, nothing to send",
+				interval.Start.Format("15:04"), interval.End.Format("15:04"))
+			result.Empty = append(result.Empty, interval)
+		case err != nil:
+			return result, err
+		case queued:
+			result.Queued = append(result.Queued, interval)
+		}
+
+		state.LastIntervalEnd = interval.End
+		if err := ds.saveTransmissionState(state); err != nil {
+			log.Printf("Error saving transmission state: %v", err)
+		}
+	}
+
+	flush, err := ds.flushOutbox()
+	result.Flush = flush
+	return result, err
+}
+
+// ProcessDataInterval creates the payload for a specific time interval, queues it in the
+// outbox and sends whatever is due. A payload that could not be sent stays queued and is
+// retried with backoff by later runs, so only errors building the payload are returned.
+func (ds *DataSender) ProcessDataInterval(startTime, endTime time.Time) error {
+	if !ds.config.Enabled {
+		return ErrDisabled
+	}
+
+	unlock, err := ds.lockTransmission()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := ds.queueInterval(startTime, endTime); err != nil {
+		return err
+	}
+
+	_, err = ds.flushOutbox()
+	return err
+}
+
+// queueInterval creates the payload for an interval and queues it in the outbox. It
+// reports false for an interval that was already transmitted or is already queued.
+func (ds *DataSender) queueInterval(startTime, endTime time.Time) (bool, error) {
+	// Check if this interval was already transmitted or is waiting to be
+	if ds.wasIntervalTransmitted(startTime, endTime) || ds.isQueued(startTime, endTime) {
+		log.Printf("Interval %s-%s already transmitted or queued, skipping", 
+			startTime.Format("15:04"), endTime.Format("15:04"))
+		return false, nil
+	}
+
+	// Load data for the specific interval
+	data, err := ds.loadDataForInterval(startTime, endTime)
+	if err == ErrNoIntervalData {
+		return false, err
+	}
+	if err != nil {
+		log.Printf("Error loading data for interval: %v", err)
+		ds.logTransmissionResult(startTime, endTime, false, err, 0, 0)
+		return false, &IntervalError{Interval: Interval{Start: startTime, End: endTime}, Err: err}
+	}
+
+	// Create transmission payload
+	payload := ds.createIntervalTransmissionPayload(data, startTime, endTime)
+
+	// Save transmission data locally
+	if err := ds.saveTransmissionData(payload, startTime); err != nil {
+		log.Printf("Error saving transmission data: %v", err)
+	}
+
+	if err := ds.enqueue(payload, startTime, endTime); err != nil {
+		ds.logTransmissionResult(startTime, endTime, false, err, 0, 0)
+		return false, &IntervalError{Interval: Interval{Start: startTime, End: endTime}, Err: err}
+	}
+
+	return true, nil
+}
+
+// loadDataForInterval loads and filters data for a specific time interval. An interval
+// that crosses local midnight reads the day file of every date it touches.
+func (ds *DataSender) loadDataForInterval(startTime, endTime time.Time) (*CombinedData, error) {
+	var filteredData *CombinedData
+	var events []TimelineEvent
+
+	for _, date := range intervalDates(startTime, endTime) {
+		dataFile := filepath.Join(ds.dataDir, fmt.Sprintf("combined_%s.json", date))
+
+		data, err := ioutil.ReadFile(dataFile)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			log.Printf("Error reading data file %s: %v", dataFile, err)
+			return nil, err
+		}
+
+		// Day files written by older agents are upgraded to the current schema
+		combinedData, _, err := schema.Decode(data)
+		if err != nil {
+			log.Printf("Error reading data file %s: %v", dataFile, err)
+			return nil, err
+		}
+
+		samples, err := ds.loadSamples(date)
+		if err != nil {
+			log.Printf("Error reading sample log for %s: %v", date, err)
+			return nil, err
+		}
+
+		// Filter data for the specific interval
+		dayData := ds.filterDataForInterval(combinedData, samples, startTime, endTime)
+		if filteredData == nil {
+			filteredData = dayData
+		} else {
+			mergeIntervalData(filteredData, dayData)
+		}
+
+		dayEvents, err := ds.loadEvents(date)
+		if err != nil {
+			log.Printf("Error reading event log for %s: %v", date, err)
+			continue
+		}
+		events = append(events, dayEvents...)
+	}
+
+	if filteredData == nil {
+		return nil, ErrNoIntervalData
+	}
+
+	// Derive exact focus time within the interval from the agent's event log
+	if len(events) > 0 {
+		until := endTime
+		if updatedAt := filteredData.Running.UpdatedAt; !updatedAt.IsZero() && updatedAt.Before(until) {
+			until = updatedAt
+		}
+		_, filteredData.Sessions = focusInInterval(focusSpans(events, until), startTime, endTime)
+	}
+
+	return filteredData, nil
+}
+
+// intervalDates returns the local calendar dates whose day files cover [startTime, endTime).
+// Days are stepped with time.Date so 23 and 25 hour days around DST changes are handled.
+func intervalDates(startTime, endTime time.Time) []string {
+	var dates []string
+
+	year, month, day := startTime.In(time.Local).Date()
+	for {
+		dayStart := time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+		if len(dates) > 0 && !dayStart.Before(endTime) {
+			break
+		}
+		dates = append(dates, dayStart.Format("2006-01-02"))
+		day++
+	}
+
+	return dates
+}
+
+// mergeIntervalData merges the filtered data of a later day into the interval's data
+func mergeIntervalData(into, later *CombinedData) {
+	into.Date = later.Date
+
+	// Counters are per-interval deltas, so both days' shares are added up
+	for appName, appInfo := range later.Apps {
+		existing, exists := into.Apps[appName]
+		if !exists {
+			into.Apps[appName] = appInfo
+			continue
+		}
+		existing.FocusTime += appInfo.FocusTime
+		existing.ForegroundTime += appInfo.ForegroundTime
+		if appInfo.FirstSeen.Before(existing.FirstSeen) {
+			existing.FirstSeen = appInfo.FirstSeen
+		}
+		if appInfo.LastSeen.After(existing.LastSeen) {
+			existing.LastSeen = appInfo.LastSeen
+		}
+	}
+	for connKey, connInfo := range later.Network {
+		existing, exists := into.Network[connKey]
+		if !exists {
+			into.Network[connKey] = connInfo
+			continue
+		}
+		existing.Duration += connInfo.Duration
+		if connInfo.LastSeen.After(existing.LastSeen) {
+			existing.LastSeen = connInfo.LastSeen
+			existing.IsActive = connInfo.IsActive
+		}
+		existing.Apps = mergeNames(existing.Apps, connInfo.Apps)
+	}
+	into.Suspended = append(into.Suspended, later.Suspended...)
+
+	if later.Running.UpdatedAt.After(into.Running.UpdatedAt) {
+		into.Running = later.Running
+		into.Capture = later.Capture
+	}
+}
+
+// filterDataForInterval filters data to only include activity within the specified interval.
+// App and connection counters become the deltas credited within the interval.
+func (ds *DataSender) filterDataForInterval(data *schema.DayFile, samples []UsageSample, startTime, endTime time.Time) *CombinedData {
+	filtered := &CombinedData{
+		DayFile: schema.DayFile{
+			SchemaVersion: data.SchemaVersion,
+			Date:          data.Date,
+			Apps:          make(map[string]*AppUsage),
+			Network:       make(map[string]*NetworkConn),
+		},
+	}
+
+	// The running view and capture health only describe the interval if the agent updated them within the interval
+	if !data.Running.UpdatedAt.Before(startTime) && !data.Running.UpdatedAt.After(endTime) {
+		filtered.Running = data.Running
+		filtered.Capture = data.Capture
+	}
+
+	// Keep the apps and connections credited within the interval, with their deltas
+	applySamples(filtered, data, samples, startTime, endTime)
+
+	// Keep the parts of suspended periods that fall within the interval
+	for _, period := range data.Suspended {
+		start, end := period.Start, period.End
+		if start.Before(startTime) {
+			start = startTime
+		}
+		if end.After(endTime) {
+			end = endTime
+		}
+		if end.After(start) {
+			filtered.Suspended = append(filtered.Suspended, SuspendedPeriod{
+				Start:   start,
+				End:     end,
+				Seconds: int64(end.Sub(start) / time.Second),
+				Reason:  period.Reason,
+			})
+		}
+	}
+
+	log.Printf("Filtered data for interval %s-%s: %d apps, %d network connections",
+		startTime.Format("15:04"), endTime.Format("15:04"), 
+		len(filtered.Apps), len(filtered.Network))
+
+	return filtered
+}
+
+// createIntervalTransmissionPayload creates a payload for a specific interval
+func (ds *DataSender) createIntervalTransmissionPayload(data *CombinedData, startTime, endTime time.Time) TransmissionPayload {
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+
+	payload := TransmissionPayload{
+		DeviceID:     ds.config.DeviceID,
+		Timestamp:    timestamp,
+		IntervalMins: ds.intervalMinutes,
+		StartTime:    startTime.UTC().Format(time.RFC3339),
+		EndTime:      endTime.UTC().Format(time.RFC3339),
+		Apps:         make([]AppData, 0),
+		Networks:     make([]NetworkData, 0),
+		Suspended:    make([]SuspendedData, 0),
+	}
+
+	// Report suspended periods so missing usage is explained rather than silently absent
+	suspendedSeconds := 0
+	for _, period := range data.Suspended {
+		payload.Suspended = append(payload.Suspended, SuspendedData{
+			StartTime: period.Start.UTC().Format(time.RFC3339),
+			EndTime:   period.End.UTC().Format(time.RFC3339),
+			Seconds:   int(period.Seconds),
+			Reason:    period.Reason,
+		})
+		suspendedSeconds += int(period.Seconds)
+	}
+
+	// Process application data from the agent's running view
+	focusedApp := data.Running.FocusedApp
+	activeApp := focusedApp
+	if activeApp == "" && len(data.Running.Apps) > 0 {
+		activeApp = data.Running.Apps[0]
+	}
+
+	// The focused app is the one with the most focus time credited within the interval
+	var maxFocusTime, focusSeconds, foregroundSeconds int64
+	mostFocused := ""
+	for appName, appInfo := range data.Apps {
+		focusSeconds += appInfo.FocusTime
+		foregroundSeconds += appInfo.ForegroundTime
+		if appInfo.FocusTime > maxFocusTime || (appInfo.FocusTime == maxFocusTime && maxFocusTime > 0 && appName < mostFocused) {
+			mostFocused = appName
+			maxFocusTime = appInfo.FocusTime
+		}
+	}
+	if mostFocused != "" {
+		focusedApp = mostFocused
+	}
+	if activeApp == "" {
+		activeApp = focusedApp
+	}
+
+	if activeApp != "" || focusedApp != "" {
+		appData := AppData{
+			ActiveApp:  activeApp,
+			FocusedApp: focusedApp,
+			FocusTime:  int(maxFocusTime), // Convert int64 to int
+			Timestamp:  timestamp,
+		}
+		payload.Apps = append(payload.Apps, appData)
+	}
+
+	// Every app used within the interval, unless the server only understands the single entry above
+	if ds.config.PayloadFormat != PayloadFormatLegacy {
+		payload.Format = 2
+		payload.AppUsage = appUsageData(data)
+	}
+
+	// Process network data - count access frequency and the time credited within the interval
+	domainAccess := make(map[string]*NetworkData)
+	var networkSeconds int64
+
+	for _, connInfo := range data.Network {
+		networkSeconds += connInfo.Duration
+
+		key := fmt.Sprintf("%s:%d", connInfo.Domain, connInfo.Port)
+		if existing, exists := domainAccess[key]; exists {
+			existing.AccessCount++
+			existing.Duration += int(connInfo.Duration)
+			existing.Apps = mergeNames(existing.Apps, connInfo.Apps)
+		} else {
+			domainAccess[key] = &NetworkData{
+				FQDN:        connInfo.Domain,
+				Port:        connInfo.Port,
+				AccessCount: 1,
+				Protocol:    connInfo.Protocol,
+				Duration:    int(connInfo.Duration),
+				Timestamp:   timestamp,
+				PortSource:  connInfo.PortSource,
+				Apps:        mergeNames(nil, connInfo.Apps),
+				DomainSource: connInfo.DomainSource,
+			}
+		}
+	}
+
+	// Convert map to slice
+	for _, networkData := range domainAccess {
+		payload.Networks = append(payload.Networks, *networkData)
+	}
+
+	// Add metadata
+	payload.Metadata.OSVersion = "macOS"
+	payload.Metadata.AgentVersion = "1.0.0"
+	payload.Metadata.TotalApps = len(data.Apps)
+	payload.Metadata.TotalDomains = len(domainAccess)
+	payload.Metadata.SuspendedSeconds = suspendedSeconds
+	payload.Metadata.FocusSeconds = int(focusSeconds)
+	payload.Metadata.ForegroundSeconds = int(foregroundSeconds)
+	payload.Metadata.NetworkSeconds = int(networkSeconds)
+	if capture := data.Capture; capture != nil {
+		payload.Metadata.Capture = &CaptureData{
+			State:    capture.State,
+			Reason:   capture.Reason,
+			Since:    capture.Since.UTC().Format(time.RFC3339),
+			Restarts: capture.Restarts,
+			Warning:  capture.Warning,
+		}
+	}
+
+	return payload
+}
+
+// saveTransmissionData saves the transmission data to local folder
+func (ds *DataSender) saveTransmissionData(payload TransmissionPayload, startTime time.Time) error {
+	timestamp := startTime.Format("20060102_150405")
+	filename := fmt.Sprintf("transmission_%s.json", timestamp)
+	filePath := filepath.Join(ds.transmissionDir, filename)
+
+	data, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filePath, data, 0644)
+}
+
+// appUsageData lists every app credited within the interval, most focused first
+func appUsageData(data *CombinedData) []AppUsageData {
+	// The day file lists each app's domains for the whole day; only the interval's connections count here
+	appDomains := make(map[string][]string)
+	for _, connInfo := range data.Network {
+		for _, appName := range connInfo.Apps {
+			appDomains[appName] = mergeNames(appDomains[appName], []string{connInfo.Domain})
+		}
+	}
+	for _, domains := range appDomains {
+		sort.Strings(domains)
+	}
+
+	apps := make([]AppUsageData, 0, len(data.Apps))
+	for appName, appInfo := range data.Apps {
+		apps = append(apps, AppUsageData{
+			Name:           appName,
+			FocusTime:      int(appInfo.FocusTime),
+			ForegroundTime: int(appInfo.ForegroundTime),
+			FirstSeen:      appInfo.FirstSeen.UTC().Format(time.RFC3339),
+			LastSeen:       appInfo.LastSeen.UTC().Format(time.RFC3339),
+			Sessions:       data.Sessions[appName],
+			Domains:        appDomains[appName],
+		})
+	}
+
+	sort.Slice(apps, func(i, j int) bool {
+		if apps[i].FocusTime != apps[j].FocusTime {
+			return apps[i].FocusTime > apps[j].FocusTime
+		}
+		if apps[i].ForegroundTime != apps[j].ForegroundTime {
+			return apps[i].ForegroundTime > apps[j].ForegroundTime
+		}
+		return apps[i].Name < apps[j].Name
+	})
+	return apps
+}
+
+// mergeNames adds the names in more that are not in names yet
+func mergeNames(names, more []string) []string {
+	for _, name := range more {
+		found := false
+		for _, existing := range names {
+			if existing == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,114 @@
+package transmit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"roi-agent-schema"
+)
+
+// UsageSample is the usage one agent tick credited to a day file (matching agent/samples.go)
+type UsageSample struct {
+	Start   time.Time              `json:"start"`
+	End     time.Time              `json:"end"`
+	Apps    map[string]*SampleApp  `json:"apps,omitempty"`
+	Network map[string]*SampleConn `json:"network,omitempty"`
+}
+
+// SampleApp is the whole seconds credited to one app by a sample
+type SampleApp struct {
+	FocusTime      int64 `json:"focus_time,omitempty"`
+	ForegroundTime int64 `json:"foreground_time,omitempty"`
+}
+
+// SampleConn is the whole seconds credited to one connection by a sample
+type SampleConn struct {
+	Duration int64 `json:"duration"`
+}
+
+// loadSamples loads the sample log for a date, skipping a half-written last line
+func (ds *DataSender) loadSamples(date string) ([]UsageSample, error) {
+	path := filepath.Join(ds.dataDir, fmt.Sprintf("samples_%s.jsonl", date))
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []UsageSample{}, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var samples []UsageSample
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var sample UsageSample
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			log.Printf("Skipping malformed sample in %s: %v", path, err)
+			continue
+		}
+		samples = append(samples, sample)
+	}
+
+	return samples, scanner.Err()
+}
+
+// sampleInInterval reports whether a sample belongs to the interval. Each sample is
+// assigned to the interval containing its end, so consecutive intervals never count a
+// sample twice and their deltas sum to the day file's counters.
+func sampleInInterval(sample UsageSample, startTime, endTime time.Time) bool {
+	return sample.End.After(startTime) && !sample.End.After(endTime)
+}
+
+// applySamples replaces the counters of the day's apps and connections with the deltas
+// credited by the samples within the interval. Entries without any delta are dropped.
+// An app's FirstSeen and LastSeen become the first and last time it was credited within
+// the interval.
+func applySamples(filtered *CombinedData, data *schema.DayFile, samples []UsageSample, startTime, endTime time.Time) {
+	for _, sample := range samples {
+		if !sampleInInterval(sample, startTime, endTime) {
+			continue
+		}
+
+		sampleStart := sample.Start
+		if sampleStart.Before(startTime) {
+			sampleStart = startTime
+		}
+
+		for appName, delta := range sample.Apps {
+			appInfo, exists := filtered.Apps[appName]
+			if !exists {
+				appInfo = &AppUsage{Name: appName, FirstSeen: sampleStart}
+				filtered.Apps[appName] = appInfo
+			}
+			if sampleStart.Before(appInfo.FirstSeen) {
+				appInfo.FirstSeen = sampleStart
+			}
+			if sample.End.After(appInfo.LastSeen) {
+				appInfo.LastSeen = sample.End
+			}
+			appInfo.FocusTime += delta.FocusTime
+			appInfo.ForegroundTime += delta.ForegroundTime
+		}
+
+		for connKey, delta := range sample.Network {
+			connInfo, exists := filtered.Network[connKey]
+			if !exists {
+				dayInfo, ok := data.Network[connKey]
+				if !ok {
+					continue
+				}
+				copied := *dayInfo
+				copied.Duration = 0
+				connInfo = &copied
+				filtered.Network[connKey] = connInfo
+			}
+			connInfo.Duration += delta.Duration
+		}
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package transmit
 
 import (
 	"bytes"
@@ -12,7 +12,8 @@ import (
 
 // sendData sends the payload to the remote server
 func (ds *DataSender) sendData(payload TransmissionPayload) error {
-	if ds.config.BaseURL == "" || ds.config.APIKey == "" {
+	credential := ds.credential
+	if credential == nil && (ds.config.BaseURL == "" || ds.config.APIKey == "") {
 		return fmt.Errorf("BaseURL or APIKey not configured")
 	}
 
@@ -23,7 +24,7 @@ func (ds *DataSender) sendData(payload TransmissionPayload) error {
 	}
 
 	// Create HTTP request - use BaseURL directly (not BaseURL/data)
-	url := ds.config.BaseURL
+	url := ds.endpoint()
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("error creating request: %v", err)
@@ -31,7 +32,11 @@ func (ds *DataSender) sendData(payload TransmissionPayload) error {
 
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-API-Key", ds.config.APIKey)
+	if credential != nil {
+		req.Header.Set("Authorization", "Bearer "+credential.Token)
+	} else {
+		req.Header.Set("X-API-Key", ds.config.APIKey)
+	}
 	req.Header.Set("User-Agent", "ROI-Agent/1.0.0")
 
 	// Send request
@@ -52,7 +57,11 @@ func (ds *DataSender) sendData(payload TransmissionPayload) error {
 		responseBody = []byte("(unable to read response)")
 	}
 
-	// Check response
+	// Check response; a 401 for a device credential means the device was offboarded
+	if resp.StatusCode == http.StatusUnauthorized && credential != nil {
+		ds.revokeCredential()
+		return ErrRevoked
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return fmt.Errorf("server returned status %d: %s", resp.StatusCode, string(responseBody))
 	}
@@ -0,0 +1,491 @@
+package transmit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/joho/godotenv"
+)
+
+// Setting is one data-sender configuration value. Every setting can come from, in order
+// of precedence, a flag (--<name>), the environment, a .env file, the legacy
+// transmission_config.json or its default.
+type Setting struct {
+	Name    string // used by config get/set/unset and as the flag name
+	Env     string // environment variable and .env key
+	Default string
+	Secret  bool // masked in config show
+	Usage   string
+
+	validate func(value string) error
+}
+
+// Settings lists every data-sender setting
+var Settings = []Setting{
+	{Name: "base_url", Env: "ROI_AGENT_BASE_URL", Default: "https://api.sample-server.com/v1/roi-agent-sample",
+		Usage: "Server base URL", validate: validateURL},
+	{Name: "api_key", Env: "ROI_AGENT_API_KEY", Default: "sample-api-key-replace-with-actual", Secret: true,
+		Usage: "API authentication key", validate: validateNotEmpty},
+	{Name: "enabled", Env: "ROI_AGENT_ENABLED",
+		Usage: "Send data (default: true when base_url and api_key are set or the device is enrolled)", validate: validateBool},
+	{Name: "interval_minutes", Env: "ROI_AGENT_INTERVAL_MINUTES", Default: "10",
+		Usage: "Transmission interval in minutes", validate: validateIntRange(1, 1440)},
+	{Name: "device_id", Env: "ROI_AGENT_DEVICE_ID",
+		Usage: "Override the persisted device ID"},
+	{Name: "device_id_source", Env: "ROI_AGENT_DEVICE_ID_SOURCE", Default: DeviceSourceRandom,
+		Usage: "Device ID source: random or machine", validate: validateOneOf(DeviceSourceRandom, DeviceSourceMachine)},
+	{Name: "payload_format", Env: "ROI_AGENT_PAYLOAD_FORMAT", Default: PayloadFormatV2,
+		Usage: "Payload format: v2 or legacy", validate: validateOneOf(PayloadFormatV2, PayloadFormatLegacy)},
+	{Name: "outbox_max_age_hours", Env: "ROI_AGENT_OUTBOX_MAX_AGE_HOURS", Default: "168",
+		Usage: "Drop unsent payloads older than this", validate: validateIntRange(1, 24*365)},
+	{Name: "outbox_max_mb", Env: "ROI_AGENT_OUTBOX_MAX_MB", Default: "50",
+		Usage: "Disk quota for unsent payloads", validate: validateIntRange(1, 100*1024)},
+	{Name: "data_retention_days", Env: "ROI_AGENT_MONITOR_DATA_RETENTION_DAYS", Default: "30",
+		Usage: "Days to keep sent day files, 0 for ever (the agent's monitor.data_retention_days)", validate: validateIntRange(0, 3650)},
+}
+
+// LookupSetting finds a setting by name or environment variable
+func LookupSetting(name string) (*Setting, bool) {
+	for i := range Settings {
+		if strings.EqualFold(name, Settings[i].Name) || name == Settings[i].Env {
+			return &Settings[i], true
+		}
+	}
+	return nil, false
+}
+
+// Validate checks a value for the setting
+func (s *Setting) Validate(value string) error {
+	if s.validate == nil {
+		return nil
+	}
+	return s.validate(value)
+}
+
+func validateURL(value string) error {
+	if !strings.HasPrefix(value, "https://") && !strings.HasPrefix(value, "http://") {
+		return fmt.Errorf("must start with https:// or http://")
+	}
+	return nil
+}
+
+func validateNotEmpty(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	return nil
+}
+
+func validateBool(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return fmt.Errorf("must be true or false")
+	}
+	return nil
+}
+
+func validateIntRange(min, max int) func(string) error {
+	return func(value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < min || n > max {
+			return fmt.Errorf("must be a whole number between %d and %d", min, max)
+		}
+		return nil
+	}
+}
+
+func validateOneOf(values ...string) func(string) error {
+	return func(value string) error {
+		for _, allowed := range values {
+			if value == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(values, ", "))
+	}
+}
+
+// MaskSecret shows only the start of a secret
+func MaskSecret(value string) string {
+	if len(value) <= 8 {
+		return "***"
+	}
+	return value[:4] + "..."
+}
+
+// UserEnvPath is the .env file written by config set, ~/.roiagent/data-sender/.env
+func UserEnvPath() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".roiagent", "data-sender", ".env")
+}
+
+// envFilePaths are the .env files read by both the agent and the data sender, in order of
+// precedence: the user file first, then the project files older setups used
+func envFilePaths() []string {
+	return []string{
+		UserEnvPath(),
+		".env",                // Current directory
+		"./data-sender/.env",  // From project root
+		"../.env",             // Parent directory
+		"../data-sender/.env", // From the agent directory
+	}
+}
+
+var (
+	loadEnvOnce sync.Once
+	envMutex    sync.Mutex
+
+	// envFileOrigins records which file each variable loaded from a .env file came from
+	envFileOrigins = make(map[string]string)
+
+	// flagValues holds values given as flags, which take precedence over everything
+	flagValues = make(map[string]string)
+)
+
+// EnvFiles returns the absolute paths of the .env files that are read, whether or not they exist
+func EnvFiles() []string {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, path := range envFilePaths() {
+		absPath, err := filepath.Abs(path)
+		if err != nil || seen[absPath] {
+			continue
+		}
+		seen[absPath] = true
+		paths = append(paths, absPath)
+	}
+	return paths
+}
+
+// LoadEnvFiles loads every .env file found into the environment. A variable that is
+// already set is left alone, and earlier files take precedence over later ones.
+// It only reads the files once per process.
+func LoadEnvFiles() {
+	loadEnvOnce.Do(func() {
+		envMutex.Lock()
+		defer envMutex.Unlock()
+		loadEnvFileValues()
+	})
+}
+
+// ReloadEnvFiles reads the .env files again, replacing every value loaded from them
+// before. Variables set in the real environment still take precedence.
+func ReloadEnvFiles() {
+	LoadEnvFiles()
+
+	envMutex.Lock()
+	defer envMutex.Unlock()
+	for key := range envFileOrigins {
+		os.Unsetenv(key)
+	}
+	envFileOrigins = make(map[string]string)
+	loadEnvFileValues()
+}
+
+// loadEnvFileValues sets the variables of every .env file that are not set yet
+func loadEnvFileValues() {
+	for _, path := range EnvFiles() {
+		values, err := godotenv.Read(path)
+		if err != nil {
+			continue
+		}
+		for key, value := range values {
+			if _, exists := os.LookupEnv(key); exists {
+				continue
+			}
+			os.Setenv(key, value)
+			envFileOrigins[key] = path
+		}
+	}
+}
+
+// EnvOrigin describes where an environment variable's value came from
+func EnvOrigin(name string) string {
+	envMutex.Lock()
+	defer envMutex.Unlock()
+	if path, exists := envFileOrigins[name]; exists {
+		return "file " + path
+	}
+	return "env " + name
+}
+
+// SetFlag sets a setting from a command line flag
+func SetFlag(name, value string) error {
+	s, known := LookupSetting(name)
+	if !known {
+		return fmt.Errorf("unknown setting %s", name)
+	}
+	if err := s.Validate(value); err != nil {
+		return fmt.Errorf("--%s: %v", s.Name, err)
+	}
+	flagValues[s.Env] = value
+	return nil
+}
+
+// legacyConfig is the transmission_config.json written by earlier versions
+type legacyConfig struct {
+	BaseURL       string `json:"base_url"`
+	APIKey        string `json:"api_key"`
+	Enabled       *bool  `json:"enabled"`
+	PayloadFormat string `json:"payload_format"`
+}
+
+// loadLegacyConfig reads transmission_config.json as the lowest precedence source.
+// Its device_id is ignored: the persisted device identity replaced it.
+func (ds *DataSender) loadLegacyConfig() map[string]string {
+	values := make(map[string]string)
+
+	data, err := ioutil.ReadFile(ds.configPath)
+	if err != nil {
+		return values
+	}
+	var legacy legacyConfig
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return values
+	}
+
+	if legacy.BaseURL != "" {
+		values["ROI_AGENT_BASE_URL"] = legacy.BaseURL
+	}
+	if legacy.APIKey != "" {
+		values["ROI_AGENT_API_KEY"] = legacy.APIKey
+	}
+	if legacy.Enabled != nil {
+		values["ROI_AGENT_ENABLED"] = strconv.FormatBool(*legacy.Enabled)
+	}
+	if legacy.PayloadFormat != "" {
+		values["ROI_AGENT_PAYLOAD_FORMAT"] = legacy.PayloadFormat
+	}
+	return values
+}
+
+// resolve returns the setting's value and where it came from. An invalid value is
+// skipped in favour of the next source, with a warning naming the key and source.
+func (ds *DataSender) resolve(s *Setting, legacy map[string]string) (string, string, error) {
+	var problem error
+	candidates := []struct{ value, origin string }{}
+
+	if value, exists := flagValues[s.Env]; exists {
+		candidates = append(candidates, struct{ value, origin string }{value, "flag --" + s.Name})
+	}
+	if value := os.Getenv(s.Env); value != "" {
+		candidates = append(candidates, struct{ value, origin string }{value, EnvOrigin(s.Env)})
+	}
+	if value, exists := legacy[s.Env]; exists {
+		candidates = append(candidates, struct{ value, origin string }{value, "file " + ds.configPath})
+	}
+
+	for _, candidate := range candidates {
+		if err := s.Validate(candidate.value); err != nil {
+			if problem == nil {
+				problem = fmt.Errorf("%s=%q (%s) %v, ignored", s.Env, candidate.value, candidate.origin, err)
+			}
+			continue
+		}
+		return candidate.value, candidate.origin, problem
+	}
+	return s.Default, "default", problem
+}
+
+// SettingValue is a setting's effective value
+type SettingValue struct {
+	Setting *Setting
+	Value   string
+	Origin  string
+}
+
+// Display returns the value with secrets masked
+func (v SettingValue) Display() string {
+	if v.Setting.Secret && v.Value != "" {
+		return MaskSecret(v.Value)
+	}
+	return v.Value
+}
+
+// EffectiveSettings returns every setting's effective value and origin
+func (ds *DataSender) EffectiveSettings() []SettingValue {
+	values := make([]SettingValue, 0, len(Settings))
+	for i := range Settings {
+		s := &Settings[i]
+		value := SettingValue{Setting: s, Value: ds.values[s.Env], Origin: ds.origins[s.Env]}
+		values = append(values, value)
+	}
+	return values
+}
+
+// ShowSettings prints every setting, with its origin if requested
+func (ds *DataSender) ShowSettings(withOrigin bool) {
+	for _, value := range ds.EffectiveSettings() {
+		if withOrigin {
+			fmt.Printf("%-22s %-52s %s\n", value.Setting.Name, value.Display(), value.Origin)
+		} else {
+			fmt.Printf("%-22s %s\n", value.Setting.Name, value.Display())
+		}
+	}
+}
+
+// SetSetting validates value and writes it to the user .env file
+func (ds *DataSender) SetSetting(name, value string) (*Setting, error) {
+	s, known := LookupSetting(name)
+	if !known {
+		return nil, fmt.Errorf("unknown setting %s (known: %s)", name, settingNames())
+	}
+	if err := s.Validate(value); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", s.Name, err)
+	}
+	if err := editEnvFile(UserEnvPath(), s.Env, &value); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// OverriddenBy names the flag or environment variable that takes precedence over the
+// user .env file for this setting, or returns "" if the file's value is used
+func (s *Setting) OverriddenBy() string {
+	if _, exists := flagValues[s.Env]; exists {
+		return "flag --" + s.Name
+	}
+	if _, exists := os.LookupEnv(s.Env); exists {
+		if origin := EnvOrigin(s.Env); origin == "env "+s.Env {
+			return origin
+		}
+	}
+	return ""
+}
+
+// UnsetSetting removes a setting from the user .env file
+func (ds *DataSender) UnsetSetting(name string) (*Setting, error) {
+	s, known := LookupSetting(name)
+	if !known {
+		return nil, fmt.Errorf("unknown setting %s (known: %s)", name, settingNames())
+	}
+	if err := editEnvFile(UserEnvPath(), s.Env, nil); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Setting returns a setting's effective value
+func (ds *DataSender) Setting(name string) (SettingValue, error) {
+	s, known := LookupSetting(name)
+	if !known {
+		return SettingValue{}, fmt.Errorf("unknown setting %s (known: %s)", name, settingNames())
+	}
+	return SettingValue{Setting: s, Value: ds.values[s.Env], Origin: ds.origins[s.Env]}, nil
+}
+
+func settingNames() string {
+	names := make([]string, 0, len(Settings))
+	for _, s := range Settings {
+		names = append(names, s.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// editEnvFile sets key to *value in a .env file, or removes it if value is nil.
+// Comments, blank lines and other keys are kept as they are. The file is replaced
+// atomically and is only readable by the user, since it can hold the API key.
+func editEnvFile(path, key string, value *string) error {
+	var lines []string
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	}
+
+	var definition string
+	if value != nil {
+		quoted, err := quoteEnvValue(*value)
+		if err != nil {
+			return err
+		}
+		definition = key + "=" + quoted
+	}
+
+	var edited []string
+	replaced := false
+	for _, line := range lines {
+		if envLineKey(line) != key {
+			edited = append(edited, line)
+			continue
+		}
+		// Later duplicates are dropped so the file has a single definition
+		if value != nil && !replaced {
+			edited = append(edited, definition)
+			replaced = true
+		}
+	}
+	if value != nil && !replaced {
+		edited = append(edited, definition)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	os.Remove(tmpPath)
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	content := strings.Join(edited, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// envLineKey returns the key a .env line defines, or "" for comments and blank lines
+func envLineKey(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return ""
+	}
+	trimmed = strings.TrimPrefix(trimmed, "export ")
+	parts := strings.SplitN(trimmed, "=", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	return strings.TrimSpace(parts[0])
+}
+
+// quoteEnvValue returns value as written to a .env file: bare if it has nothing a .env
+// reader could misread, otherwise in single quotes, which godotenv reads literally.
+// Double quotes, in which godotenv expands $VAR and escapes, are used with $ and \
+// escaped for values single quotes cannot hold. A value godotenv would read back
+// differently in every form is refused.
+func quoteEnvValue(value string) (string, error) {
+	if value != "" && !strings.ContainsAny(value, " \t#\"'\\$\n\r") {
+		return value, nil
+	}
+
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "\n", `\n`, "\r", `\r`).Replace(value)
+	candidates := []string{`"` + escaped + `"`, value}
+	if !strings.ContainsAny(value, "\n\r") {
+		candidates = append([]string{"'" + value + "'"}, candidates...)
+	}
+	for _, quoted := range candidates {
+		if parsed, err := godotenv.Unmarshal("VALUE=" + quoted); err == nil && parsed["VALUE"] == value {
+			return quoted, nil
+		}
+	}
+	return "", fmt.Errorf("the value cannot be written to a .env file; set it in the environment instead")
+}
@@ -0,0 +1,318 @@
+package transmit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/joho/godotenv"
+)
+
+func TestEditEnvFile(t *testing.T) {
+	original := "# ROI Agent settings\n" +
+		"ROI_AGENT_BASE_URL=https://old.example.com\n" +
+		"\n" +
+		"  # the key is rotated monthly\n" +
+		"export ROI_AGENT_API_KEY=secret\n" +
+		"OTHER_TOOL_TOKEN=\"keep me\"\n" +
+		"ROI_AGENT_BASE_URL=https://duplicate.example.com\n"
+	value := func(s string) *string { return &s }
+
+	tests := []struct {
+		name  string
+		key   string
+		value *string
+		want  string
+	}{
+		{"replace", "ROI_AGENT_BASE_URL", value("https://roi.example.com"),
+			"# ROI Agent settings\nROI_AGENT_BASE_URL=https://roi.example.com\n\n  # the key is rotated monthly\nexport ROI_AGENT_API_KEY=secret\nOTHER_TOOL_TOKEN=\"keep me\"\n"},
+		{"replace an exported key", "ROI_AGENT_API_KEY", value("new key"),
+			"# ROI Agent settings\nROI_AGENT_BASE_URL=https://old.example.com\n\n  # the key is rotated monthly\nROI_AGENT_API_KEY='new key'\nOTHER_TOOL_TOKEN=\"keep me\"\nROI_AGENT_BASE_URL=https://duplicate.example.com\n"},
+		{"add", "ROI_AGENT_INTERVAL_MINUTES", value("5"), original + "ROI_AGENT_INTERVAL_MINUTES=5\n"},
+		{"remove", "ROI_AGENT_BASE_URL", nil,
+			"# ROI Agent settings\n\n  # the key is rotated monthly\nexport ROI_AGENT_API_KEY=secret\nOTHER_TOOL_TOKEN=\"keep me\"\n"},
+		{"remove a missing key", "ROI_AGENT_ENABLED", nil, original},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".env")
+			if err := ioutil.WriteFile(path, []byte(original), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := editEnvFile(path, tt.key, tt.value); err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("file =\n%s\nwant\n%s", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditEnvFileCreates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data-sender", ".env")
+	value := "https://roi.example.com"
+	if err := editEnvFile(path, "ROI_AGENT_BASE_URL", &value); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "ROI_AGENT_BASE_URL=https://roi.example.com\n" {
+		t.Errorf("file = %q", data)
+	}
+
+	if err := editEnvFile(path, "ROI_AGENT_BASE_URL", nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(path); len(data) != 0 {
+		t.Errorf("file after removing its only key = %q, want empty", data)
+	}
+}
+
+func TestEditEnvFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".env")
+	if err := ioutil.WriteFile(path, []byte("ROI_AGENT_API_KEY=secret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A temporary file left by an interrupted edit is replaced
+	if err := ioutil.WriteFile(path+".tmp", []byte("ROI_AGENT_API_KEY=half"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	value := "rotated"
+	if err := editEnvFile(path, "ROI_AGENT_API_KEY", &value); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "ROI_AGENT_API_KEY=rotated\n" {
+		t.Errorf("file = %q", data)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("the temporary file was left behind")
+	}
+	if info, err := os.Stat(path); err != nil || (runtime.GOOS != "windows" && info.Mode().Perm() != 0600) {
+		t.Errorf("file mode = %v, %v; want 0600", info.Mode(), err)
+	}
+
+	// When the new file cannot be written the old one is left intact
+	if err := os.MkdirAll(filepath.Join(path+".tmp", "busy"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	value = "lost"
+	if err := editEnvFile(path, "ROI_AGENT_API_KEY", &value); err == nil {
+		t.Fatal("editEnvFile() succeeded without writing the file")
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "ROI_AGENT_API_KEY=rotated\n" {
+		t.Errorf("file after a failed edit = %q", data)
+	}
+}
+
+func TestQuoteEnvValue(t *testing.T) {
+	tests := map[string]string{
+		"plain":        "plain",
+		"https://x/?a": "https://x/?a",
+		"":             "''",
+		"with space":   "'with space'",
+		"a#b":          "'a#b'",
+		`say "hi"`:     `'say "hi"'`,
+		"$HOME":        "'$HOME'",
+		`ab$HOME\x`:    `'ab$HOME\x'`,
+		`it's $5`:      `"it's \$5"`,
+		`ends in \`:    `ends in \`,
+	}
+	for value, want := range tests {
+		if got, err := quoteEnvValue(value); err != nil || got != want {
+			t.Errorf("quoteEnvValue(%q) = %s, %v; want %s", value, got, err, want)
+		}
+	}
+}
+
+func TestEditEnvFileRoundTrip(t *testing.T) {
+	// Secrets must read back exactly as they were set, whatever they contain
+	values := []string{
+		"plain",
+		"",
+		"ab$HOME\\x",
+		"$(whoami)",
+		"${ROI_AGENT_API_KEY}",
+		"p@ss w0rd # not a comment",
+		`say "hi"`,
+		`it's $5 \n not a newline`,
+		`ends in \`,
+		`'quoted'`,
+		"tab\there",
+		"two\nlines",
+	}
+
+	path := filepath.Join(t.TempDir(), ".env")
+	for _, value := range values {
+		if err := editEnvFile(path, "ROI_AGENT_API_KEY", &value); err != nil {
+			t.Errorf("editEnvFile(%q): %v", value, err)
+			continue
+		}
+		read, err := godotenv.Read(path)
+		if err != nil {
+			t.Errorf("godotenv.Read() after writing %q: %v", value, err)
+			continue
+		}
+		if read["ROI_AGENT_API_KEY"] != value {
+			t.Errorf("wrote %q, read back %q", value, read["ROI_AGENT_API_KEY"])
+		}
+	}
+
+	// A value godotenv cannot represent is refused and the file is left alone
+	before, _ := ioutil.ReadFile(path)
+	value := `'$HOME"`
+	if err := editEnvFile(path, "ROI_AGENT_API_KEY", &value); err == nil {
+		t.Errorf("editEnvFile(%q) succeeded", value)
+	}
+	if after, _ := ioutil.ReadFile(path); string(after) != string(before) {
+		t.Errorf("file after a refused value = %q, want %q", after, before)
+	}
+}
+
+// useEnv sets environment variables for the rest of the test, unsetting those whose
+// value is nil, and forgets flags and .env values set by the test afterwards
+func useEnv(t *testing.T, values map[string]*string) {
+	t.Helper()
+	for key, value := range values {
+		t.Setenv(key, "")
+		if value == nil {
+			os.Unsetenv(key)
+		} else {
+			os.Setenv(key, *value)
+		}
+	}
+	t.Cleanup(func() {
+		envMutex.Lock()
+		defer envMutex.Unlock()
+		envFileOrigins = make(map[string]string)
+		flagValues = make(map[string]string)
+	})
+}
+
+func TestResolvePrecedence(t *testing.T) {
+	s, _ := LookupSetting("interval_minutes")
+	env := func(value string) map[string]*string {
+		if value == "" {
+			return map[string]*string{s.Env: nil}
+		}
+		return map[string]*string{s.Env: &value}
+	}
+
+	tests := []struct {
+		name   string
+		flag   string
+		env    string
+		legacy string
+		want   string
+		origin string
+		warns  bool
+	}{
+		{"flag over everything", "5", "15", "20", "5", "flag --interval_minutes", false},
+		{"environment over the file", "", "15", "20", "15", "env ROI_AGENT_INTERVAL_MINUTES", false},
+		{"file over the default", "", "", "20", "20", "file ", false},
+		{"default", "", "", "", "10", "default", false},
+		{"invalid environment value skipped", "", "0", "20", "20", "file ", true},
+		{"every value invalid", "", "abc", "99999", "10", "default", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useEnv(t, env(tt.env))
+			if tt.flag != "" {
+				if err := SetFlag(s.Name, tt.flag); err != nil {
+					t.Fatal(err)
+				}
+			}
+			legacy := map[string]string{}
+			if tt.legacy != "" {
+				legacy[s.Env] = tt.legacy
+			}
+
+			ds := newTestSender(t)
+			value, origin, err := ds.resolve(s, legacy)
+			if value != tt.want || !strings.HasPrefix(origin, tt.origin) {
+				t.Errorf("resolve() = %q from %q, want %q from %q", value, origin, tt.want, tt.origin)
+			}
+			if (err != nil) != tt.warns {
+				t.Errorf("resolve() warning = %v, want one: %v", err, tt.warns)
+			}
+		})
+	}
+
+	if err := SetFlag("interval_minutes", "0"); err == nil {
+		t.Error("SetFlag() accepted an invalid value")
+	}
+	if err := SetFlag("no_such_setting", "1"); err == nil {
+		t.Error("SetFlag() accepted an unknown setting")
+	}
+}
+
+func TestEnvFilePrecedence(t *testing.T) {
+	// The user .env file sets what the environment does not, and the environment wins
+	home := t.TempDir()
+	url := "https://env.example.com"
+	useEnv(t, map[string]*string{
+		"HOME":                       &home,
+		"USERPROFILE":                &home,
+		"ROI_AGENT_BASE_URL":         &url,
+		"ROI_AGENT_API_KEY":          nil,
+		"ROI_AGENT_INTERVAL_MINUTES": nil,
+	})
+	for key, value := range map[string]string{"ROI_AGENT_BASE_URL": "https://file.example.com", "ROI_AGENT_API_KEY": "file-key"} {
+		if err := editEnvFile(UserEnvPath(), key, &value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	envMutex.Lock()
+	loadEnvFileValues()
+	envMutex.Unlock()
+
+	ds := newTestSender(t)
+	if err := ioutil.WriteFile(ds.configPath, []byte(`{"base_url": "https://legacy.example.com", "api_key": "legacy-key"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	legacy := ds.loadLegacyConfig()
+
+	tests := []struct {
+		name   string
+		value  string
+		origin string
+	}{
+		{"base_url", url, "env ROI_AGENT_BASE_URL"},
+		{"api_key", "file-key", "file " + UserEnvPath()},
+		{"interval_minutes", "10", "default"},
+	}
+	for _, tt := range tests {
+		s, _ := LookupSetting(tt.name)
+		value, origin, err := ds.resolve(s, legacy)
+		if err != nil || value != tt.value || origin != tt.origin {
+			t.Errorf("%s = %q from %q (%v), want %q from %q", tt.name, value, origin, err, tt.value, tt.origin)
+		}
+	}
+
+	// An environment variable set for real is reported as overriding the user file
+	base, _ := LookupSetting("base_url")
+	apiKey, _ := LookupSetting("api_key")
+	if base.OverriddenBy() != "env ROI_AGENT_BASE_URL" || apiKey.OverriddenBy() != "" {
+		t.Errorf("OverriddenBy() = %q, %q", base.OverriddenBy(), apiKey.OverriddenBy())
+	}
+}
+
+func TestLookupSetting(t *testing.T) {
+	for _, name := range []string{"base_url", "BASE_URL", "ROI_AGENT_BASE_URL"} {
+		if s, known := LookupSetting(name); !known || s.Name != "base_url" {
+			t.Errorf("LookupSetting(%q) = %v, %v", name, s, known)
+		}
+	}
+	if _, known := LookupSetting("roi_agent_base_url"); known {
+		t.Error("LookupSetting() matched an environment variable in lower case")
+	}
+}
@@ -0,0 +1,114 @@
+package transmit
+
+import (
+	"time"
+
+	"roi-agent-schema"
+)
+
+// AppData represents application usage data for transmission
+type AppData struct {
+	ActiveApp  string `json:"active_app"`
+	FocusedApp string `json:"focused_app"`
+	FocusTime  int    `json:"focus_time_seconds"`  // Focus time of FocusedApp within the interval
+	Timestamp  string `json:"timestamp"`
+}
+
+// AppUsageData represents one app's usage within the interval for transmission
+type AppUsageData struct {
+	Name           string `json:"name"`
+	FocusTime      int    `json:"focus_time_seconds"`
+	ForegroundTime int    `json:"foreground_time_seconds"`
+	FirstSeen      string `json:"first_seen"`
+	LastSeen       string `json:"last_seen"`
+	Sessions       int    `json:"sessions"`
+	Domains        []string `json:"domains,omitempty"` // domains of the interval's connections made by the app
+}
+
+// NetworkData represents network access data for transmission
+type NetworkData struct {
+	FQDN        string `json:"fqdn"`
+	Port        int    `json:"port"`
+	AccessCount int    `json:"access_count"`
+	Protocol    string `json:"protocol"`
+	Duration    int    `json:"duration_seconds"` // credited within the interval
+	Timestamp   string `json:"timestamp"`
+	PortSource  string `json:"port_source,omitempty"` // "observed" from the connection, or "guessed" from the domain name
+	Apps        []string `json:"apps,omitempty"` // the apps that made the connection that day, if known
+	DomainSource string  `json:"domain_source,omitempty"` // "dns", or "tls"/"quic" for server names read when DNS was not seen
+}
+
+// SuspendedData represents a period within the interval when the device was asleep
+// or otherwise not sampled, so its missing usage is explained rather than dropped
+type SuspendedData struct {
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+	Seconds   int    `json:"seconds"`
+	Reason    string `json:"reason,omitempty"` // "paused" if recording was paused by the user
+}
+
+// CaptureData is the agent's DNS capture health at the end of the interval, so gaps in
+// network data can be told apart from a device that made no connections
+type CaptureData struct {
+	State    string `json:"state"` // running, restarting, failed or disabled
+	Reason   string `json:"reason,omitempty"`
+	Since    string `json:"since"`
+	Restarts int    `json:"restarts"`
+	Warning  string `json:"warning,omitempty"` // e.g. no DNS traffic while TLS traffic is seen
+}
+
+// TransmissionPayload represents the complete data package to send
+type TransmissionPayload struct {
+	Format       int           `json:"format_version,omitempty"`
+	DeviceID     string        `json:"device_id"`
+	Timestamp    string        `json:"timestamp"`
+	IntervalMins int           `json:"interval_minutes"`
+	StartTime    string        `json:"start_time"`
+	EndTime      string        `json:"end_time"`
+	Apps         []AppData     `json:"apps"`
+	AppUsage     []AppUsageData `json:"app_usage,omitempty"` // every app, omitted in the legacy format
+	Networks     []NetworkData `json:"networks"`
+	Suspended    []SuspendedData `json:"suspended_periods"`
+	Metadata     struct {
+		OSVersion        string `json:"os_version"`
+		AgentVersion     string `json:"agent_version"`
+		TotalApps        int    `json:"total_apps"`
+		TotalDomains     int    `json:"total_domains"`
+		SuspendedSeconds int    `json:"suspended_seconds"`
+
+		// Per-interval deltas; summed over a day they equal the day file's totals
+		FocusSeconds      int `json:"focus_seconds"`
+		ForegroundSeconds int `json:"foreground_seconds"`
+		NetworkSeconds    int `json:"network_seconds"`
+
+		Capture *CaptureData `json:"capture,omitempty"` // omitted for agents that do not report it
+	} `json:"metadata"`
+}
+
+// TransmissionLog represents a transmission attempt log
+type TransmissionLog struct {
+	StartTime   time.Time `json:"start_time"`
+	EndTime     time.Time `json:"end_time"`
+	Timestamp   time.Time `json:"timestamp"`
+	Success     bool      `json:"success"`
+	Error       string    `json:"error,omitempty"`
+	RetryCount  int       `json:"retry_count"`
+	PayloadSize int       `json:"payload_size"`
+}
+
+// CombinedData is the agent's day file narrowed to one interval, with counters
+// replaced by the deltas credited within it
+type CombinedData struct {
+	schema.DayFile
+
+	// Sessions is the number of focus sessions per app within the interval, computed from the event log
+	Sessions map[string]int `json:"-"`
+}
+
+// The day file types are shared with the agents
+type (
+	AppUsage        = schema.AppUsage
+	NetworkConn     = schema.NetworkConnection
+	RunningView     = schema.RunningView
+	SuspendedPeriod = schema.SuspendedPeriod
+)
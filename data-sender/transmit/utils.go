@@ -0,0 +1,354 @@
+package transmit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TestConnection tests the data transmission connection
+func (ds *DataSender) TestConnection() {
+	fmt.Println("Testing Data Transmission Configuration...")
+	fmt.Println("=========================================")
+	
+	// Check configuration
+	if !ds.config.Enabled {
+		fmt.Println("❌ Data transmission is DISABLED")
+		fmt.Println("   Please check .env file configuration")
+		return
+	}
+	
+	fmt.Println("✅ Data transmission is ENABLED")
+	fmt.Printf("   Server URL: %s\n", ds.config.BaseURL)
+	fmt.Printf("   Interval: %d minutes\n", ds.intervalMinutes)
+	
+	// Test connection with a minimal valid payload containing sample data
+	testTimestamp := time.Now().UTC().Format(time.RFC3339)
+	
+	// Create minimal valid app data
+	testAppData := AppData{
+		ActiveApp:  "TestApp",
+		FocusedApp: "TestApp", 
+		FocusTime:  60, // 60 seconds for test
+		Timestamp:  testTimestamp,
+	}
+	
+	// Create minimal valid network data
+	testNetworkData := NetworkData{
+		FQDN:        "test.example.com",
+		Port:        443,
+		AccessCount: 1,
+		Protocol:    "HTTPS",
+		Timestamp:   testTimestamp,
+	}
+	
+	testPayload := TransmissionPayload{
+		DeviceID:     ds.config.DeviceID,
+		Timestamp:    testTimestamp,
+		IntervalMins: ds.intervalMinutes,
+		StartTime:    time.Now().Add(-time.Duration(ds.intervalMinutes)*time.Minute).UTC().Format(time.RFC3339),
+		EndTime:      time.Now().UTC().Format(time.RFC3339),
+		Apps:         []AppData{testAppData},
+		Networks:     []NetworkData{testNetworkData},
+	}
+	
+	// Set metadata with correct counts
+	testPayload.Metadata.OSVersion = "macOS"
+	testPayload.Metadata.AgentVersion = "1.0.0-test"
+	testPayload.Metadata.TotalApps = 1
+	testPayload.Metadata.TotalDomains = 1
+	
+	fmt.Println("\n🔄 Testing connection to server...")
+	fmt.Println("   Sending minimal test payload with sample app and network data")
+	if err := ds.sendData(testPayload); err != nil {
+		fmt.Printf("❌ Connection test FAILED: %v\n", err)
+	} else {
+		fmt.Println("✅ Connection test SUCCESSFUL")
+		fmt.Println("   Data transmission is working properly")
+		fmt.Println("   Sample data was accepted by the server")
+	}
+}
+
+// ShowStatus shows current status and configuration (alias for ShowConfig)
+func (ds *DataSender) ShowStatus() {
+	ds.ShowConfig()
+}
+
+// dayFilePrefixes are the agent's per-day files in the data directory, named <prefix>YYYY-MM-DD
+var dayFilePrefixes = []string{"combined_", "events_", "samples_"}
+
+// dayFileDate returns the date in the name of a day file, or its corrupt backup
+func dayFileDate(name string) (string, bool) {
+	for _, prefix := range dayFilePrefixes {
+		if !strings.HasPrefix(name, prefix) || len(name) < len(prefix)+10 {
+			continue
+		}
+		date := name[len(prefix) : len(prefix)+10]
+		if _, err := time.Parse("2006-01-02", date); err == nil {
+			return date, true
+		}
+	}
+	return "", false
+}
+
+// CleanupOldFiles removes old files: day files older than data_retention_days whose
+// intervals were all transmitted, and transmission and log files older than 7 days
+func (ds *DataSender) CleanupOldFiles() error {
+	homeDir, _ := os.UserHomeDir()
+	userDataDir := filepath.Join(homeDir, ".roiagent")
+
+	// Keep only files from the last 7 days
+	now := time.Now()
+	cutoff := now.AddDate(0, 0, -7)
+
+	// Day files go by the date in their name, like the agent's own cleanup. The samples
+	// of an interval that was never sent are what a backfill sends it from, so its day
+	// is kept until then.
+	var files []os.FileInfo
+	cutoffDate := time.Date(now.Year(), now.Month(), now.Day()-ds.dataRetentionDays, 12, 0, 0, 0, time.Local).Format("2006-01-02")
+	if ds.dataRetentionDays > 0 {
+		var err error
+		if files, err = ioutil.ReadDir(ds.dataDir); err != nil {
+			log.Printf("Directory %s not found, skipping: %v", ds.dataDir, err)
+		}
+	}
+	transmitted := make(map[string]bool)
+	for _, file := range files {
+		date, isDayFile := dayFileDate(file.Name())
+		if file.IsDir() || !isDayFile || date >= cutoffDate {
+			continue
+		}
+		if _, checked := transmitted[date]; !checked {
+			transmitted[date] = ds.DayTransmitted(date)
+			if !transmitted[date] {
+				log.Printf("Keeping the day files for %s until its intervals are transmitted", date)
+			}
+		}
+		if !transmitted[date] {
+			continue
+		}
+		filePath := filepath.Join(ds.dataDir, file.Name())
+		if err := os.Remove(filePath); err != nil {
+			log.Printf("Error removing old file %s: %v", filePath, err)
+		} else {
+			log.Printf("Removed old file: %s", filePath)
+		}
+	}
+
+	// Directories to clean up by modification time
+	dirsToClean := []string{
+		ds.transmissionDir,                 // Transmission files
+		filepath.Join(userDataDir, "logs"), // Log files
+	}
+
+	for _, dirPath := range dirsToClean {
+		files, err := ioutil.ReadDir(dirPath)
+		if err != nil {
+			// Directory might not exist, skip
+			log.Printf("Directory %s not found, skipping: %v", dirPath, err)
+			continue
+		}
+
+		for _, file := range files {
+			// The outbox is pruned by its own age and size limits, and removing the lock
+			// file would let a second process lock a new one
+			if file.IsDir() || filepath.Join(dirPath, file.Name()) == ds.lockPath() {
+				continue
+			}
+			if file.ModTime().Before(cutoff) {
+				filePath := filepath.Join(dirPath, file.Name())
+				if err := os.Remove(filePath); err != nil {
+					log.Printf("Error removing old file %s: %v", filePath, err)
+				} else {
+					log.Printf("Removed old file: %s", filePath)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// CleanupOldTransmissionFiles removes old transmission files (deprecated - use CleanupOldFiles)
+func (ds *DataSender) CleanupOldTransmissionFiles() error {
+	return ds.CleanupOldFiles()
+}
+
+// Status is a snapshot of the sender's configuration, progress and outbox
+type Status struct {
+	Enabled         bool
+	BaseURL         string
+	DeviceID        string
+	EnrolledAt      time.Time // zero unless the device sends with its own credential
+	IntervalMinutes int
+	PayloadFormat   string
+	LastIntervalEnd time.Time
+	Outbox          OutboxStatus
+	OutboxErr       error
+}
+
+// Status returns the sender's current status
+func (ds *DataSender) Status() Status {
+	status := Status{
+		Enabled:         ds.config.Enabled,
+		BaseURL:         ds.endpoint(),
+		DeviceID:        ds.config.DeviceID,
+		IntervalMinutes: ds.intervalMinutes,
+		PayloadFormat:   ds.config.PayloadFormat,
+		LastIntervalEnd: ds.loadTransmissionState().LastIntervalEnd,
+	}
+	if ds.credential != nil {
+		status.EnrolledAt = ds.credential.EnrolledAt
+	}
+	status.Outbox, status.OutboxErr = ds.outboxStatus()
+	return status
+}
+
+// ShowConfig displays the current configuration
+func (ds *DataSender) ShowConfig() {
+	fmt.Printf("Data Transmission Configuration:\n")
+	fmt.Printf("  Enabled: %v\n", ds.config.Enabled)
+	fmt.Printf("  Base URL: %s\n", ds.endpoint())
+	if ds.credential != nil {
+		fmt.Printf("  Credential: device credential from %s (enrolled %s)\n",
+			ds.credential.Server, ds.credential.EnrolledAt.Local().Format("2006-01-02 15:04:05"))
+	} else {
+		fmt.Printf("  API Key: %s\n", MaskSecret(ds.config.APIKey))
+	}
+	fmt.Printf("  Device ID: %s\n", ds.config.DeviceID)
+	fmt.Printf("  Interval: %d minutes\n", ds.intervalMinutes)
+	fmt.Printf("  Payload Format: %s\n", ds.config.PayloadFormat)
+	fmt.Printf("  Settings File: %s\n", UserEnvPath())
+	fmt.Printf("  Transmission Dir: %s\n", ds.transmissionDir)
+	fmt.Printf("  Log File: %s\n", ds.logPath)
+	status := ds.Status()
+	if !status.LastIntervalEnd.IsZero() {
+		fmt.Printf("  Last Processed Interval End: %s\n", status.LastIntervalEnd.Format("2006-01-02 15:04:05"))
+	}
+
+	outbox := status.Outbox
+	if status.OutboxErr != nil {
+		fmt.Printf("  Outbox: error reading %s: %v\n", ds.outboxDir, status.OutboxErr)
+		return
+	}
+	fmt.Printf("  Outbox: %d queued (%.1f KB of %d MB), max age %v\n",
+		outbox.Depth, float64(outbox.Bytes)/1024, ds.outboxMaxBytes/(1024*1024), ds.outboxMaxAge)
+	if outbox.Depth > 0 {
+		fmt.Printf("  Oldest Queued Interval: %s\n", outbox.Oldest.Format("2006-01-02 15:04"))
+		fmt.Printf("  Next Attempt: %s\n", outbox.NextAttempt.Format("2006-01-02 15:04:05"))
+		if outbox.LastError != "" {
+			fmt.Printf("  Last Error: %s\n", outbox.LastError)
+		}
+	}
+}
+
+// ShowTransmissionLogs displays recent transmission logs
+func (ds *DataSender) ShowTransmissionLogs(limit int) {
+	logs, err := ds.loadTransmissionLogs()
+	if err != nil {
+		log.Printf("Error loading transmission logs: %v", err)
+		return
+	}
+
+	if len(logs) == 0 {
+		fmt.Println("No transmission logs found")
+		return
+	}
+
+	// Show most recent logs first
+	start := len(logs) - limit
+	if start < 0 {
+		start = 0
+	}
+
+	fmt.Printf("Recent Transmission Logs (showing %d of %d):\n", len(logs)-start, len(logs))
+	fmt.Println("=======================================================")
+
+	for i := len(logs) - 1; i >= start; i-- {
+		logEntry := logs[i]
+		status := "SUCCESS"
+		if !logEntry.Success {
+			status = "FAILED"
+		}
+
+		fmt.Printf("[%s] %s\n", logEntry.Timestamp.Format("2006-01-02 15:04:05"), status)
+		fmt.Printf("  Interval: %s - %s\n", 
+			logEntry.StartTime.Format("15:04:05"), logEntry.EndTime.Format("15:04:05"))
+		fmt.Printf("  Payload Size: %d items, Retries: %d\n", logEntry.PayloadSize, logEntry.RetryCount)
+		
+		if logEntry.Error != "" {
+			fmt.Printf("  Error: %s\n", logEntry.Error)
+		}
+		fmt.Println()
+	}
+}
+
+// EnableTransmission enables data transmission and saves the server settings given
+func (ds *DataSender) EnableTransmission(baseURL, apiKey string) error {
+	if baseURL != "" {
+		if _, err := ds.SetSetting("base_url", baseURL); err != nil {
+			return err
+		}
+	}
+	if apiKey != "" {
+		if _, err := ds.SetSetting("api_key", apiKey); err != nil {
+			return err
+		}
+	}
+	if _, err := ds.SetSetting("enabled", "true"); err != nil {
+		return err
+	}
+	ds.config.Enabled = true
+	return nil
+}
+
+// DisableTransmission disables data transmission
+func (ds *DataSender) DisableTransmission() error {
+	if _, err := ds.SetSetting("enabled", "false"); err != nil {
+		return err
+	}
+	ds.config.Enabled = false
+	return nil
+}
+
+// CreateEnvExample creates a .env.example file
+func (ds *DataSender) CreateEnvExample() error {
+	envExampleContent := `# ROI Agent Data Transmission Environment Variables
+# Replace with your actual server URL and API key, or use "data-sender config set",
+# which writes ~/.roiagent/data-sender/.env
+
+ROI_AGENT_BASE_URL=https://api.yourserver.com/v1/roi-agent
+ROI_AGENT_API_KEY=your-actual-api-key-here
+ROI_AGENT_INTERVAL_MINUTES=10
+
+# Defaults to true once the URL and API key are set, or the device is enrolled
+# ROI_AGENT_ENABLED=true
+
+# Device ID source for ~/.roiagent/device.json: random, or machine (derived from the OS machine identifier)
+ROI_AGENT_DEVICE_ID_SOURCE=random
+
+# Unsent payloads are kept in ~/.roiagent/transmission/outbox up to this age and size
+ROI_AGENT_OUTBOX_MAX_AGE_HOURS=168
+ROI_AGENT_OUTBOX_MAX_MB=50
+
+# Payload format: v2 (every app in app_usage) or legacy (single-entry apps array only)
+ROI_AGENT_PAYLOAD_FORMAT=v2
+`
+
+	envExamplePath := filepath.Join(filepath.Dir(ds.configPath), "data-sender", ".env.example")
+	return ioutil.WriteFile(envExamplePath, []byte(envExampleContent), 0644)
+}
+
+// SetTransmissionInterval sets the transmission interval and saves it to the user .env file
+func (ds *DataSender) SetTransmissionInterval(minutes int) error {
+	if _, err := ds.SetSetting("interval_minutes", strconv.Itoa(minutes)); err != nil {
+		return err
+	}
+	ds.intervalMinutes = minutes
+	return nil
+}
@@ -0,0 +1,168 @@
+package transmit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeDay writes the agent's day files for date, with one sample per given end time
+func writeDay(t *testing.T, ds *DataSender, date string, ends ...time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(ds.dataDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var samples []byte
+	for _, end := range ends {
+		line, _ := json.Marshal(UsageSample{Start: end.Add(-time.Minute), End: end, Apps: map[string]*SampleApp{"Safari": {FocusTime: 60}}})
+		samples = append(append(samples, line...), '\n')
+	}
+	files := map[string][]byte{
+		"combined_" + date + ".json": []byte(`{"date": "` + date + `"}`),
+		"events_" + date + ".jsonl":  nil,
+		"samples_" + date + ".jsonl": samples,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(ds.dataDir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// dayFilesKept reports whether each of date's day files still exists
+func dayFilesKept(ds *DataSender, date string) bool {
+	for _, name := range []string{"combined_" + date + ".json", "events_" + date + ".jsonl", "samples_" + date + ".jsonl"} {
+		if _, err := os.Stat(filepath.Join(ds.dataDir, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCleanupOldFiles(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	ds := newTestSender(t)
+	today := time.Now()
+	day := func(daysAgo int) (string, time.Time) {
+		at := time.Date(today.Year(), today.Month(), today.Day()-daysAgo, 10, 5, 0, 0, time.Local)
+		return at.Format("2006-01-02"), at
+	}
+	sentDate, sentAt := day(40)
+	unsentDate, unsentAt := day(39)
+	queuedDate, queuedAt := day(38)
+	recentDate, recentAt := day(20) // sent, but within data_retention_days
+
+	writeDay(t, ds, sentDate, sentAt)
+	writeDay(t, ds, unsentDate, unsentAt)
+	writeDay(t, ds, queuedDate, queuedAt)
+	writeDay(t, ds, recentDate, recentAt)
+	for _, at := range []time.Time{sentAt, recentAt} {
+		sent := alignedInterval(at, ds.intervalLength())
+		ds.logTransmissionResult(sent.Start, sent.End, true, nil, 0, 1)
+	}
+	queued := alignedInterval(queuedAt, ds.intervalLength())
+	queueEntries(t, ds, queued.Start)
+	if err := ds.saveTransmissionState(TransmissionState{LastIntervalEnd: alignedInterval(today, ds.intervalLength()).Start}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Transmission files go by age, except the lock
+	old := today.AddDate(0, 0, -10)
+	oldFile := filepath.Join(ds.transmissionDir, "payload_old.json")
+	for _, path := range []string{oldFile, ds.lockPath()} {
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, old, old)
+	}
+
+	if err := ds.CleanupOldFiles(); err != nil {
+		t.Fatal(err)
+	}
+	for date, kept := range map[string]bool{sentDate: false, unsentDate: true, queuedDate: false, recentDate: true} {
+		if dayFilesKept(ds, date) != kept {
+			t.Errorf("day files for %s kept = %v, want %v", date, !kept, kept)
+		}
+	}
+	if _, err := os.Stat(oldFile); !os.IsNotExist(err) {
+		t.Error("an old transmission file was kept")
+	}
+	if _, err := os.Stat(ds.lockPath()); err != nil {
+		t.Errorf("the transmission lock was removed: %v", err)
+	}
+
+	// A retention of 0 keeps every day file
+	ds.config.Enabled = false
+	ds.dataRetentionDays = 0
+	if err := ds.CleanupOldFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if !dayFilesKept(ds, unsentDate) {
+		t.Error("a day file was removed with data_retention_days 0")
+	}
+}
+
+func TestDayTransmitted(t *testing.T) {
+	ds := newTestSender(t)
+	date := "2026-10-14"
+	at := time.Date(2026, 10, 14, 10, 5, 0, 0, time.Local)
+	writeDay(t, ds, date, at)
+	interval := alignedInterval(at, ds.intervalLength())
+
+	// A day that was not processed yet is still waiting to be sent
+	if ds.DayTransmitted(date) {
+		t.Error("DayTransmitted() before the day was processed")
+	}
+
+	if err := ds.saveTransmissionState(TransmissionState{LastIntervalEnd: time.Date(2026, 10, 16, 0, 0, 0, 0, time.Local)}); err != nil {
+		t.Fatal(err)
+	}
+	if ds.DayTransmitted(date) {
+		t.Error("DayTransmitted() with an interval that was never sent")
+	}
+
+	ds.logTransmissionResult(interval.Start, interval.End, true, nil, 0, 1)
+	if !ds.DayTransmitted(date) {
+		t.Error("DayTransmitted() = false after every interval was sent")
+	}
+
+	// With transmission disabled nothing is waiting
+	ds = newTestSender(t)
+	ds.config.Enabled = false
+	writeDay(t, ds, date, at)
+	if !ds.DayTransmitted(date) {
+		t.Error("DayTransmitted() = false with transmission disabled")
+	}
+}
+
+func TestTransmissionLogKeepsEntriesWithDayFiles(t *testing.T) {
+	ds := newTestSender(t)
+	now := time.Now()
+	keptDay := now.AddDate(0, 0, -20)
+	prunedDay := now.AddDate(0, 0, -21)
+	writeDay(t, ds, keptDay.Format("2006-01-02"))
+
+	logs := []TransmissionLog{
+		{StartTime: keptDay, EndTime: keptDay.Add(10 * time.Minute), Timestamp: keptDay, Success: true},
+		{StartTime: prunedDay, EndTime: prunedDay.Add(10 * time.Minute), Timestamp: prunedDay, Success: true},
+	}
+	data, _ := json.Marshal(logs)
+	if err := ioutil.WriteFile(ds.logPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ds.logTransmissionResult(now.Add(-10*time.Minute), now, true, nil, 0, 1)
+	logs, err := ds.loadTransmissionLogs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 || !logs[0].StartTime.Equal(keptDay) {
+		t.Errorf("transmission logs = %+v, want the entry with a day file and the new one", logs)
+	}
+}
@@ -0,0 +1,58 @@
+package transmit
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"roi-agent-schema"
+)
+
+// ValidateDataFiles checks day files against the shared schema and prints a report.
+// Without paths it checks every combined_*.json and usage_*.json in the data directory.
+// It returns the number of files with problems.
+func (ds *DataSender) ValidateDataFiles(paths []string) (int, error) {
+	if len(paths) == 0 {
+		for _, pattern := range []string{"combined_*.json", "usage_*.json"} {
+			matches, err := filepath.Glob(filepath.Join(ds.dataDir, pattern))
+			if err != nil {
+				return 0, err
+			}
+			paths = append(paths, matches...)
+		}
+		sort.Strings(paths)
+	}
+
+	if len(paths) == 0 {
+		fmt.Printf("No day files found in %s\n", ds.dataDir)
+		return 0, nil
+	}
+
+	invalid := 0
+	for _, path := range paths {
+		report, err := schema.ValidateFile(path)
+		if err != nil {
+			fmt.Printf("❌ %s: %v\n", path, err)
+			invalid++
+			continue
+		}
+
+		upgrade := ""
+		if report.Version < schema.CurrentVersion {
+			upgrade = fmt.Sprintf(" (schema v%d, upgraded to v%d when read)", report.Version, schema.CurrentVersion)
+		}
+		if report.Valid() {
+			fmt.Printf("✅ %s%s\n", path, upgrade)
+			continue
+		}
+
+		invalid++
+		fmt.Printf("❌ %s%s\n", path, upgrade)
+		for _, problem := range report.Problems {
+			fmt.Printf("    %s\n", problem)
+		}
+	}
+
+	fmt.Printf("\n%d of %d files valid\n", len(paths)-invalid, len(paths))
+	return invalid, nil
+}
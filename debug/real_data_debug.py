@@ -55,7 +55,7 @@ class RealDataDebugTools:
                 for app_name, app_data in list(apps.items())[:5]:
                     fg_time = app_data.get('foreground_time', 0)
                     focus_time = app_data.get('focus_time', 0)
-                    is_active = app_data.get('is_active', False)
+                    is_active = app_name in (data.get('running') or {}).get('apps', [])
                     print(f"   {app_name}: {fg_time}秒 (フォーカス: {focus_time}秒) {'🟢' if is_active else '🔴'}")
             
             if len(network) > 0:
@@ -132,12 +132,13 @@ class RealDataDebugTools:
                                 print(f"  🆕 新しい接続: {', '.join(list(new_connections)[:3])}")
                         
                         # アクティブなアプリを表示
-                        active_apps = [name for name, data in apps.items() if data.get('is_active', False)]
+                        running = current_data.get('running') or {}
+                        active_apps = running.get('apps') or []
                         if active_apps:
                             print(f"  🟢 アクティブアプリ: {', '.join(active_apps[:3])}")
                         
                         # フォーカス中のアプリを表示
-                        focused_apps = [name for name, data in apps.items() if data.get('is_focused', False)]
+                        focused_apps = [running['focused_app']] if running.get('focused_app') else []
                         if focused_apps:
                             print(f"  🎯 フォーカス中: {', '.join(focused_apps)}")
                         
@@ -294,7 +295,7 @@ class RealDataDebugTools:
             print(f"\n🔍 データ品質チェック:")
             
             # アクティブなアプリの確認
-            active_apps = sum(1 for app_data in apps.values() if app_data.get('is_active', False))
+            active_apps = len((data.get('running') or {}).get('apps') or [])
             print(f"   現在アクティブなアプリ: {active_apps}個")
             
             # アクティブなネットワーク接続の確認
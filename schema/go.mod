@@ -0,0 +1,3 @@
+module roi-agent-schema
+
+go 1.19
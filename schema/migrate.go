@@ -0,0 +1,172 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// document is a day file decoded generically, so migrations can work on files whose
+// shape no longer matches the current types
+type document map[string]interface{}
+
+// migration upgrades a document from one schema version to the next
+type migration func(doc document) error
+
+// dayFileMigrations and usageDayMigrations upgrade a file from version i+1 to i+2
+var (
+	dayFileMigrations = []migration{
+		migrateDayFileV1,
+	}
+	usageDayMigrations = []migration{
+		migrateUsageDayV1,
+	}
+)
+
+// Decode reads a combined_YYYY-MM-DD.json file, upgrading it to CurrentVersion.
+// It returns the version the file was written with.
+func Decode(data []byte) (*DayFile, int, error) {
+	upgraded, version, err := upgrade(data, dayFileMigrations)
+	if err != nil {
+		return nil, version, err
+	}
+
+	var day DayFile
+	if err := json.Unmarshal(upgraded, &day); err != nil {
+		return nil, version, err
+	}
+	return &day, version, nil
+}
+
+// DecodeUsage reads a usage_YYYY-MM-DD.json file, upgrading it to CurrentVersion.
+// It returns the version the file was written with.
+func DecodeUsage(data []byte) (*UsageDay, int, error) {
+	upgraded, version, err := upgrade(data, usageDayMigrations)
+	if err != nil {
+		return nil, version, err
+	}
+
+	var day UsageDay
+	if err := json.Unmarshal(upgraded, &day); err != nil {
+		return nil, version, err
+	}
+	return &day, version, nil
+}
+
+// upgrade applies migrations to the JSON document in data and returns it re-encoded
+// at CurrentVersion, along with the version it was written with. Files without a
+// schema_version are version 1; files newer than CurrentVersion are rejected rather
+// than read with fields silently dropped.
+func upgrade(data []byte, migrations []migration) ([]byte, int, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var doc document
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, 0, err
+	}
+	if doc == nil {
+		return nil, 0, fmt.Errorf("file does not contain a JSON object")
+	}
+
+	version, err := doc.version()
+	if err != nil {
+		return nil, 0, err
+	}
+	if version > CurrentVersion {
+		return nil, version, fmt.Errorf("schema version %d is newer than supported version %d", version, CurrentVersion)
+	}
+	if version == CurrentVersion {
+		return data, version, nil
+	}
+
+	for v := version; v < CurrentVersion; v++ {
+		if err := migrations[v-1](doc); err != nil {
+			return nil, version, fmt.Errorf("migrating from schema version %d: %v", v, err)
+		}
+	}
+	doc["schema_version"] = CurrentVersion
+
+	upgraded, err := json.Marshal(doc)
+	if err != nil {
+		return nil, version, err
+	}
+	return upgraded, version, nil
+}
+
+// version returns the document's schema_version, or 1 if it has none
+func (doc document) version() (int, error) {
+	raw, exists := doc["schema_version"]
+	if !exists || raw == nil {
+		return 1, nil
+	}
+	number, ok := raw.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("schema_version is not a number")
+	}
+	version, err := number.Int64()
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid schema_version %s", number)
+	}
+	return int(version), nil
+}
+
+// objects returns the entries of the object at key that are themselves objects
+func (doc document) objects(key string) map[string]map[string]interface{} {
+	result := make(map[string]map[string]interface{})
+	entries, _ := doc[key].(map[string]interface{})
+	for name, value := range entries {
+		if entry, ok := value.(map[string]interface{}); ok {
+			result[name] = entry
+		}
+	}
+	return result
+}
+
+// migrateDayFileV1 upgrades a combined file written before schema_version. Those files
+// kept is_active/is_focused on every app instead of the running view, and some apps
+// were written without a name.
+func migrateDayFileV1(doc document) error {
+	var running []string
+	focused := ""
+	for name, app := range doc.objects("apps") {
+		if _, exists := app["name"]; !exists {
+			app["name"] = name
+		}
+		if active, _ := app["is_active"].(bool); active {
+			running = append(running, name)
+		}
+		if isFocused, _ := app["is_focused"].(bool); isFocused {
+			focused = name
+		}
+		delete(app, "is_active")
+		delete(app, "is_focused")
+	}
+
+	if _, exists := doc["running"]; !exists {
+		sort.Strings(running)
+		if running == nil {
+			running = []string{}
+		}
+		doc["running"] = map[string]interface{}{
+			"apps":        running,
+			"focused_app": focused,
+		}
+	}
+	if _, exists := doc["suspended"]; !exists {
+		doc["suspended"] = []interface{}{}
+	}
+	return nil
+}
+
+// migrateUsageDayV1 upgrades a Windows usage file written before schema_version.
+// Apps gained first_seen, which stays unset for days recorded before it existed.
+func migrateUsageDayV1(doc document) error {
+	for name, app := range doc.objects("apps") {
+		if _, exists := app["name"]; !exists {
+			app["name"] = name
+		}
+	}
+	return nil
+}
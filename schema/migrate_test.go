@@ -0,0 +1,125 @@
+package schema
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// v1DayFile is a combined file written before schema_version, with the liveness flags
+// on every app and one app without a name
+const v1DayFile = `{
+  "date": "2026-10-16",
+  "apps": {
+    "Safari": {"name": "Safari", "focus_time": 60, "foreground_time": 90, "is_active": true, "is_focused": true},
+    "Mail": {"focus_time": 30, "foreground_time": 30, "is_active": true, "is_focused": false},
+    "Notes": {"name": "Notes", "focus_time": 0, "foreground_time": 10, "is_active": false}
+  },
+  "network": {},
+  "app_total": {"foreground_time": 130, "focus_time": 90},
+  "network_total": {}
+}`
+
+func TestDecodeDayFileV1(t *testing.T) {
+	day, version, err := Decode([]byte(v1DayFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 || day.SchemaVersion != CurrentVersion {
+		t.Errorf("Decode() version %d, schema_version %d; want 1 and %d", version, day.SchemaVersion, CurrentVersion)
+	}
+	if day.Apps["Mail"].Name != "Mail" || day.Apps["Safari"].FocusTime != 60 {
+		t.Errorf("apps = %+v, want names filled in and counters kept", day.Apps)
+	}
+	if strings.Join(day.Running.Apps, ",") != "Mail,Safari" || day.Running.FocusedApp != "Safari" {
+		t.Errorf("running = %+v, want Mail and Safari with Safari focused", day.Running)
+	}
+	if day.Suspended == nil {
+		t.Error("suspended is null, want an empty list")
+	}
+}
+
+func TestDecodeDayFileV1KeepsRunning(t *testing.T) {
+	data := `{"date": "2026-10-16", "apps": {"Safari": {"is_active": true}}, "running": {"apps": [], "focused_app": ""}}`
+	day, _, err := Decode([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(day.Running.Apps) != 0 || day.Apps["Safari"].Name != "Safari" {
+		t.Errorf("Decode() running %+v, apps %+v; want the file's running view kept", day.Running, day.Apps)
+	}
+}
+
+func TestDecodeVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		version int
+		err     string
+	}{
+		{"current", `{"schema_version": 2, "date": "2026-10-16"}`, 2, ""},
+		{"newer", `{"schema_version": 3, "date": "2026-10-16"}`, 3, "newer than supported"},
+		{"not a number", `{"schema_version": "2"}`, 0, "not a number"},
+		{"zero", `{"schema_version": 0}`, 0, "invalid schema_version"},
+		{"not an object", `null`, 0, "not contain a JSON object"},
+		{"not JSON", `{"date": `, 0, "unexpected EOF"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, version, err := Decode([]byte(tt.data))
+			if version != tt.version {
+				t.Errorf("Decode() version = %d, want %d", version, tt.version)
+			}
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("Decode() err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDecodeUsageV1(t *testing.T) {
+	data := `{"date": "2026-10-16", "apps": {"chrome.exe": {"focus_time": 60}}, "total": {"focus_time": 60}}`
+	day, version, err := DecodeUsage([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 || day.SchemaVersion != CurrentVersion || day.Apps["chrome.exe"].Name != "chrome.exe" {
+		t.Errorf("DecodeUsage() = %+v, version %d", day, version)
+	}
+}
+
+func TestValidateFile(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "combined_2026-10-16.json")
+	if err := ioutil.WriteFile(valid, []byte(v1DayFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := ValidateFile(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() || report.Version != 1 {
+		t.Errorf("ValidateFile() of a version 1 file = %+v, want it valid after migration", report)
+	}
+
+	// The totals have to match the apps
+	invalid := filepath.Join(dir, "usage_2026-10-16.json")
+	data := `{"schema_version": 2, "date": "2026-10-15", "apps": {"chrome.exe": {"name": "chrome.exe", "focus_time": 60}}, "total": {"focus_time": 50}}`
+	if err := ioutil.WriteFile(invalid, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err = ValidateFile(invalid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems := strings.Join(report.Problems, "\n")
+	if !strings.Contains(problems, "does not match the file name") || !strings.Contains(problems, "total.focus_time") {
+		t.Errorf("ValidateFile() problems = %q, want the date and the total", problems)
+	}
+
+	if _, err := ValidateFile(filepath.Join(dir, "events_2026-10-16.jsonl")); err == nil {
+		t.Error("ValidateFile() accepted a file that is not a day file")
+	}
+}
@@ -0,0 +1,147 @@
+// Package schema defines the day files written by the ROI agents and read by the
+// data sender and dashboards, with an explicit schema version and the migrations
+// that upgrade older files when they are read.
+package schema
+
+import "time"
+
+// CurrentVersion is the schema version written by this code. Version 1 is every file
+// written before schema_version existed.
+const CurrentVersion = 2
+
+// DayFile is the macOS agent's combined_YYYY-MM-DD.json: application and network usage for one day
+type DayFile struct {
+	SchemaVersion int                           `json:"schema_version"`
+	Date          string                        `json:"date"`
+	Apps          map[string]*AppUsage          `json:"apps"`
+	Network       map[string]*NetworkConnection `json:"network"`
+	Running       RunningView                   `json:"running"`
+	Suspended     []SuspendedPeriod             `json:"suspended"`
+	AppTotal      AppTotal                      `json:"app_total"`
+	NetworkTotal  NetworkTotal                  `json:"network_total"`
+	Capture       *CaptureHealth                `json:"capture,omitempty"`
+}
+
+// UsageDay is the Windows agent's usage_YYYY-MM-DD.json: application usage for one day
+type UsageDay struct {
+	SchemaVersion int                  `json:"schema_version"`
+	Date          string               `json:"date"`
+	Apps          map[string]*AppUsage `json:"apps"`
+	Total         AppTotal             `json:"total"`
+}
+
+// AppUsage represents one app's cumulative usage for the day. The descriptive fields
+// and the IsActive/IsFocused flags are only written by the Windows agent; the macOS
+// agent reports liveness in RunningView instead.
+type AppUsage struct {
+	Name           string    `json:"name"`
+	DisplayName    string    `json:"display_name,omitempty"`
+	Category       string    `json:"category,omitempty"`
+	Vendor         string    `json:"vendor,omitempty"`
+	IconPath       string    `json:"icon_path,omitempty"`
+	WindowTitle    string    `json:"window_title,omitempty"`
+	ForegroundTime int64     `json:"foreground_time"` // seconds
+	BackgroundTime int64     `json:"background_time"` // seconds
+	FocusTime      int64     `json:"focus_time"`      // seconds
+	FirstSeen      time.Time `json:"first_seen"`
+	LastSeen       time.Time `json:"last_seen"`
+	IsActive       bool      `json:"is_active,omitempty"`
+	IsFocused      bool      `json:"is_focused,omitempty"`
+	Domains        []string  `json:"domains,omitempty"` // domains of the day's connections made by the app, sorted
+}
+
+// NetworkConnection represents a simplified network connection with FQDN
+type NetworkConnection struct {
+	Domain          string         `json:"domain"`
+	Port            int            `json:"port"`
+	Protocol        string         `json:"protocol"`
+	Duration        int64          `json:"duration"` // seconds
+	FirstSeen       time.Time      `json:"first_seen"`
+	LastSeen        time.Time      `json:"last_seen"`
+	IsActive        bool           `json:"is_active"`
+	AppName         string         `json:"app_name"`       // the app that made the connection last, or "Unknown"
+	Apps            []string       `json:"apps,omitempty"` // every app that made the connection that day
+	ConnectionState string         `json:"connection_state"`
+	PortSource      string         `json:"port_source,omitempty"`   // PortObserved or PortGuessed; empty in files from older agents, which guessed
+	DomainSource    string         `json:"domain_source,omitempty"` // DomainFromDNS, DomainFromTLS or DomainFromQUIC; empty in files from older agents, which used DNS
+	DNS             *DNSResolution `json:"dns,omitempty"`
+}
+
+// How a connection's port and protocol were determined
+const (
+	PortObserved = "observed" // from the connection seen in the capture
+	PortGuessed  = "guessed"  // from the domain name, when only the DNS lookup was seen
+)
+
+// Where a connection's domain was read from
+const (
+	DomainFromDNS  = "dns"  // the client's DNS lookup of the address it connected to
+	DomainFromTLS  = "tls"  // the server name in a TLS ClientHello, when the lookup was not seen
+	DomainFromQUIC = "quic" // the server name in the ClientHello of a QUIC Initial packet
+)
+
+// DNSResolution is how a connection's domain was last resolved, kept for debugging
+type DNSResolution struct {
+	Chain      []string  `json:"chain,omitempty"` // the domain and its CNAME targets in order, if it is an alias
+	Addresses  []string  `json:"addresses"`
+	TTL        uint32    `json:"ttl"` // the shortest TTL along the chain, in seconds
+	ResolvedAt time.Time `json:"resolved_at"`
+}
+
+// RunningView is the live view of which apps were running at the last update.
+// Apps in DayFile keeps every app seen during the day, running or not.
+type RunningView struct {
+	Apps       []string  `json:"apps"`
+	FocusedApp string    `json:"focused_app"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// SuspendedPeriod is a gap between samples that was too long to be usage,
+// typically because the machine was asleep
+type SuspendedPeriod struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Seconds int64     `json:"seconds"`
+	Reason  string    `json:"reason,omitempty"` // SuspendPaused, or empty for a gap between samples
+}
+
+// SuspendPaused marks a period in which recording was paused by the user
+const SuspendPaused = "paused"
+
+// CaptureHealth is the state of the DNS capture at the last update. Network usage is
+// only recorded while State is CaptureRunning.
+type CaptureHealth struct {
+	State       string     `json:"state"`
+	Reason      string     `json:"reason,omitempty"` // why the capture is restarting, failed or disabled
+	Since       time.Time  `json:"since"`            // when State was entered
+	Restarts    int        `json:"restarts"`         // since the agent started
+	LastExit    string     `json:"last_exit,omitempty"`
+	LastExitAt  *time.Time `json:"last_exit_at,omitempty"`
+	LastStderr  string     `json:"last_stderr,omitempty"` // the end of the last exited process's stderr
+	NextRestart *time.Time `json:"next_restart,omitempty"`
+	LastDNS     *time.Time `json:"last_dns,omitempty"` // when DNS traffic was last captured
+	LastTLS     *time.Time `json:"last_tls,omitempty"` // when a TLS or QUIC server name was last captured
+	Warning     string     `json:"warning,omitempty"`  // e.g. no DNS traffic while TLS traffic is seen
+}
+
+// Capture states
+const (
+	CaptureRunning    = "running"
+	CaptureRestarting = "restarting" // exited and waiting to be restarted
+	CaptureFailed     = "failed"     // keeps failing, restarted at the longest backoff
+	CaptureDisabled   = "disabled"   // network.dns_snooping is off
+)
+
+// AppTotal is the sum of all apps' counters
+type AppTotal struct {
+	ForegroundTime int64 `json:"foreground_time"`
+	BackgroundTime int64 `json:"background_time"`
+	FocusTime      int64 `json:"focus_time"`
+}
+
+// NetworkTotal summarizes the day's connections
+type NetworkTotal struct {
+	TotalDuration     int64 `json:"total_duration"`
+	UniqueConnections int   `json:"unique_connections"`
+	UniqueDomains     int   `json:"unique_domains"`
+}
@@ -0,0 +1,233 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Report is the result of validating one day file
+type Report struct {
+	Path     string
+	Version  int      // schema version the file was written with
+	Problems []string // empty if the file is valid
+}
+
+// Valid reports whether the file has no problems
+func (r *Report) Valid() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *Report) addf(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// ValidateFile checks a combined_YYYY-MM-DD.json or usage_YYYY-MM-DD.json file
+// against the current schema, after applying any migrations it needs. It returns
+// an error only if the file cannot be read or is not a day file at all.
+func ValidateFile(path string) (*Report, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	base := filepath.Base(path)
+	switch {
+	case strings.HasPrefix(base, "combined_"):
+		return validateDayFile(path, data, strings.TrimSuffix(strings.TrimPrefix(base, "combined_"), ".json")), nil
+	case strings.HasPrefix(base, "usage_"):
+		return validateUsageDay(path, data, strings.TrimSuffix(strings.TrimPrefix(base, "usage_"), ".json")), nil
+	}
+	return nil, fmt.Errorf("%s is not a combined_*.json or usage_*.json file", base)
+}
+
+func validateDayFile(path string, data []byte, fileDate string) *Report {
+	report := &Report{Path: path}
+
+	upgraded, version, err := upgrade(data, dayFileMigrations)
+	report.Version = version
+	if err != nil {
+		report.addf("cannot read: %v", err)
+		return report
+	}
+
+	var day DayFile
+	if !decodeStrict(report, upgraded, &day) {
+		return report
+	}
+
+	checkDate(report, day.Date, fileDate)
+
+	var total AppTotal
+	for key, app := range day.Apps {
+		if checkApp(report, key, app) {
+			total.ForegroundTime += app.ForegroundTime
+			total.BackgroundTime += app.BackgroundTime
+			total.FocusTime += app.FocusTime
+		}
+	}
+	checkAppTotal(report, "app_total", day.AppTotal, total)
+
+	var duration int64
+	domains := make(map[string]bool)
+	for key, conn := range day.Network {
+		if conn == nil {
+			report.addf("network[%s]: entry is null", key)
+			continue
+		}
+		if conn.Domain == "" {
+			report.addf("network[%s]: domain is empty", key)
+		}
+		if conn.Port < 0 || conn.Port > 65535 {
+			report.addf("network[%s]: port %d is out of range", key, conn.Port)
+		}
+		if conn.Duration < 0 {
+			report.addf("network[%s]: duration is negative", key)
+		}
+		checkSeen(report, "network["+key+"]", conn.FirstSeen, conn.LastSeen)
+		duration += conn.Duration
+		domains[conn.Domain] = true
+	}
+	if day.NetworkTotal.TotalDuration != duration {
+		report.addf("network_total.total_duration is %d, connections sum to %d", day.NetworkTotal.TotalDuration, duration)
+	}
+	if day.NetworkTotal.UniqueConnections != len(day.Network) {
+		report.addf("network_total.unique_connections is %d, file has %d connections", day.NetworkTotal.UniqueConnections, len(day.Network))
+	}
+	if day.NetworkTotal.UniqueDomains != len(domains) {
+		report.addf("network_total.unique_domains is %d, file has %d domains", day.NetworkTotal.UniqueDomains, len(domains))
+	}
+
+	for name, app := range day.Apps {
+		for _, domain := range app.Domains {
+			if !domains[domain] {
+				report.addf("apps[%s].domains: %s is not in network", name, domain)
+			}
+		}
+	}
+
+	for _, name := range day.Running.Apps {
+		if _, exists := day.Apps[name]; !exists {
+			report.addf("running.apps: %s is not in apps", name)
+		}
+	}
+	if day.Running.FocusedApp != "" {
+		if _, exists := day.Apps[day.Running.FocusedApp]; !exists {
+			report.addf("running.focused_app: %s is not in apps", day.Running.FocusedApp)
+		}
+	}
+
+	for i, period := range day.Suspended {
+		if !period.Start.Before(period.End) {
+			report.addf("suspended[%d]: start is not before end", i)
+		}
+		if period.Seconds < 0 {
+			report.addf("suspended[%d]: seconds is negative", i)
+		}
+	}
+
+	sort.Strings(report.Problems)
+	return report
+}
+
+func validateUsageDay(path string, data []byte, fileDate string) *Report {
+	report := &Report{Path: path}
+
+	upgraded, version, err := upgrade(data, usageDayMigrations)
+	report.Version = version
+	if err != nil {
+		report.addf("cannot read: %v", err)
+		return report
+	}
+
+	var day UsageDay
+	if !decodeStrict(report, upgraded, &day) {
+		return report
+	}
+
+	checkDate(report, day.Date, fileDate)
+
+	var total AppTotal
+	for key, app := range day.Apps {
+		if checkApp(report, key, app) {
+			total.ForegroundTime += app.ForegroundTime
+			total.BackgroundTime += app.BackgroundTime
+			total.FocusTime += app.FocusTime
+		}
+	}
+	checkAppTotal(report, "total", day.Total, total)
+
+	sort.Strings(report.Problems)
+	return report
+}
+
+// decodeStrict decodes data into v, reporting fields the schema does not know.
+// It returns false if the file could not be decoded at all.
+func decodeStrict(report *Report, data []byte, v interface{}) bool {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		if !strings.Contains(err.Error(), "unknown field") {
+			report.addf("cannot decode: %v", err)
+			return false
+		}
+		report.addf("%v", strings.TrimPrefix(err.Error(), "json: "))
+
+		// Check the rest of the file as the agents would read it
+		if err := json.Unmarshal(data, v); err != nil {
+			report.addf("cannot decode: %v", err)
+			return false
+		}
+	}
+	return true
+}
+
+func checkDate(report *Report, date, fileDate string) {
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		report.addf("date %q is not YYYY-MM-DD", date)
+		return
+	}
+	if date != fileDate {
+		report.addf("date %s does not match the file name", date)
+	}
+}
+
+// checkApp checks one entry of apps and returns whether it can be counted in the totals
+func checkApp(report *Report, key string, app *AppUsage) bool {
+	field := "apps[" + key + "]"
+	if app == nil {
+		report.addf("%s: entry is null", field)
+		return false
+	}
+	if app.Name != key {
+		report.addf("%s: name %q does not match its key", field, app.Name)
+	}
+	if app.ForegroundTime < 0 || app.BackgroundTime < 0 || app.FocusTime < 0 {
+		report.addf("%s: negative usage time", field)
+	}
+	checkSeen(report, field, app.FirstSeen, app.LastSeen)
+	return true
+}
+
+func checkSeen(report *Report, field string, firstSeen, lastSeen time.Time) {
+	if !firstSeen.IsZero() && !lastSeen.IsZero() && firstSeen.After(lastSeen) {
+		report.addf("%s: first_seen is after last_seen", field)
+	}
+}
+
+func checkAppTotal(report *Report, field string, got, want AppTotal) {
+	if got.ForegroundTime != want.ForegroundTime {
+		report.addf("%s.foreground_time is %d, apps sum to %d", field, got.ForegroundTime, want.ForegroundTime)
+	}
+	if got.BackgroundTime != want.BackgroundTime {
+		report.addf("%s.background_time is %d, apps sum to %d", field, got.BackgroundTime, want.BackgroundTime)
+	}
+	if got.FocusTime != want.FocusTime {
+		report.addf("%s.focus_time is %d, apps sum to %d", field, got.FocusTime, want.FocusTime)
+	}
+}
@@ -46,7 +46,7 @@ fi
 # Build Go agent
 echo "🔨 Building Go agent..."
 cd "$PROJECT_ROOT/agent"
-GOOS=darwin GOARCH=amd64 go build -o "$MACOS_DIR/roi-agent" main.go
+GOOS=darwin GOARCH=amd64 go build -o "$MACOS_DIR/roi-agent" .
 chmod +x "$MACOS_DIR/roi-agent"
 
 # Copy Python Web UI
@@ -27,17 +27,18 @@ echo ""
 echo "📋 Setup Instructions:"
 echo "====================="
 echo ""
-echo "1. Configure data transmission:"
-echo "   ./data-sender config"
+echo "1. Configure data transmission (replace with your actual URL and API key):"
+echo "   ./data-sender config set base_url https://api.yourserver.com/v1/roi-agent"
+echo "   ./data-sender config set api_key your-api-key-here"
 echo ""
-echo "2. Enable transmission (replace with your actual URL and API key):"
-echo "   ./data-sender enable https://api.yourserver.com/v1/roi-agent your-api-key-here"
+echo "2. Check the settings and where each one comes from:"
+echo "   ./data-sender config show --origin"
 echo ""
 echo "3. Test data transmission:"
 echo "   ./data-sender process"
 echo ""
 echo "4. Disable transmission:"
-echo "   ./data-sender disable"
+echo "   ./data-sender config set enabled false"
 echo ""
 echo "📊 What data is sent every 10 minutes:"
 echo "====================================="
@@ -60,8 +61,8 @@ echo "  - Total apps and domains count"
 echo ""
 echo "🔒 Security Notes:"
 echo "================="
-echo "- Data transmission is DISABLED by default"
-echo "- Configuration stored in ~/.roiagent/transmission_config.json"
+echo "- Data transmission is DISABLED until the server URL and API key are set"
+echo "- Configuration stored in ~/.roiagent/data-sender/.env (readable only by you)"
 echo "- Transmission logs saved in ~/.roiagent/transmission/"
 echo "- All data is sent over HTTPS"
 echo "- API key authentication required"
@@ -72,10 +73,11 @@ echo "# Build data sender"
 echo "cd data-sender && go build -o data-sender main.go"
 echo ""
 echo "# Check configuration"
-echo "./data-sender/data-sender config"
+echo "./data-sender/data-sender config show --origin"
 echo ""
 echo "# Enable transmission"
-echo "./data-sender/data-sender enable <BASE_URL> <API_KEY>"
+echo "./data-sender/data-sender config set base_url <BASE_URL>"
+echo "./data-sender/data-sender config set api_key <API_KEY>"
 echo ""
 echo "# Process current data"
 echo "./data-sender/data-sender process"
@@ -86,6 +88,6 @@ echo "~/.roiagent/"
 echo "├── data/                    # Monitoring data"
 echo "├── logs/                    # Agent logs"
 echo "├── transmission/            # Transmission logs"
-echo "└── transmission_config.json # Transmission config"
+echo "└── data-sender/.env         # Transmission config"
 echo ""
 echo "✅ Setup complete! Use the commands above to configure data transmission."
@@ -126,7 +126,7 @@ fi
 # Kill any existing processes
 echo "🔄 Stopping any existing ROI Agent processes..."
 sudo pkill -f "tcpdump.*port 53" || true
-pkill -f "main.go" || true
+sudo pkill -f "agent/roi-agent" || true
 pkill -f "enhanced_app.py" || true
 sleep 2
 
@@ -140,6 +140,12 @@ echo "📁 Log directory: $LOG_DIR"
 echo "🚀 Starting Go agent (tcpdump DNS Monitor)..."
 cd "$PROJECT_ROOT/agent"
 
+# The agent is split across several files, so build the whole package
+if ! go build -o roi-agent . ; then
+    echo "❌ Error: failed to build Go agent"
+    exit 1
+fi
+
 # Pass environment variables explicitly to sudo
 SUDO_ENV=""
 if [ "$ROI_AGENT_BASE_URL" != "" ]; then
@@ -154,7 +160,7 @@ if [ "$ROI_AGENT_INTERVAL_MINUTES" != "" ]; then
 fi
 
 echo "🔧 Passing environment variables to agent: $SUDO_ENV"
-nohup sudo env $SUDO_ENV go run main.go > "$LOG_DIR/agent.log" 2>&1 &
+nohup sudo env $SUDO_ENV "$PROJECT_ROOT/agent/roi-agent" > "$LOG_DIR/agent.log" 2>&1 &
 AGENT_PID=$!
 echo "   Agent PID: $AGENT_PID"
 
@@ -213,7 +219,7 @@ echo "   Web UI: tail -f $LOG_DIR/webui.log"
 echo ""
 echo "🛑 To stop all services:"
 echo "   sudo pkill -f \"tcpdump.*port 53\""
-echo "   pkill -f main.go"
+echo "   sudo pkill -f agent/roi-agent"
 echo "   pkill -f enhanced_app.py"
 echo ""
 echo "ℹ️  tcpdump DNS monitoring requires sudo permissions."
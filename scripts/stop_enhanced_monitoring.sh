@@ -7,8 +7,22 @@ echo "プロセス停止中..."
 # Stop tcpdump processes
 sudo pkill -f "tcpdump.*port 53" || true
 
-# Stop Go agent
-pkill -f "main.go" || true
+# Stop Go agent: SIGTERM lets it save the day file and finish a running transmission
+PID_FILE="$HOME/.roiagent/agent.pid"
+AGENT_PID=$(cat "$PID_FILE" 2>/dev/null)
+if [ -n "$AGENT_PID" ] && sudo kill -TERM "$AGENT_PID" 2>/dev/null; then
+    echo "エージェント終了待機中 (PID: $AGENT_PID)..."
+    for i in $(seq 1 35); do
+        sudo kill -0 "$AGENT_PID" 2>/dev/null || break
+        sleep 1
+    done
+    if sudo kill -0 "$AGENT_PID" 2>/dev/null; then
+        echo "⚠️ エージェントが終了しないため強制終了します"
+        sudo kill -KILL "$AGENT_PID" || true
+    fi
+else
+    sudo pkill -TERM -f "agent/roi-agent" || true
+fi
 
 # Stop Web UI
 pkill -f "enhanced_app.py" || true
@@ -28,7 +42,8 @@ if [ -f "$REAL_DATA_FILE" ]; then
     if command -v jq > /dev/null 2>&1; then
         echo ""
         echo "📈 統計情報:"
-        echo "  アクティブアプリ数: $(jq '[.apps[] | select(.is_active == true)] | length' "$REAL_DATA_FILE" 2>/dev/null || echo "0")"
+        echo "  実行中アプリ数: $(jq '.running.apps | length' "$REAL_DATA_FILE" 2>/dev/null || echo "0")"
+        echo "  本日のアプリ数: $(jq '.apps | length' "$REAL_DATA_FILE" 2>/dev/null || echo "0")"
         echo "  アクティブネットワーク接続数: $(jq '[.network[] | select(.is_active == true)] | length' "$REAL_DATA_FILE" 2>/dev/null || echo "0")"
         echo "  ユニークドメイン数: $(jq '.network_total.unique_domains' "$REAL_DATA_FILE" 2>/dev/null || echo "0")"
         echo "  総接続時間: $(jq '.network_total.total_duration' "$REAL_DATA_FILE" 2>/dev/null || echo "0")秒"
@@ -37,7 +52,7 @@ if [ -f "$REAL_DATA_FILE" ]; then
         jq -r '.network | to_entries[] | select(.value.is_active == true) | "  " + .value.domain + ":" + (.value.port | tostring) + " (" + .value.protocol + ")"' "$REAL_DATA_FILE" 2>/dev/null | head -10 || echo "  データなし"
         echo ""
         echo "🎯 フォーカス中のアプリ:"
-        jq -r '.apps | to_entries[] | select(.value.is_focused == true) | "  " + .key + " (フォーカス時間: " + (.value.focus_time | tostring) + "秒)"' "$REAL_DATA_FILE" 2>/dev/null || echo "  データなし"
+        jq -r '.running.focused_app as $f | .apps | to_entries[] | select(.key == $f) | "  " + .key + " (フォーカス時間: " + (.value.focus_time | tostring) + "秒)"' "$REAL_DATA_FILE" 2>/dev/null || echo "  データなし"
     fi
 else
     echo "データファイルが見つかりません"
@@ -185,7 +185,7 @@ test_permissions() {
     
     # Accessibility権限チェック
     cd "$PROJECT_ROOT/agent"
-    if go run main.go check-permissions 2>/dev/null | grep -q "OK"; then
+    if go run . check-permissions 2>/dev/null | grep -q "OK"; then
         log_success "Accessibility権限が許可されています"
     else
         log_warning "Accessibility権限が必要です"
@@ -234,7 +234,7 @@ check_status() {
     log_info "現在の動作状況を確認中..."
     
     # プロセス確認
-    if pgrep -f "main.go" > /dev/null; then
+    if pgrep -f "agent/roi-agent" > /dev/null; then
         log_success "Agent プロセスが動作中です"
     else
         log_info "Agent プロセスは停止中です"
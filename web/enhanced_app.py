@@ -96,6 +96,60 @@ class EnhancedMonitorUI:
             }
         }
     
+    def load_events(self, date=None):
+        """Load the agent's append-only event log for a specific date"""
+        if date is None:
+            date = datetime.now().strftime("%Y-%m-%d")
+        
+        events = []
+        events_file = os.path.join(self.data_dir, f"events_{date}.jsonl")
+        if not os.path.exists(events_file):
+            return events
+        
+        with open(events_file, 'r') as f:
+            for line in f:
+                try:
+                    events.append(json.loads(line))
+                except ValueError:
+                    # Skip a half-written last line
+                    continue
+        return events
+    
+    def get_focus_sessions(self, events, until):
+        """Turn focus events into sessions (mirrors focusSpans in data-sender/transmit/events.go)"""
+        sessions = []
+        current = None
+        
+        def close_current(at):
+            if current is not None and at > current["start"]:
+                current["end"] = at
+                current["duration"] = int((at - current["start"]).total_seconds())
+                sessions.append(current)
+        
+        for event in events:
+            at = parse_event_time(event.get("time", ""))
+            if at is None or at > until:
+                continue
+            event_type = event.get("type")
+            if event_type == "focus_change":
+                # A focus change carried over midnight continues the same session
+                if event.get("carried") and current is not None and current["app"] == event.get("app"):
+                    continue
+                close_current(at)
+                current = {"app": event["app"], "start": at} if event.get("app") else None
+            elif event_type == "app_quit" and current is not None and current["app"] == event.get("app"):
+                close_current(at)
+                current = None
+            elif event_type in ("agent_start", "suspend"):
+                close_current(at)
+                current = None
+        close_current(until)
+        
+        for session in sessions:
+            session["start"] = session["start"].isoformat()
+            session["end"] = session["end"].isoformat()
+        return sessions
+    
     def get_agent_status(self):
         """Get current agent status"""
         if not AGENT_BINARY:
@@ -145,16 +199,17 @@ class EnhancedMonitorUI:
             return f"{bytes_count / (1024 * 1024 * 1024):.1f}GB"
     
     def get_app_ranking_data(self, data, category="foreground_time"):
-        """Get app ranking data for specified category - only active apps"""
+        """Get app ranking data for specified category - every app seen during the day"""
         if not data or not data.get("apps"):
             return []
         
+        # Liveness comes from the agent's running view, not from the day's roster
+        running = data.get("running") or {}
+        running_apps = set(running.get("apps") or [])
+        focused_app = running.get("focused_app", "")
+        
         apps = []
         for app_name, app_data in data["apps"].items():
-            # Only include currently active apps
-            if not app_data.get("is_active", False):
-                continue
-                
             usage_time = app_data.get(category, 0)
             if usage_time > 0:
                 apps.append({
@@ -164,8 +219,9 @@ class EnhancedMonitorUI:
                     "foreground_time": app_data.get("foreground_time", 0),
                     "background_time": app_data.get("background_time", 0),
                     "focus_time": app_data.get("focus_time", 0),
-                    "is_active": app_data.get("is_active", False),
-                    "is_focused": app_data.get("is_focused", False),
+                    "is_active": app_name in running_apps,
+                    "is_focused": app_name == focused_app,
+                    "first_seen": app_data.get("first_seen", ""),
                     "last_seen": app_data.get("last_seen", "")
                 })
         
@@ -215,6 +271,18 @@ class EnhancedMonitorUI:
         connections.sort(key=lambda x: x["value"], reverse=True)
         return connections
 
+def parse_event_time(value):
+    """Parse an RFC 3339 timestamp written by the Go agent"""
+    try:
+        # Go writes up to nanoseconds; Python only parses microseconds
+        if "." in value:
+            head, rest = value.split(".", 1)
+            digits = len(rest) - len(rest.lstrip("0123456789"))
+            value = head + "." + rest[:min(digits, 6)] + rest[digits:]
+        return datetime.fromisoformat(value.replace("Z", "+00:00"))
+    except ValueError:
+        return None
+
 # Initialize UI handler
 ui = EnhancedMonitorUI()
 
@@ -327,6 +395,37 @@ def api_network_domains():
     result.sort(key=lambda x: x["total_duration"], reverse=True)
     return jsonify(result)
 
+@app.route('/api/timeline')
+def api_timeline():
+    """Get focus sessions and exact focus time per app from the event log"""
+    date = request.args.get('date')
+    events = ui.load_events(date)
+    
+    # A session that is still open ends at the agent's last update
+    data = ui.load_combined_data(date) or {}
+    until = parse_event_time((data.get("running") or {}).get("updated_at", ""))
+    if until is None:
+        until = datetime.now().astimezone()
+    
+    sessions = ui.get_focus_sessions(events, until)
+    
+    focus_by_app = {}
+    for session in sessions:
+        stats = focus_by_app.setdefault(session["app"], {"app": session["app"], "focus_time": 0, "sessions": 0})
+        stats["focus_time"] += session["duration"]
+        stats["sessions"] += 1
+    
+    apps = sorted(focus_by_app.values(), key=lambda x: x["focus_time"], reverse=True)
+    for stats in apps:
+        stats["formatted_focus_time"] = ui.format_time(stats["focus_time"])
+    
+    return jsonify({
+        "events": events,
+        "sessions": sessions,
+        "apps": apps,
+        "suspended": data.get("suspended") or []
+    })
+
 if __name__ == '__main__':
     print("=== ROI Agent Enhanced Web UI (Apps + Network) ===")
     print(f"Data directory: {DATA_DIR}")
@@ -2,4 +2,9 @@ module roi-agent-windows
 
 go 1.19
 
-require golang.org/x/sys v0.15.0
+require (
+	golang.org/x/sys v0.15.0
+	roi-agent-schema v0.0.0
+)
+
+replace roi-agent-schema => ../schema
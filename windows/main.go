@@ -13,6 +13,7 @@ import (
 	"unsafe"
 
 	"golang.org/x/sys/windows"
+	"roi-agent-schema"
 )
 
 // Windows API declarations
@@ -34,32 +35,11 @@ const (
 	PROCESS_VM_READ           = 0x0010
 )
 
-// AppUsage represents application usage data
-type AppUsage struct {
-	Name           string    `json:"name"`
-	DisplayName    string    `json:"display_name"`
-	Category       string    `json:"category"`
-	Vendor         string    `json:"vendor"`
-	IconPath       string    `json:"icon_path"`
-	WindowTitle    string    `json:"window_title"`
-	ForegroundTime int64     `json:"foreground_time"` // seconds
-	BackgroundTime int64     `json:"background_time"` // seconds
-	FocusTime      int64     `json:"focus_time"`      // seconds
-	LastSeen       time.Time `json:"last_seen"`
-	IsActive       bool      `json:"is_active"`
-	IsFocused      bool      `json:"is_focused"`
-}
-
-// DailyData represents a day's worth of application usage data
-type DailyData struct {
-	Date  string               `json:"date"`
-	Apps  map[string]*AppUsage `json:"apps"`
-	Total struct {
-		ForegroundTime int64 `json:"foreground_time"`
-		BackgroundTime int64 `json:"background_time"`
-		FocusTime      int64 `json:"focus_time"`
-	} `json:"total"`
-}
+// The day file types are shared with the macOS agent and the data sender
+type (
+	AppUsage  = schema.AppUsage
+	DailyData = schema.UsageDay
+)
 
 // Agent represents the main monitoring agent
 type Agent struct {
@@ -91,18 +71,25 @@ func (a *Agent) initDailyData() {
 	today := time.Now().Format("2006-01-02")
 	dataFile := filepath.Join(a.dataDir, fmt.Sprintf("usage_%s.json", today))
 
-	// Try to load existing data
+	// Try to load existing data, upgrading files written by older versions
 	if data, err := ioutil.ReadFile(dataFile); err == nil {
-		if err := json.Unmarshal(data, &a.dailyData); err == nil {
+		dailyData, _, err := schema.DecodeUsage(data)
+		if err == nil {
+			a.dailyData = dailyData
+			if a.dailyData.Apps == nil {
+				a.dailyData.Apps = make(map[string]*AppUsage)
+			}
 			log.Printf("Loaded existing data for %s", today)
 			return
 		}
+		log.Printf("Error loading data for %s: %v", today, err)
 	}
 
 	// Create new daily data
 	a.dailyData = &DailyData{
-		Date: today,
-		Apps: make(map[string]*AppUsage),
+		SchemaVersion: schema.CurrentVersion,
+		Date:          today,
+		Apps:          make(map[string]*AppUsage),
 	}
 	log.Printf("Created new daily data for %s", today)
 }
@@ -506,6 +493,7 @@ func (a *Agent) updateAppUsage() {
 				ForegroundTime: 0,
 				BackgroundTime: 0,
 				FocusTime:      0,
+				FirstSeen:      now,
 				LastSeen:       now,
 				IsActive:       true,
 				IsFocused:      isFocused,